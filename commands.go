@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+
+	"mediastream/config"
+	"mediastream/logging"
+	"mediastream/models"
+	"mediastream/routes"
+)
+
+// command is a subcommand of the binary
+type command struct {
+	usage string
+	run   func(opts *options, args []string) error
+}
+
+// commands maps subcommand names to their handlers
+var commands = map[string]command{
+	"serve":       {"serve                      start the media server (the default)", runServeCommand},
+	"scan":        {"scan [library...]          scan libraries and update the index", runScanCommand},
+	"user":        {"user add|del|passwd|list   manage user accounts", runUserCommand},
+	"config":      {"config validate|print      check or show the effective configuration", runConfigCommand},
+	"index":       {"index rebuild              rebuild the library index from scratch", runIndexCommand},
+	"backup":      {"backup [file]              archive the configuration and state", runBackupCommand},
+	"restore":     {"restore [-force] file      restore the configuration and state from a backup", runRestoreCommand},
+	"openapi":     {"openapi                    print the OpenAPI document of the API", runOpenAPICommand},
+	"healthcheck": {"healthcheck                ask the running server's liveness probe, e.g. for Docker", runHealthcheckCommand},
+}
+
+// recordCLIAudit appends an audit event for a change made from the command line
+func recordCLIAudit(eventType, target string, success bool, details map[string]string) {
+	event := models.AuditEvent{
+		Time:    time.Now(),
+		Type:    eventType,
+		Actor:   "cli",
+		Target:  target,
+		Success: success,
+		Details: details,
+	}
+	if err := models.AppendAuditEvent(event, config.AuditFile); err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: could not write audit log:", err)
+	}
+}
+
+// runServeCommand starts the media server
+func runServeCommand(opts *options, args []string) error {
+	if len(args) > 0 {
+		return errors.New("usage: serve")
+	}
+	serve(opts)
+	return nil
+}
+
+// selectLibraries returns the named libraries, or all of them when no names are given
+func selectLibraries(cfg *config.Config, ids []string) ([]config.Library, error) {
+	if len(ids) == 0 {
+		return cfg.Libraries, nil
+	}
+
+	var libraries []config.Library
+	for _, id := range ids {
+		library := cfg.FindLibrary(id)
+		if library == nil {
+			return nil, fmt.Errorf("library %q not found", id)
+		}
+		libraries = append(libraries, *library)
+	}
+	return libraries, nil
+}
+
+// runScanCommand scans libraries, prints what was found and stores the result in the index.
+// A running server keeps its own index until its next rescan.
+func runScanCommand(opts *options, args []string) error {
+	store, err := loadConfigStore(opts)
+	if err != nil {
+		return err
+	}
+	cfg := store.Get()
+
+	libraries, err := selectLibraries(cfg, args)
+	if err != nil {
+		return err
+	}
+
+	index, err := models.LoadIndex(config.IndexFile)
+	if err != nil {
+		index = models.NewIndex(config.IndexFile)
+	}
+
+	return scanLibraries(index, libraries, cfg)
+}
+
+// scanLibraries rescans libraries into an index, printing the item count of every root
+func scanLibraries(index *models.Index, libraries []config.Library, cfg *config.Config) error {
+	ctx := logging.BackgroundContext()
+	failed := 0
+	for _, library := range libraries {
+		start := time.Now()
+		items, err := index.Rescan(ctx, library, cfg)
+		if err != nil {
+			fmt.Printf("%s: scan failed: %v\n", library.ID, err)
+			failed++
+			continue
+		}
+
+		perRoot := map[string]int{}
+		for _, item := range items {
+			perRoot[item.Root]++
+		}
+		for _, root := range library.Paths {
+			fmt.Printf("%s: %s: %d items\n", library.ID, root, perRoot[config.RootKey(root)])
+		}
+		fmt.Printf("%s: %d items indexed in %s\n", library.ID, len(items), time.Since(start).Round(time.Millisecond))
+	}
+
+	recordCLIAudit(models.AuditLibraryScan, strings.Join(libraryIDs(libraries), ","), failed == 0, nil)
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d libraries could not be scanned", failed, len(libraries))
+	}
+	return nil
+}
+
+// libraryIDs returns the IDs of libraries
+func libraryIDs(libraries []config.Library) []string {
+	ids := make([]string, len(libraries))
+	for i, library := range libraries {
+		ids[i] = library.ID
+	}
+	return ids
+}
+
+// runIndexCommand handles "index rebuild", which discards the index and scans every library
+func runIndexCommand(opts *options, args []string) error {
+	if len(args) != 1 || args[0] != "rebuild" {
+		return errors.New("usage: index rebuild")
+	}
+
+	store, err := loadConfigStore(opts)
+	if err != nil {
+		return err
+	}
+	cfg := store.Get()
+
+	if err := os.Remove(config.IndexFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return scanLibraries(models.NewIndex(config.IndexFile), cfg.Libraries, cfg)
+}
+
+// readPassword reads a password from stdin, without echoing it when stdin is a terminal
+func readPassword(prompt string) (string, error) {
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		return string(password), nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no password given")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// runUserCommand manages user accounts without the web UI
+func runUserCommand(opts *options, args []string) error {
+	usage := errors.New("usage: user add [-admin] <username> | user del <username> | user passwd <username> | user list")
+	if len(args) == 0 {
+		return usage
+	}
+
+	store, err := loadConfigStore(opts)
+	if err != nil {
+		return err
+	}
+	cfg := store.Get()
+
+	users, err := models.LoadUsers(config.UsersFile)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "USERNAME\tROLE\tSTATUS\tLIBRARIES\tCREATED")
+		for _, user := range users {
+			role, status, libraries := "user", "active", "all"
+			if user.IsAdmin {
+				role = "admin"
+			}
+			if user.Disabled {
+				status = "disabled"
+			}
+			if user.Libraries != nil {
+				libraries = cmp.Or(strings.Join(user.Libraries, ","), "none")
+			}
+			created := "-"
+			if !user.Created.IsZero() {
+				created = user.Created.Format("2006-01-02")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", user.Username, role, status, libraries, created)
+		}
+		return w.Flush()
+
+	case "add":
+		flags := flag.NewFlagSet("user add", flag.ExitOnError)
+		isAdmin := flags.Bool("admin", false, "make the user an admin")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			return usage
+		}
+		username := flags.Arg(0)
+
+		if err := models.ValidateUsername(username); err != nil {
+			return err
+		}
+		password, err := readPassword("Password: ")
+		if err != nil {
+			return err
+		}
+		if err := cfg.PasswordPolicy.Validate(password); err != nil {
+			return err
+		}
+
+		user, err := models.CreateUser(users, username, password, *isAdmin)
+		if err != nil {
+			return err
+		}
+		if err := models.SaveUsers(append(users, *user), config.UsersFile); err != nil {
+			return err
+		}
+
+		recordCLIAudit(models.AuditUserCreate, user.Username, true, map[string]string{"isAdmin": fmt.Sprint(user.IsAdmin)})
+		fmt.Printf("Created user %s\n", user.Username)
+
+		// Creating the first admin completes a headless setup
+		if user.IsAdmin && !config.IsSetupCompleted() {
+			if err := config.MarkSetupCompleted(); err != nil {
+				return err
+			}
+			fmt.Println("Setup completed")
+		}
+		return nil
+
+	case "del":
+		if len(args) != 2 {
+			return usage
+		}
+		user := models.FindUserByUsername(users, args[1])
+		if user == nil {
+			return fmt.Errorf("user %q not found", args[1])
+		}
+		if models.IsLastActiveAdmin(users, user.ID) {
+			return errors.New("cannot delete the last admin")
+		}
+
+		deletedProfileIDs := []string{user.ID}
+		for _, profile := range user.Profiles {
+			deletedProfileIDs = append(deletedProfileIDs, profile.ID)
+		}
+
+		var remaining []models.User
+		for _, other := range users {
+			if other.ID != user.ID {
+				remaining = append(remaining, other)
+			}
+		}
+		if err := models.SaveUsers(remaining, config.UsersFile); err != nil {
+			return err
+		}
+
+		// Remove the user's watch history and playlists
+		profileData, err := models.LoadProfileData(config.ProfilesFile)
+		if err == nil {
+			for _, id := range deletedProfileIDs {
+				delete(profileData, id)
+			}
+			err = models.SaveProfileData(profileData, config.ProfilesFile)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Warning: could not remove profile data:", err)
+		}
+
+		recordCLIAudit(models.AuditUserDelete, user.Username, true, nil)
+		fmt.Printf("Deleted user %s\n", user.Username)
+		return nil
+
+	case "passwd":
+		if len(args) != 2 {
+			return usage
+		}
+		user := models.FindUserByUsername(users, args[1])
+		if user == nil {
+			return fmt.Errorf("user %q not found", args[1])
+		}
+
+		password, err := readPassword("New password: ")
+		if err != nil {
+			return err
+		}
+		if err := cfg.PasswordPolicy.Validate(password); err != nil {
+			return err
+		}
+		if err := models.SetPassword(user, password); err != nil {
+			return err
+		}
+		user.EndSessions()
+		if err := models.SaveUsers(users, config.UsersFile); err != nil {
+			return err
+		}
+
+		recordCLIAudit(models.AuditUserPasswordReset, user.Username, true, nil)
+		fmt.Printf("Changed password of %s\n", user.Username)
+		return nil
+	}
+
+	return usage
+}
+
+// runConfigCommand handles "config validate", which checks the configuration,
+// and "config print", which shows the effective configuration after the config file,
+// environment and flags have been merged
+func runConfigCommand(opts *options, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: config validate | config print [-format json|yaml|toml]")
+	}
+
+	switch args[0] {
+	case "validate":
+		store, err := loadConfigStore(opts)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range config.UnknownEnv() {
+			fmt.Printf("Warning: unknown environment variable %s\n", name)
+		}
+		for _, library := range store.Get().Libraries {
+			for _, path := range library.Paths {
+				if info, err := os.Stat(path); err != nil || !info.IsDir() {
+					fmt.Printf("Warning: %s library folder does not exist: %s\n", library.ID, path)
+				}
+			}
+		}
+
+		fmt.Printf("%s is valid\n", config.ConfigFile)
+		return nil
+
+	case "print":
+		flags := flag.NewFlagSet("config print", flag.ExitOnError)
+		format := flags.String("format", config.FormatJSON, "output format: json, yaml or toml")
+		flags.Parse(args[1:])
+
+		if *format != config.FormatJSON && *format != config.FormatYAML && *format != config.FormatTOML {
+			return fmt.Errorf("unknown format %q", *format)
+		}
+
+		store, err := loadConfigStore(opts)
+		if err != nil {
+			return err
+		}
+
+		data, err := config.Encode(store.Get(), *format)
+		if err != nil {
+			return err
+		}
+
+		os.Stdout.Write(data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			fmt.Println()
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown config command %q", args[0])
+}
+
+// runOpenAPICommand prints the OpenAPI document of the versioned API, as served at
+// /api/v1/openapi.json
+func runOpenAPICommand(opts *options, args []string) error {
+	if len(args) > 0 {
+		return errors.New("usage: openapi")
+	}
+
+	store, err := loadConfigStore(opts)
+	if err != nil {
+		return err
+	}
+	routes.SetBasePath(store.Get().Server.BasePath)
+
+	data, err := json.MarshalIndent(routes.OpenAPI(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// runBackupCommand archives the configuration and state, to a dated file in the current
+// directory unless a file name is given ("-" writes to stdout)
+func runBackupCommand(opts *options, args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: backup [file]")
+	}
+	if _, err := loadConfigStore(opts); err != nil {
+		return err
+	}
+
+	filename := "mediastream-backup-" + time.Now().Format("20060102-150405") + ".tar.gz"
+	if len(args) == 1 {
+		filename = args[0]
+	}
+
+	var manifest *models.BackupManifest
+	var err error
+	if filename == "-" {
+		manifest, err = models.WriteBackup(os.Stdout)
+	} else {
+		manifest, err = writeBackupFile(filename)
+	}
+
+	var details map[string]string
+	if err != nil {
+		details = map[string]string{"error": err.Error()}
+	}
+	recordCLIAudit(models.AuditBackupCreate, filename, err == nil, details)
+	if err != nil {
+		return err
+	}
+
+	if filename != "-" {
+		fmt.Printf("Backed up %d files to %s\n", len(manifest.Files), filename)
+	}
+	return nil
+}
+
+// writeBackupFile writes a backup to a new file and syncs it to disk. The file is removed again
+// if anything fails.
+func writeBackupFile(filename string) (manifest *models.BackupManifest, err error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(filename)
+		}
+	}()
+
+	if manifest, err = models.WriteBackup(file); err != nil {
+		return nil, err
+	}
+	if err = file.Sync(); err != nil {
+		return nil, err
+	}
+	if err = file.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// runRestoreCommand restores the configuration and state from a backup archive.
+// Existing state is only replaced with -force; the server should be stopped first.
+func runRestoreCommand(opts *options, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	force := flags.Bool("force", false, "replace existing configuration and state")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: restore [-force] file")
+	}
+	// The config is replaced, so it needn't load
+	setPaths(opts)
+
+	if !*force {
+		for _, path := range models.BackupFiles() {
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s already exists, use -force to replace it", path)
+			}
+		}
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		return err
+	}
+
+	manifest, err := models.RestoreBackup(file, opts.configFile)
+	if err != nil {
+		return err
+	}
+
+	recordCLIAudit(models.AuditBackupRestore, flags.Arg(0), true, map[string]string{"version": fmt.Sprint(manifest.Version)})
+
+	fmt.Printf("Restored %d files from a version %d backup into %s\n", len(manifest.Files), manifest.Version, config.DataDir)
+	return nil
+}
+
+// runHealthcheckCommand asks the liveness probe of a server running with the same configuration,
+// so that container health checks follow its port, socket, TLS and base path
+func runHealthcheckCommand(opts *options, args []string) error {
+	if len(args) > 0 {
+		return errors.New("usage: healthcheck")
+	}
+
+	store, err := loadConfigStore(opts)
+	if err != nil {
+		return err
+	}
+	server := store.Get().Server
+
+	transport := &http.Transport{}
+	scheme := "http"
+	if server.TLSEnabled() {
+		// The certificate names the public host, not the local address asked here
+		scheme = "https"
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	host := net.JoinHostPort(probeHost(server.Address), strconv.Itoa(server.Port))
+	if server.Socket != "" {
+		host = "localhost"
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", server.Socket)
+		}
+	}
+	target := scheme + "://" + host + server.BasePath + "/healthz"
+
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+	resp, err := client.Get(target)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", target, resp.Status)
+	}
+	return nil
+}
+
+// probeHost returns the address to reach a server bound to the given interface from the same host
+func probeHost(address string) string {
+	if ip := net.ParseIP(address); address == "" || ip != nil && ip.IsUnspecified() {
+		return "localhost"
+	}
+	return address
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+func TestBackupCommandsUseConfigFlag(t *testing.T) {
+	dataDir, configFile := config.DataDir, config.ConfigFile
+	t.Cleanup(func() {
+		config.SetDataDir(dataDir)
+		config.ConfigFile = configFile
+	})
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	os.MkdirAll(source, 0755)
+	sourceConfig := filepath.Join(dir, "source.yaml")
+	if err := os.WriteFile(sourceConfig, []byte("scanIntervalMinutes: 15\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "users.json"), []byte(`[{"id":"1","username":"admin"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	backup := filepath.Join(dir, "backup.tar.gz")
+	opts, _ := parseOptions([]string{"-data-dir", source, "-config", sourceConfig})
+	if err := runBackupCommand(opts, []string{backup}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The config from -config is backed up and restored to the -config of the new server
+	target := filepath.Join(dir, "target")
+	targetConfig := filepath.Join(dir, "target.json")
+	opts, _ = parseOptions([]string{"-data-dir", target, "-config", targetConfig})
+	if err := runRestoreCommand(opts, []string{backup}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(targetConfig)
+	if err != nil || cfg.ScanIntervalMinutes != 15 {
+		t.Errorf("restored config = %+v, %v", cfg, err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "users.json")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBackupCommandAudit(t *testing.T) {
+	dataDir, configFile := config.DataDir, config.ConfigFile
+	t.Cleanup(func() {
+		config.SetDataDir(dataDir)
+		config.ConfigFile = configFile
+	})
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "users.json"), []byte(`[{"id":"1","username":"admin"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	opts, _ := parseOptions([]string{"-data-dir", dir})
+
+	backup := filepath.Join(dir, "backup.tar.gz")
+	if err := runBackupCommand(opts, []string{backup}); err != nil {
+		t.Fatal(err)
+	}
+	// An existing file is neither overwritten nor removed
+	if err := runBackupCommand(opts, []string{backup}); err == nil {
+		t.Fatal("backup overwrote an existing file")
+	}
+	if _, err := os.Stat(backup); err != nil {
+		t.Error(err)
+	}
+
+	events, err := models.LoadAuditEvents(config.AuditFile, models.AuditFilter{Type: models.AuditBackupCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []bool
+	for _, event := range events {
+		results = append(results, event.Success)
+	}
+	if len(results) != 2 || results[0] == results[1] {
+		t.Errorf("audited backups succeeded %v, want one success and one failure", results)
+	}
+}
@@ -1,47 +1,119 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"unicode"
+
+	"mediastream/utils"
 )
 
 // Config holds the application configuration
 type Config struct {
-	MediaFolders        []MediaFolder       `json:"mediaFolders"`
-	SupportedExtensions map[string][]string `json:"supportedExtensions"`
+	Server              ServerConfig             `json:"server"`
+	Libraries           []Library                `json:"libraries"`
+	MediaFolders        []MediaFolder            `json:"mediaFolders,omitempty"` // Legacy format, migrated to Libraries on load
+	SupportedExtensions map[string][]string      `json:"supportedExtensions"`
+	PasswordPolicy      PasswordPolicy           `json:"passwordPolicy"`
+	ScanIntervalMinutes int                      `json:"scanIntervalMinutes"` // Periodic library rescan, 0 disables it
+	Logging             LoggingConfig            `json:"logging"`
+	MetadataProviders   []MetadataProviderConfig `json:"metadataProviders,omitempty"` // Online metadata sources libraries can use
+	Previews            PreviewConfig            `json:"previews"`
 }
 
-// MediaFolder represents a media library folder
-type MediaFolder struct {
-	Path string `json:"path"`
-	Type string `json:"type"`
+// PasswordPolicy describes the requirements a user password must meet
+type PasswordPolicy struct {
+	MinLength     int  `json:"minLength"`
+	RequireUpper  bool `json:"requireUpper"`
+	RequireLower  bool `json:"requireLower"`
+	RequireDigit  bool `json:"requireDigit"`
+	RequireSymbol bool `json:"requireSymbol"`
 }
 
 // DefaultConfig returns a new default configuration
 func DefaultConfig() *Config {
-	// Get current working directory instead of hardcoded path
-	projectDir, err := os.Getwd()
+	// Default media folders live in the data directory
+	projectDir, err := filepath.Abs(DataDir)
 	if err != nil {
 		// Fallback to relative path if getting working directory fails
 		projectDir = "."
 	}
 
 	return &Config{
-		MediaFolders: []MediaFolder{
-			{Path: filepath.Join(projectDir, "media/movies"), Type: "movies"},
-			{Path: filepath.Join(projectDir, "media/tvshows"), Type: "tvshows"},
-			{Path: filepath.Join(projectDir, "media/music"), Type: "music"},
-		},
-		SupportedExtensions: map[string][]string{
-			"video": {".mp4", ".mkv", ".avi", ".mov", ".webm"},
-			"audio": {".mp3", ".wav", ".flac", ".ogg", ".aac"},
-			"image": {".jpg", ".jpeg", ".png", ".gif", ".webp"},
+		Server: DefaultServerConfig(),
+		Libraries: []Library{
+			{ID: "movies", Name: "Movies", Kind: KindMovies, Paths: []string{filepath.Join(projectDir, "media/movies")}},
+			{ID: "tvshows", Name: "TV Shows", Kind: KindShows, Paths: []string{filepath.Join(projectDir, "media/tvshows")}},
+			{ID: "music", Name: "Music", Kind: KindMusic, Paths: []string{filepath.Join(projectDir, "media/music")}},
 		},
+		SupportedExtensions: DefaultSupportedExtensions(),
+		PasswordPolicy:      DefaultPasswordPolicy(),
+		ScanIntervalMinutes: DefaultScanIntervalMinutes,
+		Logging:             DefaultLoggingConfig(),
+		Previews:            DefaultPreviewConfig(),
+	}
+}
+
+// DefaultSupportedExtensions returns the file extensions scanned for each media type unless
+// configured otherwise. It is a new map each time, as decoding a config file fills it in.
+func DefaultSupportedExtensions() map[string][]string {
+	return map[string][]string{
+		"video": {".mp4", ".mkv", ".avi", ".mov", ".webm"},
+		"audio": {".mp3", ".wav", ".flac", ".ogg", ".aac"},
+		"image": {".jpg", ".jpeg", ".png", ".gif", ".webp", ".heic", ".heif", ".tif", ".tiff", ".dng", ".cr2", ".nef", ".arw"},
 	}
 }
 
-// LoadConfig loads configuration from a file
+// DefaultScanIntervalMinutes is how often libraries are rescanned unless configured otherwise
+const DefaultScanIntervalMinutes = 30
+
+// DefaultPasswordPolicy returns the password policy used when none is configured
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{MinLength: 8}
+}
+
+// Validate checks a password against the policy and returns a user-facing error
+func (p PasswordPolicy) Validate(password string) error {
+	if len(password) < p.MinLength {
+		return fmt.Errorf("Password must be at least %d characters", p.MinLength)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		return errors.New("Password must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		return errors.New("Password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		return errors.New("Password must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		return errors.New("Password must contain a symbol")
+	}
+
+	return nil
+}
+
+// LoadConfig loads configuration from a JSON, YAML or TOML file
 func LoadConfig(filename string) (*Config, error) {
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
 		// If config file doesn't exist, return default config
@@ -53,47 +125,184 @@ func LoadConfig(filename string) (*Config, error) {
 		return nil, err
 	}
 
+	return ParseConfigFile(filename, data)
+}
+
+// ParseConfigFile parses the contents of a config file in the format given by its name
+func ParseConfigFile(filename string, data []byte) (*Config, error) {
+	data, err := toJSON(data, FileFormat(filename))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	config, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return config, nil
+}
+
+// ParseConfig parses and validates a JSON configuration, filling in defaults for missing settings.
+// Unknown settings and values of the wrong type are rejected with an error naming the setting.
+func ParseConfig(data []byte) (*Config, error) {
 	// Create a config object to unmarshal into
 	config := &Config{
-		SupportedExtensions: map[string][]string{
-			"video": {".mp4", ".mkv", ".avi", ".mov", ".webm"},
-			"audio": {".mp3", ".wav", ".flac", ".ogg", ".aac"},
-			"image": {".jpg", ".jpeg", ".png", ".gif", ".webp"},
-		},
+		Server:              DefaultServerConfig(),
+		SupportedExtensions: DefaultSupportedExtensions(),
+		PasswordPolicy:      DefaultPasswordPolicy(),
+		ScanIntervalMinutes: DefaultScanIntervalMinutes,
+		Logging:             DefaultLoggingConfig(),
+		Previews:            DefaultPreviewConfig(),
 	}
 
 	// Unmarshal directly to the empty config
-	err = json.Unmarshal(data, config)
-	if err != nil {
-		return nil, err
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, describeJSONError(err, data)
+	}
+
+	// Migrate the legacy media folder list into libraries
+	if len(config.Libraries) == 0 && len(config.MediaFolders) > 0 {
+		config.Libraries = librariesFromMediaFolders(config.MediaFolders)
 	}
+	config.MediaFolders = nil
 
-	// Only use defaults if no media folders were specified in the config file
-	if len(config.MediaFolders) == 0 {
+	// Only use defaults if no libraries were specified in the config file
+	if len(config.Libraries) == 0 {
 		defaultConfig := DefaultConfig()
-		config.MediaFolders = defaultConfig.MediaFolders
+		config.Libraries = defaultConfig.Libraries
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
 
 	return config, nil
 }
 
-// SaveConfig saves configuration to a file
+// MergeJSON applies a JSON configuration on top of cfg: settings in the document replace
+// those of cfg and missing ones keep their value. Unknown settings are rejected.
+func MergeJSON(cfg *Config, data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return describeJSONError(err, data)
+	}
+
+	if len(cfg.MediaFolders) > 0 {
+		cfg.Libraries = librariesFromMediaFolders(cfg.MediaFolders)
+	}
+	cfg.MediaFolders = nil
+	return nil
+}
+
+// ValidationError rejects a configuration change whose result is invalid, as opposed to
+// one that could not be saved
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string { return e.Err.Error() }
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// Validate checks the whole configuration and returns a user-facing error
+func (c *Config) Validate() error {
+	if err := c.Server.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.ValidateLibraries(); err != nil {
+		return err
+	}
+
+	for mediaType, extensions := range c.SupportedExtensions {
+		if mediaType != "video" && mediaType != "audio" && mediaType != "image" {
+			return fmt.Errorf("Unknown media type in supportedExtensions: %s", mediaType)
+		}
+		for _, ext := range extensions {
+			if len(ext) < 2 || ext[0] != '.' || ext != strings.ToLower(ext) {
+				return fmt.Errorf("Extension %q for %s must be lowercase and start with a dot", ext, mediaType)
+			}
+		}
+	}
+
+	if c.PasswordPolicy.MinLength < 1 {
+		return errors.New("passwordPolicy.minLength must be at least 1")
+	}
+
+	if c.ScanIntervalMinutes < 0 {
+		return errors.New("scanIntervalMinutes must not be negative")
+	}
+
+	if err := c.Logging.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.validateMetadataProviders(); err != nil {
+		return err
+	}
+
+	if err := c.Previews.Validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SaveConfig saves configuration to a file in the format given by its extension.
+// The file is replaced atomically so a crash never leaves a half-written config behind.
 func SaveConfig(config *Config, filename string) error {
-	data, err := json.MarshalIndent(config, "", "  ")
+	data, err := Encode(config, FileFormat(filename))
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(filename, data, 0644)
+	return utils.WriteFileAtomic(filename, data, 0644)
 }
 
-// Constants
-const (
+// DataDir is the directory holding the configuration and all server state
+var DataDir = "."
+
+// Files in the data directory, updated by SetDataDir
+var (
 	SetupFlagFile = "setup-completed"
 	UsersFile     = "users.json"
 	ConfigFile    = "config.json"
+	InvitesFile   = "invites.json"
+	AuditFile     = "audit.log"
+	ProfilesFile  = "profiles.json"
+	IndexFile     = "index.json"
+	EditsFile     = "metadata-edits.json"
+	CacheDir      = "cache" // Generated files such as artwork, safe to delete
 )
 
+// ConfigFileNames are the config files looked for in the data directory, in order of preference
+var ConfigFileNames = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// SetDataDir moves every state file into dir.
+// The config file is the first of config.json, config.yaml, config.yml and config.toml that exists.
+func SetDataDir(dir string) {
+	DataDir = dir
+	SetupFlagFile = filepath.Join(dir, "setup-completed")
+	UsersFile = filepath.Join(dir, "users.json")
+	InvitesFile = filepath.Join(dir, "invites.json")
+	AuditFile = filepath.Join(dir, "audit.log")
+	ProfilesFile = filepath.Join(dir, "profiles.json")
+	IndexFile = filepath.Join(dir, "index.json")
+	EditsFile = filepath.Join(dir, "metadata-edits.json")
+	CacheDir = filepath.Join(dir, "cache")
+
+	ConfigFile = filepath.Join(dir, ConfigFileNames[0])
+	for _, name := range ConfigFileNames {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			ConfigFile = filepath.Join(dir, name)
+			break
+		}
+	}
+}
+
 // IsSetupCompleted checks if setup has been completed
 func IsSetupCompleted() bool {
 	_, err := os.Stat(SetupFlagFile)
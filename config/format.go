@@ -0,0 +1,149 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config file formats, chosen by file extension
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// FileFormat returns the format of a config file from its extension, JSON unless it is YAML or TOML
+func FileFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatJSON
+	}
+}
+
+// toJSON converts a YAML or TOML document to JSON so every format shares one parser
+func toJSON(data []byte, format string) ([]byte, error) {
+	var document map[string]interface{}
+
+	switch format {
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf("Invalid YAML: %v", err)
+		}
+	case FormatTOML:
+		if err := toml.Unmarshal(data, &document); err != nil {
+			var decodeErr *toml.DecodeError
+			if errors.As(err, &decodeErr) {
+				row, column := decodeErr.Position()
+				return nil, fmt.Errorf("Invalid TOML at line %d, column %d: %v", row, column, err)
+			}
+			return nil, fmt.Errorf("Invalid TOML: %v", err)
+		}
+	default:
+		return data, nil
+	}
+
+	if document == nil {
+		document = map[string]interface{}{}
+	}
+	return json.Marshal(document)
+}
+
+// Encode serializes a configuration in the given format
+func Encode(cfg *Config, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil || format == FormatJSON {
+		return data, err
+	}
+
+	// Go through a generic document so the JSON field names are kept
+	var document map[string]interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	document = wholeNumbers(document).(map[string]interface{})
+
+	if format == FormatTOML {
+		return toml.Marshal(document)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(document); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// wholeNumbers turns the float64 values JSON decoding produces back into integers
+// where possible, so YAML and TOML don't write 3000 as 3000.0
+func wholeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = wholeNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = wholeNumbers(item)
+		}
+	case float64:
+		if v == math.Trunc(v) {
+			return int64(v)
+		}
+	}
+	return value
+}
+
+// describeJSONError turns a JSON decoding error into a message naming the setting or position
+func describeJSONError(err error, data []byte) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line := bytes.Count(data[:syntaxErr.Offset], []byte("\n")) + 1
+		return fmt.Errorf("Invalid JSON at line %d: %v", line, syntaxErr)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		if typeErr.Field == "" {
+			return fmt.Errorf("Expected %s, got %s", describeKind(typeErr.Type.Kind().String()), typeErr.Value)
+		}
+		return fmt.Errorf("%s must be %s, got %s", typeErr.Field, describeKind(typeErr.Type.Kind().String()), typeErr.Value)
+	}
+
+	if field, found := strings.CutPrefix(err.Error(), "json: unknown field "); found {
+		return fmt.Errorf("Unknown setting %s", field)
+	}
+
+	return err
+}
+
+// describeKind names a Go kind the way a config file author thinks of it
+func describeKind(kind string) string {
+	switch kind {
+	case "int", "int64":
+		return "a whole number"
+	case "bool":
+		return "true or false"
+	case "string":
+		return "a string"
+	case "slice":
+		return "a list"
+	case "map", "struct":
+		return "an object"
+	default:
+		return kind
+	}
+}
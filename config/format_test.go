@@ -0,0 +1,113 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFileFormat(t *testing.T) {
+	tests := map[string]string{
+		"config.json":         FormatJSON,
+		"config.yaml":         FormatYAML,
+		"config.YML":          FormatYAML,
+		"/etc/ms/config.toml": FormatTOML,
+		"config":              FormatJSON,
+		"config.conf":         FormatJSON,
+	}
+	for filename, want := range tests {
+		if got := FileFormat(filename); got != want {
+			t.Errorf("FileFormat(%q) = %q, want %q", filename, got, want)
+		}
+	}
+}
+
+// roundTripConfig sets a value in most kinds of settings
+func roundTripConfig() *Config {
+	cfg := DefaultConfig()
+	cfg.Server.Port = 8443
+	cfg.Server.BasePath = "/media"
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8", "::1"}
+	cfg.Libraries = append(cfg.Libraries, Library{
+		ID:    "home",
+		Name:  "Home Videos: 2024 \"Best\"",
+		Kind:  KindHomeVideos,
+		Paths: []string{"/srv/home", "/mnt/backup/home"},
+		Options: ScannerOptions{
+			IncludeHidden:     true,
+			Exclude:           []string{"*.tmp", "@eaDir"},
+			MaxDepth:          3,
+			WriteNFO:          true,
+			MetadataProviders: []string{"tmdb", "local"},
+			MetadataPriority:  map[string][]string{"plot": {"local", "tmdb"}},
+		},
+	})
+	cfg.MetadataProviders = []MetadataProviderConfig{{Name: "tmdb", Type: "http", URL: "https://metadata.example/tmdb", APIKey: "secret", TimeoutSeconds: 5}}
+	cfg.PasswordPolicy.RequireDigit = true
+	cfg.ScanIntervalMinutes = 0
+	cfg.Logging.Subsystems = map[string]string{"scanner": "debug"}
+	cfg.Previews.Enabled = true
+	return cfg
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	want := roundTripConfig()
+	if err := want.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{FormatJSON, FormatYAML, FormatTOML} {
+		t.Run(format, func(t *testing.T) {
+			data, err := Encode(want, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseConfigFile("config."+format, data)
+			if err != nil {
+				t.Fatalf("%v:\n%s", err, data)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("config read back =\n%+v\nwant\n%+v\nfrom\n%s", got, want, data)
+			}
+			// Whole numbers stay whole numbers
+			if strings.Contains(string(data), "8443.0") || strings.Contains(string(data), "8443e") {
+				t.Errorf("port written as a float:\n%s", data)
+			}
+		})
+	}
+
+	// Converting from one format to the next loses nothing either
+	cfg := want
+	for _, format := range []string{FormatJSON, FormatYAML, FormatTOML, FormatYAML, FormatJSON} {
+		data, err := Encode(cfg, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg, err = ParseConfigFile("config."+format, data); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("config after converting between formats =\n%+v\nwant\n%+v", cfg, want)
+	}
+}
+
+func TestParseConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		filename, data, err string
+	}{
+		{"config.json", `{"server": {"port": "80"}}`, "server.port must be a whole number"},
+		{"config.json", `{"scanIntervalMinutes": 5,}`, "Invalid JSON at line 1"},
+		{"config.yaml", "server:\n  port: [80]\n", "server.port must be a whole number"},
+		{"config.yaml", "server: port: 80\n", "Invalid YAML"},
+		{"config.toml", "[server]\nport = \"80\"\n", "server.port must be a whole number"},
+		{"config.toml", "[server\nport = 80\n", "Invalid TOML at line 1"},
+		{"config.yaml", "serve:\n  port: 80\n", "Unknown setting"},
+	}
+	for _, test := range tests {
+		_, err := ParseConfigFile(test.filename, []byte(test.data))
+		if err == nil || !strings.Contains(err.Error(), test.err) || !strings.HasPrefix(err.Error(), test.filename+": ") {
+			t.Errorf("%s %q: error = %v, want %q", test.filename, test.data, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// EnvPrefix starts every environment variable that overrides a setting,
+// e.g. MEDIASTREAM_SERVER_PORT for server.port
+const EnvPrefix = "MEDIASTREAM_"
+
+// envAliases are shorter environment variable names for common settings
+var envAliases = map[string]string{
+	"MEDIASTREAM_ADDRESS":    "server.address",
+	"MEDIASTREAM_PORT":       "server.port",
+	"MEDIASTREAM_SOCKET":     "server.socket",
+	"MEDIASTREAM_BASE_PATH":  "server.basePath",
+	"MEDIASTREAM_TLS_CERT":   "server.tlsCert",
+	"MEDIASTREAM_TLS_KEY":    "server.tlsKey",
+	"MEDIASTREAM_LOG_LEVEL":  "logging.level",
+	"MEDIASTREAM_LOG_FORMAT": "logging.format",
+	"MEDIASTREAM_FFMPEG":     "previews.ffmpegPath",
+	"MEDIASTREAM_FFPROBE":    "previews.ffprobePath",
+}
+
+// envOptions are MEDIASTREAM_* variables that are not settings but read by the server itself
+var envOptions = map[string]bool{
+	"MEDIASTREAM_ENV":      true,
+	"MEDIASTREAM_DATA_DIR": true,
+	"MEDIASTREAM_CONFIG":   true,
+}
+
+// setting is a configurable field addressed by its dotted JSON path
+type setting struct {
+	path  string
+	value reflect.Value
+}
+
+// settings lists every setting of a configuration, descending into nested sections.
+// Lists and maps such as libraries are a single setting holding JSON.
+func settings(cfg *Config) []setting {
+	var result []setting
+	collectSettings(reflect.ValueOf(cfg).Elem(), "", &result)
+	return result
+}
+
+// collectSettings appends the settings of a struct whose fields are prefixed by prefix
+func collectSettings(v reflect.Value, prefix string, result *[]setting) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" || name == "mediaFolders" {
+			continue
+		}
+
+		path := prefix + name
+		if field.Type.Kind() == reflect.Struct {
+			collectSettings(v.Field(i), path+".", result)
+			continue
+		}
+		*result = append(*result, setting{path: path, value: v.Field(i)})
+	}
+}
+
+// EnvName returns the environment variable for a setting, e.g. MEDIASTREAM_SERVER_BASE_PATH for server.basePath
+func EnvName(path string) string {
+	var name strings.Builder
+	name.WriteString(EnvPrefix)
+	for i, r := range path {
+		switch {
+		case r == '.':
+			name.WriteRune('_')
+		case unicode.IsUpper(r) && i > 0 && path[i-1] != '.':
+			name.WriteRune('_')
+			name.WriteRune(r)
+		default:
+			name.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return name.String()
+}
+
+// SettingNames lists the dotted paths of every setting
+func SettingNames() []string {
+	var names []string
+	for _, s := range settings(DefaultConfig()) {
+		names = append(names, s.path)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set changes one setting from its text form. Numbers and booleans are parsed,
+// lists and maps are given as JSON.
+func Set(cfg *Config, path, value string) error {
+	for _, s := range settings(cfg) {
+		if !strings.EqualFold(s.path, path) {
+			continue
+		}
+
+		switch s.value.Kind() {
+		case reflect.String:
+			s.value.SetString(value)
+		case reflect.Int:
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return fmt.Errorf("%s must be a whole number, got %q", s.path, value)
+			}
+			s.value.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(strings.TrimSpace(value))
+			if err != nil {
+				return fmt.Errorf("%s must be true or false, got %q", s.path, value)
+			}
+			s.value.SetBool(b)
+		default:
+			parsed := reflect.New(s.value.Type())
+			if err := json.Unmarshal([]byte(value), parsed.Interface()); err != nil {
+				return fmt.Errorf("%s must be JSON: %v", s.path, describeJSONError(err, []byte(value)))
+			}
+			s.value.Set(parsed.Elem())
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Unknown setting %q", path)
+}
+
+// UnknownEnv lists MEDIASTREAM_* environment variables that don't match any setting, e.g. typos
+func UnknownEnv() []string {
+	known := map[string]bool{}
+	for _, name := range SettingNames() {
+		known[EnvName(name)] = true
+	}
+
+	var unknown []string
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if strings.HasPrefix(name, EnvPrefix) && !known[name] && envAliases[name] == "" && !envOptions[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// ApplyOverrides applies MEDIASTREAM_* environment variables and then path=value settings,
+// e.g. from command-line flags, on top of a configuration and validates the result
+func ApplyOverrides(cfg *Config, assignments []string) error {
+	for _, s := range settings(cfg) {
+		if value, ok := os.LookupEnv(EnvName(s.path)); ok {
+			if err := Set(cfg, s.path, value); err != nil {
+				return fmt.Errorf("%s: %v", EnvName(s.path), err)
+			}
+		}
+	}
+
+	aliases := make([]string, 0, len(envAliases))
+	for name := range envAliases {
+		aliases = append(aliases, name)
+	}
+	sort.Strings(aliases)
+	for _, name := range aliases {
+		if value, ok := os.LookupEnv(name); ok {
+			if err := Set(cfg, envAliases[name], value); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+
+	for _, assignment := range assignments {
+		path, value, found := strings.Cut(assignment, "=")
+		if !found {
+			return fmt.Errorf("Setting %q must have the form name=value", assignment)
+		}
+		if err := Set(cfg, strings.TrimSpace(path), value); err != nil {
+			return err
+		}
+	}
+
+	cfg.Server.BasePath = NormalizeBasePath(cfg.Server.BasePath)
+	return cfg.Validate()
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"scanIntervalMinutes":      "MEDIASTREAM_SCAN_INTERVAL_MINUTES",
+		"libraries":                "MEDIASTREAM_LIBRARIES",
+		"server.port":              "MEDIASTREAM_SERVER_PORT",
+		"server.basePath":          "MEDIASTREAM_SERVER_BASE_PATH",
+		"server.tlsCert":           "MEDIASTREAM_SERVER_TLS_CERT",
+		"server.trustedProxies":    "MEDIASTREAM_SERVER_TRUSTED_PROXIES",
+		"passwordPolicy.minLength": "MEDIASTREAM_PASSWORD_POLICY_MIN_LENGTH",
+		"previews.ffmpegPath":      "MEDIASTREAM_PREVIEWS_FFMPEG_PATH",
+		"logging.level":            "MEDIASTREAM_LOGGING_LEVEL",
+	}
+	for path, want := range tests {
+		if got := EnvName(path); got != want {
+			t.Errorf("EnvName(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	// Every setting has its own variable
+	seen := map[string]string{}
+	for _, path := range SettingNames() {
+		name := EnvName(path)
+		if other, ok := seen[name]; ok {
+			t.Errorf("%s and %s share %s", other, path, name)
+		}
+		seen[name] = path
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		path, value string
+		get         func(*Config) any
+		want        any
+		err         string
+	}{
+		{"server.port", "9000", func(c *Config) any { return c.Server.Port }, 9000, ""},
+		{"SERVER.PORT", " 9001 ", func(c *Config) any { return c.Server.Port }, 9001, ""},
+		{"server.port", "90.5", nil, nil, "server.port must be a whole number"},
+		{"logging.level", "debug", func(c *Config) any { return c.Logging.Level }, "debug", ""},
+		{"server.address", "", func(c *Config) any { return c.Server.Address }, "", ""},
+		{"passwordPolicy.requireDigit", "true", func(c *Config) any { return c.PasswordPolicy.RequireDigit }, true, ""},
+		{"passwordPolicy.requireDigit", "0", func(c *Config) any { return c.PasswordPolicy.RequireDigit }, false, ""},
+		{"passwordPolicy.requireDigit", "yes", nil, nil, "passwordPolicy.requireDigit must be true or false"},
+		{"server.trustedProxies", `["10.0.0.0/8", "::1"]`, func(c *Config) any { return c.Server.TrustedProxies }, []string{"10.0.0.0/8", "::1"}, ""},
+		{"server.trustedProxies", "10.0.0.0/8", nil, nil, "server.trustedProxies must be JSON"},
+		{"logging.subsystems", `{"scanner": "debug"}`, func(c *Config) any { return c.Logging.Subsystems }, map[string]string{"scanner": "debug"}, ""},
+		{"libraries", `[{"id": "a", "name": "A", "kind": "movies", "paths": ["/a"]}]`, func(c *Config) any { return c.Libraries },
+			[]Library{{ID: "a", Name: "A", Kind: KindMovies, Paths: []string{"/a"}}}, ""},
+		{"libraries", `[{"id": 1}]`, nil, nil, "libraries must be JSON"},
+		{"nope", "1", nil, nil, `Unknown setting "nope"`},
+		{"server", "{}", nil, nil, "Unknown setting"},
+		{"mediaFolders", "[]", nil, nil, "Unknown setting"},
+	}
+
+	for _, test := range tests {
+		cfg := DefaultConfig()
+		err := Set(cfg, test.path, test.value)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Set(%s, %q) = %v, want %q", test.path, test.value, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Set(%s, %q) = %v", test.path, test.value, err)
+			continue
+		}
+		if got := test.get(cfg); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Set(%s, %q) set %#v, want %#v", test.path, test.value, got, test.want)
+		}
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		assignments []string
+		check       func(*Config) bool
+		err         string
+	}{
+		{"environment", map[string]string{"MEDIASTREAM_SERVER_PORT": "9000", "MEDIASTREAM_LOGGING_FORMAT": "json"}, nil,
+			func(c *Config) bool { return c.Server.Port == 9000 && c.Logging.Format == "json" }, ""},
+		{"alias after the full name", map[string]string{"MEDIASTREAM_SERVER_PORT": "9000", "MEDIASTREAM_PORT": "9100"}, nil,
+			func(c *Config) bool { return c.Server.Port == 9100 }, ""},
+		{"assignments last", map[string]string{"MEDIASTREAM_PORT": "9100"}, []string{"server.port=9200", "scanIntervalMinutes = 5"},
+			func(c *Config) bool { return c.Server.Port == 9200 && c.ScanIntervalMinutes == 5 }, ""},
+		{"value with an equals sign", nil, []string{"logging.subsystems={\"a=b\": \"debug\"}"},
+			func(c *Config) bool { return c.Logging.Subsystems["a=b"] == "debug" }, ""},
+		{"base path normalized", map[string]string{"MEDIASTREAM_BASE_PATH": "media/"}, nil,
+			func(c *Config) bool { return c.Server.BasePath == "/media" }, ""},
+		{"invalid environment", map[string]string{"MEDIASTREAM_SERVER_PORT": "http"}, nil, nil, "MEDIASTREAM_SERVER_PORT: server.port must be a whole number"},
+		{"invalid alias", map[string]string{"MEDIASTREAM_PORT": "http"}, nil, nil, "MEDIASTREAM_PORT: server.port must be a whole number"},
+		{"missing value", nil, []string{"server.port"}, nil, "must have the form name=value"},
+		{"unknown setting", nil, []string{"server.prot=1"}, nil, `Unknown setting "server.prot"`},
+		{"invalid result", nil, []string{"server.port=0"}, nil, "server.port must be between 1 and 65535"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			cfg := DefaultConfig()
+			err := ApplyOverrides(cfg, test.assignments)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.check(cfg) {
+				t.Errorf("config after overrides = %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestUnknownEnv(t *testing.T) {
+	t.Setenv("MEDIASTREAM_SERVER_PROT", "1")
+	t.Setenv("MEDIASTREAM_SERVER_PORT", "1")
+	t.Setenv("MEDIASTREAM_PORT", "1")
+	t.Setenv("MEDIASTREAM_DATA_DIR", "/tmp")
+	if unknown := UnknownEnv(); !slices.Equal(unknown, []string{"MEDIASTREAM_SERVER_PROT"}) {
+		t.Errorf("UnknownEnv() = %v", unknown)
+	}
+}
@@ -0,0 +1,238 @@
+package config
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Library kinds
+const (
+	KindMovies     = "movies"
+	KindShows      = "shows"
+	KindMusic      = "music"
+	KindPhotos     = "photos"
+	KindHomeVideos = "homevideos"
+	KindMixed      = "mixed"
+)
+
+// LibraryKinds lists every supported library kind
+var LibraryKinds = []string{KindMovies, KindShows, KindMusic, KindPhotos, KindHomeVideos, KindMixed}
+
+// Library is a named collection of media made up of one or more root folders
+type Library struct {
+	ID      string         `json:"id"`
+	Name    string         `json:"name"`
+	Kind    string         `json:"kind"`
+	Paths   []string       `json:"paths"`
+	Options ScannerOptions `json:"options"`
+}
+
+// ScannerOptions tune how a library's folders are scanned
+type ScannerOptions struct {
+	MediaTypes    []string `json:"mediaTypes,omitempty"`    // Overrides which of video, audio and image the kind picks up
+	IncludeHidden bool     `json:"includeHidden,omitempty"` // Scan files and folders starting with a dot
+	Exclude       []string `json:"exclude,omitempty"`       // Glob patterns of file and folder names to skip
+	MaxDepth      int      `json:"maxDepth,omitempty"`      // Limit folder recursion, 0 means unlimited
+	WriteNFO      bool     `json:"writeNfo,omitempty"`      // Write edited metadata back to NFO files, e.g. for Kodi
+
+	MetadataProviders []string            `json:"metadataProviders,omitempty"` // Providers asked for metadata, highest priority first
+	MetadataPriority  map[string][]string `json:"metadataPriority,omitempty"`  // Provider order for single fields, e.g. {"plot": ["tmdb", "local"]}
+}
+
+// MediaFolder represents a media library folder in the legacy configuration format
+type MediaFolder struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+}
+
+// libraryIDPattern restricts library IDs to URL-safe slugs
+var libraryIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// IsValidKind reports whether kind is a supported library kind
+func IsValidKind(kind string) bool {
+	for _, k := range LibraryKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// MediaTypes returns the media types the library picks up, based on its kind unless overridden
+func (l *Library) MediaTypes() []string {
+	if len(l.Options.MediaTypes) > 0 {
+		return l.Options.MediaTypes
+	}
+
+	switch l.Kind {
+	case KindMovies, KindShows, KindHomeVideos:
+		return []string{"video"}
+	case KindMusic:
+		return []string{"audio"}
+	case KindPhotos:
+		return []string{"image"}
+	default:
+		return []string{"video", "audio", "image"}
+	}
+}
+
+// Validate checks a library definition and returns a user-facing error
+func (l *Library) Validate() error {
+	if !libraryIDPattern.MatchString(l.ID) {
+		return fmt.Errorf("Library ID %q must be lowercase letters, digits, '-' or '_'", l.ID)
+	}
+	if strings.TrimSpace(l.Name) == "" {
+		return fmt.Errorf("Library %s needs a name", l.ID)
+	}
+	if !IsValidKind(l.Kind) {
+		return fmt.Errorf("Library %s has unknown kind %q (expected one of %s)", l.ID, l.Kind, strings.Join(LibraryKinds, ", "))
+	}
+	if len(l.Paths) == 0 {
+		return fmt.Errorf("Library %s needs at least one path", l.ID)
+	}
+	roots := map[string]bool{}
+	for _, path := range l.Paths {
+		if strings.TrimSpace(path) == "" {
+			return fmt.Errorf("Library %s has an empty path", l.ID)
+		}
+		if roots[RootKey(path)] {
+			return fmt.Errorf("Library %s lists the path %s twice", l.ID, path)
+		}
+		roots[RootKey(path)] = true
+	}
+	for _, mediaType := range l.Options.MediaTypes {
+		if mediaType != "video" && mediaType != "audio" && mediaType != "image" {
+			return fmt.Errorf("Library %s has unknown media type %q", l.ID, mediaType)
+		}
+	}
+	for _, pattern := range l.Options.Exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Library %s has an invalid exclude pattern %q", l.ID, pattern)
+		}
+	}
+	if l.Options.MaxDepth < 0 {
+		return fmt.Errorf("Library %s has a negative maxDepth", l.ID)
+	}
+	return nil
+}
+
+// RootKey returns a short stable identifier for a library root folder.
+// It is derived from the path so reordering a library's roots doesn't change media IDs.
+func RootKey(path string) string {
+	sum := sha1.Sum([]byte(filepath.Clean(path)))
+	return hex.EncodeToString(sum[:4])
+}
+
+// FindRoot returns the root folder of the library with the given key
+func (l *Library) FindRoot(key string) (string, bool) {
+	for _, path := range l.Paths {
+		if RootKey(path) == key {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// FindLibrary finds a library by ID
+func (c *Config) FindLibrary(id string) *Library {
+	for i := range c.Libraries {
+		if c.Libraries[i].ID == id {
+			return &c.Libraries[i]
+		}
+	}
+	return nil
+}
+
+// ValidateLibraries checks every library and that their IDs are unique
+func (c *Config) ValidateLibraries() error {
+	seen := map[string]bool{}
+	for i := range c.Libraries {
+		if err := c.Libraries[i].Validate(); err != nil {
+			return err
+		}
+		if seen[c.Libraries[i].ID] {
+			return errors.New("Duplicate library ID: " + c.Libraries[i].ID)
+		}
+		seen[c.Libraries[i].ID] = true
+	}
+	return nil
+}
+
+// NewLibraryID derives an unused library ID from a display name
+func (c *Config) NewLibraryID(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteRune('-')
+		}
+	}
+
+	base := strings.TrimSuffix(b.String(), "-")
+	if base == "" {
+		base = "library"
+	}
+
+	id := base
+	for n := 2; c.FindLibrary(id) != nil; n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+	return id
+}
+
+// librariesFromMediaFolders converts the legacy one-folder-per-type configuration
+func librariesFromMediaFolders(folders []MediaFolder) []Library {
+	libraries := []Library{}
+	for _, folder := range folders {
+		kind := folder.Type
+		switch folder.Type {
+		case "tvshows":
+			kind = KindShows
+		case KindMovies, KindMusic, KindPhotos, KindHomeVideos:
+		default:
+			kind = KindMixed
+		}
+
+		// A second folder of the same type becomes another root of the same library
+		merged := false
+		for i := range libraries {
+			if libraries[i].ID == folder.Type {
+				libraries[i].Paths = append(libraries[i].Paths, folder.Path)
+				merged = true
+				break
+			}
+		}
+		if merged {
+			continue
+		}
+
+		libraries = append(libraries, Library{
+			ID:    folder.Type,
+			Name:  libraryDisplayName(folder.Type),
+			Kind:  kind,
+			Paths: []string{folder.Path},
+		})
+	}
+	return libraries
+}
+
+// libraryDisplayName returns the default display name for a legacy library type
+func libraryDisplayName(libraryType string) string {
+	switch libraryType {
+	case "tvshows":
+		return "TV Shows"
+	case "":
+		return ""
+	}
+	r := []rune(libraryType)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// LoggingConfig controls what the server logs and how
+type LoggingConfig struct {
+	Level      string            `json:"level"`                // debug, info, warn or error
+	Format     string            `json:"format"`               // text or json
+	Subsystems map[string]string `json:"subsystems,omitempty"` // Level overrides by subsystem, e.g. {"scanner": "debug"}
+}
+
+// DefaultLoggingConfig returns the logging settings used when none are configured
+func DefaultLoggingConfig() LoggingConfig {
+	return LoggingConfig{Level: "info", Format: "text"}
+}
+
+// ParseLevel converts a level name such as "warn" to a log level
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+}
+
+// Validate checks the logging settings and returns a user-facing error
+func (l LoggingConfig) Validate() error {
+	if _, err := ParseLevel(l.Level); err != nil {
+		return fmt.Errorf("logging.level: %v", err)
+	}
+	if l.Format != "text" && l.Format != "json" {
+		return fmt.Errorf("logging.format must be text or json, got %q", l.Format)
+	}
+
+	subsystems := make([]string, 0, len(l.Subsystems))
+	for subsystem := range l.Subsystems {
+		subsystems = append(subsystems, subsystem)
+	}
+	sort.Strings(subsystems)
+	for _, subsystem := range subsystems {
+		if _, err := ParseLevel(l.Subsystems[subsystem]); err != nil {
+			return fmt.Errorf("logging.subsystems.%s: %v", subsystem, err)
+		}
+	}
+	return nil
+}
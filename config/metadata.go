@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// LocalProvider is the built-in metadata provider reading NFO files and file names.
+// It is the only provider a library uses unless configured otherwise.
+const LocalProvider = "local"
+
+// Metadata provider types
+const (
+	ProviderHTTP = "http"
+)
+
+// DefaultMetadataCacheHours is how long results of online providers are kept unless configured otherwise
+const DefaultMetadataCacheHours = 24 * 7
+
+// MetadataProviderConfig defines an online metadata provider that libraries can add to their chain
+type MetadataProviderConfig struct {
+	Name           string `json:"name"`
+	Type           string `json:"type"` // http
+	URL            string `json:"url"`
+	APIKey         string `json:"apiKey,omitempty"`
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty"` // Per request, 10 by default
+	CacheHours     int    `json:"cacheHours,omitempty"`     // How long results are cached, a week by default
+}
+
+// FindMetadataProvider finds a configured metadata provider by name
+func (c *Config) FindMetadataProvider(name string) *MetadataProviderConfig {
+	for i := range c.MetadataProviders {
+		if c.MetadataProviders[i].Name == name {
+			return &c.MetadataProviders[i]
+		}
+	}
+	return nil
+}
+
+// MetadataChain returns the providers a library asks for metadata, highest priority first
+func (l *Library) MetadataChain() []string {
+	if len(l.Options.MetadataProviders) > 0 {
+		return l.Options.MetadataProviders
+	}
+	return []string{LocalProvider}
+}
+
+// validateMetadataProviders checks the provider definitions and every library's chain
+func (c *Config) validateMetadataProviders() error {
+	names := map[string]bool{LocalProvider: true}
+	for _, provider := range c.MetadataProviders {
+		if !libraryIDPattern.MatchString(provider.Name) {
+			return fmt.Errorf("Metadata provider name %q must be lowercase letters, digits, '-' or '_'", provider.Name)
+		}
+		if names[provider.Name] {
+			return fmt.Errorf("Duplicate metadata provider name: %s", provider.Name)
+		}
+		names[provider.Name] = true
+
+		if provider.Type != ProviderHTTP {
+			return fmt.Errorf("Metadata provider %s has unknown type %q (expected %s)", provider.Name, provider.Type, ProviderHTTP)
+		}
+		u, err := url.Parse(provider.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Metadata provider %s needs an http or https URL", provider.Name)
+		}
+		if provider.TimeoutSeconds < 0 || provider.CacheHours < 0 {
+			return fmt.Errorf("Metadata provider %s has a negative timeout or cache duration", provider.Name)
+		}
+	}
+
+	for _, library := range c.Libraries {
+		for _, name := range library.Options.MetadataProviders {
+			if !names[name] {
+				return fmt.Errorf("Library %s uses unknown metadata provider %q", library.ID, name)
+			}
+		}
+		for field, chain := range library.Options.MetadataPriority {
+			if !isMetadataField(field) {
+				return fmt.Errorf("Library %s sets the priority of unknown metadata field %q", library.ID, field)
+			}
+			for _, name := range chain {
+				if !names[name] {
+					return fmt.Errorf("Library %s uses unknown metadata provider %q for %s", library.ID, name, field)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// MetadataFields are the metadata fields whose provider priority can be set per library
+var MetadataFields = []string{"title", "originalTitle", "sortTitle", "year", "runtime", "plot", "genres", "cast", "contentRating", "ratings", "artwork"}
+
+// isMetadataField reports whether name is one of MetadataFields
+func isMetadataField(name string) bool {
+	for _, field := range MetadataFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
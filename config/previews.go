@@ -0,0 +1,75 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PreviewConfig controls the poster frames and trickplay thumbnails generated for videos
+type PreviewConfig struct {
+	Enabled         bool   `json:"enabled"`               // Generate previews in the background after scans
+	FFmpegPath      string `json:"ffmpegPath,omitempty"`  // ffmpeg binary, found on the PATH if empty
+	FFprobePath     string `json:"ffprobePath,omitempty"` // ffprobe binary, found on the PATH if empty
+	IntervalSeconds int    `json:"intervalSeconds"`       // Time between trickplay thumbnails
+	Width           int    `json:"width"`                 // Width of trickplay thumbnails
+	Columns         int    `json:"columns"`               // Thumbnails per row and rows per sprite sheet
+	MaxConcurrent   int    `json:"maxConcurrent"`         // Videos processed at once
+	MaxPerHour      int    `json:"maxPerHour"`            // Videos started per hour, 0 for no limit
+	TimeoutMinutes  int    `json:"timeoutMinutes"`        // Longest ffmpeg may take for one video
+}
+
+// DefaultPreviewConfig returns the preview settings used when none are configured
+func DefaultPreviewConfig() PreviewConfig {
+	return PreviewConfig{
+		IntervalSeconds: 10,
+		Width:           240,
+		Columns:         10,
+		MaxConcurrent:   1,
+		TimeoutMinutes:  30,
+	}
+}
+
+// FFmpeg returns the ffmpeg binary to run
+func (p PreviewConfig) FFmpeg() string {
+	if p.FFmpegPath == "" {
+		return "ffmpeg"
+	}
+	return p.FFmpegPath
+}
+
+// FFprobe returns the ffprobe binary to run
+func (p PreviewConfig) FFprobe() string {
+	if p.FFprobePath == "" {
+		return "ffprobe"
+	}
+	return p.FFprobePath
+}
+
+// SameBinaries reports whether two preview settings run the same ffmpeg and ffprobe. The binaries
+// run on the server, so they may only be set in the config file, by flags or by the environment.
+func (p PreviewConfig) SameBinaries(other PreviewConfig) bool {
+	return p.FFmpegPath == other.FFmpegPath && p.FFprobePath == other.FFprobePath
+}
+
+// Validate checks the preview settings and returns a user-facing error
+func (p PreviewConfig) Validate() error {
+	if p.IntervalSeconds < 1 {
+		return errors.New("previews.intervalSeconds must be at least 1")
+	}
+	if p.Width < 32 || p.Width > 1920 {
+		return fmt.Errorf("previews.width must be between 32 and 1920, got %d", p.Width)
+	}
+	if p.Columns < 1 || p.Columns > 20 {
+		return fmt.Errorf("previews.columns must be between 1 and 20, got %d", p.Columns)
+	}
+	if p.MaxConcurrent < 1 {
+		return errors.New("previews.maxConcurrent must be at least 1")
+	}
+	if p.MaxPerHour < 0 {
+		return errors.New("previews.maxPerHour must not be negative")
+	}
+	if p.TimeoutMinutes < 1 {
+		return errors.New("previews.timeoutMinutes must be at least 1")
+	}
+	return nil
+}
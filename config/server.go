@@ -0,0 +1,89 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ServerConfig describes where and how the server listens
+type ServerConfig struct {
+	Address  string `json:"address"`            // Interface to bind to, empty for all interfaces
+	Port     int    `json:"port"`               // TCP port, ignored when listening on a socket
+	Socket   string `json:"socket,omitempty"`   // Unix socket path, replaces address and port
+	BasePath string `json:"basePath,omitempty"` // URL prefix when hosted below a reverse proxy path, e.g. /media
+	TLSCert  string `json:"tlsCert,omitempty"`  // Certificate file, enables HTTPS together with TLSKey
+	TLSKey   string `json:"tlsKey,omitempty"`
+
+	// Addresses or CIDR ranges of reverse proxies whose X-Forwarded-For header names the client.
+	// Without any, clients are identified by the address they connect from.
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+
+	ReadTimeoutSeconds     int `json:"readTimeoutSeconds"`     // Time to read a whole request, 0 for no limit
+	WriteTimeoutSeconds    int `json:"writeTimeoutSeconds"`    // Time to write a response, 0 for no limit so long streams aren't cut off
+	IdleTimeoutSeconds     int `json:"idleTimeoutSeconds"`     // How long keep-alive connections stay open
+	ShutdownTimeoutSeconds int `json:"shutdownTimeoutSeconds"` // How long active streams may finish on shutdown
+}
+
+// DefaultPort is the TCP port used unless configured otherwise
+const DefaultPort = 3000
+
+// DefaultServerConfig returns the server settings used when none are configured
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Port:                   DefaultPort,
+		ReadTimeoutSeconds:     60,
+		IdleTimeoutSeconds:     120,
+		ShutdownTimeoutSeconds: 30,
+	}
+}
+
+// NormalizeBasePath turns "media", "/media/" and "/media" into "/media" and "/" into ""
+func NormalizeBasePath(path string) string {
+	path = strings.Trim(strings.TrimSpace(path), "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
+
+// TLSEnabled reports whether the server serves HTTPS
+func (s ServerConfig) TLSEnabled() bool {
+	return s.TLSCert != "" && s.TLSKey != ""
+}
+
+// ListenAddress returns the host:port the server binds to
+func (s ServerConfig) ListenAddress() string {
+	return fmt.Sprintf("%s:%d", s.Address, s.Port)
+}
+
+// Validate checks the server settings and returns a user-facing error
+func (s ServerConfig) Validate() error {
+	if s.Socket == "" && (s.Port < 1 || s.Port > 65535) {
+		return fmt.Errorf("server.port must be between 1 and 65535, got %d", s.Port)
+	}
+
+	if s.BasePath != NormalizeBasePath(s.BasePath) {
+		return fmt.Errorf("server.basePath must start with a slash and not end with one, e.g. %q", NormalizeBasePath(s.BasePath))
+	}
+	if strings.ContainsAny(s.BasePath, "?#\"<> ") {
+		return errors.New("server.basePath contains invalid characters")
+	}
+
+	if s.ReadTimeoutSeconds < 0 || s.WriteTimeoutSeconds < 0 || s.IdleTimeoutSeconds < 0 || s.ShutdownTimeoutSeconds < 0 {
+		return errors.New("server timeouts must not be negative")
+	}
+
+	for _, proxy := range s.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return fmt.Errorf("server.trustedProxies must hold IP addresses or CIDR ranges, got %q", proxy)
+		}
+	}
+
+	if (s.TLSCert == "") != (s.TLSKey == "") {
+		return errors.New("server.tlsCert and server.tlsKey must be set together")
+	}
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package config
+
+import "testing"
+
+func TestTrustedProxies(t *testing.T) {
+	for proxies, valid := range map[string]bool{
+		"":                     true,
+		"127.0.0.1":            true,
+		"10.0.0.0/8":           true,
+		"::1":                  true,
+		"fd00::/8":             true,
+		"proxy.example.com":    false,
+		"10.0.0.0/33":          false,
+		"192.168.1.1/24,extra": false,
+	} {
+		s := DefaultServerConfig()
+		if proxies != "" {
+			s.TrustedProxies = []string{proxies}
+		}
+		if err := s.Validate(); (err == nil) != valid {
+			t.Errorf("trustedProxies %q: error %v, want valid %v", proxies, err, valid)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+)
+
+// Store holds the active configuration and persists changes to it.
+// Readers get an immutable snapshot, so a change never affects a request halfway through.
+type Store struct {
+	mu        sync.Mutex // Serializes updates and reloads
+	current   atomic.Pointer[Config]
+	saved     *Config                 // The configuration as stored in the file, without overrides
+	overrides func(cfg *Config) error // Settings that take precedence over the file, e.g. from flags
+	filename  string
+	listeners []func(old, new *Config)
+}
+
+// NewStore creates a store for a loaded configuration that saves to filename
+func NewStore(cfg *Config, filename string) *Store {
+	s := &Store{filename: filename, saved: cfg}
+	s.current.Store(cfg)
+	return s
+}
+
+// SetOverrides registers settings that are applied on top of the file but never saved to it
+func (s *Store) SetOverrides(fn func(cfg *Config) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.overrides = fn
+	cfg, err := s.effective(s.saved)
+	if err != nil {
+		return err
+	}
+	s.current.Store(cfg)
+	return nil
+}
+
+// Saved returns the configuration as stored in the file, which must not be modified
+func (s *Store) Saved() *Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saved
+}
+
+// effective applies the overrides to a copy of a saved configuration
+func (s *Store) effective(saved *Config) (*Config, error) {
+	if s.overrides == nil {
+		return saved, nil
+	}
+
+	cfg, err := saved.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.overrides(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, &ValidationError{Err: err}
+	}
+	return cfg, nil
+}
+
+// Get returns the active configuration, which must not be modified
+func (s *Store) Get() *Config {
+	return s.current.Load()
+}
+
+// OnChange registers a function that is called after the active configuration was replaced
+func (s *Store) OnChange(fn func(old, new *Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// Update applies fn to a copy of the active configuration, saves it and makes it active
+func (s *Store) Update(fn func(cfg *Config) error) (*Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg, err := s.saved.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fn(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, &ValidationError{Err: err}
+	}
+
+	active, err := s.effective(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := SaveConfig(cfg, s.filename); err != nil {
+		return nil, err
+	}
+
+	s.saved = cfg
+	s.swap(active)
+	return active, nil
+}
+
+// Reload reads the configuration file again and makes it active if it is valid
+func (s *Store) Reload() (*Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg, err := LoadConfig(s.filename)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := s.effective(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s.saved = cfg
+	s.swap(active)
+	return active, nil
+}
+
+// swap activates cfg and notifies listeners; the caller must hold s.mu
+func (s *Store) swap(cfg *Config) {
+	old := s.current.Swap(cfg)
+	for _, fn := range s.listeners {
+		fn(old, cfg)
+	}
+}
+
+// Clone returns a deep copy of the configuration
+func (c *Config) Clone() (*Config, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := &Config{}
+	if err := json.Unmarshal(data, clone); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
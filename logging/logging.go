@@ -0,0 +1,162 @@
+// Package logging provides the server's leveled, structured loggers.
+//
+// Every logger belongs to a subsystem (server, http, scanner, config) whose level can be
+// overridden in the configuration. Records logged with a context carrying a request ID
+// include it, so the log lines of one request or background job can be found together.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"mediastream/config"
+	"mediastream/utils"
+)
+
+var (
+	// output is the handler every logger writes through, replaced by Configure
+	output atomic.Pointer[slog.Handler]
+
+	levelsMu        sync.RWMutex
+	defaultLevel    = slog.LevelInfo
+	subsystemLevels = map[string]slog.Level{}
+)
+
+func init() {
+	setOutput("text")
+	slog.SetDefault(For("server"))
+}
+
+// setOutput writes every log record to stderr in the given format
+func setOutput(format string) {
+	// Levels are checked per subsystem before records get here
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	output.Store(&handler)
+}
+
+// Configure applies the logging settings. It can be called again when the configuration changes.
+func Configure(cfg config.LoggingConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	level, _ := config.ParseLevel(cfg.Level)
+	levels := map[string]slog.Level{}
+	for subsystem, name := range cfg.Subsystems {
+		levels[subsystem], _ = config.ParseLevel(name)
+	}
+
+	levelsMu.Lock()
+	defaultLevel = level
+	subsystemLevels = levels
+	levelsMu.Unlock()
+
+	setOutput(cfg.Format)
+	return nil
+}
+
+// levelFor returns the lowest level logged for a subsystem
+func levelFor(subsystem string) slog.Level {
+	levelsMu.RLock()
+	defer levelsMu.RUnlock()
+	if level, ok := subsystemLevels[subsystem]; ok {
+		return level
+	}
+	return defaultLevel
+}
+
+// For returns the logger of a subsystem
+func For(subsystem string) *slog.Logger {
+	return slog.New(&handler{subsystem: subsystem})
+}
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// NewRequestID returns a random ID for a request or background job
+func NewRequestID() string {
+	return utils.GenerateUniqueID()[:16]
+}
+
+// WithRequestID returns a context carrying a request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by a context, or an empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// BackgroundContext returns a context with a new request ID for work not started by a request,
+// such as periodic scans
+func BackgroundContext() context.Context {
+	return WithRequestID(context.Background(), NewRequestID())
+}
+
+// handler filters records by the level of its subsystem and adds the subsystem and request ID
+// before passing them to the current output
+type handler struct {
+	subsystem string
+	steps     []step // Attributes and groups added with With and WithGroup, in order
+}
+
+// step is either a group or attributes added to a handler
+type step struct {
+	group string
+	attrs []slog.Attr
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= levelFor(h.subsystem)
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	target := *output.Load()
+
+	attrs := []slog.Attr{slog.String("subsystem", h.subsystem)}
+	if ctx != nil {
+		if id := RequestID(ctx); id != "" {
+			attrs = append(attrs, slog.String("requestId", id))
+		}
+	}
+	target = target.WithAttrs(attrs)
+
+	for _, step := range h.steps {
+		if step.group != "" {
+			target = target.WithGroup(step.group)
+		} else {
+			target = target.WithAttrs(step.attrs)
+		}
+	}
+	return target.Handle(ctx, record)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(step{attrs: attrs})
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(step{group: name})
+}
+
+// with returns a copy of the handler with one more step
+func (h *handler) with(s step) slog.Handler {
+	steps := make([]step, len(h.steps), len(h.steps)+1)
+	copy(steps, h.steps)
+	return &handler{subsystem: h.subsystem, steps: append(steps, s)}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -14,57 +19,158 @@ import (
 	"golang.org/x/crypto/bcrypt"
 
 	"mediastream/config"
+	"mediastream/logging"
 	"mediastream/models"
 	"mediastream/routes"
 	"mediastream/utils"
+	"mediastream/version"
 )
 
 func main() {
-	// Check for dev mode
-	devMode := os.Getenv("MEDIASTREAM_ENV") == "development"
-
-	// Also check command line arguments for "dev"
-	for _, arg := range os.Args[1:] {
-		if arg == "dev" {
-			devMode = true
-			break
-		}
+	opts, args := parseOptions(os.Args[1:])
+
+	// Without a command the server is started
+	if len(args) == 0 {
+		serve(opts)
+		return
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q, run with -h for help\n", args[0])
+		os.Exit(2)
+	}
+	if err := command.run(opts, args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
+}
+
+// Loggers of the server process and its configuration
+var (
+	serverLog = logging.For("server")
+	configLog = logging.For("config")
+)
+
+// fatal logs an error that keeps the server from running and exits
+func fatal(msg string, err error) {
+	serverLog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// serve runs the media server until it fails
+func serve(opts *options) {
+	devMode := opts.devMode
 
 	// Set Gin mode based on dev flag
 	if devMode {
 		gin.SetMode(gin.DebugMode)
-		log.Println("Running in development mode (debug)")
+		serverLog.Info("Running in development mode (debug)")
 	} else {
 		gin.SetMode(gin.ReleaseMode)
-		log.Println("Running in release mode")
+		serverLog.Info("Running in release mode")
 	}
 
-	// Create default config
-	cfg, err := config.LoadConfig(config.ConfigFile)
+	// Load the config file, environment and flags; the store hands out the active config
+	// so library changes apply without a restart
+	configStore, err := loadConfigStore(opts)
 	if err != nil {
-		log.Fatalf("Error loading configuration: %v", err)
+		fatal("Error loading configuration", err)
 	}
+	cfg := configStore.Get()
+
+	for _, name := range config.UnknownEnv() {
+		configLog.Warn("Ignoring unknown environment variable", "name", name)
+	}
+
+	// Server settings only apply on startup, so they are pinned for the lifetime of the process
+	server := cfg.Server
+	if err := configStore.SetOverrides(func(cfg *config.Config) error {
+		if err := config.ApplyOverrides(cfg, opts.settings); err != nil {
+			return err
+		}
+		cfg.Server = server
+		return nil
+	}); err != nil {
+		fatal("Error loading configuration", err)
+	}
+	routes.SetBasePath(server.BasePath)
+
+	// Load the library index and keep it in line with configuration changes
+	index, err := models.LoadIndex(config.IndexFile)
+	if err != nil {
+		serverLog.Warn("Error loading library index, rescanning all libraries", "error", err)
+		index = models.NewIndex(config.IndexFile)
+	}
+
+	// Generate video previews after each scan, and for indexed videos on startup or once they are turned on
+	previews := models.NewPreviewGenerator(configStore, models.NewFFmpegExtractor)
+	index.OnScan(previews.QueueLibrary)
+
+	configStore.OnChange(func(old, new *config.Config) {
+		if err := logging.Configure(new.Logging); err != nil {
+			configLog.Error("Error applying logging settings", "error", err)
+		}
+		index.Sync(logging.BackgroundContext(), new)
+		if new.Previews.Enabled && !old.Previews.Enabled {
+			go previews.QueueIndexed(logging.BackgroundContext(), index, new)
+		}
+	})
+	index.Sync(logging.BackgroundContext(), cfg)
+	go previews.QueueIndexed(logging.BackgroundContext(), index, configStore.Get())
+
+	stopScans := make(chan struct{})
+	go index.RunPeriodicScans(configStore, stopScans)
+
+	// Reload the configuration on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			event := models.AuditEvent{
+				Time:    time.Now(),
+				Type:    models.AuditConfigChange,
+				Actor:   "system",
+				Target:  "config",
+				Success: true,
+				Details: map[string]string{"action": "reload", "source": "SIGHUP"},
+			}
+
+			if _, err := configStore.Reload(); err != nil {
+				configLog.Error("Error reloading configuration, keeping the active one", "error", err)
+				event.Success = false
+				event.Details["error"] = err.Error()
+			} else {
+				configLog.Info("Configuration reloaded")
+			}
+
+			if err := models.AppendAuditEvent(event, config.AuditFile); err != nil {
+				configLog.Error("Error writing audit log", "error", err)
+			}
+		}
+	}()
 
 	// Create media directories if they don't exist
 	// But skip creation if paths are likely external (Docker volumes, network shares, etc.)
-	for _, folder := range cfg.MediaFolders {
-		// Check if this is a Docker volume or external path that shouldn't be created locally
-		isExternal := strings.HasPrefix(folder.Path, "/mnt/") ||
-			strings.HasPrefix(folder.Path, "/media/") ||
-			strings.HasPrefix(folder.Path, "/volume") ||
-			strings.HasPrefix(folder.Path, "/data/")
-
-		if isExternal {
-			// Skip directory creation for external paths
-			log.Printf("External media path detected, skipping directory creation: %s", folder.Path)
-			continue
-		}
+	for _, library := range cfg.Libraries {
+		for _, path := range library.Paths {
+			// Check if this is a Docker volume or external path that shouldn't be created locally
+			isExternal := strings.HasPrefix(path, "/mnt/") ||
+				strings.HasPrefix(path, "/media/") ||
+				strings.HasPrefix(path, "/volume") ||
+				strings.HasPrefix(path, "/data/")
+
+			if isExternal {
+				// Skip directory creation for external paths
+				serverLog.Info("External media path detected, skipping directory creation", "path", path)
+				continue
+			}
 
-		if _, err := os.Stat(folder.Path); os.IsNotExist(err) {
-			err = os.MkdirAll(folder.Path, 0755)
-			if err != nil {
-				log.Printf("Failed to create media directory: %s: %v", folder.Path, err)
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				err = os.MkdirAll(path, 0755)
+				if err != nil {
+					serverLog.Error("Failed to create media directory", "path", path, "error", err)
+				}
 			}
 		}
 	}
@@ -74,7 +180,7 @@ func main() {
 		// Load existing users or create empty user array
 		users, err := models.LoadUsers(config.UsersFile)
 		if err != nil {
-			log.Printf("Error loading users: %v", err)
+			serverLog.Error("Error loading users", "error", err)
 			users = []models.User{}
 		}
 
@@ -83,7 +189,7 @@ func main() {
 			// Hash password
 			hashedPassword, err := bcrypt.GenerateFromPassword([]byte("dev"), bcrypt.DefaultCost)
 			if err != nil {
-				log.Printf("Error creating dev user: %v", err)
+				serverLog.Error("Error creating dev user", "error", err)
 			} else {
 				// Create dev user
 				users = append(users, models.User{
@@ -95,139 +201,233 @@ func main() {
 
 				// Save users to file
 				if err := models.SaveUsers(users, config.UsersFile); err != nil {
-					log.Printf("Error saving dev user: %v", err)
+					serverLog.Error("Error saving dev user", "error", err)
 				}
 
 				// Mark setup as completed
 				if err := config.MarkSetupCompleted(); err != nil {
-					log.Printf("Error marking setup as completed: %v", err)
+					serverLog.Error("Error marking setup as completed", "error", err)
 				}
 
-				log.Println("Created development user: username=dev, password=dev (admin)")
+				serverLog.Info("Created development user: username=dev, password=dev (admin)")
 			}
 		}
 	}
 
 	// Create Gin router
-	router := gin.Default()
+	router := gin.New()
+	// Only proxies in front of the server may say who the client is; otherwise anyone could
+	// dodge the login and PIN limits and forge audit records with X-Forwarded-For
+	if err := router.SetTrustedProxies(server.TrustedProxies); err != nil {
+		fatal("Error setting trusted proxies", err)
+	}
+	router.Use(routes.RequestID(), routes.LogRequests(), gin.Recovery(), routes.RecordMetrics())
 
 	// Setup sessions
 	store := cookie.NewStore([]byte("media-stream-secret"))
 	store.Options(sessions.Options{
-		Path:     "/",
+		Path:     routes.URL("/"),
 		MaxAge:   86400, // 1 day
 		HttpOnly: true,
 	})
 	router.Use(sessions.Sessions("mediastream", store))
 
+	// Serve everything below the base path
+	app := router.Group(server.BasePath)
+
 	// Setup static file server
-	router.Static("/static", "./public")
-	router.StaticFile("/style.css", "./public/style.css")
+	app.Static("/static", "./public")
+	app.StaticFile("/style.css", "./public/style.css")
+
+	// Probes and metrics, open to orchestrators without a login
+	app.GET("/healthz", routes.HandleHealth)
+	app.GET("/readyz", func(c *gin.Context) {
+		routes.HandleReady(c, configStore, index)
+	})
+	app.GET("/metrics", routes.HandleMetrics)
 
 	// Setup middleware for routes that need authentication
 	authMiddleware := routes.EnsureAuthenticated(cfg)
-	adminMiddleware := routes.EnsureAdmin()
 	setupMiddleware := routes.CheckSetup()
 
 	// Root route
-	router.GET("/", authMiddleware, func(c *gin.Context) {
-		c.File(filepath.Join("public", "index.html"))
+	app.GET("/", authMiddleware, func(c *gin.Context) {
+		routes.ServePage(c, "index.html")
 	})
 
 	// Setup route
-	router.GET("/setup", func(c *gin.Context) {
+	app.GET("/setup", func(c *gin.Context) {
 		if config.IsSetupCompleted() {
-			c.Redirect(http.StatusFound, "/")
+			c.Redirect(http.StatusFound, routes.URL("/"))
 			return
 		}
-		c.File(filepath.Join("public", "setup.html"))
+		routes.ServePage(c, "setup.html")
 	})
-	router.POST("/api/setup", routes.HandleSetup)
 
 	// Auth routes
-	router.GET("/login", setupMiddleware, func(c *gin.Context) {
+	app.GET("/login", setupMiddleware, func(c *gin.Context) {
 		if c.Request.URL.Query().Get("error") == "1" {
-			c.File(filepath.Join("public", "login.html"))
+			routes.ServePage(c, "login.html")
 			return
 		}
-		c.File(filepath.Join("public", "login.html"))
+		routes.ServePage(c, "login.html")
 	})
-	router.POST("/login", routes.HandleLogin)
-	router.GET("/logout", routes.HandleLogout)
-
-	// Admin routes
-	adminGroup := router.Group("/api/admin")
-	adminGroup.Use(authMiddleware, adminMiddleware)
-	{
-		adminGroup.GET("/users", routes.HandleGetUsers)
-		adminGroup.POST("/users", routes.HandleCreateUser)
-		adminGroup.DELETE("/users/:id", routes.HandleDeleteUser)
+	app.POST("/login", routes.HandleLogin)
+	app.GET("/logout", routes.HandleLogout)
+
+	// Invite page (the token in the link is the credential)
+	app.GET("/invite/:token", setupMiddleware, routes.HandleInvitePage)
+
+	// The API is served at /api/v1, and at /api for clients written before it was versioned.
+	// Only /api/v1 answers errors with the documented error envelope.
+	routes.RegisterAPI(app.Group("/api"), configStore, index, previews)
+	routes.RegisterAPI(app.Group(routes.APIPrefix), configStore, index, previews)
+	router.NoRoute(routes.HandleNotFound)
+	if err := routes.CheckAPIRoutes(router.Routes()); err != nil {
+		fatal("Invalid API routes", err)
 	}
 
-	// Media library routes
-	router.GET("/api/libraries", authMiddleware, func(c *gin.Context) {
-		routes.HandleGetLibraries(c, cfg)
-	})
-	router.GET("/api/library/:type", authMiddleware, func(c *gin.Context) {
-		routes.HandleGetLibrary(c, cfg)
-	})
-	router.GET("/api/media/:id", authMiddleware, func(c *gin.Context) {
-		routes.HandleGetMediaItem(c, cfg)
-	})
-	router.GET("/api/search", authMiddleware, func(c *gin.Context) {
-		routes.HandleSearch(c, cfg)
+	// Media streaming route, the path is relative to the library root folder identified by its key
+	app.GET("/stream/:library/:root/*path", authMiddleware, func(c *gin.Context) {
+		routes.HandleStreamMedia(c, configStore.Get())
 	})
 
-	// Debug endpoint to check media scanning
-	router.GET("/api/debug/scan", authMiddleware, func(c *gin.Context) {
-		var debugInfo []gin.H
+	app.GET("/script.js", authMiddleware, func(c *gin.Context) {
+		c.File("public/script.js")
+	})
 
-		for _, folder := range cfg.MediaFolders {
-			entries, err := os.ReadDir(folder.Path)
+	// Start server
+	listener, err := listen(server)
+	if err != nil {
+		fatal("Failed to start server", err)
+	}
 
-			entryList := []string{}
-			if err == nil {
-				for _, entry := range entries {
-					entryList = append(entryList, entry.Name())
-				}
-			}
+	httpServer := &http.Server{
+		Handler:           router,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       seconds(server.ReadTimeoutSeconds),
+		WriteTimeout:      seconds(server.WriteTimeoutSeconds),
+		IdleTimeout:       seconds(server.IdleTimeoutSeconds),
+	}
+	if server.TLSEnabled() {
+		certs, err := utils.NewCertReloader(server.TLSCert, server.TLSKey)
+		if err != nil {
+			fatal("Error loading TLS certificate", err)
+		}
+		httpServer.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+	}
 
-			items, _ := models.ScanDirectory(folder.Path, folder.Type, cfg)
+	serverLog.Info("Media server running", "url", serverURL(server), "version", version.Get().String())
+	for _, library := range cfg.Libraries {
+		serverLog.Info("Media library", "id", library.ID, "name", library.Name, "kind", library.Kind, "paths", strings.Join(library.Paths, ", "))
+	}
 
-			debugInfo = append(debugInfo, gin.H{
-				"type":      folder.Type,
-				"path":      folder.Path,
-				"entries":   entryList,
-				"itemCount": len(items),
-				"items":     items,
-				"error":     err != nil,
-			})
+	// Serve until the server fails or is asked to stop
+	serveErr := make(chan error, 1)
+	go func() {
+		if server.TLSEnabled() {
+			serveErr <- httpServer.ServeTLS(listener, "", "")
+		} else {
+			serveErr <- httpServer.Serve(listener)
 		}
+	}()
 
-		c.JSON(http.StatusOK, debugInfo)
-	})
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
-	// Media streaming routes - using different URL patterns to avoid conflicts
-	router.GET("/stream/file/:type/:filename", authMiddleware, func(c *gin.Context) {
-		routes.HandleStreamMedia(c, cfg)
-	})
-	router.GET("/stream/movie/:type/:folder/:filename", authMiddleware, func(c *gin.Context) {
-		routes.HandleStreamMediaWithFolder(c, cfg)
-	})
+	select {
+	case err := <-serveErr:
+		fatal("Failed to start server", err)
+	case sig := <-stop:
+		serverLog.Info("Shutting down", "signal", sig.String())
+	}
 
-	router.GET("/script.js", authMiddleware, func(c *gin.Context) {
-		c.File("public/script.js")
-	})
+	shutdown(httpServer, index, previews, stopScans, seconds(server.ShutdownTimeoutSeconds))
+}
 
-	// Start server
-	port := 3000
-	log.Printf("Media server running at http://localhost:%d", port)
-	log.Println("Media directories:")
-	for _, folder := range cfg.MediaFolders {
-		log.Printf("- %s: %s", folder.Type, folder.Path)
+// readHeaderTimeout limits how long a client may take to send request headers
+const readHeaderTimeout = 10 * time.Second
+
+// seconds converts a timeout setting to a duration
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// shutdown stops accepting connections and lets active requests and streams finish until the
+// timeout, then stops background scans and saves the library index
+func shutdown(httpServer *http.Server, index *models.Index, previews *models.PreviewGenerator, stopScans chan struct{}, timeout time.Duration) {
+	if streams := routes.ActiveStreams(); streams > 0 {
+		serverLog.Info("Waiting for active streams to finish", "streams", streams, "timeout", timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		serverLog.Warn("Closing remaining streams", "streams", routes.ActiveStreams(), "error", err)
+		httpServer.Close()
+	}
+
+	// Scans can't be interrupted halfway, so give running ones the same time to finish
+	close(stopScans)
+	scansDone := make(chan struct{})
+	go func() {
+		index.Wait()
+		close(scansDone)
+	}()
+	select {
+	case <-scansDone:
+	case <-time.After(timeout):
+		serverLog.Warn("Library scans did not finish in time, their results are discarded")
+	}
+
+	// Unfinished previews are generated again after the next start
+	previews.Stop()
+
+	if err := index.Save(); err != nil {
+		serverLog.Error("Error saving library index", "error", err)
+	}
+
+	serverLog.Info("Server stopped")
+}
+
+// listen opens the Unix socket or TCP port configured for the server
+func listen(server config.ServerConfig) (net.Listener, error) {
+	if server.Socket == "" {
+		return net.Listen("tcp", server.ListenAddress())
+	}
+
+	// Remove a socket left behind by a previous run
+	if info, err := os.Stat(server.Socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(server.Socket)
+	}
+
+	listener, err := net.Listen("unix", server.Socket)
+	if err != nil {
+		return nil, err
+	}
+
+	// Let a reverse proxy in the same group connect
+	if err := os.Chmod(server.Socket, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// serverURL describes where the server can be reached for the startup log
+func serverURL(server config.ServerConfig) string {
+	if server.Socket != "" {
+		return "unix:" + server.Socket + " (base path " + routes.URL("/") + ")"
+	}
+
+	scheme := "http"
+	if server.TLSEnabled() {
+		scheme = "https"
 	}
 
-	if err := router.Run(fmt.Sprintf(":%d", port)); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	host := server.Address
+	if host == "" {
+		host = "localhost"
 	}
+	return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, strconv.Itoa(server.Port)), routes.URL("/"))
 }
@@ -0,0 +1,279 @@
+// Package metrics keeps counters, gauges and histograms and writes them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric is anything that can write its samples
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+)
+
+// register adds a metric to the output of WriteAll
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, m)
+}
+
+// WriteAll writes every registered metric in the Prometheus text format
+func WriteAll(w io.Writer) {
+	registryMu.Lock()
+	metrics := append([]metric(nil), registry...)
+	registryMu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// vec holds what every metric has: its name, help text and label names
+type vec struct {
+	name   string
+	help   string
+	labels []string
+}
+
+// key joins label values into a map key
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s needs %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// Escaping of HELP text and of label values in the text format, which unlike Go strings
+// keeps every other character as it is
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// header writes the HELP and TYPE lines
+func (v *vec) header(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, helpEscaper.Replace(v.help), v.name, kind)
+}
+
+// labelString formats label pairs as {a="x",b="y"}, adding any extra pairs
+func (v *vec) labelString(key string, extra ...string) string {
+	var pairs []string
+	if len(v.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, v.labels[i]+`="`+labelEscaper.Replace(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+labelEscaper.Replace(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// sortedKeys returns the keys of a map of samples in a stable order
+func sortedKeys[T any](values map[string]T) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatValue formats a sample value the way Prometheus expects
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Counter is a value that only goes up, one per combination of label values
+type Counter struct {
+	vec
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter registers a counter with the given label names
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: vec{name: name, help: help, labels: labels}, values: map[string]float64{}}
+	register(c)
+	return c
+}
+
+// Inc adds one to the counter for the label values
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds a non-negative amount to the counter for the label values
+func (c *Counter) Add(amount float64, labels ...string) {
+	if amount < 0 {
+		return
+	}
+	key := c.key(labels)
+	c.mu.Lock()
+	c.values[key] += amount
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w, "counter")
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(key), formatValue(c.values[key]))
+	}
+}
+
+// Gauge is a value that goes up and down, one per combination of label values
+type Gauge struct {
+	vec
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewGauge registers a gauge with the given label names
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec: vec{name: name, help: help, labels: labels}, values: map[string]float64{}}
+	if len(labels) == 0 {
+		// Unlabelled gauges are reported even before they are first set
+		g.values[""] = 0
+	}
+	register(g)
+	return g
+}
+
+// Set sets the gauge for the label values
+func (g *Gauge) Set(value float64, labels ...string) {
+	key := g.key(labels)
+	g.mu.Lock()
+	g.values[key] = value
+	g.mu.Unlock()
+}
+
+// Add changes the gauge for the label values by amount, which may be negative
+func (g *Gauge) Add(amount float64, labels ...string) {
+	key := g.key(labels)
+	g.mu.Lock()
+	g.values[key] += amount
+	g.mu.Unlock()
+}
+
+// Delete removes the gauge for the label values, e.g. for a removed library
+func (g *Gauge) Delete(labels ...string) {
+	key := g.key(labels)
+	g.mu.Lock()
+	delete(g.values, key)
+	g.mu.Unlock()
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(w, "gauge")
+	for _, key := range sortedKeys(g.values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelString(key), formatValue(g.values[key]))
+	}
+}
+
+// GaugeFunc is an unlabelled gauge whose value is read when metrics are written
+type GaugeFunc struct {
+	vec
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge that reports the result of value
+func NewGaugeFunc(name, help string, value func() float64) *GaugeFunc {
+	g := &GaugeFunc{vec: vec{name: name, help: help}, value: value}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.header(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.value()))
+}
+
+// DefaultBuckets suit request latencies in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogramSample counts observations for one combination of label values
+type histogramSample struct {
+	counts []uint64 // One per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// Histogram counts observations in buckets, one set per combination of label values
+type Histogram struct {
+	vec
+	buckets []float64
+	mu      sync.Mutex
+	samples map[string]*histogramSample
+}
+
+// NewHistogram registers a histogram with the given upper bucket bounds and label names
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		vec:     vec{name: name, help: help, labels: labels},
+		buckets: append([]float64(nil), buckets...),
+		samples: map[string]*histogramSample{},
+	}
+	sort.Float64s(h.buckets)
+	register(h)
+	return h
+}
+
+// Observe records a value for the label values
+func (h *Histogram) Observe(value float64, labels ...string) {
+	key := h.key(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sample, ok := h.samples[key]
+	if !ok {
+		sample = &histogramSample{counts: make([]uint64, len(h.buckets))}
+		h.samples[key] = sample
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			sample.counts[i]++
+			break
+		}
+	}
+	sample.count++
+	sample.sum += value
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w, "histogram")
+	for _, key := range sortedKeys(h.samples) {
+		sample := h.samples[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += sample.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(key, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(key, "le", "+Inf"), sample.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(key), formatValue(sample.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(key), sample.count)
+	}
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+// output returns what a metric writes
+func output(m metric) string {
+	var b strings.Builder
+	m.write(&b)
+	return b.String()
+}
+
+func TestCounterFormat(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests, by path.", "method", "path")
+	c.Inc("GET", "/b")
+	c.Add(2.5, "GET", "/a")
+	c.Add(-1, "GET", "/a")
+
+	want := `# HELP test_requests_total Requests, by path.
+# TYPE test_requests_total counter
+test_requests_total{method="GET",path="/a"} 2.5
+test_requests_total{method="GET",path="/b"} 1
+`
+	if got := output(c); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	c := NewCounter("test_escaped_total", "Help with a \\ backslash\nand a \"quoted\" line.", "library")
+	c.Inc("C:\\Movies \"HD\"\nnew")
+	c.Inc("Filme für Kinder")
+
+	want := `# HELP test_escaped_total Help with a \\ backslash\nand a "quoted" line.
+# TYPE test_escaped_total counter
+test_escaped_total{library="C:\\Movies \"HD\"\nnew"} 1
+test_escaped_total{library="Filme für Kinder"} 1
+`
+	if got := output(c); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGaugeFormat(t *testing.T) {
+	unlabelled := NewGauge("test_sessions", "Sessions.")
+	if got, want := output(unlabelled), "# HELP test_sessions Sessions.\n# TYPE test_sessions gauge\ntest_sessions 0\n"; got != want {
+		t.Errorf("unset gauge: got\n%s\nwant\n%s", got, want)
+	}
+
+	g := NewGauge("test_items", "Items.", "library")
+	g.Set(10, "movies")
+	g.Add(-3, "movies")
+	g.Set(1, "removed")
+	g.Delete("removed")
+	if got, want := output(g), "# HELP test_items Items.\n# TYPE test_items gauge\ntest_items{library=\"movies\"} 7\n"; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramFormat(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "Durations.", []float64{1, 0.1}, "route")
+	h.Observe(0.05, "/")
+	h.Observe(0.5, "/")
+	h.Observe(3, "/")
+
+	want := `# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/",le="0.1"} 1
+test_duration_seconds_bucket{route="/",le="1"} 2
+test_duration_seconds_bucket{route="/",le="+Inf"} 3
+test_duration_seconds_sum{route="/"} 3.55
+test_duration_seconds_count{route="/"} 3
+`
+	if got := output(h); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("wrong number of label values accepted")
+		}
+	}()
+	NewCounter("test_labels_total", "Labels.", "a", "b").Inc("only one")
+}
@@ -0,0 +1,32 @@
+package metrics
+
+// The metrics recorded by the server
+var (
+	HTTPRequests = NewCounter("mediastream_http_requests_total",
+		"HTTP requests handled, by route and status code.", "method", "route", "status")
+	HTTPRequestDuration = NewHistogram("mediastream_http_request_duration_seconds",
+		"Time to handle HTTP requests, by route. Streams count until the last byte is sent.", DefaultBuckets, "method", "route")
+
+	StreamBytes = NewCounter("mediastream_stream_bytes_total",
+		"Bytes of media streamed, by library.", "library")
+
+	LibraryScans = NewCounter("mediastream_library_scans_total",
+		"Library scans, by library and result.", "library", "result")
+	LibraryScanDuration = NewGauge("mediastream_library_scan_duration_seconds",
+		"Duration of the last scan of each library.", "library")
+	LibraryItems = NewGauge("mediastream_library_items",
+		"Media items found by the last scan of each library.", "library")
+	IndexLookups = NewCounter("mediastream_index_lookups_total",
+		"Library index lookups, by whether the cached scan was used (hit) or the library had to be scanned (miss).", "result")
+
+	ArtworkCache = NewCounter("mediastream_artwork_cache_lookups_total",
+		"Artwork cache lookups, by cache (remote downloads or resized images) and whether the file was cached (hit) or had to be made (miss).", "cache", "result")
+
+	PreviewJobs = NewCounter("mediastream_preview_jobs_total",
+		"Videos processed for poster frames and trickplay thumbnails, by result.", "result")
+	PreviewCache = NewCounter("mediastream_preview_cache_lookups_total",
+		"Trickplay file requests, by whether the file was generated already (hit) or not (miss).", "result")
+
+	LoginFailures = NewCounter("mediastream_login_failures_total",
+		"Failed logins, by reason.", "reason")
+)
@@ -0,0 +1,486 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Only the first frame of a GIF is kept when resizing
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"mediastream/config"
+	"mediastream/metrics"
+	"mediastream/utils"
+)
+
+// Artwork kinds
+const (
+	ArtworkPoster = "poster"
+	ArtworkFanart = "fanart"
+	ArtworkBanner = "banner"
+	ArtworkThumb  = "thumb"  // Still of an episode or video
+	ArtworkSeason = "season" // Poster of an episode's season
+	ArtworkCover  = "cover"  // Album cover
+)
+
+// ErrArtworkNotFound is returned for artwork an item doesn't have
+var ErrArtworkNotFound = errors.New("artwork not found")
+
+// ErrUnsupportedImage is returned for artwork files that don't decode as an image
+var ErrUnsupportedImage = errors.New("unsupported image")
+
+// artworkExtensions are the image types looked for next to media files, in order of preference
+var artworkExtensions = []string{".jpg", ".jpeg", ".png", ".webp"}
+
+// folderImages maps the lowercase base names of the images in a folder below a root to
+// their slash-separated paths relative to the root
+func folderImages(root, dir string) map[string]string {
+	images := map[string]string{}
+	entries, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(dir)))
+	if err != nil {
+		return images
+	}
+
+	for _, ext := range artworkExtensions {
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !strings.EqualFold(path.Ext(name), ext) {
+				continue
+			}
+			base := strings.ToLower(strings.TrimSuffix(name, path.Ext(name)))
+			if _, ok := images[base]; !ok {
+				images[base] = path.Join(dir, name)
+			}
+		}
+	}
+	return images
+}
+
+// seasonFolder matches season folders such as "Season 1" and captures the number
+var seasonFolder = regexp.MustCompile(`(?i)^(?:season|series|staffel|saison)[ ._-]*(\d{1,3})$`)
+
+// findLocalArtwork finds the images of a media file by Kodi's and Plex's naming conventions:
+// "<file>-poster.jpg" next to the file, poster.jpg, folder.jpg and fanart.jpg in movie folders,
+// the show folder's images and season posters for episodes and cover.jpg for albums
+func findLocalArtwork(library config.Library, root, relativePath string) []Artwork {
+	dir := path.Dir(relativePath)
+	base := strings.ToLower(strings.TrimSuffix(path.Base(relativePath), path.Ext(relativePath)))
+	images := folderImages(root, dir)
+
+	var artwork []Artwork
+	found := map[string]bool{}
+	add := func(kind string, images map[string]string, names ...string) {
+		if found[kind] {
+			return
+		}
+		for _, name := range names {
+			if image, ok := images[name]; ok {
+				artwork = append(artwork, Artwork{Kind: kind, Path: image})
+				found[kind] = true
+				return
+			}
+		}
+	}
+
+	// Images named after the file itself
+	for _, kind := range []string{ArtworkPoster, ArtworkFanart, ArtworkBanner, ArtworkThumb} {
+		add(kind, images, base+"-"+kind)
+	}
+
+	switch library.Kind {
+	case config.KindShows:
+		show, _, ok := strings.Cut(relativePath, "/")
+		if !ok {
+			break
+		}
+		showImages := images
+		if dir != show {
+			showImages = folderImages(root, show)
+
+			// The season poster is in the season folder or named after the season in the show folder
+			add(ArtworkSeason, images, "poster", "folder")
+			season := path.Base(dir)
+			if match := seasonFolder.FindStringSubmatch(season); match != nil {
+				number, _ := strconv.Atoi(match[1])
+				add(ArtworkSeason, showImages, fmt.Sprintf("season%02d-poster", number))
+			} else if strings.EqualFold(season, "specials") {
+				add(ArtworkSeason, showImages, "season-specials-poster")
+			}
+		}
+		add(ArtworkPoster, showImages, "poster", "folder")
+		add(ArtworkFanart, showImages, "fanart", "backdrop")
+		add(ArtworkBanner, showImages, "banner")
+
+	case config.KindMusic:
+		add(ArtworkCover, images, "cover", "folder", "front", "album")
+		add(ArtworkFanart, images, "fanart", "backdrop")
+		if parent := path.Dir(dir); parent != "." {
+			add(ArtworkFanart, folderImages(root, parent), "fanart", "backdrop", "artist")
+		}
+
+	default:
+		// A folder of its own belongs to the item, e.g. a movie folder
+		if dir != "." {
+			add(ArtworkPoster, images, "poster", "folder", "cover")
+			add(ArtworkFanart, images, "fanart", "backdrop")
+			add(ArtworkBanner, images, "banner")
+		}
+	}
+
+	return artwork
+}
+
+// ItemArtwork lists the images of an item: those of its metadata first, then local images
+// of other kinds and finally the generated poster frame of a video without a poster
+func ItemArtwork(library config.Library, item *MediaItem) []Artwork {
+	artwork := itemArtwork(library, item)
+	if file, ok := PosterFrame(item); ok {
+		kinds := map[string]bool{}
+		for _, image := range artwork {
+			kinds[image.Kind] = true
+		}
+		for _, kind := range []string{ArtworkPoster, ArtworkThumb} {
+			if !kinds[kind] {
+				artwork = append(artwork, Artwork{Kind: kind, File: file})
+			}
+		}
+	}
+	return artwork
+}
+
+// itemArtwork lists the images of an item's metadata and the local images
+func itemArtwork(library config.Library, item *MediaItem) []Artwork {
+	var artwork []Artwork
+	kinds := map[string]bool{}
+	if item.Metadata != nil {
+		for _, image := range item.Metadata.Artwork {
+			artwork = append(artwork, image)
+			kinds[image.Kind] = true
+		}
+	}
+
+	if root, ok := library.FindRoot(item.Root); ok {
+		for _, image := range findLocalArtwork(library, root, item.RelativePath) {
+			if !kinds[image.Kind] {
+				artwork = append(artwork, image)
+				kinds[image.Kind] = true
+			}
+		}
+	}
+	return artwork
+}
+
+// artworkFallbacks are the kinds used when an item has no image of the requested kind
+var artworkFallbacks = map[string][]string{
+	ArtworkPoster: {ArtworkCover, ArtworkSeason},
+	ArtworkCover:  {ArtworkPoster},
+	ArtworkSeason: {ArtworkPoster},
+	ArtworkThumb:  {ArtworkFanart},
+}
+
+// FindArtwork returns the image of an item of the given kind, or of a similar kind
+func FindArtwork(library config.Library, item *MediaItem, kind string) (Artwork, error) {
+	artwork := ItemArtwork(library, item)
+	for _, k := range append([]string{kind}, artworkFallbacks[kind]...) {
+		for _, image := range artwork {
+			if image.Kind == k {
+				return image, nil
+			}
+		}
+	}
+	return Artwork{}, ErrArtworkNotFound
+}
+
+// ArtworkImage is an image file ready to be served
+type ArtworkImage struct {
+	Path        string
+	ETag        string // Changes whenever the source image or the variant changes
+	ContentType string
+}
+
+// artworkWidths are the widths images are resized to; requests are rounded up to the next one
+// so the cache holds a bounded number of variants
+var artworkWidths = []int{100, 200, 300, 400, 600, 800, 1200, 1600, 2000}
+
+// ArtworkFormats are the formats images can be converted to, by name
+var ArtworkFormats = map[string]string{"jpeg": "image/jpeg", "png": "image/png"}
+
+// passthroughFormats are the source formats served as they are when no variant is requested;
+// images in any other format are converted
+var passthroughFormats = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+}
+
+// Limits keeping image rendering from exhausting memory
+const (
+	maxArtworkPixels   = 64 << 20
+	maxArtworkDownload = 20 << 20
+	maxArtworkRenders  = 4
+)
+
+// artworkRenders bounds how many images are decoded and resized at once
+var artworkRenders = make(chan struct{}, maxArtworkRenders)
+
+// artworkClient downloads artwork of online metadata providers and poster URLs set by admins.
+// It only connects to public addresses, so an image URL can't reach the server itself or other
+// hosts on its network, including after a redirect or through a name resolving to them.
+var artworkClient = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: dialPublicOnly}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// errPrivateAddress rejects image URLs pointing into the server's network
+var errPrivateAddress = errors.New("image URLs must point to a public address")
+
+// nonPublicPrefixes are unicast ranges that aren't private by RFC 1918 or 4193 but aren't
+// reachable on the internet either
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // This network
+	netip.MustParsePrefix("100.64.0.0/10"), // Shared address space of carrier-grade NAT
+}
+
+// isPublicAddress reports whether an IP address is reachable on the internet, as opposed to
+// loopback, link-local, private, shared, multicast or unspecified addresses
+func isPublicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublicOnly refuses connections to addresses that aren't public
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !isPublicAddress(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// checkImageURL rejects image URLs whose host is obviously not public. Names are checked
+// again when they are resolved for the download.
+func checkImageURL(u *url.URL) error {
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return errPrivateAddress
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !isPublicAddress(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// snapWidth rounds a requested width up to one of a list of widths
+func snapWidth(widths []int, width int) int {
+	for _, w := range widths {
+		if width <= w {
+			return w
+		}
+	}
+	return widths[len(widths)-1]
+}
+
+// hashKey returns the hex SHA-1 of a cache key
+func hashKey(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// artworkSource returns the file of an image and a key identifying its current content.
+// Online images are downloaded into the cache once.
+func artworkSource(ctx context.Context, library config.Library, item *MediaItem, artwork Artwork) (string, string, error) {
+	if artwork.File != "" {
+		info, err := os.Stat(artwork.File)
+		if err != nil {
+			return "", "", ErrArtworkNotFound
+		}
+		return artwork.File, fmt.Sprintf("file:%s:%d:%d", artwork.File, info.ModTime().UnixNano(), info.Size()), nil
+	}
+	if artwork.Path != "" {
+		file, _, info, err := ResolveLibraryFile(library, item.Root, artwork.Path)
+		if err != nil {
+			return "", "", ErrArtworkNotFound
+		}
+		return file, fmt.Sprintf("file:%s:%d:%d", file, info.ModTime().UnixNano(), info.Size()), nil
+	}
+
+	file := filepath.Join(config.CacheDir, "artwork", "remote", hashKey(artwork.URL))
+	key := "url:" + artwork.URL
+	if _, err := os.Stat(file); err == nil {
+		metrics.ArtworkCache.Inc("remote", "hit")
+		return file, key, nil
+	}
+	metrics.ArtworkCache.Inc("remote", "miss")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, artwork.URL, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := artworkClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", "", ErrArtworkNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("downloading %s: %s", artwork.URL, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxArtworkDownload+1))
+	if err != nil {
+		return "", "", err
+	}
+	if len(data) > maxArtworkDownload {
+		return "", "", fmt.Errorf("downloading %s: image too large", artwork.URL)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return "", "", err
+	}
+	if err := utils.WriteFileAtomic(file, data, 0644); err != nil {
+		return "", "", err
+	}
+	return file, key, nil
+}
+
+// RenderArtwork returns an image of an item, resized to a width and converted to a format
+// if requested. Variants are cached, so each is only rendered once.
+func RenderArtwork(ctx context.Context, library config.Library, item *MediaItem, artwork Artwork, width int, format string) (*ArtworkImage, error) {
+	source, key, err := artworkSource(ctx, library, item, artwork)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Only files that decode as an image are served, never by a type sniffed from their
+	// contents: a poster holding HTML would otherwise run as a page of the server
+	cfg, sourceFormat, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	if cfg.Width*cfg.Height > maxArtworkPixels {
+		return nil, errImageTooLarge
+	}
+
+	if contentType, ok := passthroughFormats[sourceFormat]; ok && width <= 0 && format == "" {
+		return &ArtworkImage{Path: source, ETag: hashKey(key)[:16], ContentType: contentType}, nil
+	}
+
+	// Keep transparency unless asked otherwise
+	if format == "" {
+		format = "jpeg"
+		if sourceFormat == "png" || sourceFormat == "gif" {
+			format = "png"
+		}
+	}
+	if width > 0 {
+		width = snapWidth(artworkWidths, width)
+	}
+	if width <= 0 || width > cfg.Width {
+		width = cfg.Width
+	}
+
+	variant := hashKey(fmt.Sprintf("%s:%d:%s", key, width, format))
+	target := filepath.Join(config.CacheDir, "artwork", variant[:2], variant+"."+format)
+	result := &ArtworkImage{Path: target, ETag: variant[:16], ContentType: ArtworkFormats[format]}
+	if _, err := os.Stat(target); err == nil {
+		metrics.ArtworkCache.Inc("resized", "hit")
+		return result, nil
+	}
+	metrics.ArtworkCache.Inc("resized", "miss")
+
+	select {
+	case artworkRenders <- struct{}{}:
+		defer func() { <-artworkRenders }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+
+	if err := saveImage(scaleImage(img, width), format, target); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// scaleImage shrinks an image to a width, keeping its aspect ratio
+func scaleImage(img image.Image, width int) image.Image {
+	if width <= 0 || width >= img.Bounds().Dx() {
+		return img
+	}
+	height := img.Bounds().Dy() * width / img.Bounds().Dx()
+	if height < 1 {
+		height = 1
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return scaled
+}
+
+// saveImage encodes an image as jpeg or png into a cache file
+func saveImage(img image.Image, format, target string) error {
+	var buf bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(target, buf.Bytes(), 0644)
+}
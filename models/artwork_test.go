@@ -0,0 +1,121 @@
+package models
+
+import (
+	"errors"
+	"net/netip"
+	"net/url"
+	"testing"
+)
+
+func TestIsPublicAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"8.8.8.8", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"::ffff:93.184.216.34", true},
+		{"100.63.255.255", true},
+		{"100.128.0.0", true},
+
+		{"127.0.0.1", false},
+		{"127.10.0.1", false},
+		{"::1", false},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"::", false},
+		{"10.0.0.1", false},
+		{"172.16.0.1", false},
+		{"172.31.255.255", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fe80::1%eth0", false},
+		{"fc00::1", false},
+		{"fd12:3456:789a::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"100.127.255.255", false},
+		{"::ffff:100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"ff02::1", false},
+		{"255.255.255.255", false},
+	}
+	for _, test := range tests {
+		if got := isPublicAddress(netip.MustParseAddr(test.ip)); got != test.want {
+			t.Errorf("isPublicAddress(%s) = %v, want %v", test.ip, got, test.want)
+		}
+	}
+}
+
+func TestCheckImageURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{"https://image.tmdb.org/t/p/original/poster.jpg", nil},
+		{"http://93.184.216.34/poster.jpg", nil},
+		{"http://[2606:2800:220:1:248:1893:25c8:1946]/poster.jpg", nil},
+		// Names are only checked once they are resolved
+		{"http://intranet.example/poster.jpg", nil},
+
+		{"http://localhost/poster.jpg", errPrivateAddress},
+		{"http://LOCALHOST:8080/poster.jpg", errPrivateAddress},
+		{"http://media.localhost/poster.jpg", errPrivateAddress},
+		{"http://127.0.0.1/poster.jpg", errPrivateAddress},
+		{"http://[::1]/poster.jpg", errPrivateAddress},
+		{"http://10.1.2.3/poster.jpg", errPrivateAddress},
+		{"http://192.168.0.10:8096/poster.jpg", errPrivateAddress},
+		{"http://169.254.169.254/latest/meta-data", errPrivateAddress},
+		{"http://[fe80::1]/poster.jpg", errPrivateAddress},
+		{"http://[fd00::1]/poster.jpg", errPrivateAddress},
+		{"http://[::ffff:127.0.0.1]/poster.jpg", errPrivateAddress},
+		{"http://[::ffff:192.168.0.1]/poster.jpg", errPrivateAddress},
+		{"http://100.100.100.100/poster.jpg", errPrivateAddress},
+		{"http://0.0.0.0/poster.jpg", errPrivateAddress},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkImageURL(u); !errors.Is(err, test.want) {
+			t.Errorf("checkImageURL(%s) = %v, want %v", test.url, err, test.want)
+		}
+	}
+}
+
+func TestDialPublicOnly(t *testing.T) {
+	tests := []struct {
+		address string
+		want    error
+	}{
+		{"93.184.216.34:443", nil},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", nil},
+
+		{"127.0.0.1:80", errPrivateAddress},
+		{"[::1]:80", errPrivateAddress},
+		{"10.0.0.1:443", errPrivateAddress},
+		{"172.20.0.1:443", errPrivateAddress},
+		{"192.168.1.1:443", errPrivateAddress},
+		{"169.254.169.254:80", errPrivateAddress},
+		{"[fe80::1%eth0]:80", errPrivateAddress},
+		{"[fd00::1]:443", errPrivateAddress},
+		{"[::ffff:10.0.0.1]:443", errPrivateAddress},
+		{"100.64.0.1:443", errPrivateAddress},
+		// Dialing resolves names first, so anything but an address is refused
+		{"localhost:80", errPrivateAddress},
+	}
+	for _, test := range tests {
+		if err := dialPublicOnly("tcp", test.address, nil); !errors.Is(err, test.want) {
+			t.Errorf("dialPublicOnly(%s) = %v, want %v", test.address, err, test.want)
+		}
+	}
+
+	if err := dialPublicOnly("tcp", "127.0.0.1", nil); err == nil {
+		t.Error("dialPublicOnly accepted an address without a port")
+	}
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audit event types
+const (
+	AuditLogin              = "auth.login"
+	AuditLogout             = "auth.logout"
+	AuditSetupComplete      = "setup.complete"
+	AuditUserCreate         = "user.create"
+	AuditUserUpdate         = "user.update"
+	AuditUserDelete         = "user.delete"
+	AuditUserPasswordReset  = "user.password_reset"
+	AuditUserPasswordChange = "user.password_change"
+	AuditInviteCreate       = "invite.create"
+	AuditInviteRevoke       = "invite.revoke"
+	AuditInviteAccept       = "invite.accept"
+	AuditConfigChange       = "config.change"
+	AuditLibraryScan        = "library.scan"
+	AuditBackupCreate       = "backup.create"
+	AuditBackupRestore      = "backup.restore"
+	AuditMetadataEdit       = "metadata.edit"
+	AuditMetadataReset      = "metadata.reset"
+	AuditProfileSwitch      = "profile.switch"
+)
+
+// AuditEvent is a single entry in the audit log
+type AuditEvent struct {
+	Time    time.Time         `json:"time"`
+	Type    string            `json:"type"`
+	ActorID string            `json:"actorId,omitempty"`
+	Actor   string            `json:"actor,omitempty"`
+	IP      string            `json:"ip,omitempty"`
+	Target  string            `json:"target,omitempty"`
+	Success bool              `json:"success"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// AuditFilter selects audit events; zero values match everything
+type AuditFilter struct {
+	Type    string // Exact type or a prefix ending in "." such as "user."
+	Actor   string // Matches the actor's username or ID
+	IP      string
+	Success *bool
+	Since   time.Time
+	Until   time.Time
+}
+
+// Matches reports whether an event satisfies the filter
+func (f AuditFilter) Matches(event AuditEvent) bool {
+	if f.Type != "" {
+		if strings.HasSuffix(f.Type, ".") {
+			if !strings.HasPrefix(event.Type, f.Type) {
+				return false
+			}
+		} else if event.Type != f.Type {
+			return false
+		}
+	}
+	if f.Actor != "" && event.Actor != f.Actor && event.ActorID != f.Actor {
+		return false
+	}
+	if f.IP != "" && event.IP != f.IP {
+		return false
+	}
+	if f.Success != nil && event.Success != *f.Success {
+		return false
+	}
+	if !f.Since.IsZero() && event.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && event.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// auditMu serializes writes so concurrent events don't interleave lines
+var auditMu sync.Mutex
+
+// AppendAuditEvent appends an event to the audit log as a single JSON line
+func AppendAuditEvent(event AuditEvent, filename string) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// LoadAuditEvents reads all events matching the filter, oldest first
+func LoadAuditEvents(filename string, filter AuditFilter) ([]AuditEvent, error) {
+	events := []AuditEvent{}
+
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		// If file doesn't exist, return empty array
+		return events, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			// Skip a torn line rather than losing the rest of the log
+			continue
+		}
+		if filter.Matches(event) {
+			events = append(events, event)
+		}
+	}
+
+	return events, scanner.Err()
+}
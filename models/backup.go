@@ -0,0 +1,502 @@
+package models
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"mediastream/config"
+	"mediastream/utils"
+)
+
+// BackupVersion is the archive format written by WriteBackup.
+// Version 1 archives had no manifest and held only the state files.
+const BackupVersion = 2
+
+// backupManifestName is the archive entry describing the backup
+const backupManifestName = "manifest.json"
+
+// maxBackupSize guards against archives that would fill the disk
+const maxBackupSize = 4 << 30
+
+// maxManifestSize limits the manifest, the only entry read into memory whole
+const maxManifestSize = 64 << 20
+
+// BackupManifest describes the contents of a backup archive
+type BackupManifest struct {
+	Version int          `json:"version"`
+	Created time.Time    `json:"created"`
+	Files   []BackupFile `json:"files"`
+}
+
+// BackupFile is a file in a backup archive, named by its slash-separated path in the data directory
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// configBackupName is the name of the config file in a backup, whatever its name on disk
+func configBackupName() string {
+	name := "config" + strings.ToLower(filepath.Ext(config.ConfigFile))
+	if !slices.Contains(config.ConfigFileNames, name) {
+		return config.ConfigFileNames[0]
+	}
+	return name
+}
+
+// stateFiles returns the files holding server state, each with its name in a backup
+func stateFiles() map[string]string {
+	return map[string]string{
+		configBackupName():    config.ConfigFile,
+		"users.json":          config.UsersFile,
+		"setup-completed":     config.SetupFlagFile,
+		"invites.json":        config.InvitesFile,
+		"profiles.json":       config.ProfilesFile,
+		"audit.log":           config.AuditFile,
+		"index.json":          config.IndexFile,
+		"metadata-edits.json": config.EditsFile,
+	}
+}
+
+// BackupFiles returns the files and folders a backup is made of
+func BackupFiles() []string {
+	var files []string
+	for _, file := range stateFiles() {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return append(files, config.CacheDir)
+}
+
+// collectBackupFiles maps the name of every existing file in a backup to its path on disk
+func collectBackupFiles() (map[string]string, error) {
+	files := map[string]string{}
+	for name, file := range stateFiles() {
+		if _, err := os.Stat(file); err == nil {
+			files[name] = file
+		}
+	}
+
+	err := filepath.WalkDir(config.CacheDir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && file == config.CacheDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.Type().IsRegular() {
+			relative, err := filepath.Rel(config.CacheDir, file)
+			if err != nil {
+				return err
+			}
+			files["cache/"+filepath.ToSlash(relative)] = file
+		}
+		return nil
+	})
+	return files, err
+}
+
+// WriteBackup streams a versioned, gzipped tar archive of the server state and returns its manifest.
+// Files are hashed as they are written, so the manifest is the last entry.
+func WriteBackup(w io.Writer) (*BackupManifest, error) {
+	files, err := collectBackupFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	manifest := &BackupManifest{Version: BackupVersion, Created: time.Now()}
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	for _, name := range names {
+		file, err := writeBackupFile(archive, name, files[name], manifest.Created)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	header := &tar.Header{Name: backupManifestName, Mode: 0600, Size: int64(len(data)), ModTime: manifest.Created}
+	if err := archive.WriteHeader(header); err != nil {
+		return nil, err
+	}
+	if _, err := archive.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// writeBackupFile copies a file into an archive, hashing it on the way
+func writeBackupFile(archive *tar.Writer, name, path string, created time.Time) (BackupFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return BackupFile{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return BackupFile{}, err
+	}
+	header := &tar.Header{Name: name, Mode: 0600, Size: info.Size(), ModTime: created}
+	if err := archive.WriteHeader(header); err != nil {
+		return BackupFile{}, err
+	}
+
+	// A file growing meanwhile, like the audit log, is cut at the size in its header
+	hash := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(archive, hash), file, info.Size()); err != nil {
+		return BackupFile{}, fmt.Errorf("could not back up %s: %v", name, err)
+	}
+	return BackupFile{Name: name, Size: info.Size(), SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// validBackupName reports whether an archive entry may be restored into the data directory
+func validBackupName(name string) bool {
+	if name != path.Clean(name) || path.IsAbs(name) || strings.HasPrefix(name, "../") || name == ".." {
+		return false
+	}
+	if _, ok := stateFiles()[name]; ok {
+		return true
+	}
+	if slices.Contains(config.ConfigFileNames, name) {
+		return true
+	}
+	return strings.HasPrefix(name, "cache/") && len(name) > len("cache/")
+}
+
+// stagedBackup is a backup archive unpacked into a staging directory
+type stagedBackup struct {
+	dir      string
+	manifest *BackupManifest       // Nil for archives that predate versioning
+	files    map[string]BackupFile // By name, as found in the archive
+}
+
+// path returns where a file of the backup is staged
+func (b *stagedBackup) path(name string) string {
+	return filepath.Join(b.dir, filepath.FromSlash(name))
+}
+
+// read returns the contents of a staged state file
+func (b *stagedBackup) read(name string) ([]byte, error) {
+	return os.ReadFile(b.path(name))
+}
+
+// names returns the names of the staged files in order
+func (b *stagedBackup) names() []string {
+	names := make([]string, 0, len(b.files))
+	for name := range b.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readBackup unpacks a backup archive into dir, hashing every file on the way
+func readBackup(r io.Reader, dir string) (*stagedBackup, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %v", err)
+	}
+	defer gz.Close()
+
+	backup := &stagedBackup{dir: dir, files: map[string]BackupFile{}}
+	var total int64
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("corrupt backup archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if header.Name == backupManifestName {
+			if header.Size > maxManifestSize {
+				return nil, errors.New("backup manifest is too large")
+			}
+			data, err := io.ReadAll(archive)
+			if err != nil {
+				return nil, fmt.Errorf("corrupt backup archive: %v", err)
+			}
+			backup.manifest = &BackupManifest{}
+			if err := json.Unmarshal(data, backup.manifest); err != nil {
+				return nil, fmt.Errorf("invalid backup manifest: %v", err)
+			}
+			continue
+		}
+
+		// Names are checked before anything is written so entries can't escape the staging directory
+		if !validBackupName(header.Name) {
+			return nil, fmt.Errorf("backup contains unexpected file %s", header.Name)
+		}
+		total += header.Size
+		if total > maxBackupSize {
+			return nil, errors.New("backup archive is too large")
+		}
+
+		file, err := stageBackupFile(archive, backup.path(header.Name))
+		if err != nil {
+			return nil, err
+		}
+		file.Name = header.Name
+		backup.files[header.Name] = file
+	}
+	return backup, nil
+}
+
+// stageBackupFile copies an archive entry to file and returns its size and checksum
+func stageBackupFile(r io.Reader, file string) (BackupFile, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return BackupFile{}, err
+	}
+	out, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return BackupFile{}, err
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), r)
+	if err != nil {
+		return BackupFile{}, fmt.Errorf("corrupt backup archive: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		return BackupFile{}, err
+	}
+	return BackupFile{Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// verifyBackup checks an archive against its manifest and returns its version
+func verifyBackup(backup *stagedBackup) (int, error) {
+	manifest := backup.manifest
+	if manifest == nil {
+		// Archives without a manifest predate versioning
+		return 1, nil
+	}
+	if manifest.Version < 2 || manifest.Version > BackupVersion {
+		return 0, fmt.Errorf("backup version %d is not supported, this server reads versions 1 to %d", manifest.Version, BackupVersion)
+	}
+
+	listed := map[string]bool{}
+	for _, file := range manifest.Files {
+		staged, ok := backup.files[file.Name]
+		if !ok {
+			return 0, fmt.Errorf("backup is missing %s", file.Name)
+		}
+		if staged.SHA256 != file.SHA256 {
+			return 0, fmt.Errorf("backup file %s is corrupt", file.Name)
+		}
+		listed[file.Name] = true
+	}
+
+	// Files added to an archive after it was written would be restored unchecked
+	for _, name := range slices.Sorted(maps.Keys(backup.files)) {
+		if !listed[name] {
+			return 0, fmt.Errorf("backup file %s is not in the manifest", name)
+		}
+	}
+	return manifest.Version, nil
+}
+
+// migrateBackup upgrades the staged files of an older archive to the current version
+func migrateBackup(version int, backup *stagedBackup) error {
+	if version < 2 {
+		// Version 1 could hold a config with the legacy mediaFolders list, which is rewritten as libraries
+		for name := range backup.files {
+			if !strings.HasPrefix(name, "config.") {
+				continue
+			}
+			data, err := backup.read(name)
+			if err != nil {
+				return err
+			}
+			cfg, err := config.ParseConfigFile(name, data)
+			if err != nil {
+				return err
+			}
+			if data, err = config.Encode(cfg, config.FileFormat(name)); err != nil {
+				return err
+			}
+			if err := os.WriteFile(backup.path(name), data, 0600); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateBackup makes sure the state files of an archive can be loaded before anything is replaced
+func validateBackup(backup *stagedBackup) error {
+	for name := range backup.files {
+		var target any
+		switch {
+		case strings.HasPrefix(name, "config."):
+		case name == "users.json":
+			target = &[]User{}
+		case name == "invites.json":
+			target = &[]Invite{}
+		case name == "profiles.json":
+			target = &map[string]*ProfileData{}
+		case name == "index.json":
+			target = &map[string]*IndexedLibrary{}
+		default:
+			continue
+		}
+
+		data, err := backup.read(name)
+		if err == nil && target == nil {
+			_, err = config.ParseConfigFile(name, data)
+		} else if err == nil {
+			err = json.Unmarshal(data, target)
+		}
+		if err != nil {
+			return fmt.Errorf("backup file %s is invalid: %v", name, err)
+		}
+	}
+
+	if _, ok := backup.files["users.json"]; !ok {
+		return errors.New("backup contains no users")
+	}
+	return nil
+}
+
+// restoreConfig writes the config of a backup to configFile, converted to its format
+func restoreConfig(backup *stagedBackup, name, configFile string) error {
+	data, err := backup.read(name)
+	if err != nil {
+		return err
+	}
+	if config.FileFormat(name) != config.FileFormat(configFile) {
+		cfg, err := config.ParseConfigFile(name, data)
+		if err != nil {
+			return err
+		}
+		if data, err = config.Encode(cfg, config.FileFormat(configFile)); err != nil {
+			return err
+		}
+	}
+	return utils.WriteFileAtomic(configFile, data, 0600)
+}
+
+// RestoreBackup validates a backup archive, migrates it from older versions and replaces the
+// state in the data directory with it. Nothing is changed if the archive is invalid.
+// The config is written to configFile when one is given, instead of into the data directory.
+func RestoreBackup(r io.Reader, configFile string) (*BackupManifest, error) {
+	// Unpack everything next to its destination, then move it into place
+	staging, err := os.MkdirTemp(config.DataDir, ".restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	backup, err := readBackup(r, staging)
+	if err != nil {
+		return nil, err
+	}
+	version, err := verifyBackup(backup)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateBackup(version, backup); err != nil {
+		return nil, fmt.Errorf("could not migrate version %d backup: %v", version, err)
+	}
+	if err := validateBackup(backup); err != nil {
+		return nil, err
+	}
+
+	manifest := &BackupManifest{Version: version}
+	names := backup.names()
+	for _, name := range names {
+		manifest.Files = append(manifest.Files, BackupFile{Name: name, Size: backup.files[name].Size})
+	}
+
+	if configFile != "" {
+		for _, name := range names {
+			if strings.HasPrefix(name, "config.") {
+				if err := restoreConfig(backup, name, configFile); err != nil {
+					return nil, err
+				}
+				names = slices.DeleteFunc(names, func(other string) bool { return other == name })
+				break
+			}
+		}
+	}
+
+	// Cached files and the index belong to the old state
+	if err := os.RemoveAll(config.CacheDir); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(config.IndexFile); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// State files missing from the archive didn't exist when it was made,
+	// and a restored config file replaces one in any other format
+	removed := BackupFiles()
+	if configFile == "" {
+		removed = append(removed, config.ConfigFileNames...)
+	}
+	for _, file := range removed {
+		name := filepath.Base(file)
+		if file == config.CacheDir || file == config.ConfigFile && configFile != "" {
+			continue
+		}
+		if _, ok := backup.files[name]; !ok {
+			if err := os.Remove(filepath.Join(config.DataDir, name)); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+
+	for _, name := range names {
+		target := filepath.Join(config.DataDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(backup.path(name), target); err != nil {
+			return nil, err
+		}
+	}
+
+	return manifest, nil
+}
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mediastream/config"
+)
+
+// backupTest moves the data directory to a new temporary one holding the given files
+func backupTest(t *testing.T, files map[string]string) string {
+	dataDir := config.DataDir
+	t.Cleanup(func() { config.SetDataDir(dataDir) })
+
+	dir := t.TempDir()
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.SetDataDir(dir)
+	return dir
+}
+
+// archive builds a backup archive from entries in order
+func archive(t *testing.T, entries ...[2]string) *bytes.Buffer {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: entry[0], Mode: 0600, Size: int64(len(entry[1]))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(entry[1]))
+	}
+	tw.Close()
+	gz.Close()
+	return &buf
+}
+
+var backupState = map[string]string{
+	"config.yaml":         "scanIntervalMinutes: 15\n",
+	"users.json":          `[{"id":"1","username":"admin"}]`,
+	"audit.log":           "{}\n",
+	"cache/artwork/a.jpg": "jpeg",
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	backupTest(t, backupState)
+	var buf bytes.Buffer
+	manifest, err := WriteBackup(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != len(backupState) {
+		t.Errorf("manifest = %+v", manifest.Files)
+	}
+
+	// The manifest comes last, after the files it describes
+	gz, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last string
+	for tr := tar.NewReader(gz); ; {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		last = header.Name
+	}
+	if last != backupManifestName {
+		t.Errorf("last entry is %s", last)
+	}
+
+	dir := backupTest(t, map[string]string{"config.json": "{}", "invites.json": "[]"})
+	if _, err := RestoreBackup(&buf, ""); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range backupState {
+		if data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name))); err != nil || string(data) != want {
+			t.Errorf("restored %s = %q, %v", name, data, err)
+		}
+	}
+	for _, name := range []string{"config.json", "invites.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s not part of the backup but kept", name)
+		}
+	}
+}
+
+func TestRestoreBackupToConfigFile(t *testing.T) {
+	backupTest(t, backupState)
+	var buf bytes.Buffer
+	if _, err := WriteBackup(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := backupTest(t, nil)
+	configFile := filepath.Join(t.TempDir(), "mediastream.json")
+	config.ConfigFile = configFile
+	if _, err := RestoreBackup(&buf, configFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// The YAML config is converted for the JSON file given, and not restored into the data directory
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil || cfg.ScanIntervalMinutes != 15 {
+		t.Fatalf("restored config = %+v, %v", cfg, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "config.yaml")); !os.IsNotExist(err) {
+		t.Error("config restored into the data directory too")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "users.json")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRestoreBackupManifestFirst(t *testing.T) {
+	// Archives once started with their manifest
+	backupTest(t, nil)
+	manifest := `{"version":2,"files":[{"name":"users.json","size":2,"sha256":"4f53cda18c2baa0c0354bb5f9a3ecbe5ed12ab4d8e11ba873c2f11161202b945"}]}`
+	restored, err := RestoreBackup(archive(t, [2]string{backupManifestName, manifest}, [2]string{"users.json", "[]"}), "")
+	if err != nil || restored.Version != 2 || len(restored.Files) != 1 {
+		t.Errorf("restore = %+v, %v", restored, err)
+	}
+}
+
+func TestRestoreBackupRejects(t *testing.T) {
+	users := [2]string{"users.json", "[]"}
+	tests := map[string]struct {
+		archive *bytes.Buffer
+		err     string
+	}{
+		"corrupt file":    {archive(t, [2]string{"users.json", "[ ]"}, [2]string{backupManifestName, `{"version":2,"files":[{"name":"users.json","sha256":"4f53cda18c2baa0c0354bb5f9a3ecbe5ed12ab4d8e11ba873c2f11161202b945"}]}`}), "corrupt"},
+		"missing file":    {archive(t, users, [2]string{backupManifestName, `{"version":2,"files":[{"name":"invites.json"}]}`}), "missing invites.json"},
+		"unlisted file":   {archive(t, users, [2]string{"invites.json", "[]"}, [2]string{backupManifestName, `{"version":2,"files":[{"name":"users.json","sha256":"4f53cda18c2baa0c0354bb5f9a3ecbe5ed12ab4d8e11ba873c2f11161202b945"}]}`}), "invites.json is not in the manifest"},
+		"newer version":   {archive(t, users, [2]string{backupManifestName, `{"version":99}`}), "not supported"},
+		"escaping path":   {archive(t, users, [2]string{"../outside", "x"}), "unexpected file"},
+		"unexpected file": {archive(t, users, [2]string{"notes.txt", "x"}), "unexpected file"},
+		"invalid state":   {archive(t, [2]string{"users.json", "{"}), "users.json is invalid"},
+		"no users":        {archive(t, [2]string{"invites.json", "[]"}), "no users"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := backupTest(t, map[string]string{"users.json": "kept"})
+			if _, err := RestoreBackup(test.archive, ""); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error = %v, want %q", err, test.err)
+			}
+			if data, _ := os.ReadFile(filepath.Join(dir, "users.json")); string(data) != "kept" {
+				t.Errorf("users replaced by a rejected backup: %q", data)
+			}
+			if entries, _ := filepath.Glob(filepath.Join(dir, ".restore-*")); len(entries) != 0 {
+				t.Errorf("staging left behind: %v", entries)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "outside")); err == nil {
+				t.Error("entry written outside the staging directory")
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"mediastream/config"
+	"mediastream/utils"
+)
+
+// MetadataEdit holds the fields an admin corrected for an item. Every field that is set is
+// locked, so scans and metadata providers no longer change it.
+type MetadataEdit struct {
+	Title         *string   `json:"title,omitempty"`
+	SortTitle     *string   `json:"sortTitle,omitempty"`
+	OriginalTitle *string   `json:"originalTitle,omitempty"`
+	Year          *int      `json:"year,omitempty"`
+	Plot          *string   `json:"plot,omitempty"`
+	Genres        *[]string `json:"genres,omitempty"`
+	ContentRating *string   `json:"contentRating,omitempty"`
+	Poster        *string   `json:"poster,omitempty"` // Image URL, or path relative to the library root
+	Updated       time.Time `json:"updated"`
+	UpdatedBy     string    `json:"updatedBy,omitempty"`
+	Size          int64     `json:"size,omitempty"`    // Of the item's file when edited, to find it again after a rename
+	Modified      time.Time `json:"modified,omitzero"` // Of the item's file when edited
+}
+
+// EditableFields are the metadata fields admins can edit and lock
+var EditableFields = []string{"title", "sortTitle", "originalTitle", "year", "plot", "genres", "contentRating", "poster"}
+
+// setField sets a field of an edit from its JSON value, or unlocks it for null
+func setField[T any](target **T, value json.RawMessage) error {
+	if string(bytes.TrimSpace(value)) == "null" {
+		*target = nil
+		return nil
+	}
+	var v T
+	if err := json.Unmarshal(value, &v); err != nil {
+		return err
+	}
+	*target = &v
+	return nil
+}
+
+// Set edits a field from its JSON value. Null unlocks the field again.
+func (e *MetadataEdit) Set(field string, value json.RawMessage) error {
+	var err error
+	switch field {
+	case "title":
+		err = setField(&e.Title, value)
+	case "sortTitle":
+		err = setField(&e.SortTitle, value)
+	case "originalTitle":
+		err = setField(&e.OriginalTitle, value)
+	case "year":
+		err = setField(&e.Year, value)
+	case "plot":
+		err = setField(&e.Plot, value)
+	case "genres":
+		err = setField(&e.Genres, value)
+	case "contentRating":
+		err = setField(&e.ContentRating, value)
+	case "poster":
+		err = setField(&e.Poster, value)
+	default:
+		return fmt.Errorf("%s can't be edited", field)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", field, err)
+	}
+	return nil
+}
+
+// Validate checks the edited values and normalizes the content rating
+func (e *MetadataEdit) Validate() error {
+	if e.Title != nil && strings.TrimSpace(*e.Title) == "" {
+		return errors.New("title can't be empty")
+	}
+	if e.Year != nil && (*e.Year < 1800 || *e.Year > 2999) {
+		return errors.New("year must be between 1800 and 2999")
+	}
+	if e.ContentRating != nil {
+		rating := normalizeContentRating(*e.ContentRating)
+		e.ContentRating = &rating
+	}
+	if e.Poster != nil {
+		poster := strings.TrimSpace(*e.Poster)
+		if u, err := url.Parse(poster); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+			return checkImageURL(u)
+		}
+		cleaned := path.Clean(strings.TrimPrefix(poster, "/"))
+		if poster == "" || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+			return errors.New("poster must be an http(s) URL or a path within the library")
+		}
+		e.Poster = &cleaned
+	}
+	return nil
+}
+
+// Locked returns the fields an edit sets
+func (e *MetadataEdit) Locked() []string {
+	var locked []string
+	set := []bool{e.Title != nil, e.SortTitle != nil, e.OriginalTitle != nil, e.Year != nil,
+		e.Plot != nil, e.Genres != nil, e.ContentRating != nil, e.Poster != nil}
+	for i, field := range EditableFields {
+		if set[i] {
+			locked = append(locked, field)
+		}
+	}
+	return locked
+}
+
+// apply overrides an item's metadata with the edited fields
+func (e *MetadataEdit) apply(item *MediaItem) {
+	meta := Metadata{Title: item.Title, Source: MetadataFromFilename}
+	if item.Metadata != nil {
+		meta = *item.Metadata
+	}
+
+	if e.Title != nil {
+		meta.Title = *e.Title
+		item.Title = *e.Title
+	}
+	if e.SortTitle != nil {
+		meta.SortTitle = *e.SortTitle
+	}
+	if e.OriginalTitle != nil {
+		meta.OriginalTitle = *e.OriginalTitle
+	}
+	if e.Year != nil {
+		meta.Year = *e.Year
+	}
+	if e.Plot != nil {
+		meta.Plot = *e.Plot
+	}
+	if e.Genres != nil {
+		meta.Genres = *e.Genres
+	}
+	if e.ContentRating != nil {
+		meta.ContentRating = *e.ContentRating
+	}
+	if e.Poster != nil {
+		poster := Artwork{Kind: "poster", Path: *e.Poster}
+		if strings.Contains(*e.Poster, "://") {
+			poster = Artwork{Kind: "poster", URL: *e.Poster}
+		}
+		artwork := []Artwork{poster}
+		for _, image := range meta.Artwork {
+			if image.Kind != "poster" {
+				artwork = append(artwork, image)
+			}
+		}
+		meta.Artwork = artwork
+	}
+
+	meta.Locked = e.Locked()
+	item.Metadata = &meta
+}
+
+// EditKey identifies an item for metadata edits: its library and movie folder or file path.
+// It doesn't depend on the root, so copies of an item on several disks share their edits.
+func EditKey(item MediaItem) string {
+	return item.LibraryID + ":" + duplicateKey(item)
+}
+
+// editsCache keeps the edits file in memory, since every item lookup applies it
+var editsCache struct {
+	sync.Mutex
+	file     string
+	modified time.Time // Of the file when it was read, so changes by other programs are noticed
+	size     int64
+	edits    map[string]MetadataEdit // Shared, never modified
+}
+
+// editsMu serializes changes to the edits file
+var editsMu sync.Mutex
+
+// cachedMetadataEdits returns the metadata edits by item key, reading the file only if it changed.
+// The map is shared and must not be modified.
+func cachedMetadataEdits(filename string) (map[string]MetadataEdit, error) {
+	var modified time.Time
+	var size int64
+	info, err := os.Stat(filename)
+	if err == nil {
+		modified, size = info.ModTime(), info.Size()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	editsCache.Lock()
+	defer editsCache.Unlock()
+	if editsCache.edits != nil && editsCache.file == filename && editsCache.modified.Equal(modified) && editsCache.size == size {
+		return editsCache.edits, nil
+	}
+
+	edits := map[string]MetadataEdit{}
+	if info != nil {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &edits); err != nil {
+			return nil, err
+		}
+	}
+	editsCache.file, editsCache.modified, editsCache.size, editsCache.edits = filename, modified, size, edits
+	return edits, nil
+}
+
+// LoadMetadataEdits loads the metadata edits by item key, starting empty if the file doesn't exist
+func LoadMetadataEdits(filename string) (map[string]MetadataEdit, error) {
+	edits, err := cachedMetadataEdits(filename)
+	if err != nil {
+		return nil, err
+	}
+	return maps.Clone(edits), nil
+}
+
+// SaveMetadataEdits saves the metadata edits to their file
+func SaveMetadataEdits(edits map[string]MetadataEdit, filename string) error {
+	data, err := json.MarshalIndent(edits, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	editsCache.Lock()
+	defer editsCache.Unlock()
+	editsCache.edits = nil
+	return utils.WriteFileAtomic(filename, data, 0644)
+}
+
+// UpdateMetadataEdits changes the metadata edits with update and saves them, unless it fails.
+// Updates are serialized, so concurrent changes don't overwrite each other.
+func UpdateMetadataEdits(filename string, update func(edits map[string]MetadataEdit) error) error {
+	editsMu.Lock()
+	defer editsMu.Unlock()
+
+	edits, err := LoadMetadataEdits(filename)
+	if err != nil {
+		return err
+	}
+	if err := update(edits); err != nil {
+		return err
+	}
+	return SaveMetadataEdits(edits, filename)
+}
+
+// editedFile identifies the file of an item by its size and modification time
+type editedFile struct {
+	size     int64
+	modified int64
+}
+
+// migrateMetadataEdits moves the edits of items renamed or moved within a library to their new
+// key. Items are recognized by the size and modification time their file had when edited, which
+// a rename keeps; edits and items that can't be told apart that way are left alone.
+func migrateMetadataEdits(ctx context.Context, library config.Library, items []MediaItem) {
+	edits, err := cachedMetadataEdits(config.EditsFile)
+	if err != nil || len(edits) == 0 {
+		return
+	}
+
+	present := map[string]bool{}
+	for _, item := range items {
+		present[EditKey(item)] = true
+	}
+	orphans := map[editedFile][]string{}
+	for key, edit := range edits {
+		if strings.HasPrefix(key, library.ID+":") && !present[key] && edit.Size > 0 && !edit.Modified.IsZero() {
+			file := editedFile{edit.Size, edit.Modified.UnixNano()}
+			orphans[file] = append(orphans[file], key)
+		}
+	}
+	if len(orphans) == 0 {
+		return
+	}
+
+	// Copies in several roots share their key, so they count once
+	renamed := map[editedFile][]string{}
+	for _, item := range items {
+		key := EditKey(item)
+		file := editedFile{item.Size, item.Modified.UnixNano()}
+		if _, edited := edits[key]; !edited && orphans[file] != nil && !slices.Contains(renamed[file], key) {
+			renamed[file] = append(renamed[file], key)
+		}
+	}
+	moves := map[string]string{}
+	for file, keys := range orphans {
+		if len(keys) == 1 && len(renamed[file]) == 1 {
+			moves[keys[0]] = renamed[file][0]
+		}
+	}
+	if len(moves) == 0 {
+		return
+	}
+
+	err = UpdateMetadataEdits(config.EditsFile, func(edits map[string]MetadataEdit) error {
+		for from, to := range moves {
+			edit, ok := edits[from]
+			if _, taken := edits[to]; !ok || taken {
+				continue
+			}
+			delete(edits, from)
+			edits[to] = edit
+			scanLog.InfoContext(ctx, "Moved metadata edits of a renamed item", "library", library.ID, "from", from, "to", to)
+		}
+		return nil
+	})
+	if err != nil {
+		scanLog.ErrorContext(ctx, "Error moving metadata edits of renamed items", "library", library.ID, "error", err)
+	}
+}
+
+// applyMetadataEdits applies the saved edits to freshly scanned items
+func applyMetadataEdits(ctx context.Context, items []MediaItem) {
+	edits, err := cachedMetadataEdits(config.EditsFile)
+	if err != nil {
+		scanLog.ErrorContext(ctx, "Error loading metadata edits", "error", err)
+		return
+	}
+	if len(edits) == 0 {
+		return
+	}
+
+	for i := range items {
+		if edit, ok := edits[EditKey(items[i])]; ok {
+			edit.apply(&items[i])
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mediastream/config"
+)
+
+func TestMetadataEditsCache(t *testing.T) {
+	backupTest(t, nil)
+	title := "Edited"
+
+	edits, err := LoadMetadataEdits(config.EditsFile)
+	if err != nil || len(edits) != 0 {
+		t.Fatalf("edits without a file = %v, %v", edits, err)
+	}
+
+	// Callers get their own copy to change
+	edits["movies:file:a.mp4"] = MetadataEdit{Title: &title}
+	if cached, _ := cachedMetadataEdits(config.EditsFile); len(cached) != 0 {
+		t.Error("changing loaded edits changed the cache")
+	}
+
+	if err := SaveMetadataEdits(edits, config.EditsFile); err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := cachedMetadataEdits(config.EditsFile); len(cached) != 1 {
+		t.Errorf("cache not updated by saving: %v", cached)
+	}
+
+	// Changes by other programs, like a restore, are noticed
+	if err := os.WriteFile(config.EditsFile, []byte(`{"movies:file:a.mp4": {}, "movies:file:b.mp4": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(config.EditsFile, future, future)
+	if cached, _ := cachedMetadataEdits(config.EditsFile); len(cached) != 2 {
+		t.Errorf("cache not updated after the file changed: %v", cached)
+	}
+}
+
+func TestUpdateMetadataEditsFailure(t *testing.T) {
+	backupTest(t, nil)
+	err := UpdateMetadataEdits(config.EditsFile, func(edits map[string]MetadataEdit) error {
+		edits["movies:file:a.mp4"] = MetadataEdit{}
+		return os.ErrInvalid
+	})
+	if err != os.ErrInvalid {
+		t.Errorf("error = %v", err)
+	}
+	if _, err := os.Stat(config.EditsFile); !os.IsNotExist(err) {
+		t.Error("edits saved after a failed update")
+	}
+}
+
+// editTest creates a movie library holding videos and returns it with a config
+func editTest(t *testing.T, videos ...string) (config.Library, *config.Config, string) {
+	dir := backupTest(t, nil)
+	root := filepath.Join(dir, "movies")
+	for i, video := range videos {
+		file := filepath.Join(root, filepath.FromSlash(video))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, make([]byte, 10+i), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := config.DefaultConfig()
+	cfg.Libraries = []config.Library{{ID: "movies", Name: "Movies", Kind: config.KindMovies, Paths: []string{root}}}
+	return cfg.Libraries[0], cfg, root
+}
+
+// editItem saves an edit of the title of an item the way the edit endpoint does
+func editItem(t *testing.T, item MediaItem, title string) {
+	err := UpdateMetadataEdits(config.EditsFile, func(edits map[string]MetadataEdit) error {
+		edits[EditKey(item)] = MetadataEdit{Title: &title, Size: item.Size, Modified: item.Modified}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// findTitle returns the title of the scanned item whose folder is folder
+func findTitle(items []MediaItem, folder string) string {
+	for _, item := range items {
+		if item.Folder == folder {
+			return item.Title
+		}
+	}
+	return ""
+}
+
+func TestMetadataEditsFollowRenames(t *testing.T) {
+	library, cfg, root := editTest(t, "Film (2001)/Film (2001).mp4", "Other (2002)/Other (2002).mp4")
+	ctx := context.Background()
+	items, err := ScanLibrary(ctx, library, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		if item.Folder == "Film (2001)" {
+			editItem(t, item, "My Film")
+		}
+	}
+
+	// Renaming keeps the size and modification time of the file
+	if err := os.Rename(filepath.Join(root, "Film (2001)"), filepath.Join(root, "Film - Director's Cut (2001)")); err != nil {
+		t.Fatal(err)
+	}
+	items, err = ScanLibrary(ctx, library, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title := findTitle(items, "Film - Director's Cut (2001)"); title != "My Film" {
+		t.Errorf("renamed item is titled %q", title)
+	}
+	edits, _ := LoadMetadataEdits(config.EditsFile)
+	if _, ok := edits["movies:movie:film - director's cut (2001)"]; !ok || len(edits) != 1 {
+		t.Errorf("edits after the rename = %v", edits)
+	}
+}
+
+func TestMetadataEditsAmbiguousRename(t *testing.T) {
+	library, cfg, root := editTest(t, "Film (2001)/Film (2001).mp4")
+	ctx := context.Background()
+	items, err := ScanLibrary(ctx, library, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	editItem(t, items[0], "My Film")
+
+	// Two files that could be the renamed one: the edit waits for the original to come back
+	for _, folder := range []string{"Copy A (2001)", "Copy B (2001)"} {
+		if err := os.MkdirAll(filepath.Join(root, folder), 0755); err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(root, folder, folder+".mp4")
+		if err := os.WriteFile(file, make([]byte, items[0].Size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(file, items[0].Modified, items[0].Modified)
+	}
+	if err := os.RemoveAll(filepath.Join(root, "Film (2001)")); err != nil {
+		t.Fatal(err)
+	}
+	items, err = ScanLibrary(ctx, library, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, folder := range []string{"Copy A (2001)", "Copy B (2001)"} {
+		if title := findTitle(items, folder); title == "My Film" {
+			t.Errorf("edit moved to %s, one of two matches", folder)
+		}
+	}
+	if edits, _ := LoadMetadataEdits(config.EditsFile); len(edits) != 1 {
+		t.Errorf("edits = %v", edits)
+	}
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mediastream/config"
+)
+
+// ErrExtractorUnavailable is returned when the tools a frame extractor runs can't be started
+var ErrExtractorUnavailable = errors.New("frame extractor unavailable")
+
+// FrameExtractor grabs still frames from video files
+type FrameExtractor interface {
+	// Duration returns the length of a video
+	Duration(ctx context.Context, file string) (time.Duration, error)
+	// Frame returns the frame shown at an offset, scaled down to a width
+	Frame(ctx context.Context, file string, at time.Duration, width int) (image.Image, error)
+	// Frames calls fn with one frame every interval from the start, scaled to a width, in order
+	Frames(ctx context.Context, file string, interval time.Duration, width int, fn func(index int, frame image.Image) error) error
+}
+
+// ffmpegExtractor extracts frames by running ffmpeg and ffprobe
+type ffmpegExtractor struct {
+	ffmpeg  string
+	ffprobe string
+}
+
+// NewFFmpegExtractor returns a frame extractor running the configured ffmpeg and ffprobe binaries
+func NewFFmpegExtractor(cfg config.PreviewConfig) FrameExtractor {
+	return ffmpegExtractor{ffmpeg: cfg.FFmpeg(), ffprobe: cfg.FFprobe()}
+}
+
+// run runs a command and returns its output, with the last line of its error output in errors
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExtractorUnavailable, err)
+	}
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+			return nil, fmt.Errorf("%s: %v: %s", filepath.Base(name), err, last)
+		}
+		return nil, fmt.Errorf("%s: %w", filepath.Base(name), err)
+	}
+	return stdout.Bytes(), nil
+}
+
+func (e ffmpegExtractor) Duration(ctx context.Context, file string) (time.Duration, error) {
+	out, err := run(ctx, e.ffprobe, "-v", "error", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", file)
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("ffprobe: unknown duration %q", strings.TrimSpace(string(out)))
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func (e ffmpegExtractor) Frame(ctx context.Context, file string, at time.Duration, width int) (image.Image, error) {
+	// Seeking before the input is fast and lands on the exact frame; small videos aren't enlarged
+	out, err := run(ctx, e.ffmpeg, "-hide_banner", "-loglevel", "error", "-nostdin",
+		"-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64), "-i", file,
+		"-frames:v", "1", "-vf", fmt.Sprintf("scale='min(%d,iw)':-2", width),
+		"-f", "image2pipe", "-c:v", "mjpeg", "-q:v", "2", "pipe:1")
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, errors.New("ffmpeg: no frame at offset")
+	}
+	img, _, err := image.Decode(bytes.NewReader(out))
+	return img, err
+}
+
+func (e ffmpegExtractor) Frames(ctx context.Context, file string, interval time.Duration, width int, fn func(index int, frame image.Image) error) error {
+	dir, err := os.MkdirTemp("", "mediastream-frames-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = run(ctx, e.ffmpeg, "-hide_banner", "-loglevel", "error", "-nostdin", "-i", file,
+		"-an", "-sn", "-vf", fmt.Sprintf("fps=1/%s,scale=%d:-2", strconv.FormatFloat(interval.Seconds(), 'f', -1, 64), width),
+		"-q:v", "5", filepath.Join(dir, "%06d.jpg"))
+	if err != nil {
+		return err
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.jpg"))
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return errors.New("ffmpeg: no frames extracted")
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		frame, err := decodeImageFile(name)
+		if err != nil {
+			return err
+		}
+		if err := fn(i, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeImageFile decodes an image file
+func decodeImageFile(name string) (image.Image, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
@@ -0,0 +1,329 @@
+package models
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mediastream/config"
+	"mediastream/utils"
+)
+
+// defaultProviderTimeout limits requests to providers that don't configure a timeout
+const defaultProviderTimeout = 10 * time.Second
+
+// maxProviderResponse limits how much of a provider response is read
+const maxProviderResponse = 4 << 20
+
+// httpProvider asks an online metadata service speaking a small JSON protocol:
+//
+//	GET {url}/search?kind=&title=&year=&showTitle=&season=&episode=  → [Metadata]
+//	GET {url}/items/{source}/{id}?kind=                              → Metadata
+//	GET {url}/items/{source}/{id}/artwork?kind=                      → [Artwork]
+//
+// A 404 response means the item is unknown. The API key is sent as a bearer token.
+type httpProvider struct {
+	def    config.MetadataProviderConfig
+	client *http.Client
+}
+
+// newHTTPProvider creates the provider for a configured HTTP metadata service
+func newHTTPProvider(def config.MetadataProviderConfig) *httpProvider {
+	timeout := defaultProviderTimeout
+	if def.TimeoutSeconds > 0 {
+		timeout = time.Duration(def.TimeoutSeconds) * time.Second
+	}
+	return &httpProvider{def: def, client: &http.Client{Timeout: timeout}}
+}
+
+func (p *httpProvider) Name() string {
+	return p.def.Name
+}
+
+// get requests a path below the provider URL and decodes the JSON response into result
+func (p *httpProvider) get(ctx context.Context, path string, params url.Values, result any) error {
+	target := strings.TrimSuffix(p.def.URL, "/") + path
+	if len(params) > 0 {
+		target += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.def.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.def.APIKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrMetadataNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxProviderResponse)).Decode(result); err != nil {
+		return fmt.Errorf("invalid response to %s: %v", path, err)
+	}
+	return nil
+}
+
+// clean marks metadata as coming from this provider and drops what only local files may set
+func (p *httpProvider) clean(meta *Metadata) {
+	meta.Source = p.def.Name
+	meta.NFO = ""
+	meta.Artwork = nil
+}
+
+func (p *httpProvider) Search(ctx context.Context, query MetadataQuery) ([]Metadata, error) {
+	params := url.Values{"kind": {query.Kind}, "title": {query.Title}}
+	if query.Year > 0 {
+		params.Set("year", strconv.Itoa(query.Year))
+	}
+	if query.ShowTitle != "" {
+		params.Set("showTitle", query.ShowTitle)
+	}
+	if query.Season > 0 || query.Episode > 0 {
+		params.Set("season", strconv.Itoa(query.Season))
+		params.Set("episode", strconv.Itoa(query.Episode))
+	}
+
+	var results []Metadata
+	if err := p.get(ctx, "/search", params, &results); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		p.clean(&results[i])
+	}
+	return results, nil
+}
+
+func (p *httpProvider) Fetch(ctx context.Context, query MetadataQuery, source, id string) (*Metadata, error) {
+	var meta Metadata
+	path := "/items/" + url.PathEscape(source) + "/" + url.PathEscape(id)
+	if err := p.get(ctx, path, url.Values{"kind": {query.Kind}}, &meta); err != nil {
+		return nil, err
+	}
+	p.clean(&meta)
+	return &meta, nil
+}
+
+// Artwork asks for the images of the first external ID the provider knows
+func (p *httpProvider) Artwork(ctx context.Context, query MetadataQuery) ([]Artwork, error) {
+	for _, source := range sortedKeys(query.IDs) {
+		var artwork []Artwork
+		path := "/items/" + url.PathEscape(source) + "/" + url.PathEscape(query.IDs[source]) + "/artwork"
+		err := p.get(ctx, path, url.Values{"kind": {query.Kind}}, &artwork)
+		if errors.Is(err, ErrMetadataNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Remote artwork is only ever referenced by URL
+		images := artwork[:0]
+		for _, image := range artwork {
+			if image.URL != "" {
+				image.Path = ""
+				images = append(images, image)
+			}
+		}
+		return images, nil
+	}
+	return nil, ErrMetadataNotFound
+}
+
+// cachedProvider keeps the answers of a provider on disk, so rescans don't ask again
+type cachedProvider struct {
+	provider MetadataProvider
+	dir      string
+	ttl      time.Duration
+}
+
+// newCachedProvider caches a provider's answers for the given number of hours, a week if zero
+func newCachedProvider(provider MetadataProvider, hours int) *cachedProvider {
+	if hours == 0 {
+		hours = config.DefaultMetadataCacheHours
+	}
+	return &cachedProvider{
+		provider: provider,
+		dir:      filepath.Join(config.CacheDir, "metadata", provider.Name()),
+		ttl:      time.Duration(hours) * time.Hour,
+	}
+}
+
+// Failed lookups are retried after a minute, then after twice as long each time up to a day
+const (
+	minProviderBackoff = time.Minute
+	maxProviderBackoff = 24 * time.Hour
+)
+
+// errProviderUnavailable is returned instead of asking a provider that failed recently
+var errProviderUnavailable = errors.New("provider unavailable after errors")
+
+// providerBackoff returns how long to wait before asking again after failures in a row
+func providerBackoff(failures int) time.Duration {
+	return min(minProviderBackoff<<min(failures-1, 20), maxProviderBackoff)
+}
+
+// providerHealth tracks providers failing in a row by name. A failing provider isn't asked at
+// all until its backoff ends, so a scan doesn't wait for its timeout on every item.
+var providerHealth = struct {
+	sync.Mutex
+	failures map[string]int
+	retry    map[string]time.Time
+}{failures: map[string]int{}, retry: map[string]time.Time{}}
+
+// providerAvailable reports whether a provider may be asked, because its backoff ended
+func providerAvailable(name string) bool {
+	providerHealth.Lock()
+	defer providerHealth.Unlock()
+	return !time.Now().Before(providerHealth.retry[name])
+}
+
+// providerFailed starts or extends the backoff of a provider
+func providerFailed(name string) {
+	providerHealth.Lock()
+	defer providerHealth.Unlock()
+	providerHealth.failures[name]++
+	providerHealth.retry[name] = time.Now().Add(providerBackoff(providerHealth.failures[name]))
+}
+
+// providerSucceeded ends the backoff of a provider
+func providerSucceeded(name string) {
+	providerHealth.Lock()
+	defer providerHealth.Unlock()
+	delete(providerHealth.failures, name)
+	delete(providerHealth.retry, name)
+}
+
+// cacheEntry is a cached answer; unknown items are cached too. A failed lookup records its error
+// and when to retry, keeping the answer from before it if there was one.
+type cacheEntry[T any] struct {
+	Stored   time.Time `json:"stored,omitzero"`
+	NotFound bool      `json:"notFound,omitempty"`
+	Value    T         `json:"value"`
+	Error    string    `json:"error,omitempty"`
+	Failures int       `json:"failures,omitempty"`
+	Retry    time.Time `json:"retry,omitzero"`
+}
+
+// answer returns the cached answer, or the error of the last lookup if there is none
+func (e cacheEntry[T]) answer() (T, error) {
+	switch {
+	case e.Stored.IsZero() && e.Error != "":
+		return e.Value, fmt.Errorf("%w: %s", errProviderUnavailable, e.Error)
+	case e.Stored.IsZero() || e.NotFound:
+		return e.Value, ErrMetadataNotFound
+	}
+	return e.Value, nil
+}
+
+// cachedCall returns the cached answer for key, calling fetch and caching its answer if there is
+// none or it expired. Errors are cached too and retried with a growing backoff, while the answer
+// from before keeps being used. Offline contexts only ever get cached answers.
+func cachedCall[T any](ctx context.Context, p *cachedProvider, key string, fetch func() (T, error)) (T, error) {
+	sum := sha1.Sum([]byte(key))
+	filename := filepath.Join(p.dir, hex.EncodeToString(sum[:])+".json")
+
+	var entry cacheEntry[T]
+	if data, err := os.ReadFile(filename); err == nil {
+		if json.Unmarshal(data, &entry) != nil {
+			entry = cacheEntry[T]{}
+		}
+	}
+
+	expired := entry.Stored.IsZero() || time.Since(entry.Stored) >= p.ttl
+	backingOff := entry.Error != "" && time.Now().Before(entry.Retry)
+	if isOffline(ctx) || !expired || backingOff {
+		return entry.answer()
+	}
+	if !providerAvailable(p.Name()) {
+		if !entry.Stored.IsZero() {
+			return entry.answer()
+		}
+		var zero T
+		return zero, errProviderUnavailable
+	}
+
+	value, err := fetch()
+	if err != nil && !errors.Is(err, ErrMetadataNotFound) {
+		if ctx.Err() != nil {
+			// A cancelled scan says nothing about the provider
+			return value, err
+		}
+		providerFailed(p.Name())
+		entry.Error = err.Error()
+		entry.Failures++
+		entry.Retry = time.Now().Add(min(providerBackoff(entry.Failures), p.ttl))
+		p.store(ctx, filename, entry)
+		if !entry.Stored.IsZero() {
+			return entry.answer()
+		}
+		return value, err
+	}
+
+	providerSucceeded(p.Name())
+	entry = cacheEntry[T]{Stored: time.Now(), NotFound: err != nil, Value: value}
+	p.store(ctx, filename, entry)
+	return value, err
+}
+
+// store writes a cache entry, logging failures since the answer is still usable
+func (p *cachedProvider) store(ctx context.Context, filename string, entry any) {
+	data, _ := json.Marshal(entry)
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		scanLog.WarnContext(ctx, "Error caching metadata", "provider", p.Name(), "error", err)
+	} else if err := utils.WriteFileAtomic(filename, data, 0644); err != nil {
+		scanLog.WarnContext(ctx, "Error caching metadata", "provider", p.Name(), "error", err)
+	}
+}
+
+func (p *cachedProvider) Name() string {
+	return p.provider.Name()
+}
+
+func (p *cachedProvider) Search(ctx context.Context, query MetadataQuery) ([]Metadata, error) {
+	key := strings.Join([]string{"search", query.Kind, strings.ToLower(query.Title), strconv.Itoa(query.Year),
+		strings.ToLower(query.ShowTitle), strconv.Itoa(query.Season), strconv.Itoa(query.Episode)}, "\x00")
+	return cachedCall(ctx, p, key, func() ([]Metadata, error) {
+		return p.provider.Search(ctx, query)
+	})
+}
+
+func (p *cachedProvider) Fetch(ctx context.Context, query MetadataQuery, source, id string) (*Metadata, error) {
+	key := strings.Join([]string{"fetch", query.Kind, source, id}, "\x00")
+	return cachedCall(ctx, p, key, func() (*Metadata, error) {
+		return p.provider.Fetch(ctx, query, source, id)
+	})
+}
+
+func (p *cachedProvider) Artwork(ctx context.Context, query MetadataQuery) ([]Artwork, error) {
+	var ids []string
+	for _, source := range sortedKeys(query.IDs) {
+		ids = append(ids, source+"="+query.IDs[source])
+	}
+	key := strings.Join(append([]string{"artwork", query.Kind}, ids...), "\x00")
+	return cachedCall(ctx, p, key, func() ([]Artwork, error) {
+		return p.provider.Artwork(ctx, query)
+	})
+}
@@ -0,0 +1,383 @@
+package models
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"mediastream/config"
+	"mediastream/logging"
+	"mediastream/metrics"
+	"mediastream/utils"
+)
+
+// IndexedLibrary is the cached scan result of one library
+type IndexedLibrary struct {
+	Fingerprint string        `json:"fingerprint"` // Changes whenever the library definition, extensions, base path or metadata providers change
+	Items       []MediaItem   `json:"items"`
+	Scanned     time.Time     `json:"scanned"`
+	Duration    time.Duration `json:"duration"`
+}
+
+// IndexStatus summarizes an indexed library for status endpoints
+type IndexStatus struct {
+	LibraryID string        `json:"libraryId"`
+	ItemCount int           `json:"itemCount"`
+	Scanned   time.Time     `json:"scanned"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// Index caches the scanned contents of every library so requests don't walk the disk
+type Index struct {
+	mu        sync.RWMutex
+	libraries map[string]*IndexedLibrary
+	scanLocks map[string]*sync.Mutex // One per library, so a library is never scanned twice at once
+	filename  string
+	scans     sync.WaitGroup // Background rescans
+	onScan    []func(ctx context.Context, library config.Library, items []MediaItem)
+	searches  map[string]*searchIndex // Built on the first search after a library's items change
+	galleries map[string]*gallery     // Built on the first timeline or album request after a library's items change
+	saveErr   error                   // Of the last save
+}
+
+// NewIndex creates an empty index that is saved to filename
+func NewIndex(filename string) *Index {
+	return &Index{
+		libraries: map[string]*IndexedLibrary{},
+		scanLocks: map[string]*sync.Mutex{},
+		searches:  map[string]*searchIndex{},
+		galleries: map[string]*gallery{},
+		filename:  filename,
+	}
+}
+
+// LoadIndex loads the persisted index, starting empty if the file doesn't exist
+func LoadIndex(filename string) (*Index, error) {
+	index := NewIndex(filename)
+
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &index.libraries); err != nil {
+		return nil, err
+	}
+
+	// Report the persisted scans until the libraries are scanned again
+	for id, entry := range index.libraries {
+		metrics.LibraryScanDuration.Set(entry.Duration.Seconds(), id)
+		metrics.LibraryItems.Set(float64(len(entry.Items)), id)
+	}
+
+	return index, nil
+}
+
+// Save writes the index to its file atomically
+func (x *Index) Save() error {
+	x.mu.RLock()
+	data, err := json.Marshal(x.libraries)
+	x.mu.RUnlock()
+	if err == nil {
+		err = utils.WriteFileAtomic(x.filename, data, 0644)
+	}
+
+	x.mu.Lock()
+	x.saveErr = err
+	x.mu.Unlock()
+	return err
+}
+
+// SaveError returns the error of the last save, nil if it succeeded or nothing was saved yet
+func (x *Index) SaveError() error {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.saveErr
+}
+
+// scanVersion changes whenever scanned items gain new details, so indexes from older versions are rescanned
+const scanVersion = 6
+
+// libraryFingerprint hashes everything that influences a library's scan result
+func libraryFingerprint(library config.Library, cfg *config.Config) string {
+	data, _ := json.Marshal(struct {
+		Version    int
+		Library    config.Library
+		Extensions map[string][]string
+		BasePath   string
+		Providers  []config.MetadataProviderConfig
+	}{scanVersion, library, cfg.SupportedExtensions, cfg.Server.BasePath, cfg.MetadataProviders})
+
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// scanLock returns the lock serializing scans of a library
+func (x *Index) scanLock(libraryID string) *sync.Mutex {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	lock, ok := x.scanLocks[libraryID]
+	if !ok {
+		lock = &sync.Mutex{}
+		x.scanLocks[libraryID] = lock
+	}
+	return lock
+}
+
+// cached returns the indexed items of a library if they match its current definition
+func (x *Index) cached(library config.Library, fingerprint string) ([]MediaItem, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	entry, ok := x.libraries[library.ID]
+	if !ok || entry.Fingerprint != fingerprint {
+		return nil, false
+	}
+	return entry.Items, true
+}
+
+// Items returns the media items of a library, scanning it first if it isn't indexed yet.
+// The returned slice is shared and must not be modified.
+func (x *Index) Items(ctx context.Context, library config.Library, cfg *config.Config) ([]MediaItem, error) {
+	fingerprint := libraryFingerprint(library, cfg)
+	if items, ok := x.cached(library, fingerprint); ok {
+		metrics.IndexLookups.Inc("hit")
+		return items, nil
+	}
+
+	lock := x.scanLock(library.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Another request may have finished the scan while we waited
+	if items, ok := x.cached(library, fingerprint); ok {
+		metrics.IndexLookups.Inc("hit")
+		return items, nil
+	}
+
+	metrics.IndexLookups.Inc("miss")
+	return x.scan(ctx, library, cfg, fingerprint)
+}
+
+// Rescan scans a library again and replaces its indexed items
+func (x *Index) Rescan(ctx context.Context, library config.Library, cfg *config.Config) ([]MediaItem, error) {
+	lock := x.scanLock(library.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return x.scan(ctx, library, cfg, libraryFingerprint(library, cfg))
+}
+
+// scan scans a library and stores the result; the caller must hold the library's scan lock
+func (x *Index) scan(ctx context.Context, library config.Library, cfg *config.Config, fingerprint string) ([]MediaItem, error) {
+	start := time.Now()
+	items, err := ScanLibrary(ctx, library, cfg)
+	if err != nil {
+		metrics.LibraryScans.Inc(library.ID, "error")
+		scanLog.ErrorContext(ctx, "Error scanning library", "library", library.ID, "error", err)
+		return nil, err
+	}
+	duration := time.Since(start)
+
+	x.mu.RLock()
+	previous := x.libraries[library.ID]
+	x.mu.RUnlock()
+	keepAddedDates(items, previous, start)
+
+	x.mu.Lock()
+	x.libraries[library.ID] = &IndexedLibrary{
+		Fingerprint: fingerprint,
+		Items:       items,
+		Scanned:     start,
+		Duration:    duration,
+	}
+	x.mu.Unlock()
+
+	metrics.LibraryScans.Inc(library.ID, "success")
+	metrics.LibraryScanDuration.Set(duration.Seconds(), library.ID)
+	metrics.LibraryItems.Set(float64(len(items)), library.ID)
+	scanLog.InfoContext(ctx, "Scanned library", "library", library.ID, "items", len(items), "duration", duration)
+
+	if err := x.Save(); err != nil {
+		scanLog.ErrorContext(ctx, "Error saving library index", "error", err)
+	}
+
+	x.mu.RLock()
+	hooks := x.onScan
+	x.mu.RUnlock()
+	for _, fn := range hooks {
+		fn(ctx, library, items)
+	}
+
+	return items, nil
+}
+
+// keepAddedDates dates items by when the index first saw them. Items of a library's first scan
+// are dated by their modification time, files that appear later by the scan that found them.
+func keepAddedDates(items []MediaItem, previous *IndexedLibrary, scanned time.Time) {
+	added := map[string]time.Time{}
+	if previous != nil {
+		for _, item := range previous.Items {
+			added[item.ID] = item.Added
+		}
+	}
+
+	for i := range items {
+		when, known := added[items[i].ID]
+		switch {
+		case !when.IsZero():
+			items[i].Added = when
+		case known || previous == nil:
+			// Indexes of older versions didn't record the date
+			items[i].Added = items[i].Modified
+		default:
+			items[i].Added = scanned
+		}
+	}
+}
+
+// OnScan registers a function that is called with the items of every library scan.
+// It must not modify the items.
+func (x *Index) OnScan(fn func(ctx context.Context, library config.Library, items []MediaItem)) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.onScan = append(x.onScan, fn)
+}
+
+// RefreshItems rebuilds the indexed items sharing an edit key, e.g. after their metadata was edited,
+// and returns them. The library is scanned first if it isn't indexed yet.
+func (x *Index) RefreshItems(ctx context.Context, library config.Library, cfg *config.Config, key string) ([]MediaItem, error) {
+	if _, err := x.Items(ctx, library, cfg); err != nil {
+		return nil, err
+	}
+
+	lock := x.scanLock(library.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	x.mu.RLock()
+	entry := x.libraries[library.ID]
+	x.mu.RUnlock()
+	if entry == nil {
+		return nil, nil
+	}
+
+	// Indexed items are shared with readers, so the list is replaced instead of changed in place
+	items := append([]MediaItem(nil), entry.Items...)
+	var refreshed []MediaItem
+	for i, item := range items {
+		if EditKey(item) != key {
+			continue
+		}
+		fresh, err := FindLibraryItem(ctx, library, cfg, item.Root, item.RelativePath)
+		if err != nil {
+			scanLog.WarnContext(ctx, "Error refreshing media item", "library", library.ID, "path", item.RelativePath, "error", err)
+			continue
+		}
+		fresh.Duplicates = item.Duplicates
+		fresh.Added = item.Added
+		items[i] = *fresh
+		refreshed = append(refreshed, *fresh)
+	}
+
+	updated := *entry
+	updated.Items = items
+	x.mu.Lock()
+	x.libraries[library.ID] = &updated
+	x.mu.Unlock()
+
+	if err := x.Save(); err != nil {
+		scanLog.ErrorContext(ctx, "Error saving library index", "error", err)
+	}
+	return refreshed, nil
+}
+
+// RescanInBackground rescans libraries without blocking the caller.
+// The scans keep the request ID of ctx but aren't cancelled with it.
+func (x *Index) RescanInBackground(ctx context.Context, libraries []config.Library, cfg *config.Config) {
+	ctx = context.WithoutCancel(ctx)
+	for _, library := range libraries {
+		x.scans.Add(1)
+		go func(library config.Library) {
+			defer x.scans.Done()
+			x.Rescan(ctx, library, cfg)
+		}(library)
+	}
+}
+
+// Sync brings the index in line with a new configuration: removed libraries are dropped
+// and libraries whose definition changed are rescanned in the background
+func (x *Index) Sync(ctx context.Context, cfg *config.Config) {
+	var changed []config.Library
+
+	x.mu.Lock()
+	for id := range x.libraries {
+		if cfg.FindLibrary(id) == nil {
+			delete(x.libraries, id)
+			delete(x.searches, id)
+			delete(x.galleries, id)
+			metrics.LibraryScanDuration.Delete(id)
+			metrics.LibraryItems.Delete(id)
+		}
+	}
+	for _, library := range cfg.Libraries {
+		entry, ok := x.libraries[library.ID]
+		if !ok || entry.Fingerprint != libraryFingerprint(library, cfg) {
+			changed = append(changed, library)
+		}
+	}
+	x.mu.Unlock()
+
+	x.RescanInBackground(ctx, changed, cfg)
+}
+
+// RunPeriodicScans rescans every library at the configured interval until stop is closed
+func (x *Index) RunPeriodicScans(store *config.Store, stop <-chan struct{}) {
+	for {
+		interval := time.Duration(store.Get().ScanIntervalMinutes) * time.Minute
+		if interval == 0 {
+			// Periodic scans are disabled, check again later in case the config changes
+			interval = time.Minute
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+			cfg := store.Get()
+			if cfg.ScanIntervalMinutes > 0 {
+				x.RescanInBackground(logging.BackgroundContext(), cfg.Libraries, cfg)
+			}
+		}
+	}
+}
+
+// Wait blocks until all background rescans have finished
+func (x *Index) Wait() {
+	x.scans.Wait()
+}
+
+// Status returns a summary of every indexed library
+func (x *Index) Status() []IndexStatus {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	status := []IndexStatus{}
+	for id, entry := range x.libraries {
+		status = append(status, IndexStatus{
+			LibraryID: id,
+			ItemCount: len(entry.Items),
+			Scanned:   entry.Scanned,
+			Duration:  entry.Duration,
+		})
+	}
+	return status
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"mediastream/utils"
+)
+
+// Invite represents an onboarding link that lets someone create their own account
+type Invite struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	IsAdmin   bool      `json:"isAdmin"`
+	Libraries []string  `json:"libraries"` // nil means all libraries
+	MaxUses   int       `json:"maxUses"`
+	Uses      int       `json:"uses"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	CreatedBy string    `json:"createdBy"`
+	Created   time.Time `json:"created"`
+}
+
+// IsUsable reports whether the invite can still be redeemed at the given time
+func (i *Invite) IsUsable(now time.Time) bool {
+	return i.Uses < i.MaxUses && now.Before(i.ExpiresAt)
+}
+
+// LoadInvites loads invites from the invites file
+func LoadInvites(filename string) ([]Invite, error) {
+	var invites []Invite
+
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		// If file doesn't exist, return empty array
+		return invites, nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &invites)
+	if err != nil {
+		return nil, err
+	}
+
+	return invites, nil
+}
+
+// SaveInvites saves invites to the invites file
+func SaveInvites(invites []Invite, filename string) error {
+	data, err := json.MarshalIndent(invites, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(filename, data, 0600)
+}
+
+// FindInviteByToken finds an invite by its secret token
+func FindInviteByToken(invites []Invite, token string) *Invite {
+	for i := range invites {
+		if invites[i].Token == token {
+			return &invites[i]
+		}
+	}
+	return nil
+}
+
+// CreateInvite creates a new invite valid for the given duration
+func CreateInvite(createdBy string, isAdmin bool, libraries []string, maxUses int, validFor time.Duration) *Invite {
+	now := time.Now()
+	return &Invite{
+		ID:        utils.GenerateUniqueID(),
+		Token:     utils.GenerateUniqueID() + utils.GenerateUniqueID(),
+		IsAdmin:   isAdmin,
+		Libraries: libraries,
+		MaxUses:   maxUses,
+		ExpiresAt: now.Add(validFor),
+		CreatedBy: createdBy,
+		Created:   now,
+	}
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Sort orders of library listings
+const (
+	SortByTitle      = "title"
+	SortByAdded      = "added"
+	SortByYear       = "year"
+	SortBySize       = "size"
+	SortByDuration   = "duration"
+	SortByLastPlayed = "lastPlayed"
+)
+
+// SortDescending tells the default direction of each sort order: titles from A to Z,
+// everything else newest, largest or longest first
+var SortDescending = map[string]bool{
+	SortByTitle:      false,
+	SortByAdded:      true,
+	SortByYear:       true,
+	SortBySize:       true,
+	SortByDuration:   true,
+	SortByLastPlayed: true,
+}
+
+// titleArticles are skipped at the start of titles when sorting, so "The Matrix" sorts under M
+var titleArticles = []string{"the ", "a ", "an "}
+
+// SortTitle returns the folded title an item is sorted by: its sort title if it has one,
+// otherwise its title without a leading article
+func (item *MediaItem) SortTitle() string {
+	if item.Metadata != nil && item.Metadata.SortTitle != "" {
+		return foldText(strings.TrimSpace(item.Metadata.SortTitle))
+	}
+	title := foldText(strings.TrimSpace(item.Title))
+	for _, article := range titleArticles {
+		if rest, ok := strings.CutPrefix(title, article); ok && strings.TrimSpace(rest) != "" {
+			return strings.TrimSpace(rest)
+		}
+	}
+	return title
+}
+
+// Letter returns the upper case first letter of an item's sort title, or # if it starts with
+// a digit or symbol
+func (item *MediaItem) Letter() string {
+	for _, r := range item.SortTitle() {
+		if unicode.IsLetter(r) {
+			return string(unicode.ToUpper(r))
+		}
+		break
+	}
+	return "#"
+}
+
+// playDuration returns the length of an item in seconds: its runtime, or else the length the
+// player reported when it was played
+func playDuration(item *MediaItem, history map[string]WatchEntry) float64 {
+	if item.Metadata != nil && item.Metadata.Runtime > 0 {
+		return float64(item.Metadata.Runtime * 60)
+	}
+	return history[item.ID].Duration
+}
+
+// sortValue returns the value an item is sorted by, and whether the item has one
+func sortValue(item *MediaItem, sortBy string, history map[string]WatchEntry) (float64, bool) {
+	switch sortBy {
+	case SortByAdded:
+		return float64(item.Added.Unix()), !item.Added.IsZero()
+	case SortByYear:
+		year := item.Year()
+		return float64(year), year != 0
+	case SortBySize:
+		return float64(item.Size), true
+	case SortByDuration:
+		duration := playDuration(item, history)
+		return duration, duration > 0
+	case SortByLastPlayed:
+		played := history[item.ID].Updated
+		return float64(played.UnixNano()), !played.IsZero()
+	}
+	return 0, false
+}
+
+// ListQuery selects a page of filtered, sorted media items
+type ListQuery struct {
+	Filter     ItemFilter
+	Sort       string
+	Descending bool
+	Offset     int
+	Limit      int
+}
+
+// ItemPage is a page of a listing, with the number of items on all pages
+type ItemPage struct {
+	Total  int         `json:"total"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Items  []MediaItem `json:"items"`
+}
+
+// ListItems filters and sorts items and returns a page of them. Items without a value to sort
+// by, such as videos that were never played, come last in either direction; items with the
+// same value are ordered by title.
+func ListItems(items []MediaItem, query ListQuery) ItemPage {
+	type entry struct {
+		item  *MediaItem
+		title string
+		value float64
+		known bool
+	}
+
+	var entries []entry
+	for i := range items {
+		item := &items[i]
+		if !query.Filter.Matches(item) {
+			continue
+		}
+		value, known := sortValue(item, query.Sort, query.Filter.History)
+		entries = append(entries, entry{item, item.SortTitle(), value, known})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if query.Sort != SortByTitle {
+			if a.known != b.known {
+				return a.known
+			}
+			if a.value != b.value {
+				return (a.value < b.value) != query.Descending
+			}
+		}
+		if a.title != b.title {
+			return (a.title < b.title) != (query.Sort == SortByTitle && query.Descending)
+		}
+		return a.item.ID < b.item.ID
+	})
+
+	page := ItemPage{Total: len(entries), Offset: query.Offset, Limit: query.Limit, Items: []MediaItem{}}
+	start := min(query.Offset, len(entries))
+	end := len(entries)
+	if query.Limit > 0 {
+		end = min(start+query.Limit, len(entries))
+	}
+	for _, e := range entries[start:end] {
+		page.Items = append(page.Items, *e.item)
+	}
+	return page
+}
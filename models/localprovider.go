@@ -0,0 +1,35 @@
+package models
+
+import (
+	"context"
+
+	"mediastream/config"
+)
+
+// localProvider reads metadata from NFO files and file names and artwork from images next to the media
+type localProvider struct{}
+
+func (localProvider) Name() string {
+	return config.LocalProvider
+}
+
+// Search reads the NFO sidecars of the queried file, so it always finds exactly one item
+func (localProvider) Search(ctx context.Context, query MetadataQuery) ([]Metadata, error) {
+	if query.Root == "" {
+		return []Metadata{filenameMetadata(query.Name)}, nil
+	}
+	return []Metadata{loadMetadata(query.Library, query.Root, query.RelativePath, query.Name)}, nil
+}
+
+// Fetch finds nothing, local files aren't indexed by external ID
+func (localProvider) Fetch(ctx context.Context, query MetadataQuery, source, id string) (*Metadata, error) {
+	return nil, ErrMetadataNotFound
+}
+
+// Artwork finds the images next to the queried file
+func (localProvider) Artwork(ctx context.Context, query MetadataQuery) ([]Artwork, error) {
+	if query.Root == "" {
+		return nil, nil
+	}
+	return findLocalArtwork(query.Library, query.Root, query.RelativePath), nil
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -11,292 +12,481 @@ import (
 	"time"
 
 	"mediastream/config"
+	"mediastream/logging"
 	"mediastream/utils"
 )
 
+// scanLog logs library scans
+var scanLog = logging.For("scanner")
+
 // MediaItem represents a media item (video, audio, image)
 type MediaItem struct {
-	ID          string    `json:"id"`
-	Title       string    `json:"title"`
-	Type        string    `json:"type"`        // video, audio, image
-	LibraryType string    `json:"libraryType"` // movies, tvshows, music
-	Filename    string    `json:"filename"`
-	Path        string    `json:"path"` // Stream path
-	Size        int64     `json:"size"`
-	Modified    time.Time `json:"modified"`
-	Folder      string    `json:"folder,omitempty"` // For movies organized in folders
+	ID           string         `json:"id"`
+	Title        string         `json:"title"`
+	Type         string         `json:"type"`         // video, audio, image
+	LibraryID    string         `json:"libraryId"`    // ID of the library the item belongs to
+	LibraryType  string         `json:"libraryType"`  // Kind of that library: movies, shows, music, ...
+	Root         string         `json:"root"`         // Key of the library root folder the file is in
+	RelativePath string         `json:"relativePath"` // Slash-separated path below the root folder
+	Filename     string         `json:"filename"`
+	Path         string         `json:"path"` // Stream path
+	Size         int64          `json:"size"`
+	Modified     time.Time      `json:"modified"`
+	Added        time.Time      `json:"added,omitzero"`       // When the index first saw the file
+	Folder       string         `json:"folder,omitempty"`     // For movies organized in folders
+	Duplicates   []string       `json:"duplicates,omitempty"` // IDs of copies of the same item in other roots
+	Metadata     *Metadata      `json:"metadata,omitempty"`   // Videos only
+	Release      *utils.Release `json:"release,omitempty"`    // Videos only, tags of the release name such as resolution and edition
+	Photo        *PhotoInfo     `json:"photo,omitempty"`      // Images only, EXIF data such as the date taken
 }
 
-// ScanDirectory scans a directory for media files
-func ScanDirectory(directoryPath, libraryType string, cfg *config.Config) ([]MediaItem, error) {
-	mediaFiles := []MediaItem{}
+// mediaTypeForFile returns video, audio or image for a supported file, or "" otherwise
+func mediaTypeForFile(filename string, cfg *config.Config) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+
+	for _, mediaType := range []string{"video", "audio", "image"} {
+		for _, supportedExt := range cfg.SupportedExtensions[mediaType] {
+			if ext == supportedExt {
+				return mediaType
+			}
+		}
+	}
 
-	// Debug: Print the directory being scanned
-	fmt.Printf("Scanning directory: %s for library type: %s\n", directoryPath, libraryType)
+	return ""
+}
 
-	// Check if directory exists
-	if _, err := os.Stat(directoryPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("directory does not exist: %s", directoryPath)
+// acceptsMediaType reports whether the library picks up files of the given media type
+func acceptsMediaType(library config.Library, mediaType string) bool {
+	for _, t := range library.MediaTypes() {
+		if t == mediaType {
+			return true
+		}
 	}
+	return false
+}
 
-	entries, err := os.ReadDir(directoryPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read directory %s: %v", directoryPath, err)
+// isExcluded reports whether a file or folder name is skipped by the library's scanner options
+func isExcluded(library config.Library, name string) bool {
+	if !library.Options.IncludeHidden && strings.HasPrefix(name, ".") {
+		return true
+	}
+	for _, pattern := range library.Options.Exclude {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeMediaID builds a URL-safe media ID from a library ID, root key and path relative to that root
+func encodeMediaID(libraryID, rootKey, relativePath string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(libraryID + ":" + rootKey + ":" + relativePath))
+}
+
+// streamPath builds the stream URL for a file below the base path, escaping every path segment
+func streamPath(basePath, libraryID, rootKey, relativePath string) string {
+	segments := strings.Split(relativePath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
 	}
+	return basePath + "/stream/" + url.PathEscape(libraryID) + "/" + rootKey + "/" + strings.Join(segments, "/")
+}
 
-	fmt.Printf("Found %d entries in directory %s\n", len(entries), directoryPath)
+// newMediaItem creates the media item for a file at a slash-separated path relative to a library root
+func newMediaItem(ctx context.Context, library config.Library, cfg *config.Config, rootKey, relativePath, mediaType string, fileInfo os.FileInfo) MediaItem {
+	fileName := filepath.Base(relativePath)
+	item := MediaItem{
+		ID:           encodeMediaID(library.ID, rootKey, relativePath),
+		Type:         mediaType,
+		LibraryID:    library.ID,
+		LibraryType:  library.Kind,
+		Root:         rootKey,
+		RelativePath: relativePath,
+		Filename:     fileName,
+		Path:         streamPath(cfg.Server.BasePath, library.ID, rootKey, relativePath),
+		Size:         fileInfo.Size(),
+		Modified:     fileInfo.ModTime(),
+	}
 
-	// Handle movies differently (each movie is in its own folder)
-	if libraryType == "movies" {
-		for _, entry := range entries {
-			if entry.IsDir() {
-				entryPath := filepath.Join(directoryPath, entry.Name())
-				fmt.Printf("Processing movie folder: %s\n", entryPath)
-
-				// Use folder name as the movie title
-				movieTitle := entry.Name()
-
-				// Find video files in the movie folder
-				movieFiles, err := os.ReadDir(entryPath)
-				if err != nil {
-					fmt.Printf("Error reading movie directory %s: %v\n", entryPath, err)
-					continue
-				}
+	name := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	release := utils.ParseRelease(name)
 
-				// Find all video files (not just the first one)
-				videoFilesFound := 0
-				for _, file := range movieFiles {
-					if file.IsDir() {
-						continue
-					}
-
-					ext := strings.ToLower(filepath.Ext(file.Name()))
-					isVideoFile := false
-
-					for _, supportedExt := range cfg.SupportedExtensions["video"] {
-						if ext == supportedExt {
-							isVideoFile = true
-							break
-						}
-					}
-
-					if isVideoFile {
-						filePath := filepath.Join(entryPath, file.Name())
-						fileInfo, err := os.Stat(filePath)
-						if err != nil {
-							fmt.Printf("Error getting file info for %s: %v\n", filePath, err)
-							continue
-						}
-
-						// Create ID from path
-						relativePath := filepath.Join(entry.Name(), file.Name())
-						id := base64.StdEncoding.EncodeToString([]byte(libraryType + ":" + relativePath))
-
-						// Properly URL-escape both folder name and filename
-						escapedFolder := url.PathEscape(entry.Name())
-						escapedFilename := url.PathEscape(file.Name())
-
-						mediaFiles = append(mediaFiles, MediaItem{
-							ID:          id,
-							Title:       utils.GetTitle(movieTitle),
-							Type:        "video",
-							LibraryType: libraryType,
-							Filename:    file.Name(),
-							Path:        "/stream/movie/" + libraryType + "/" + escapedFolder + "/" + escapedFilename,
-							Size:        fileInfo.Size(),
-							Modified:    fileInfo.ModTime(),
-							Folder:      movieTitle,
-						})
-						videoFilesFound++
-					}
-				}
-				fmt.Printf("Found %d video files in movie folder: %s\n", videoFilesFound, entryPath)
+	// Movies live in their own folder, which names the movie
+	if library.Kind == config.KindMovies {
+		if folder, _, found := strings.Cut(relativePath, "/"); found {
+			item.Folder = folder
+			name = folder
+			release = fillRelease(utils.ParseRelease(folder), release)
+		}
+	}
+	item.Title = release.Title
+
+	if mediaType == "video" {
+		item.Release = &release
+		if root, ok := library.FindRoot(rootKey); ok {
+			title := filenameMetadata(name)
+			meta := ResolveMetadata(ctx, library, cfg, MetadataQuery{
+				Kind:         library.Kind,
+				Title:        title.Title,
+				Year:         title.Year,
+				ShowTitle:    title.ShowTitle,
+				Season:       title.Season,
+				Episode:      title.Episode,
+				Library:      library,
+				Root:         root,
+				RelativePath: relativePath,
+				Name:         name,
+			})
+			item.Metadata = &meta
+			if meta.Title != "" {
+				item.Title = meta.Title
 			}
 		}
-	} else {
-		// Regular handling for other library types
-		for _, entry := range entries {
-			entryPath := filepath.Join(directoryPath, entry.Name())
-			fileInfo, err := os.Stat(entryPath)
+	}
 
-			if err != nil {
-				continue
-			}
+	if mediaType == "image" {
+		if root, ok := library.FindRoot(rootKey); ok {
+			item.Photo = readPhotoInfo(filepath.Join(root, filepath.FromSlash(relativePath)), fileInfo)
+		}
+	}
 
-			if fileInfo.IsDir() {
-				// If directory, scan recursively (for TV shows with seasons)
-				subItems, err := ScanDirectory(entryPath, libraryType, cfg)
-				if err == nil {
-					mediaFiles = append(mediaFiles, subItems...)
-				}
-			} else {
-				ext := strings.ToLower(filepath.Ext(entry.Name()))
-				var mediaType string
-
-				// Determine media type based on extension
-				for _, videoExt := range cfg.SupportedExtensions["video"] {
-					if ext == videoExt {
-						mediaType = "video"
-						break
-					}
-				}
+	return item
+}
 
-				if mediaType == "" {
-					for _, audioExt := range cfg.SupportedExtensions["audio"] {
-						if ext == audioExt {
-							mediaType = "audio"
-							break
-						}
-					}
-				}
+// fillRelease completes what a movie folder's name tells with the tags of the file name,
+// e.g. the resolution of "Inception (2010)/Inception.2010.1080p.BluRay.mkv"
+func fillRelease(folder, file utils.Release) utils.Release {
+	if folder.Year == 0 {
+		folder.Year = file.Year
+	}
+	if folder.Resolution == "" {
+		folder.Resolution = file.Resolution
+	}
+	if folder.Source == "" {
+		folder.Source = file.Source
+	}
+	if folder.VideoCodec == "" {
+		folder.VideoCodec = file.VideoCodec
+	}
+	if folder.AudioCodec == "" {
+		folder.AudioCodec = file.AudioCodec
+	}
+	if folder.Edition == "" {
+		folder.Edition = file.Edition
+	}
+	if folder.Part == 0 {
+		folder.Part = file.Part
+	}
+	if folder.Group == "" {
+		folder.Group = file.Group
+	}
+	folder.ThreeD = folder.ThreeD || file.ThreeD
+	return folder
+}
 
-				if mediaType == "" {
-					for _, imageExt := range cfg.SupportedExtensions["image"] {
-						if ext == imageExt {
-							mediaType = "image"
-							break
-						}
-					}
-				}
+// duplicateKey identifies the same media item stored under different roots
+func duplicateKey(item MediaItem) string {
+	if item.Folder != "" {
+		// Movie folders may hold differently named files for the same movie
+		return "movie:" + strings.ToLower(item.Folder)
+	}
+	return "file:" + strings.ToLower(item.RelativePath)
+}
 
-				if mediaType != "" {
-					// Create ID using the file path relative to the media directory
-					relativePath := entry.Name()
-					id := base64.StdEncoding.EncodeToString([]byte(libraryType + ":" + relativePath))
-
-					mediaFiles = append(mediaFiles, MediaItem{
-						ID:          id,
-						Title:       utils.GetTitle(entry.Name()),
-						Type:        mediaType,
-						LibraryType: libraryType,
-						Filename:    entry.Name(),
-						Path:        "/stream/file/" + libraryType + "/" + url.PathEscape(entry.Name()),
-						Size:        fileInfo.Size(),
-						Modified:    fileInfo.ModTime(),
-					})
+// markDuplicates links items that appear in more than one root of the same library
+func markDuplicates(items []MediaItem) {
+	groups := map[string][]int{}
+	for i, item := range items {
+		key := duplicateKey(item)
+		groups[key] = append(groups[key], i)
+	}
+
+	for _, indexes := range groups {
+		for _, i := range indexes {
+			for _, j := range indexes {
+				// Only copies in other roots count, a movie folder may hold several parts
+				if items[j].Root != items[i].Root {
+					items[i].Duplicates = append(items[i].Duplicates, items[j].ID)
 				}
 			}
 		}
 	}
+}
 
-	fmt.Printf("Total media files found in %s: %d\n", directoryPath, len(mediaFiles))
+// ScanLibrary scans every root folder of a library for media files and merges them into one list
+func ScanLibrary(ctx context.Context, library config.Library, cfg *config.Config) ([]MediaItem, error) {
+	mediaFiles := []MediaItem{}
+	var lastErr error
+	scanned := 0
+
+	for _, root := range library.Paths {
+		items, err := ScanDirectory(ctx, root, library, cfg)
+		if err != nil {
+			scanLog.WarnContext(ctx, "Error scanning library root", "library", library.ID, "root", root, "error", err)
+			lastErr = err
+			continue
+		}
+		scanned++
+		mediaFiles = append(mediaFiles, items...)
+	}
+
+	// Only fail if no root could be scanned at all
+	if scanned == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+
+	markDuplicates(mediaFiles)
+	if scanned == len(library.Paths) {
+		// Items of a root that couldn't be scanned weren't renamed
+		migrateMetadataEdits(ctx, library, mediaFiles)
+	}
+	applyMetadataEdits(ctx, mediaFiles)
 	return mediaFiles, nil
 }
 
-// FindMediaByID finds a media item by its ID
-func FindMediaByID(id string, cfg *config.Config) (*MediaItem, error) {
-	// Decode the ID
-	decodedBytes, err := base64.StdEncoding.DecodeString(id)
-	if err != nil {
-		return nil, err
-	}
+// ScanDirectory scans a library root folder for media files
+func ScanDirectory(ctx context.Context, directoryPath string, library config.Library, cfg *config.Config) ([]MediaItem, error) {
+	scanLog.DebugContext(ctx, "Scanning directory", "library", library.ID, "kind", library.Kind, "path", directoryPath)
 
-	decoded := string(decodedBytes)
-	parts := strings.SplitN(decoded, ":", 2)
-	if len(parts) != 2 {
-		return nil, errors.New("invalid media ID format")
+	// Check if directory exists
+	if _, err := os.Stat(directoryPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("directory does not exist: %s", directoryPath)
 	}
 
-	libraryType := parts[0]
-	relativePath := parts[1]
+	// Handle movies differently (each movie is in its own folder)
+	if library.Kind == config.KindMovies {
+		return scanMovieFolders(ctx, directoryPath, library, cfg)
+	}
 
-	// Find the library folder
-	var libraryFolder config.MediaFolder
-	for _, folder := range cfg.MediaFolders {
-		if folder.Type == libraryType {
-			libraryFolder = folder
-			break
-		}
+	mediaFiles := []MediaItem{}
+	if err := scanTree(ctx, directoryPath, config.RootKey(directoryPath), "", 1, library, cfg, &mediaFiles); err != nil {
+		return nil, err
 	}
 
-	if libraryFolder.Path == "" {
-		return nil, errors.New("library not found")
+	scanLog.DebugContext(ctx, "Scanned directory", "library", library.ID, "path", directoryPath, "items", len(mediaFiles))
+	return mediaFiles, nil
+}
+
+// scanMovieFolders scans a movies root where every movie has its own folder
+func scanMovieFolders(ctx context.Context, directoryPath string, library config.Library, cfg *config.Config) ([]MediaItem, error) {
+	mediaFiles := []MediaItem{}
+	rootKey := config.RootKey(directoryPath)
+
+	entries, err := os.ReadDir(directoryPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %v", directoryPath, err)
 	}
 
-	// Check if this is a movie (which has folder/file format)
-	if strings.Contains(relativePath, "/") && libraryType == "movies" {
-		pathParts := strings.Split(relativePath, "/")
+	for _, entry := range entries {
+		if !entry.IsDir() || isExcluded(library, entry.Name()) {
+			continue
+		}
 
-		if len(pathParts) > 1 {
-			folder := pathParts[0]
-			fileName := pathParts[len(pathParts)-1]
+		entryPath := filepath.Join(directoryPath, entry.Name())
 
-			filePath := filepath.Join(libraryFolder.Path, relativePath)
-			if _, err := os.Stat(filePath); os.IsNotExist(err) {
-				return nil, errors.New("file not found")
+		// Find video files in the movie folder
+		movieFiles, err := os.ReadDir(entryPath)
+		if err != nil {
+			scanLog.WarnContext(ctx, "Error reading movie folder", "library", library.ID, "path", entryPath, "error", err)
+			continue
+		}
+
+		// Find all video files (not just the first one)
+		videoFilesFound := 0
+		for _, file := range movieFiles {
+			if file.IsDir() || isExcluded(library, file.Name()) {
+				continue
+			}
+
+			mediaType := mediaTypeForFile(file.Name(), cfg)
+			if mediaType == "" || !acceptsMediaType(library, mediaType) {
+				continue
 			}
 
+			filePath := filepath.Join(entryPath, file.Name())
 			fileInfo, err := os.Stat(filePath)
 			if err != nil {
-				return nil, err
+				scanLog.WarnContext(ctx, "Error reading media file", "library", library.ID, "path", filePath, "error", err)
+				continue
 			}
 
-			return &MediaItem{
-				ID:          id,
-				Title:       utils.GetTitle(folder),
-				Type:        "video",
-				LibraryType: libraryType,
-				Filename:    fileName,
-				Path:        "/stream/movie/" + libraryType + "/" + url.PathEscape(folder) + "/" + url.PathEscape(fileName),
-				Size:        fileInfo.Size(),
-				Modified:    fileInfo.ModTime(),
-				Folder:      folder,
-			}, nil
+			relativePath := entry.Name() + "/" + file.Name()
+			mediaFiles = append(mediaFiles, newMediaItem(ctx, library, cfg, rootKey, relativePath, mediaType, fileInfo))
+			videoFilesFound++
 		}
+		scanLog.DebugContext(ctx, "Scanned movie folder", "library", library.ID, "path", entryPath, "items", videoFilesFound)
 	}
 
-	// Standard handling for non-movie files
-	filePath := filepath.Join(libraryFolder.Path, relativePath)
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return nil, errors.New("file not found")
-	}
+	scanLog.DebugContext(ctx, "Scanned directory", "library", library.ID, "path", directoryPath, "items", len(mediaFiles))
+	return mediaFiles, nil
+}
 
-	fileInfo, err := os.Stat(filePath)
+// scanTree recursively collects media files below dir, whose path relative to the root is prefix
+func scanTree(ctx context.Context, dir, rootKey, prefix string, depth int, library config.Library, cfg *config.Config, mediaFiles *[]MediaItem) error {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to read directory %s: %v", dir, err)
 	}
 
-	ext := strings.ToLower(filepath.Ext(filePath))
-	var mediaType string
+	for _, entry := range entries {
+		if isExcluded(library, entry.Name()) {
+			continue
+		}
 
-	for _, videoExt := range cfg.SupportedExtensions["video"] {
-		if ext == videoExt {
-			mediaType = "video"
-			break
+		entryPath := filepath.Join(dir, entry.Name())
+		relativePath := entry.Name()
+		if prefix != "" {
+			relativePath = prefix + "/" + entry.Name()
+		}
+
+		fileInfo, err := os.Stat(entryPath)
+		if err != nil {
+			continue
 		}
-	}
 
-	if mediaType == "" {
-		for _, audioExt := range cfg.SupportedExtensions["audio"] {
-			if ext == audioExt {
-				mediaType = "audio"
-				break
+		if fileInfo.IsDir() {
+			// If directory, scan recursively (for TV shows with seasons)
+			if library.Options.MaxDepth == 0 || depth < library.Options.MaxDepth {
+				scanTree(ctx, entryPath, rootKey, relativePath, depth+1, library, cfg, mediaFiles)
 			}
+			continue
 		}
+
+		mediaType := mediaTypeForFile(entry.Name(), cfg)
+		if mediaType == "" || !acceptsMediaType(library, mediaType) {
+			continue
+		}
+
+		*mediaFiles = append(*mediaFiles, newMediaItem(ctx, library, cfg, rootKey, relativePath, mediaType, fileInfo))
 	}
 
-	if mediaType == "" {
-		for _, imageExt := range cfg.SupportedExtensions["image"] {
-			if ext == imageExt {
-				mediaType = "image"
-				break
-			}
+	return nil
+}
+
+// ResolveLibraryFile finds a file by its slash-separated path relative to a library root.
+// Without a root key the roots are tried in order; paths escaping a root are rejected.
+func ResolveLibraryFile(library config.Library, rootKey, relativePath string) (string, string, os.FileInfo, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(relativePath, "/")))
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) || filepath.IsAbs(cleaned) {
+		return "", "", nil, errors.New("invalid path")
+	}
+
+	roots := library.Paths
+	if rootKey != "" {
+		root, ok := library.FindRoot(rootKey)
+		if !ok {
+			return "", "", nil, errors.New("root not found")
 		}
+		roots = []string{root}
+	}
+
+	for _, root := range roots {
+		filePath := filepath.Join(root, cleaned)
+		fileInfo, err := os.Stat(filePath)
+		if err == nil && !fileInfo.IsDir() {
+			return filePath, config.RootKey(root), fileInfo, nil
+		}
+	}
+
+	return "", "", nil, errors.New("file not found")
+}
+
+// decodeMediaID splits a media ID into library ID, root key and relative path.
+// IDs from before libraries had several roots carry no root key and may use standard base64.
+func decodeMediaID(id string) (string, string, string, error) {
+	normalized := strings.NewReplacer("+", "-", "/", "_").Replace(strings.TrimRight(id, "="))
+	decodedBytes, err := base64.RawURLEncoding.DecodeString(normalized)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	libraryID, rest, found := strings.Cut(string(decodedBytes), ":")
+	if !found {
+		return "", "", "", errors.New("invalid media ID format")
+	}
+
+	rootKey, relativePath, found := strings.Cut(rest, ":")
+	if !found || len(rootKey) != 8 {
+		// Legacy ID without a root key
+		return libraryID, "", rest, nil
+	}
+
+	return libraryID, rootKey, relativePath, nil
+}
+
+// FindMediaByID finds a media item by its ID
+func FindMediaByID(ctx context.Context, id string, cfg *config.Config) (*MediaItem, error) {
+	libraryID, rootKey, relativePath, err := decodeMediaID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the library
+	library := cfg.FindLibrary(libraryID)
+	if library == nil {
+		return nil, errors.New("library not found")
+	}
+
+	// A legacy path may itself contain a colon, so fall back to treating the whole rest as the path
+	if _, ok := library.FindRoot(rootKey); rootKey != "" && !ok {
+		relativePath = rootKey + ":" + relativePath
+		rootKey = ""
 	}
 
-	if mediaType == "" {
+	return FindLibraryItem(ctx, *library, cfg, rootKey, relativePath)
+}
+
+// FindLibraryItem returns the media item for a file by its path relative to a library root.
+// Online metadata providers only give what they answered during scans, so requests don't wait for them.
+func FindLibraryItem(ctx context.Context, library config.Library, cfg *config.Config, rootKey, relativePath string) (*MediaItem, error) {
+	ctx = offline(ctx)
+	_, rootKey, fileInfo, err := ResolveLibraryFile(library, rootKey, relativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	relativePath = filepath.ToSlash(filepath.Clean(filepath.FromSlash(strings.TrimPrefix(relativePath, "/"))))
+	mediaType := mediaTypeForFile(relativePath, cfg)
+	if mediaType == "" || !acceptsMediaType(library, mediaType) {
 		return nil, errors.New("unsupported file type")
 	}
 
-	fileName := filepath.Base(relativePath)
-	return &MediaItem{
-		ID:          id,
-		Title:       utils.GetTitle(fileName),
-		Type:        mediaType,
-		LibraryType: libraryType,
-		Filename:    fileName,
-		Path:        "/stream/file/" + libraryType + "/" + url.PathEscape(fileName),
-		Size:        fileInfo.Size(),
-		Modified:    fileInfo.ModTime(),
-	}, nil
+	items := []MediaItem{newMediaItem(ctx, library, cfg, rootKey, relativePath, mediaType, fileInfo)}
+	applyMetadataEdits(ctx, items)
+	return &items[0], nil
+}
+
+// ContentRating returns the content rating of an item, empty if it is unrated
+func (item *MediaItem) ContentRating() string {
+	if item.Metadata == nil {
+		return ""
+	}
+	return item.Metadata.ContentRating
+}
+
+// Year returns the year an item was released or a photo was taken, 0 if it is unknown
+func (item *MediaItem) Year() int {
+	switch {
+	case item.Metadata != nil && item.Metadata.Year != 0:
+		return item.Metadata.Year
+	case item.Release != nil && item.Release.Year != 0:
+		return item.Release.Year
+	case item.Photo != nil && !item.Photo.TakenFromFile:
+		return item.Photo.Taken.Year()
+	}
+	return 0
+}
+
+// Genres returns the genres of an item
+func (item *MediaItem) Genres() []string {
+	if item.Metadata == nil {
+		return nil
+	}
+	return item.Metadata.Genres
+}
+
+// AllowedUnder reports whether an item may be shown under a profile's rating limit.
+// Only videos are rated; music and photos are always allowed.
+func (item *MediaItem) AllowedUnder(limit RatingLimit) bool {
+	return item.Type != "video" || limit.Allows(item.ContentRating())
 }
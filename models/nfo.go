@@ -0,0 +1,469 @@
+package models
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mediastream/config"
+	"mediastream/utils"
+)
+
+// Sources of an item's metadata
+const (
+	MetadataFromNFO      = "nfo"
+	MetadataFromFilename = "filename"
+)
+
+// Metadata describes what a media item is, read from an NFO sidecar file or guessed from its name
+type Metadata struct {
+	Title         string            `json:"title,omitempty"`
+	OriginalTitle string            `json:"originalTitle,omitempty"`
+	SortTitle     string            `json:"sortTitle,omitempty"` // Used instead of the title when sorting, e.g. "Matrix, The"
+	Year          int               `json:"year,omitempty"`
+	Runtime       int               `json:"runtime,omitempty"` // Minutes
+	Plot          string            `json:"plot,omitempty"`
+	Genres        []string          `json:"genres,omitempty"`
+	Cast          []CastMember      `json:"cast,omitempty"`
+	ContentRating string            `json:"contentRating,omitempty"` // e.g. PG-13 or TV-MA, used for profile rating limits
+	Ratings       []Rating          `json:"ratings,omitempty"`
+	IDs           map[string]string `json:"ids,omitempty"` // External database IDs by source, e.g. imdb, tmdb, tvdb
+	ShowTitle     string            `json:"showTitle,omitempty"`
+	Season        int               `json:"season,omitempty"`
+	Episode       int               `json:"episode,omitempty"`
+	Artwork       []Artwork         `json:"artwork,omitempty"`
+	Locked        []string          `json:"locked,omitempty"` // Fields an admin edited, which scans and providers don't change
+	Source        string            `json:"source"`           // nfo, filename or the name of a metadata provider
+	NFO           string            `json:"nfo,omitempty"`    // Sidecar the metadata was read from, relative to the library root
+}
+
+// CastMember is an actor and the role they play
+type CastMember struct {
+	Name string `json:"name"`
+	Role string `json:"role,omitempty"`
+}
+
+// Rating is a score from a rating site such as IMDb
+type Rating struct {
+	Source string  `json:"source"`
+	Value  float64 `json:"value"`
+	Max    int     `json:"max,omitempty"`
+	Votes  int     `json:"votes,omitempty"`
+}
+
+// NFO root elements
+const (
+	nfoMovie   = "movie"
+	nfoShow    = "tvshow"
+	nfoEpisode = "episodedetails"
+)
+
+// nfoDocument is a Kodi NFO file. Elements the server doesn't use are kept in Other,
+// so writing a file back doesn't lose what Kodi or other tools put there.
+type nfoDocument struct {
+	XMLName       xml.Name
+	Title         string        `xml:"title,omitempty"`
+	OriginalTitle string        `xml:"originaltitle,omitempty"`
+	SortTitle     string        `xml:"sorttitle,omitempty"`
+	ShowTitle     string        `xml:"showtitle,omitempty"`
+	Year          string        `xml:"year,omitempty"`
+	Runtime       string        `xml:"runtime,omitempty"` // Minutes
+	Season        string        `xml:"season,omitempty"`
+	Episode       string        `xml:"episode,omitempty"`
+	Plot          string        `xml:"plot,omitempty"`
+	MPAA          string        `xml:"mpaa,omitempty"`
+	Genres        []string      `xml:"genre"`
+	Ratings       *nfoRatings   `xml:"ratings"`
+	Rating        string        `xml:"rating,omitempty"` // Older single-rating format
+	Votes         string        `xml:"votes,omitempty"`
+	UniqueIDs     []nfoUniqueID `xml:"uniqueid"`
+	ID            string        `xml:"id,omitempty"` // Older IMDb or TVDB ID
+	IMDbID        string        `xml:"imdbid,omitempty"`
+	TMDbID        string        `xml:"tmdbid,omitempty"`
+	Actors        []nfoActor    `xml:"actor"`
+	Other         []nfoOther    `xml:",any"`
+
+	trailer string // Scraper URL following the XML
+}
+
+type nfoRatings struct {
+	Ratings []nfoRating `xml:"rating"`
+}
+
+type nfoRating struct {
+	Name    string `xml:"name,attr"`
+	Max     string `xml:"max,attr,omitempty"`
+	Default string `xml:"default,attr,omitempty"`
+	Value   string `xml:"value"`
+	Votes   string `xml:"votes,omitempty"`
+}
+
+type nfoUniqueID struct {
+	Type    string `xml:"type,attr"`
+	Default string `xml:"default,attr,omitempty"`
+	Value   string `xml:",chardata"`
+}
+
+type nfoActor struct {
+	Name  string     `xml:"name"`
+	Role  string     `xml:"role,omitempty"`
+	Other []nfoOther `xml:",any"`
+}
+
+// nfoOther is an element kept as it was read
+type nfoOther struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   []byte     `xml:",innerxml"`
+}
+
+// parseNFO reads an NFO file. Kodi allows a scraper URL after the XML, which is ignored.
+func parseNFO(data []byte) (*nfoDocument, error) {
+	var doc nfoDocument
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	doc.trailer = strings.TrimSpace(string(data[decoder.InputOffset():]))
+	switch doc.XMLName.Local {
+	case nfoMovie, nfoShow, nfoEpisode:
+		return &doc, nil
+	}
+	return nil, errors.New("not a movie, tvshow or episodedetails NFO")
+}
+
+// metadata converts an NFO document into item metadata
+func (doc *nfoDocument) metadata() Metadata {
+	meta := Metadata{
+		Title:         strings.TrimSpace(doc.Title),
+		OriginalTitle: strings.TrimSpace(doc.OriginalTitle),
+		SortTitle:     strings.TrimSpace(doc.SortTitle),
+		ShowTitle:     strings.TrimSpace(doc.ShowTitle),
+		Plot:          strings.TrimSpace(doc.Plot),
+		ContentRating: normalizeContentRating(doc.MPAA),
+		Source:        MetadataFromNFO,
+	}
+	meta.Year, _ = strconv.Atoi(strings.TrimSpace(doc.Year))
+	meta.Runtime, _ = strconv.Atoi(strings.TrimSpace(doc.Runtime))
+	meta.Season, _ = strconv.Atoi(strings.TrimSpace(doc.Season))
+	meta.Episode, _ = strconv.Atoi(strings.TrimSpace(doc.Episode))
+
+	for _, genre := range doc.Genres {
+		// Some tools put every genre in one element
+		for _, g := range strings.Split(genre, " / ") {
+			if g = strings.TrimSpace(g); g != "" {
+				meta.Genres = append(meta.Genres, g)
+			}
+		}
+	}
+
+	for _, actor := range doc.Actors {
+		if name := strings.TrimSpace(actor.Name); name != "" {
+			meta.Cast = append(meta.Cast, CastMember{Name: name, Role: strings.TrimSpace(actor.Role)})
+		}
+	}
+
+	var ratings []nfoRating
+	if doc.Ratings != nil {
+		ratings = doc.Ratings.Ratings
+	}
+	for _, rating := range ratings {
+		value, err := strconv.ParseFloat(strings.TrimSpace(rating.Value), 64)
+		if err != nil {
+			continue
+		}
+		r := Rating{Source: rating.Name, Value: value}
+		r.Max, _ = strconv.Atoi(rating.Max)
+		r.Votes, _ = strconv.Atoi(strings.ReplaceAll(strings.TrimSpace(rating.Votes), ",", ""))
+		meta.Ratings = append(meta.Ratings, r)
+	}
+	if len(meta.Ratings) == 0 && doc.Rating != "" {
+		if value, err := strconv.ParseFloat(strings.TrimSpace(doc.Rating), 64); err == nil {
+			votes, _ := strconv.Atoi(strings.ReplaceAll(strings.TrimSpace(doc.Votes), ",", ""))
+			meta.Ratings = append(meta.Ratings, Rating{Source: "default", Value: value, Max: 10, Votes: votes})
+		}
+	}
+
+	ids := map[string]string{}
+	for _, id := range doc.UniqueIDs {
+		if value := strings.TrimSpace(id.Value); value != "" && id.Type != "" {
+			ids[strings.ToLower(id.Type)] = value
+		}
+	}
+	if doc.IMDbID != "" && ids["imdb"] == "" {
+		ids["imdb"] = strings.TrimSpace(doc.IMDbID)
+	}
+	if doc.TMDbID != "" && ids["tmdb"] == "" {
+		ids["tmdb"] = strings.TrimSpace(doc.TMDbID)
+	}
+	if id := strings.TrimSpace(doc.ID); id != "" {
+		if strings.HasPrefix(id, "tt") && ids["imdb"] == "" {
+			ids["imdb"] = id
+		} else if doc.XMLName.Local != nfoMovie && ids["tvdb"] == "" {
+			ids["tvdb"] = id
+		}
+	}
+	if len(ids) > 0 {
+		meta.IDs = ids
+	}
+
+	return meta
+}
+
+// apply replaces the elements of the document that metadata describes, keeping everything else
+func (doc *nfoDocument) apply(meta Metadata) {
+	doc.Title = meta.Title
+	doc.OriginalTitle = meta.OriginalTitle
+	doc.SortTitle = meta.SortTitle
+	doc.Plot = meta.Plot
+	doc.MPAA = meta.ContentRating
+	doc.Genres = meta.Genres
+	doc.Year = ""
+	if meta.Year > 0 {
+		doc.Year = strconv.Itoa(meta.Year)
+	}
+	if meta.Runtime > 0 {
+		doc.Runtime = strconv.Itoa(meta.Runtime)
+	}
+
+	if doc.XMLName.Local == nfoEpisode {
+		doc.ShowTitle = meta.ShowTitle
+		doc.Season, doc.Episode = "", ""
+		if meta.Season > 0 || meta.Episode > 0 {
+			doc.Season = strconv.Itoa(meta.Season)
+			doc.Episode = strconv.Itoa(meta.Episode)
+		}
+	}
+
+	// Keep the thumbnails and other details of actors that are still in the cast
+	actors := map[string]nfoActor{}
+	for _, actor := range doc.Actors {
+		actors[actor.Name] = actor
+	}
+	doc.Actors = nil
+	for _, member := range meta.Cast {
+		actor := actors[member.Name]
+		actor.Name, actor.Role = member.Name, member.Role
+		doc.Actors = append(doc.Actors, actor)
+	}
+
+	doc.Ratings = nil
+	doc.Rating, doc.Votes = "", ""
+	var ratings []nfoRating
+	for i, rating := range meta.Ratings {
+		r := nfoRating{Name: rating.Source, Value: strconv.FormatFloat(rating.Value, 'f', -1, 64)}
+		if rating.Max > 0 {
+			r.Max = strconv.Itoa(rating.Max)
+		}
+		if rating.Votes > 0 {
+			r.Votes = strconv.Itoa(rating.Votes)
+		}
+		if i == 0 {
+			r.Default = "true"
+		}
+		ratings = append(ratings, r)
+	}
+	if len(ratings) > 0 {
+		doc.Ratings = &nfoRatings{Ratings: ratings}
+	}
+
+	// The older ID elements are replaced by uniqueid
+	doc.ID, doc.IMDbID, doc.TMDbID = "", "", ""
+	doc.UniqueIDs = nil
+	for _, source := range sortedKeys(meta.IDs) {
+		id := nfoUniqueID{Type: source, Value: meta.IDs[source]}
+		if len(doc.UniqueIDs) == 0 {
+			id.Default = "true"
+		}
+		doc.UniqueIDs = append(doc.UniqueIDs, id)
+	}
+}
+
+// sortedKeys returns the keys of a string map in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// contentRatingPrefix matches country and "Rated" prefixes such as "US:" or "Rated "
+var contentRatingPrefix = regexp.MustCompile(`(?i)^(rated\s+|[a-z]{2}\s*:\s*)`)
+
+// normalizeContentRating turns "Rated PG-13" and "US:PG-13" into "PG-13"
+func normalizeContentRating(rating string) string {
+	rating = strings.TrimSpace(rating)
+	for {
+		trimmed := contentRatingPrefix.ReplaceAllString(rating, "")
+		if trimmed == rating {
+			break
+		}
+		rating = strings.TrimSpace(trimmed)
+	}
+	if upper := strings.ToUpper(rating); IsKnownRating(upper) {
+		return upper
+	}
+	return rating
+}
+
+// nfoCandidates lists the NFO files that may describe a media file, most specific first.
+// Paths are slash-separated and relative to the library root.
+func nfoCandidates(library config.Library, relativePath string) []string {
+	dir := path.Dir(relativePath)
+	base := strings.TrimSuffix(path.Base(relativePath), path.Ext(relativePath))
+
+	candidates := []string{path.Join(dir, base+".nfo")}
+	switch library.Kind {
+	case config.KindMovies:
+		candidates = append(candidates, path.Join(dir, "movie.nfo"))
+	case config.KindShows:
+		candidates = append(candidates, path.Join(dir, "episode.nfo"))
+	default:
+		candidates = append(candidates, path.Join(dir, "movie.nfo"), path.Join(dir, "episode.nfo"))
+	}
+	return candidates
+}
+
+// showNFOPath returns the tvshow.nfo of the show a file belongs to, which is the
+// first folder below the library root
+func showNFOPath(relativePath string) (string, bool) {
+	show, _, found := strings.Cut(relativePath, "/")
+	if !found {
+		return "", false
+	}
+	return show + "/tvshow.nfo", true
+}
+
+// readNFO parses an NFO file below a library root
+func readNFO(root, relativePath string) (*nfoDocument, error) {
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(relativePath)))
+	if err != nil {
+		return nil, err
+	}
+	return parseNFO(data)
+}
+
+// filenameMetadata guesses metadata from the folder or file name of an item, without its extension
+func filenameMetadata(name string) Metadata {
+	release := utils.ParseRelease(name)
+	meta := Metadata{Source: MetadataFromFilename, Title: release.Title, Year: release.Year}
+	if release.Season > 0 || release.Episode > 0 {
+		meta.ShowTitle = release.Title
+		meta.Season, meta.Episode = release.Season, release.Episode
+		meta.Title = fmt.Sprintf("%s S%02dE%02d", release.Title, release.Season, release.Episode)
+	}
+	return meta
+}
+
+// loadMetadata reads the metadata of a file from its NFO sidecars, falling back to
+// what its folder or file name reveals
+func loadMetadata(library config.Library, root, relativePath, name string) Metadata {
+	meta := filenameMetadata(name)
+
+	// Episodes inherit the show's title, genres and content rating
+	var show *Metadata
+	if library.Kind == config.KindShows {
+		if showNFO, ok := showNFOPath(relativePath); ok {
+			if doc, err := readNFO(root, showNFO); err == nil && doc.XMLName.Local == nfoShow {
+				m := doc.metadata()
+				show = &m
+			}
+		}
+	}
+
+	for _, candidate := range nfoCandidates(library, relativePath) {
+		doc, err := readNFO(root, candidate)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				scanLog.Warn("Ignoring unreadable NFO file", "library", library.ID, "path", candidate, "error", err)
+			}
+			continue
+		}
+
+		nfo := doc.metadata()
+		nfo.NFO = candidate
+		if nfo.Title == "" {
+			nfo.Title = meta.Title
+		}
+		if nfo.Year == 0 {
+			nfo.Year = meta.Year
+		}
+		meta = nfo
+		break
+	}
+
+	if show != nil {
+		if meta.ShowTitle == "" || meta.Source == MetadataFromFilename {
+			meta.ShowTitle = show.Title
+		}
+		if len(meta.Genres) == 0 {
+			meta.Genres = show.Genres
+		}
+		if meta.ContentRating == "" {
+			meta.ContentRating = show.ContentRating
+		}
+	}
+
+	return meta
+}
+
+// WriteNFO writes the metadata of an item to its NFO sidecar, creating one next to the media
+// file if it has none. Elements of an existing file that metadata doesn't cover are kept.
+func WriteNFO(library config.Library, item *MediaItem) error {
+	root, ok := library.FindRoot(item.Root)
+	if !ok {
+		return errors.New("root not found")
+	}
+	meta := item.Metadata
+	if meta == nil {
+		return errors.New("item has no metadata")
+	}
+
+	target := meta.NFO
+	var doc *nfoDocument
+	if target != "" {
+		existing, err := readNFO(root, target)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		doc = existing
+	}
+	if doc == nil {
+		kind := nfoMovie
+		if library.Kind == config.KindShows {
+			kind = nfoEpisode
+		}
+		doc = &nfoDocument{XMLName: xml.Name{Local: kind}}
+		if target == "" {
+			target = nfoCandidates(library, item.RelativePath)[0]
+		}
+	}
+
+	doc.apply(*meta)
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes" ?>`+"\n"), data...)
+	data = append(data, '\n')
+	if doc.trailer != "" {
+		data = append(data, doc.trailer+"\n"...)
+	}
+
+	if err := utils.WriteFileAtomic(filepath.Join(root, filepath.FromSlash(target)), data, 0644); err != nil {
+		return err
+	}
+	meta.NFO = target
+	meta.Source = MetadataFromNFO
+	return nil
+}
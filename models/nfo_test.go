@@ -0,0 +1,266 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mediastream/config"
+)
+
+func TestParseNFO(t *testing.T) {
+	tests := map[string]struct {
+		nfo  string
+		want Metadata
+	}{
+		"movie": {`<?xml version="1.0" encoding="UTF-8" standalone="yes" ?>
+<movie>
+  <title>The Matrix</title>
+  <originaltitle>The Matrix</originaltitle>
+  <sorttitle>Matrix, The</sorttitle>
+  <year>1999</year>
+  <runtime>136</runtime>
+  <plot> A hacker learns the truth. </plot>
+  <mpaa>Rated R</mpaa>
+  <genre>Action / Science Fiction</genre>
+  <genre>Thriller</genre>
+  <ratings>
+    <rating name="imdb" max="10" default="true"><value>8.7</value><votes>2,000,000</votes></rating>
+    <rating name="tmdb"><value>not a number</value></rating>
+  </ratings>
+  <uniqueid type="IMDB" default="true">tt0133093</uniqueid>
+  <uniqueid type="tmdb">603</uniqueid>
+  <actor><name>Keanu Reeves</name><role>Neo</role><thumb>keanu.jpg</thumb></actor>
+  <actor><name> </name></actor>
+</movie>`, Metadata{
+			Title:         "The Matrix",
+			OriginalTitle: "The Matrix",
+			SortTitle:     "Matrix, The",
+			Year:          1999,
+			Runtime:       136,
+			Plot:          "A hacker learns the truth.",
+			ContentRating: "R",
+			Genres:        []string{"Action", "Science Fiction", "Thriller"},
+			Cast:          []CastMember{{Name: "Keanu Reeves", Role: "Neo"}},
+			Ratings:       []Rating{{Source: "imdb", Value: 8.7, Max: 10, Votes: 2000000}},
+			IDs:           map[string]string{"imdb": "tt0133093", "tmdb": "603"},
+			Source:        MetadataFromNFO,
+		}},
+		"tvshow": {`<tvshow>
+  <title>Breaking Bad</title>
+  <mpaa>US:TV-MA</mpaa>
+  <genre>Drama</genre>
+  <uniqueid type="tvdb">81189</uniqueid>
+  <id>ignored</id>
+</tvshow>`, Metadata{
+			Title:         "Breaking Bad",
+			ContentRating: "TV-MA",
+			Genres:        []string{"Drama"},
+			IDs:           map[string]string{"tvdb": "81189"},
+			Source:        MetadataFromNFO,
+		}},
+		"episodedetails": {`<episodedetails>
+  <title>Pilot</title>
+  <showtitle>Breaking Bad</showtitle>
+  <season>1</season>
+  <episode> 1 </episode>
+  <mpaa>tv-14</mpaa>
+</episodedetails>`, Metadata{
+			Title:         "Pilot",
+			ShowTitle:     "Breaking Bad",
+			Season:        1,
+			Episode:       1,
+			ContentRating: "TV-14",
+			Source:        MetadataFromNFO,
+		}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			doc, err := parseNFO([]byte(test.nfo))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.XMLName.Local != name {
+				t.Errorf("root = %q", doc.XMLName.Local)
+			}
+			if got := doc.metadata(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("metadata =\n%+v\nwant\n%+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseNFORejects(t *testing.T) {
+	for _, nfo := range []string{
+		"",
+		"https://www.themoviedb.org/movie/603",
+		"<musicvideo><title>Song</title></musicvideo>",
+		"<movie><title>Unclosed</movie>",
+	} {
+		if _, err := parseNFO([]byte(nfo)); err == nil {
+			t.Errorf("parseNFO(%q) succeeded", nfo)
+		}
+	}
+}
+
+func TestNFOLegacyElements(t *testing.T) {
+	tests := []struct {
+		nfo     string
+		ratings []Rating
+		ids     map[string]string
+	}{
+		// A single <rating> with its <votes> stands for a default rating out of 10
+		{"<movie><rating>7.5</rating><votes>1,234</votes></movie>", []Rating{{Source: "default", Value: 7.5, Max: 10, Votes: 1234}}, nil},
+		{"<movie><rating>unrated</rating></movie>", nil, nil},
+		// A <ratings> block wins over the single rating
+		{`<movie><ratings><rating name="imdb"><value>8</value></rating></ratings><rating>5</rating></movie>`, []Rating{{Source: "imdb", Value: 8}}, nil},
+
+		// <id> is an IMDb ID when it looks like one, otherwise a TVDB ID outside movies
+		{"<movie><id>tt0133093</id></movie>", nil, map[string]string{"imdb": "tt0133093"}},
+		{"<movie><id>603</id></movie>", nil, nil},
+		{"<episodedetails><id>349232</id></episodedetails>", nil, map[string]string{"tvdb": "349232"}},
+		{`<tvshow><uniqueid type="tvdb">81189</uniqueid><id>1</id></tvshow>`, nil, map[string]string{"tvdb": "81189"}},
+		{"<movie><imdbid>tt0133093</imdbid><tmdbid>603</tmdbid></movie>", nil, map[string]string{"imdb": "tt0133093", "tmdb": "603"}},
+		{`<movie><uniqueid type="imdb">tt0000001</uniqueid><imdbid>tt0133093</imdbid><id>tt0000002</id></movie>`, nil, map[string]string{"imdb": "tt0000001"}},
+	}
+
+	for _, test := range tests {
+		doc, err := parseNFO([]byte(test.nfo))
+		if err != nil {
+			t.Fatalf("%s: %v", test.nfo, err)
+		}
+		meta := doc.metadata()
+		if !reflect.DeepEqual(meta.Ratings, test.ratings) {
+			t.Errorf("%s: ratings = %+v, want %+v", test.nfo, meta.Ratings, test.ratings)
+		}
+		if !reflect.DeepEqual(meta.IDs, test.ids) {
+			t.Errorf("%s: IDs = %v, want %v", test.nfo, meta.IDs, test.ids)
+		}
+	}
+}
+
+func TestNFOTrailer(t *testing.T) {
+	doc, err := parseNFO([]byte("<movie><title>The Matrix</title></movie>\n  https://www.themoviedb.org/movie/603\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.trailer != "https://www.themoviedb.org/movie/603" {
+		t.Errorf("trailer = %q", doc.trailer)
+	}
+	if title := doc.metadata().Title; title != "The Matrix" {
+		t.Errorf("title = %q", title)
+	}
+}
+
+func TestWriteNFORoundTrip(t *testing.T) {
+	root := t.TempDir()
+	library := config.Library{ID: "movies", Kind: config.KindMovies, Paths: []string{root}}
+	original := `<?xml version="1.0" encoding="UTF-8" standalone="yes" ?>
+<movie>
+  <title>Matrix</title>
+  <rating>6</rating>
+  <id>tt0133093</id>
+  <fileinfo><streamdetails><video><codec>h264</codec></video></streamdetails></fileinfo>
+  <set><name>The Matrix Collection</name></set>
+  <thumb aspect="poster" preview="small.jpg">poster.jpg</thumb>
+  <actor><name>Keanu Reeves</name><role>Thomas Anderson</role><thumb>keanu.jpg</thumb><order>0</order></actor>
+  <actor><name>Joe Pantoliano</name><role>Cypher</role></actor>
+</movie>
+https://www.themoviedb.org/movie/603
+`
+	if err := os.MkdirAll(filepath.Join(root, "The Matrix (1999)"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(root, "The Matrix (1999)", "movie.nfo")
+	if err := os.WriteFile(file, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	edited := Metadata{
+		Title:         "The Matrix",
+		Year:          1999,
+		ContentRating: "R",
+		Genres:        []string{"Action"},
+		Cast:          []CastMember{{Name: "Keanu Reeves", Role: "Neo"}, {Name: "Carrie-Anne Moss", Role: "Trinity"}},
+		Ratings:       []Rating{{Source: "imdb", Value: 8.7, Max: 10, Votes: 100}},
+		IDs:           map[string]string{"tmdb": "603", "imdb": "tt0133093"},
+		NFO:           "The Matrix (1999)/movie.nfo",
+	}
+	item := &MediaItem{Root: config.RootKey(root), RelativePath: "The Matrix (1999)/The Matrix (1999).mkv", Metadata: &edited}
+	if err := WriteNFO(library, item); err != nil {
+		t.Fatal(err)
+	}
+	if item.Metadata.Source != MetadataFromNFO {
+		t.Errorf("source after writing = %q", item.Metadata.Source)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := string(data)
+
+	// Elements the server doesn't use, actor details and the scraper URL are kept
+	for _, kept := range []string{
+		"<codec>h264</codec>",
+		"<set><name>The Matrix Collection</name></set>",
+		`<thumb aspect="poster" preview="small.jpg">poster.jpg</thumb>`,
+		"<thumb>keanu.jpg</thumb>",
+		"<order>0</order>",
+	} {
+		if !strings.Contains(written, kept) {
+			t.Errorf("written NFO lost %s:\n%s", kept, written)
+		}
+	}
+	if !strings.HasSuffix(written, "</movie>\nhttps://www.themoviedb.org/movie/603\n") {
+		t.Errorf("written NFO lost the scraper URL:\n%s", written)
+	}
+
+	// Legacy elements and removed actors are gone
+	for _, dropped := range []string{"<rating>6</rating>", "<id>", "Pantoliano"} {
+		if strings.Contains(written, dropped) {
+			t.Errorf("written NFO still has %s:\n%s", dropped, written)
+		}
+	}
+
+	// Reading it back gives the edited metadata
+	doc, err := parseNFO(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := edited
+	want.NFO = ""
+	if got := doc.metadata(); !reflect.DeepEqual(got, want) {
+		t.Errorf("metadata read back =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestWriteNFONew(t *testing.T) {
+	root := t.TempDir()
+	library := config.Library{ID: "shows", Kind: config.KindShows, Paths: []string{root}}
+	meta := Metadata{Title: "Pilot", ShowTitle: "Breaking Bad", Season: 1, Episode: 1, Source: MetadataFromFilename}
+	item := &MediaItem{Root: config.RootKey(root), RelativePath: "Breaking Bad/Season 1/S01E01.mkv", Metadata: &meta}
+	if err := os.MkdirAll(filepath.Join(root, "Breaking Bad", "Season 1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteNFO(library, item); err != nil {
+		t.Fatal(err)
+	}
+
+	if meta.NFO != "Breaking Bad/Season 1/S01E01.nfo" {
+		t.Errorf("NFO = %q", meta.NFO)
+	}
+	doc, err := readNFO(root, meta.NFO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.XMLName.Local != nfoEpisode {
+		t.Errorf("root = %q", doc.XMLName.Local)
+	}
+	if got := doc.metadata(); got.ShowTitle != "Breaking Bad" || got.Season != 1 || got.Episode != 1 || got.Title != "Pilot" {
+		t.Errorf("metadata read back = %+v", got)
+	}
+}
@@ -0,0 +1,477 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Photo formats
+const (
+	PhotoJPEG = "jpeg"
+	PhotoPNG  = "png"
+	PhotoGIF  = "gif"
+	PhotoWebP = "webp"
+	PhotoTIFF = "tiff"
+	PhotoHEIC = "heic" // Also HEIF and AVIF, which Go can't decode
+	PhotoRaw  = "raw"  // Camera raw files; TIFF-based ones carry JPEG previews
+)
+
+// rawExtensions are camera raw formats; the TIFF-based ones store their metadata like TIFF files
+var rawExtensions = map[string]bool{
+	".dng": true, ".cr2": true, ".nef": true, ".nrw": true, ".arw": true, ".srf": true, ".sr2": true,
+	".pef": true, ".orf": true, ".rw2": true, ".raf": true, ".cr3": true, ".3fr": true, ".iiq": true,
+}
+
+// sniffPhotoFormat tells the format of a photo by its first bytes, and by its extension for raw files
+func sniffPhotoFormat(header []byte, name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	switch {
+	case rawExtensions[ext]:
+		return PhotoRaw
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return PhotoJPEG
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return PhotoPNG
+	case bytes.HasPrefix(header, []byte("GIF8")):
+		return PhotoGIF
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return PhotoWebP
+	case isTIFF(header):
+		return PhotoTIFF
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		switch string(header[8:12]) {
+		case "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1", "avif", "avis":
+			return PhotoHEIC
+		}
+	}
+	return ""
+}
+
+// isTIFF reports whether a file starts like a TIFF file
+func isTIFF(header []byte) bool {
+	return bytes.HasPrefix(header, []byte("II*\x00")) || bytes.HasPrefix(header, []byte("MM\x00*"))
+}
+
+// errNoExif is returned for files without an EXIF block
+var errNoExif = errors.New("no EXIF data")
+
+// jpegExif returns the TIFF-formatted EXIF block of a JPEG file: the first APP1 segment,
+// after its "Exif\0\0" header, the way goexif finds it
+func jpegExif(data []byte) ([]byte, error) {
+	start := bytes.Index(data, []byte{0xFF, 0xE1})
+	if start < 0 || start+4 > len(data) {
+		return nil, errNoExif
+	}
+	length := int(binary.BigEndian.Uint16(data[start+2:]))
+	if length < 2 || start+2+length > len(data) {
+		return nil, errNoExif
+	}
+	segment := data[start+4 : start+2+length]
+	if !bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+		return nil, errNoExif
+	}
+	return segment[6:], nil
+}
+
+// maxTIFFDirs bounds the IFDs of an EXIF block; photos have up to five
+const maxTIFFDirs = 16
+
+// tiffTypeSizes are the sizes of the values of TIFF field types, by type
+var tiffTypeSizes = []uint64{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// Tags pointing to the EXIF, GPS and interoperability IFDs
+const (
+	tiffExifIFD    = 0x8769
+	tiffGPSIFD     = 0x8825
+	tiffInteropIFD = 0xA005
+)
+
+// safeExif reports whether goexif can decode a TIFF block. It follows the chain of IFDs until
+// one points to itself, so a longer loop never ends, and multiplies the count of a field's
+// values by their size in 32 bits, allocating the whole count when that overflows.
+func safeExif(data []byte) bool {
+	if len(data) < 8 {
+		return true
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return true
+	}
+
+	type dir struct {
+		offset int64
+		chain  bool // Followed by the next IFD, unlike the ones fields point to
+	}
+	queue := []dir{{int64(int32(order.Uint32(data[4:8]))), true}}
+	seen := map[int64]bool{}
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+		// goexif stops at IFDs outside the block
+		if d.offset == 0 || d.offset < 0 || d.offset+2 > int64(len(data)) {
+			continue
+		}
+		if seen[d.offset] {
+			if d.chain {
+				return false
+			}
+			continue
+		}
+		if len(seen) == maxTIFFDirs {
+			return false
+		}
+		seen[d.offset] = true
+
+		count := max(int64(int16(order.Uint16(data[d.offset:]))), 0)
+		for i := range count {
+			entry := d.offset + 2 + 12*i
+			if entry+12 > int64(len(data)) {
+				break
+			}
+			tag, kind, n := order.Uint16(data[entry:]), order.Uint16(data[entry+2:]), order.Uint32(data[entry+4:])
+			if int(kind) < len(tiffTypeSizes) && tiffTypeSizes[kind]*uint64(n) >= 1<<32 && n != 1<<32-1 {
+				return false
+			}
+			switch tag {
+			case tiffExifIFD, tiffGPSIFD, tiffInteropIFD:
+				// Pointers are single numbers; others could point anywhere
+				switch {
+				case kind == 4 && n == 1:
+					queue = append(queue, dir{int64(order.Uint32(data[entry+8:])), false})
+				case kind == 3 && n == 1:
+					queue = append(queue, dir{int64(order.Uint16(data[entry+8:])), false})
+				default:
+					return false
+				}
+			}
+		}
+
+		if next := d.offset + 2 + 12*count; d.chain && next+4 <= int64(len(data)) {
+			queue = append(queue, dir{int64(int32(order.Uint32(data[next:]))), true})
+		}
+	}
+	return true
+}
+
+// isoBox is a box of an ISO base media file, such as a HEIF image
+type isoBox struct {
+	kind   string
+	offset int64 // Of the payload
+	size   int64 // Of the payload
+}
+
+// readISOBoxes lists the boxes in a section of an ISO base media file
+func readISOBoxes(r io.ReaderAt, offset, end int64) []isoBox {
+	var boxes []isoBox
+	header := make([]byte, 16)
+	for offset+8 <= end && len(boxes) < 1024 {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			break
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		kind := string(header[4:8])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return boxes
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || size > end-offset {
+			break
+		}
+		boxes = append(boxes, isoBox{kind: kind, offset: offset + headerSize, size: size - headerSize})
+		offset += size
+	}
+	return boxes
+}
+
+// heicExif returns the TIFF-formatted EXIF block of a HEIF image, found through the
+// item info (iinf) and item location (iloc) boxes of its meta box
+func heicExif(r io.ReaderAt, size int64) ([]byte, error) {
+	var meta []byte
+	for _, box := range readISOBoxes(r, 0, size) {
+		if box.kind == "meta" && box.size > 4 && box.size < 16<<20 {
+			meta = make([]byte, box.size)
+			if _, err := r.ReadAt(meta, box.offset); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if meta == nil {
+		return nil, errNoExif
+	}
+
+	// The meta box is a full box: its children follow the version and flags
+	metaReader := bytes.NewReader(meta)
+	var iinf, iloc []byte
+	for _, box := range readISOBoxes(metaReader, 4, int64(len(meta))) {
+		switch box.kind {
+		case "iinf":
+			iinf = meta[box.offset : box.offset+box.size]
+		case "iloc":
+			iloc = meta[box.offset : box.offset+box.size]
+		}
+	}
+
+	id, ok := heicExifItem(iinf)
+	if !ok {
+		return nil, errNoExif
+	}
+	offset, length, ok := heicItemLocation(iloc, id)
+	if !ok || length < 8 || length > 16<<20 || offset+length > size {
+		return nil, errNoExif
+	}
+
+	data := make([]byte, length)
+	if _, err := r.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	// The block starts with the offset of the TIFF header, usually skipping "Exif\0\0"
+	start := 4 + int64(binary.BigEndian.Uint32(data[:4]))
+	if start >= length {
+		return nil, errNoExif
+	}
+	return data[start:], nil
+}
+
+// heicExifItem finds the ID of the Exif item in an iinf box
+func heicExifItem(iinf []byte) (uint32, bool) {
+	if len(iinf) < 6 {
+		return 0, false
+	}
+	start := int64(6) // Version, flags and a 16-bit entry count
+	if iinf[0] != 0 {
+		start = 8
+	}
+
+	reader := bytes.NewReader(iinf)
+	for _, box := range readISOBoxes(reader, start, int64(len(iinf))) {
+		infe := iinf[box.offset : box.offset+box.size]
+		if box.kind != "infe" || len(infe) < 4 {
+			continue
+		}
+		switch version := infe[0]; {
+		case version == 2 && len(infe) >= 12:
+			if string(infe[8:12]) == "Exif" {
+				return uint32(binary.BigEndian.Uint16(infe[4:6])), true
+			}
+		case version >= 3 && len(infe) >= 14:
+			if string(infe[10:14]) == "Exif" {
+				return binary.BigEndian.Uint32(infe[4:8]), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// heicItemLocation finds the file offset and length of an item in an iloc box
+func heicItemLocation(iloc []byte, id uint32) (int64, int64, bool) {
+	if len(iloc) < 8 {
+		return 0, 0, false
+	}
+	version := iloc[0]
+	offsetSize := int(iloc[4] >> 4)
+	lengthSize := int(iloc[4] & 0x0F)
+	baseOffsetSize := int(iloc[5] >> 4)
+	indexSize := 0
+	if version == 1 || version == 2 {
+		indexSize = int(iloc[5] & 0x0F)
+	}
+
+	pos := 6
+	read := func(n int) (uint64, bool) {
+		if n == 0 {
+			return 0, true
+		}
+		if n != 2 && n != 4 && n != 8 || pos+n > len(iloc) {
+			return 0, false
+		}
+		var v uint64
+		for _, b := range iloc[pos : pos+n] {
+			v = v<<8 | uint64(b)
+		}
+		pos += n
+		return v, true
+	}
+
+	countSize, idSize := 2, 2
+	if version == 2 {
+		countSize, idSize = 4, 4
+	}
+	count, ok := read(countSize)
+	if !ok {
+		return 0, 0, false
+	}
+	for i := uint64(0); i < count; i++ {
+		itemID, ok := read(idSize)
+		if !ok {
+			return 0, 0, false
+		}
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			if method, ok = read(2); !ok {
+				return 0, 0, false
+			}
+			method &= 0x0F
+		}
+		if _, ok = read(2); !ok { // Data reference index
+			return 0, 0, false
+		}
+		base, ok := read(baseOffsetSize)
+		if !ok {
+			return 0, 0, false
+		}
+		extents, ok := read(2)
+		if !ok {
+			return 0, 0, false
+		}
+
+		var offset, length uint64
+		for e := uint64(0); e < extents; e++ {
+			if _, ok = read(indexSize); !ok {
+				return 0, 0, false
+			}
+			extentOffset, ok := read(offsetSize)
+			if !ok {
+				return 0, 0, false
+			}
+			extentLength, ok := read(lengthSize)
+			if !ok {
+				return 0, 0, false
+			}
+			if e == 0 {
+				offset, length = base+extentOffset, extentLength
+			}
+		}
+
+		// Only items stored at a file offset in a single extent are supported
+		if uint32(itemID) == id {
+			return int64(offset), int64(length), method == 0 && extents == 1
+		}
+	}
+	return 0, 0, false
+}
+
+// TIFF tags that locate embedded JPEG previews
+const (
+	tiffCompression     = 0x0103
+	tiffStripOffsets    = 0x0111
+	tiffStripByteCounts = 0x0117
+	tiffSubIFDs         = 0x014A
+	tiffJPEGOffset      = 0x0201
+	tiffJPEGLength      = 0x0202
+)
+
+// rawPreviews lists the JPEG images embedded in a TIFF-based raw file, largest first.
+// Some are previews for cameras' displays, others the lossless raw data Go can't decode.
+func rawPreviews(r io.ReaderAt, size int64) []*io.SectionReader {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil
+	}
+	var order binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+
+	type section struct{ offset, length int64 }
+	var sections []section
+	add := func(offset, length uint32) {
+		o, l := int64(offset), int64(length)
+		if o <= 0 || l < 1024 || o+l > size {
+			return
+		}
+		magic := make([]byte, 2)
+		if _, err := r.ReadAt(magic, o); err == nil && magic[0] == 0xFF && magic[1] == 0xD8 {
+			sections = append(sections, section{o, l})
+		}
+	}
+
+	queue := []uint32{order.Uint32(header[4:8])}
+	seen := map[uint32]bool{}
+	for len(queue) > 0 && len(seen) < 32 {
+		offset := queue[0]
+		queue = queue[1:]
+		if offset == 0 || seen[offset] || int64(offset)+2 > size {
+			continue
+		}
+		seen[offset] = true
+
+		countBytes := make([]byte, 2)
+		if _, err := r.ReadAt(countBytes, int64(offset)); err != nil {
+			continue
+		}
+		count := int(order.Uint16(countBytes))
+		entries := make([]byte, count*12+4)
+		if _, err := r.ReadAt(entries, int64(offset)+2); err != nil {
+			continue
+		}
+
+		var compression, stripOffset, stripLength, jpegOffset, jpegLength uint32
+		var stripCount uint32
+		for i := 0; i < count; i++ {
+			entry := entries[i*12 : i*12+12]
+			tag, kind, n := order.Uint16(entry[0:2]), order.Uint16(entry[2:4]), order.Uint32(entry[4:8])
+			value := order.Uint32(entry[8:12])
+			if kind == 3 { // SHORT values are stored in the first two bytes
+				value = uint32(order.Uint16(entry[8:10]))
+			}
+			switch tag {
+			case tiffCompression:
+				compression = value
+			case tiffStripOffsets:
+				stripOffset, stripCount = value, n
+			case tiffStripByteCounts:
+				stripLength = value
+			case tiffJPEGOffset:
+				jpegOffset = value
+			case tiffJPEGLength:
+				jpegLength = value
+			case tiffSubIFDs:
+				if n == 1 {
+					queue = append(queue, value)
+				} else if n > 1 && n <= 16 {
+					offsets := make([]byte, 4*n)
+					if _, err := r.ReadAt(offsets, int64(value)); err == nil {
+						for j := uint32(0); j < n; j++ {
+							queue = append(queue, order.Uint32(offsets[4*j:]))
+						}
+					}
+				}
+			}
+		}
+		add(jpegOffset, jpegLength)
+		if (compression == 6 || compression == 7) && stripCount == 1 {
+			add(stripOffset, stripLength)
+		}
+		queue = append(queue, order.Uint32(entries[count*12:]))
+	}
+
+	sort.Slice(sections, func(i, j int) bool { return sections[i].length > sections[j].length })
+	previews := make([]*io.SectionReader, len(sections))
+	for i, s := range sections {
+		previews[i] = io.NewSectionReader(r, s.offset, s.length)
+	}
+	return previews
+}
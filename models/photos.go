@@ -0,0 +1,607 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"golang.org/x/image/tiff"
+
+	"mediastream/config"
+)
+
+// PhotoInfo holds what a photo's file and EXIF data tell about it
+type PhotoInfo struct {
+	Format        string       `json:"format"`                  // jpeg, png, gif, webp, tiff, heic or raw
+	Taken         time.Time    `json:"taken"`                   // When the photo was taken
+	TakenFromFile bool         `json:"takenFromFile,omitempty"` // No date in the EXIF data, Taken is the file's modification time
+	Width         int          `json:"width,omitempty"`         // As displayed, after applying the orientation
+	Height        int          `json:"height,omitempty"`
+	Orientation   int          `json:"orientation,omitempty"` // EXIF orientation 1-8; images served by the server are already upright
+	Camera        string       `json:"camera,omitempty"`      // Make and model
+	Lens          string       `json:"lens,omitempty"`
+	ExposureTime  string       `json:"exposureTime,omitempty"` // e.g. "1/250"
+	FNumber       float64      `json:"fNumber,omitempty"`
+	ISO           int          `json:"iso,omitempty"`
+	FocalLength   float64      `json:"focalLength,omitempty"` // In millimeters
+	Location      *GeoLocation `json:"location,omitempty"`
+}
+
+// GeoLocation is where a photo was taken
+type GeoLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"` // Meters above sea level
+}
+
+// maxExifRead bounds how much of a file is read looking for EXIF data
+const maxExifRead = 8 << 20
+
+// readPhotoInfo reads the format, dimensions and EXIF data of a photo. Files it can't make
+// sense of still get their format and modification time.
+func readPhotoInfo(file string, fileInfo os.FileInfo) *PhotoInfo {
+	photo := &PhotoInfo{Taken: fileInfo.ModTime(), TakenFromFile: true}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return photo
+	}
+	defer f.Close()
+
+	header := make([]byte, 16)
+	n, _ := io.ReadFull(f, header)
+	photo.Format = sniffPhotoFormat(header[:n], file)
+
+	var block []byte
+	switch {
+	case photo.Format == PhotoHEIC:
+		block, err = heicExif(f, fileInfo.Size())
+	case photo.Format == PhotoJPEG || isTIFF(header):
+		data := make([]byte, min(fileInfo.Size(), maxExifRead))
+		if _, err = f.ReadAt(data, 0); err == nil {
+			block = data
+			if photo.Format == PhotoJPEG {
+				block, err = jpegExif(data)
+			}
+		}
+	}
+	if err == nil && block != nil && safeExif(block) {
+		if x, err := exif.Decode(bytes.NewReader(block)); err == nil || !exif.IsCriticalError(err) {
+			fillPhotoInfo(photo, x)
+		}
+	}
+
+	// Formats Go decodes have their size in the header
+	if photo.Width == 0 && photo.Format != PhotoRaw && photo.Format != PhotoHEIC {
+		if cfg, err := decodeImageConfig(io.NewSectionReader(f, 0, fileInfo.Size())); err == nil {
+			photo.Width, photo.Height = cfg.Width, cfg.Height
+		}
+	}
+	if photo.Orientation >= 5 {
+		photo.Width, photo.Height = photo.Height, photo.Width
+	}
+	return photo
+}
+
+// exifString returns a text field of EXIF data without padding
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(value, "\x00"))
+}
+
+// exifRational returns a fractional EXIF field as a number
+func exifRational(x *exif.Exif, name exif.FieldName) (int64, int64, bool) {
+	tag, err := x.Get(name)
+	if err != nil {
+		return 0, 0, false
+	}
+	num, den, err := tag.Rat2(0)
+	if err != nil || den == 0 {
+		return 0, 0, false
+	}
+	return num, den, true
+}
+
+// fillPhotoInfo copies the interesting EXIF fields into a photo's info
+func fillPhotoInfo(photo *PhotoInfo, x *exif.Exif) {
+	if taken, err := x.DateTime(); err == nil && taken.Year() > 1900 {
+		photo.Taken = taken
+		photo.TakenFromFile = false
+	}
+
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if o, err := tag.Int(0); err == nil && o >= 1 && o <= 8 {
+			photo.Orientation = o
+		}
+	}
+	for _, dims := range [][2]exif.FieldName{{exif.PixelXDimension, exif.PixelYDimension}, {exif.ImageWidth, exif.ImageLength}} {
+		w, errW := x.Get(dims[0])
+		h, errH := x.Get(dims[1])
+		if errW != nil || errH != nil {
+			continue
+		}
+		width, errW := w.Int(0)
+		height, errH := h.Int(0)
+		if errW == nil && errH == nil && width > 0 && height > 0 {
+			photo.Width, photo.Height = width, height
+			break
+		}
+	}
+
+	// Models often repeat the make, e.g. "Canon" and "Canon EOS R6"
+	maker, model := exifString(x, exif.Make), exifString(x, exif.Model)
+	switch {
+	case model == "":
+		photo.Camera = maker
+	case maker == "" || strings.HasPrefix(strings.ToLower(model), strings.ToLower(maker)):
+		photo.Camera = model
+	default:
+		photo.Camera = maker + " " + model
+	}
+	photo.Lens = exifString(x, exif.LensModel)
+
+	if num, den, ok := exifRational(x, exif.ExposureTime); ok && num > 0 {
+		if num >= den {
+			photo.ExposureTime = fmt.Sprintf("%g", float64(num)/float64(den))
+		} else {
+			photo.ExposureTime = fmt.Sprintf("1/%d", int64(math.Round(float64(den)/float64(num))))
+		}
+	}
+	if num, den, ok := exifRational(x, exif.FNumber); ok {
+		photo.FNumber = math.Round(float64(num)/float64(den)*10) / 10
+	}
+	if num, den, ok := exifRational(x, exif.FocalLength); ok {
+		photo.FocalLength = math.Round(float64(num)/float64(den)*10) / 10
+	}
+	if tag, err := x.Get(exif.ISOSpeedRatings); err == nil {
+		if iso, err := tag.Int(0); err == nil {
+			photo.ISO = iso
+		}
+	}
+
+	if lat, long, err := x.LatLong(); err == nil && !(lat == 0 && long == 0) && !math.IsNaN(lat) && !math.IsNaN(long) {
+		location := &GeoLocation{Latitude: lat, Longitude: long}
+		if num, den, ok := exifRational(x, exif.GPSAltitude); ok {
+			location.Altitude = float64(num) / float64(den)
+			if tag, err := x.Get(exif.GPSAltitudeRef); err == nil && len(tag.Val) > 0 && tag.Val[0] == 1 {
+				location.Altitude = -location.Altitude
+			}
+		}
+		photo.Location = location
+	}
+}
+
+// orientImage turns an image upright according to its EXIF orientation
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	outW, outH := w, h
+	if orientation >= 5 {
+		outW, outH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, outW, outH))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored
+				dx, dy = w-1-x, y
+			case 3: // Upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // Upside down and mirrored
+				dx, dy = x, h-1-y
+			case 5: // Mirrored along the top-left diagonal
+				dx, dy = y, x
+			case 6: // Turned left, so it is rotated clockwise
+				dx, dy = h-1-y, x
+			case 7: // Mirrored along the top-right diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // Turned right, so it is rotated counterclockwise
+				dx, dy = y, w-1-x
+			}
+			s := src.PixOffset(src.Bounds().Min.X+x, src.Bounds().Min.Y+y)
+			d := dst.PixOffset(dx, dy)
+			copy(dst.Pix[d:d+4], src.Pix[s:s+4])
+		}
+	}
+	return dst
+}
+
+// ErrPhotoUnsupported is returned for photos the server can't decode, such as HEIC files without ffmpeg
+var ErrPhotoUnsupported = errors.New("photo format can't be displayed")
+
+// errImageTooLarge is returned for images with more pixels than are decoded at once
+var errImageTooLarge = errors.New("image too large")
+
+// photoWidths are the widths photos are resized to; larger than artwork for full-screen views
+var photoWidths = []int{100, 200, 300, 400, 600, 800, 1200, 1600, 2000, 2560, 3840}
+
+// tiffReader returns a reader of a TIFF file that can be read at any offset, or nil for other files.
+// image.Decode would read TIFF files through a buffer holding everything up to the offsets they
+// point to, however large.
+func tiffReader(r io.Reader) io.Reader {
+	if ra, ok := r.(io.ReaderAt); ok {
+		header := make([]byte, 4)
+		if _, err := ra.ReadAt(header, 0); err == nil && isTIFF(header) {
+			return r
+		}
+	}
+	return nil
+}
+
+// decodeImageConfig reads the size of an image at the start of r
+func decodeImageConfig(r io.Reader) (image.Config, error) {
+	if t := tiffReader(r); t != nil {
+		return tiff.DecodeConfig(t)
+	}
+	cfg, _, err := image.DecodeConfig(r)
+	return cfg, err
+}
+
+// decodeImage decodes the image at the start of r
+func decodeImage(r io.Reader) (image.Image, error) {
+	if t := tiffReader(r); t != nil {
+		return tiff.Decode(t)
+	}
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// decodePhoto decodes a photo, using the JPEG preview of raw files and the frame extractor for
+// formats Go can't decode. It reports whether the image still needs the EXIF orientation applied.
+func decodePhoto(ctx context.Context, file string, photo *PhotoInfo, width int, extractor FrameExtractor) (image.Image, bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+
+	decode := func(r io.ReadSeeker) (image.Image, error) {
+		cfg, err := decodeImageConfig(r)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Width*cfg.Height > maxArtworkPixels {
+			return nil, errImageTooLarge
+		}
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return decodeImage(r)
+	}
+
+	switch photo.Format {
+	case PhotoJPEG, PhotoPNG, PhotoGIF, PhotoWebP, PhotoTIFF:
+		img, err := decode(f)
+		if err == nil {
+			return img, true, nil
+		}
+		if errors.Is(err, errImageTooLarge) {
+			return nil, false, err
+		}
+	case PhotoRaw:
+		for _, preview := range rawPreviews(f, info.Size()) {
+			if img, err := decode(preview); err == nil {
+				return img, true, nil
+			}
+		}
+	}
+
+	// Left to the extractor, e.g. HEIC with a recent ffmpeg, which also applies the rotation
+	if extractor != nil {
+		if width <= 0 {
+			width = photoWidths[len(photoWidths)-1]
+		}
+		if img, err := extractor.Frame(ctx, file, 0, width); err == nil {
+			return img, false, nil
+		}
+	}
+	return nil, false, ErrPhotoUnsupported
+}
+
+// RenderPhoto returns a photo turned upright, resized to a width and converted to a format if
+// requested. Raw files show their embedded preview. Variants are cached, so each is only rendered once.
+func RenderPhoto(ctx context.Context, library config.Library, item *MediaItem, width int, format string, extractor FrameExtractor) (*ArtworkImage, error) {
+	if item.Photo == nil {
+		return nil, ErrArtworkNotFound
+	}
+	file, _, info, err := ResolveLibraryFile(library, item.Root, item.RelativePath)
+	if err != nil {
+		return nil, ErrArtworkNotFound
+	}
+
+	if width > 0 {
+		width = snapWidth(photoWidths, width)
+	}
+	if format == "" {
+		format = "jpeg"
+		if item.Photo.Format == PhotoPNG || item.Photo.Format == PhotoGIF {
+			format = "png"
+		}
+	}
+
+	variant := hashKey(fmt.Sprintf("photo:%s:%d:%d:%d:%s", file, info.ModTime().UnixNano(), info.Size(), width, format))
+	target := filepath.Join(config.CacheDir, "photos", variant[:2], variant+"."+format)
+	result := &ArtworkImage{Path: target, ETag: variant[:16], ContentType: ArtworkFormats[format]}
+	if fileExists(target) {
+		return result, nil
+	}
+
+	select {
+	case artworkRenders <- struct{}{}:
+		defer func() { <-artworkRenders }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	img, orient, err := decodePhoto(ctx, file, item.Photo, width, extractor)
+	if err != nil {
+		return nil, err
+	}
+
+	// Scale before turning the photo upright; the width is that of the upright photo
+	orientation := 1
+	if orient {
+		orientation = item.Photo.Orientation
+	}
+	scaleTo := width
+	if orientation >= 5 && width > 0 {
+		scaleTo = width * img.Bounds().Dx() / max(1, img.Bounds().Dy())
+	}
+	img = orientImage(scaleImage(img, scaleTo), orientation)
+
+	if err := saveImage(img, format, target); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Date returns when an item was taken: the EXIF date of photos, the modification time of other files
+func (item *MediaItem) Date() time.Time {
+	if item.Photo != nil {
+		return item.Photo.Taken
+	}
+	return item.Modified
+}
+
+// TimelineGroups are the layouts of the dates items can be grouped by
+var TimelineGroups = map[string]string{"year": "2006", "month": "2006-01", "day": "2006-01-02"}
+
+// TimelineGroup holds the photos and videos of one year, month or day
+type TimelineGroup struct {
+	Date  string      `json:"date"` // e.g. 2024, 2024-07 or 2024-07-14
+	Count int         `json:"count"`
+	Items []MediaItem `json:"items"`
+}
+
+// isGalleryItem reports whether an item belongs in photo timelines and albums
+func isGalleryItem(item *MediaItem) bool {
+	return item.Type == "image" || item.Type == "video"
+}
+
+// Album is a folder of photos and videos. Folders holding only other albums are albums too,
+// so the whole tree can be browsed.
+type Album struct {
+	ID     string    `json:"id"`
+	Name   string    `json:"name"`
+	Path   string    `json:"path"`             // Below the library roots, "" for files in the roots themselves
+	Parent string    `json:"parent,omitempty"` // ID of the enclosing album
+	Count  int       `json:"count"`            // Items directly in the album
+	Cover  string    `json:"cover,omitempty"`  // ID of the earliest item in the album or its sub-albums
+	Start  time.Time `json:"start"`            // Date range of the album and its sub-albums
+	End    time.Time `json:"end"`
+}
+
+// albumID derives a stable ID from an album's path
+func albumID(albumPath string) string {
+	return hashKey("album:" + albumPath)[:16]
+}
+
+// albumPath returns the album of an item: its folder below the root
+func albumPath(item *MediaItem) string {
+	if dir := path.Dir(item.RelativePath); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// gallery holds the photos and videos of a library in date order, grouped into albums
+type gallery struct {
+	items    []MediaItem      // All items of the library, as given
+	sorted   []int            // Indexes of photos and videos, oldest first
+	albums   []Album          // Sorted by path
+	byID     map[string]int   // Index of each album
+	contents map[string][]int // Indexes of the items directly in each album, by path, oldest first
+}
+
+// buildGallery sorts the photos and videos of a library by date and collects their folder
+// albums. Folders with the same path in several roots form one album.
+func buildGallery(library config.Library, items []MediaItem) *gallery {
+	g := &gallery{items: items, byID: map[string]int{}, contents: map[string][]int{}}
+	for i := range items {
+		if isGalleryItem(&items[i]) {
+			g.sorted = append(g.sorted, i)
+		}
+	}
+	sort.SliceStable(g.sorted, func(i, j int) bool { return items[g.sorted[i]].Date().Before(items[g.sorted[j]].Date()) })
+
+	albums := map[string]*Album{}
+	get := func(p string) *Album {
+		album, ok := albums[p]
+		if !ok {
+			album = &Album{ID: albumID(p), Name: path.Base(p), Path: p}
+			if p == "" {
+				album.Name = library.Name
+			} else if parent := path.Dir(p); parent != "." {
+				album.Parent = albumID(parent)
+			}
+			albums[p] = album
+		}
+		return album
+	}
+
+	for _, i := range g.sorted {
+		item := &items[i]
+		p := albumPath(item)
+		get(p).Count++
+		g.contents[p] = append(g.contents[p], i)
+
+		// Extend the album and every enclosing one
+		for {
+			album := get(p)
+			date := item.Date()
+			if album.Cover == "" {
+				album.Cover, album.Start = item.ID, date
+			}
+			album.End = date
+			if p == "" {
+				break
+			}
+			if p = path.Dir(p); p == "." {
+				// Files in the root belong to the root album, folders below it don't
+				break
+			}
+		}
+	}
+
+	g.albums = make([]Album, 0, len(albums))
+	for _, album := range albums {
+		g.albums = append(g.albums, *album)
+	}
+	sort.Slice(g.albums, func(i, j int) bool { return g.albums[i].Path < g.albums[j].Path })
+	for i, album := range g.albums {
+		g.byID[album.ID] = i
+	}
+	return g
+}
+
+// covers reports whether the gallery was built from items
+func (g *gallery) covers(items []MediaItem) bool {
+	return len(g.items) == len(items) && (len(items) == 0 || &g.items[0] == &items[0])
+}
+
+// libraryGallery returns the gallery of items. Galleries of a library's indexed items are
+// kept until its items change; those of other items, such as the ones allowed to a user
+// with a rating limit, are built for each request.
+func (x *Index) libraryGallery(library config.Library, items []MediaItem) *gallery {
+	x.mu.RLock()
+	cached := x.galleries[library.ID]
+	entry := x.libraries[library.ID]
+	x.mu.RUnlock()
+	if cached != nil && cached.covers(items) {
+		return cached
+	}
+
+	g := buildGallery(library, items)
+	if entry != nil && g.covers(entry.Items) {
+		x.mu.Lock()
+		x.galleries[library.ID] = g
+		x.mu.Unlock()
+	}
+	return g
+}
+
+// TimelinePage is a page of a timeline. Pages are counted in items, so a group can continue
+// on the next page; the count of a group is always that of all its items.
+type TimelinePage struct {
+	Total  int             `json:"total"` // Photos and videos on all pages
+	Offset int             `json:"offset"`
+	Limit  int             `json:"limit"`
+	Groups []TimelineGroup `json:"groups"`
+}
+
+// Timeline groups a page of the photos and videos of a library by the date they were taken,
+// newest first. A limit of 0 returns every item.
+func (x *Index) Timeline(library config.Library, items []MediaItem, group string, offset, limit int) TimelinePage {
+	layout, ok := TimelineGroups[group]
+	if !ok {
+		layout = TimelineGroups["month"]
+	}
+	g := x.libraryGallery(library, items)
+
+	page := TimelinePage{Total: len(g.sorted), Offset: offset, Limit: limit, Groups: []TimelineGroup{}}
+	start := min(offset, len(g.sorted))
+	end := len(g.sorted)
+	if limit > 0 {
+		end = min(start+limit, len(g.sorted))
+	}
+
+	// Newest first, so the page is counted from the end of the date order
+	newest := func(n int) *MediaItem { return &g.items[g.sorted[len(g.sorted)-1-n]] }
+	for n := start; n < end; n++ {
+		item := newest(n)
+		date := item.Date().Format(layout)
+		if len(page.Groups) == 0 || page.Groups[len(page.Groups)-1].Date != date {
+			page.Groups = append(page.Groups, TimelineGroup{Date: date})
+		}
+		last := &page.Groups[len(page.Groups)-1]
+		last.Items = append(last.Items, *item)
+		last.Count++
+	}
+	if len(page.Groups) == 0 {
+		return page
+	}
+
+	// The first and last groups may go on before and after the page
+	first, last := &page.Groups[0], &page.Groups[len(page.Groups)-1]
+	for n := start - 1; n >= 0 && newest(n).Date().Format(layout) == first.Date; n-- {
+		first.Count++
+	}
+	for n := end; n < len(g.sorted) && newest(n).Date().Format(layout) == last.Date; n++ {
+		last.Count++
+	}
+	return page
+}
+
+// Albums lists the folder albums of a library's photos and videos, sorted by path
+func (x *Index) Albums(library config.Library, items []MediaItem) []Album {
+	return slices.Clone(x.libraryGallery(library, items).albums)
+}
+
+// AlbumItems returns an album by its ID and the photos and videos directly in it, oldest first
+func (x *Index) AlbumItems(library config.Library, items []MediaItem, id string) (*Album, []MediaItem, bool) {
+	g := x.libraryGallery(library, items)
+	i, ok := g.byID[id]
+	if !ok {
+		return nil, nil, false
+	}
+	album := g.albums[i]
+	contents := make([]MediaItem, 0, len(g.contents[album.Path]))
+	for _, j := range g.contents[album.Path] {
+		contents = append(contents, g.items[j])
+	}
+	return &album, contents, true
+}
@@ -0,0 +1,261 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"mediastream/config"
+)
+
+// tiffEntry is a field of a TIFF IFD
+type tiffEntry struct {
+	tag, kind uint16
+	count     uint32
+	value     uint32 // The value itself, or the offset of values longer than four bytes
+}
+
+// tiffFile builds a little-endian TIFF file with one IFD at offset 8, followed by data
+func tiffFile(entries []tiffEntry, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("II*\x00")
+	binary.Write(&b, binary.LittleEndian, uint32(8))
+	binary.Write(&b, binary.LittleEndian, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&b, binary.LittleEndian, e)
+	}
+	binary.Write(&b, binary.LittleEndian, uint32(0)) // No next IFD
+	b.Write(data)
+	return b.Bytes()
+}
+
+// tiffDataOffset is where the data of a tiffFile with n entries starts
+func tiffDataOffset(n int) uint32 {
+	return uint32(8 + 2 + 12*n + 4)
+}
+
+// exifTIFF is a TIFF block taken in portrait on a Pixel, turned by its orientation
+func exifTIFF() []byte {
+	date := "2023:07:14 18:30:00\x00"
+	entries := []tiffEntry{
+		{0x0100, 3, 1, 4000}, // ImageWidth
+		{0x0101, 3, 1, 3000}, // ImageLength
+		{0x010F, 2, 7, 0},    // Make
+		{0x0112, 3, 1, 6},    // Orientation: turned 90°
+		{0x0132, 2, uint32(len(date)), 0},
+	}
+	start := tiffDataOffset(len(entries))
+	entries[2].value = start
+	entries[4].value = start + 7
+	return tiffFile(entries, []byte("Google\x00"+date))
+}
+
+// rawTIFF is a raw file whose second IFD holds a JPEG preview
+func rawTIFF() []byte {
+	preview := append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, make([]byte, 2044)...)
+	entries := []tiffEntry{
+		{0x0112, 3, 1, 1},
+		{0x014A, 4, 1, 0}, // SubIFDs
+	}
+	start := tiffDataOffset(len(entries))
+	entries[1].value = start
+
+	var sub bytes.Buffer
+	binary.Write(&sub, binary.LittleEndian, uint16(2))
+	binary.Write(&sub, binary.LittleEndian, tiffEntry{0x0201, 4, 1, start + 2 + 24 + 4})
+	binary.Write(&sub, binary.LittleEndian, tiffEntry{0x0202, 4, 1, uint32(len(preview))})
+	binary.Write(&sub, binary.LittleEndian, uint32(0))
+	sub.Write(preview)
+	return tiffFile(entries, sub.Bytes())
+}
+
+// isoBoxBytes builds an ISO base media box
+func isoBoxBytes(kind string, payload ...[]byte) []byte {
+	content := bytes.Join(payload, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(content)))
+	return append(append(box, kind...), content...)
+}
+
+// heifFile is a HEIF image whose Exif item, found through its iinf and iloc boxes, holds exif
+func heifFile(exif []byte) []byte {
+	ftyp := isoBoxBytes("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	infe := isoBoxBytes("infe", []byte{2, 0, 0, 0, 0, 1, 0, 0}, []byte("Exif\x00"))
+	iinf := isoBoxBytes("iinf", []byte{0, 0, 0, 0, 0, 1}, infe)
+	item := append([]byte("\x00\x00\x00\x06Exif\x00\x00"), exif...)
+
+	// The item follows the meta box, whose size doesn't depend on the offset it holds
+	iloc := func(offset int) []byte {
+		return isoBoxBytes("iloc", []byte{0, 0, 0, 0, 0x44, 0x00, 0, 1, 0, 1, 0, 0, 0, 1},
+			binary.BigEndian.AppendUint32(nil, uint32(offset)), binary.BigEndian.AppendUint32(nil, uint32(len(item))))
+	}
+	meta := isoBoxBytes("meta", []byte{0, 0, 0, 0}, iinf, iloc(0))
+	offset := len(ftyp) + len(meta) + 8
+	meta = isoBoxBytes("meta", []byte{0, 0, 0, 0}, iinf, iloc(offset))
+	return bytes.Join([][]byte{ftyp, meta, isoBoxBytes("mdat", item)}, nil)
+}
+
+// Files that once hung or crashed the parsers
+var (
+	// Two IFDs pointing at each other
+	loopingTIFF = []byte("II*\x00\x08\x00\x00\x00\x00\x00\x0e\x00\x00\x00\x00\x00\x08\x00\x00\x00")
+	// 0x20000001 rationals, 8 bytes when the count is multiplied in 32 bits
+	overflowingTIFF = tiffFile([]tiffEntry{{0x011A, 5, 0x20000001, 8}}, nil)
+	// An IFD 3 GB into a tiny file
+	distantTIFF = []byte("II*\x00\x08\x00\x00\xbe")
+	// A box in the meta box claiming almost 8 EiB
+	oversizedHEIF = bytes.Join([][]byte{
+		isoBoxBytes("ftyp", []byte("heic\x00\x00\x00\x00")),
+		isoBoxBytes("meta", []byte{0, 0, 0, 0}, []byte("\x00\x00\x00\x01iinf\x7f\xff\xff\xff\xff\xff\xff\xff"), make([]byte, 16)),
+	}, nil)
+)
+
+// photoFile writes a photo to a temporary file and reads it
+func photoFile(t testing.TB, name string, data []byte) *PhotoInfo {
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readPhotoInfo(file, info)
+}
+
+func TestReadPhotoInfo(t *testing.T) {
+	taken := time.Date(2023, 7, 14, 18, 30, 0, 0, time.Local)
+	for name, data := range map[string][]byte{"photo.tif": exifTIFF(), "photo.heic": heifFile(exifTIFF())} {
+		photo := photoFile(t, name, data)
+		// Portrait, so the width and height are swapped
+		if !photo.Taken.Equal(taken) || photo.TakenFromFile || photo.Orientation != 6 || photo.Camera != "Google" || photo.Width != 3000 || photo.Height != 4000 {
+			t.Errorf("%s: %+v", name, photo)
+		}
+	}
+
+	if photo := photoFile(t, "photo.heic", heifFile(nil)); photo.Format != PhotoHEIC || !photo.TakenFromFile {
+		t.Errorf("HEIF without EXIF: %+v", photo)
+	}
+}
+
+func TestRawPreviews(t *testing.T) {
+	data := rawTIFF()
+	previews := rawPreviews(bytes.NewReader(data), int64(len(data)))
+	if len(previews) != 1 || previews[0].Size() != 2048 {
+		t.Fatalf("previews = %v", previews)
+	}
+}
+
+func FuzzReadPhotoInfo(f *testing.F) {
+	f.Add(exifTIFF(), false)
+	f.Add(rawTIFF(), true)
+	f.Add(heifFile(exifTIFF()), false)
+	f.Add(heifFile(nil), false)
+	for _, data := range [][]byte{loopingTIFF, overflowingTIFF, distantTIFF, oversizedHEIF} {
+		f.Add(data, false)
+		f.Add(data, true)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, raw bool) {
+		name := "photo.jpg"
+		if raw {
+			name = "photo.dng"
+		}
+		photo := photoFile(t, name, data)
+		if photo.Orientation < 0 || photo.Orientation > 8 || photo.Width < 0 || photo.Height < 0 {
+			t.Errorf("photo = %+v", photo)
+		}
+		for _, preview := range rawPreviews(bytes.NewReader(data), int64(len(data))) {
+			if preview.Size() < 1024 {
+				t.Errorf("preview of %d bytes", preview.Size())
+			}
+		}
+	})
+}
+
+// galleryTest returns an index holding photos taken on the given days of 2024 as the scanned
+// photos library, the first two in the album "Trip"
+func galleryTest(days ...int) (*Index, config.Library, []MediaItem) {
+	library := config.Library{ID: "photos", Name: "Photos", Kind: config.KindPhotos}
+	var items []MediaItem
+	for i, day := range days {
+		name := fmt.Sprintf("%d.jpg", i)
+		if i < 2 {
+			name = "Trip/" + name
+		}
+		taken := time.Date(2024, 1, day, 12, 0, 0, 0, time.UTC)
+		items = append(items, MediaItem{ID: fmt.Sprint(i), Type: "image", RelativePath: name, LibraryID: library.ID, Photo: &PhotoInfo{Taken: taken}})
+	}
+	index := NewIndex("")
+	index.libraries[library.ID] = &IndexedLibrary{Items: items}
+	return index, library, items
+}
+
+func TestTimelinePages(t *testing.T) {
+	// January 31st is followed by February
+	index, library, items := galleryTest(1, 1, 2, 2, 2, 3, 31, 32)
+
+	tests := []struct {
+		offset, limit int
+		want          string // Date:count/on the page of each group
+	}{
+		{0, 0, "2024-02-01:1/1 2024-01-31:1/1 2024-01-03:1/1 2024-01-02:3/3 2024-01-01:2/2"},
+		{0, 3, "2024-02-01:1/1 2024-01-31:1/1 2024-01-03:1/1"},
+		{3, 2, "2024-01-02:3/2"},
+		{5, 2, "2024-01-02:3/1 2024-01-01:2/1"},
+		{7, 5, "2024-01-01:2/1"},
+		{8, 5, ""},
+	}
+	for _, test := range tests {
+		page := index.Timeline(library, items, "day", test.offset, test.limit)
+		var groups []string
+		for _, group := range page.Groups {
+			groups = append(groups, fmt.Sprintf("%s:%d/%d", group.Date, group.Count, len(group.Items)))
+		}
+		if got := strings.Join(groups, " "); got != test.want || page.Total != len(items) {
+			t.Errorf("page at %d of %d = %q of %d, want %q", test.offset, test.limit, got, page.Total, test.want)
+		}
+	}
+
+	page := index.Timeline(library, items, "month", 0, 0)
+	if len(page.Groups) != 2 || page.Groups[0].Count != 1 || page.Groups[1].Count != 7 {
+		t.Errorf("months = %+v", page.Groups)
+	}
+}
+
+func TestGalleryCache(t *testing.T) {
+	index, library, items := galleryTest(1, 2, 3)
+	albums := index.Albums(library, items)
+	if len(albums) != 2 || albums[1].Name != "Trip" || albums[1].Count != 2 || albums[1].Parent != "" || albums[1].Cover != "0" {
+		t.Fatalf("albums = %+v", albums)
+	}
+	album, contents, ok := index.AlbumItems(library, items, albums[1].ID)
+	if !ok || album.Path != "Trip" || len(contents) != 2 || contents[0].ID != "0" {
+		t.Errorf("album = %+v, %v", album, contents)
+	}
+	if _, _, ok := index.AlbumItems(library, items, "nope"); ok {
+		t.Error("unknown album found")
+	}
+
+	// The gallery of the indexed items is kept, that of the items a user may see is not
+	cached := index.galleries[library.ID]
+	if cached == nil || !cached.covers(items) {
+		t.Fatal("gallery of the indexed items not cached")
+	}
+	index.Albums(library, items[:1:1])
+	if index.galleries[library.ID] != cached {
+		t.Error("gallery of other items cached")
+	}
+
+	// New items get a new gallery
+	rescanned := slices.Clone(items)
+	index.libraries[library.ID] = &IndexedLibrary{Items: rescanned}
+	if albums := index.Albums(library, rescanned); len(albums) != 2 || index.galleries[library.ID] == cached {
+		t.Error("gallery not rebuilt after a rescan")
+	}
+}
@@ -0,0 +1,643 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+
+	"mediastream/config"
+	"mediastream/logging"
+	"mediastream/metrics"
+	"mediastream/utils"
+)
+
+var previewLog = logging.For("previews")
+
+// Files in the preview folder of an item
+const (
+	posterFrameFile   = "poster.jpg"
+	trickplayDir      = "trickplay"
+	trickplayManifest = "trickplay.json"
+	previewFailedFile = "failed" // Failed attempts, so a broken file isn't retried every scan
+
+	// TrickplayVTT is the WebVTT track pointing at the thumbnails in the sprite sheets
+	TrickplayVTT = "thumbnails.vtt"
+)
+
+// posterFrameWidth is the width of generated poster frames; the image endpoint resizes them further
+const posterFrameWidth = 1280
+
+// Preview states
+const (
+	PreviewReady    = "ready"
+	PreviewQueued   = "queued"
+	PreviewRunning  = "running"
+	PreviewFailed   = "failed"
+	PreviewMissing  = "missing"  // Not generated and not queued
+	PreviewDisabled = "disabled" // Not generated and previews are turned off
+)
+
+// Trickplay describes the sprite sheets holding the trickplay thumbnails of a video
+type Trickplay struct {
+	IntervalSeconds int       `json:"intervalSeconds"`
+	Width           int       `json:"width"` // Of one thumbnail
+	Height          int       `json:"height"`
+	Columns         int       `json:"columns"` // Thumbnails per row, and rows per full sheet
+	Count           int       `json:"count"`
+	Sheets          []string  `json:"sheets"` // File names, in order
+	Generated       time.Time `json:"generated"`
+}
+
+// PreviewStatus tells which previews of a video exist
+type PreviewStatus struct {
+	State       string     `json:"state"`
+	PosterFrame bool       `json:"posterFrame"`
+	Trickplay   *Trickplay `json:"trickplay,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// previewRoot returns the folder holding the previews of an item
+func previewRoot(item *MediaItem) string {
+	key := hashKey(item.ID)
+	return filepath.Join(config.CacheDir, "previews", key[:2], key)
+}
+
+// previewDir returns the preview folder of the item's current file; changing the file starts a new one
+func previewDir(item *MediaItem) string {
+	return filepath.Join(previewRoot(item), fmt.Sprintf("%d-%d", item.Modified.UnixNano(), item.Size))
+}
+
+// A video whose previews failed is retried after an hour, then after twice as long each time up to a week
+const (
+	minPreviewRetry = time.Hour
+	maxPreviewRetry = 7 * 24 * time.Hour
+)
+
+// previewFailure records the failed attempts at a video's previews
+type previewFailure struct {
+	Error    string    `json:"error"`
+	Failures int       `json:"failures"`
+	Retry    time.Time `json:"retry"`
+}
+
+// loadPreviewFailure returns the failed attempts of an item's current file, if any
+func loadPreviewFailure(item *MediaItem) (*previewFailure, bool) {
+	file := filepath.Join(previewDir(item), previewFailedFile)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, false
+	}
+	var failure previewFailure
+	if json.Unmarshal(data, &failure) != nil {
+		// Written by an older version as plain text
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, false
+		}
+		failure = previewFailure{Error: strings.TrimSpace(string(data)), Failures: 1, Retry: info.ModTime().Add(minPreviewRetry)}
+	}
+	return &failure, true
+}
+
+// recordPreviewFailure adds a failed attempt and schedules the next one
+func recordPreviewFailure(item *MediaItem, cause error, now time.Time) error {
+	failure, _ := loadPreviewFailure(item)
+	if failure == nil {
+		failure = &previewFailure{}
+	}
+	failure.Error = cause.Error()
+	failure.Failures++
+	failure.Retry = now.Add(min(minPreviewRetry<<min(failure.Failures-1, 20), maxPreviewRetry))
+
+	data, err := json.Marshal(failure)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(previewDir(item), previewFailedFile), data, 0644)
+}
+
+// fileExists reports whether a file exists
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// PosterFrame returns the generated poster frame of a video, if there is one
+func PosterFrame(item *MediaItem) (string, bool) {
+	if item.Type != "video" {
+		return "", false
+	}
+	file := filepath.Join(previewDir(item), posterFrameFile)
+	return file, fileExists(file)
+}
+
+// LoadTrickplay returns the trickplay thumbnails of a video, or os.ErrNotExist if there are none
+func LoadTrickplay(item *MediaItem) (*Trickplay, error) {
+	data, err := os.ReadFile(filepath.Join(previewDir(item), trickplayDir, trickplayManifest))
+	if err != nil {
+		return nil, err
+	}
+	var trickplay Trickplay
+	if err := json.Unmarshal(data, &trickplay); err != nil {
+		return nil, err
+	}
+	return &trickplay, nil
+}
+
+// TrickplayFile returns the WebVTT track or a sprite sheet of a video by its file name
+func TrickplayFile(item *MediaItem, name string) (string, error) {
+	trickplay, err := LoadTrickplay(item)
+	if err != nil {
+		metrics.PreviewCache.Inc("miss")
+		return "", os.ErrNotExist
+	}
+	if name != TrickplayVTT && !slices.Contains(trickplay.Sheets, name) {
+		metrics.PreviewCache.Inc("miss")
+		return "", os.ErrNotExist
+	}
+	metrics.PreviewCache.Inc("hit")
+	return filepath.Join(previewDir(item), trickplayDir, name), nil
+}
+
+// needsPosterFrame reports whether a video has no poster or still image of its own
+func needsPosterFrame(library config.Library, item *MediaItem) bool {
+	for _, image := range itemArtwork(library, item) {
+		if image.Kind == ArtworkPoster || image.Kind == ArtworkThumb {
+			return false
+		}
+	}
+	return true
+}
+
+// previewsComplete reports whether every preview of a video was generated
+func previewsComplete(library config.Library, item *MediaItem) bool {
+	dir := previewDir(item)
+	if !fileExists(filepath.Join(dir, trickplayDir, trickplayManifest)) {
+		return false
+	}
+	return fileExists(filepath.Join(dir, posterFrameFile)) || !needsPosterFrame(library, item)
+}
+
+// previewJob is a video waiting for its previews
+type previewJob struct {
+	library config.Library
+	item    MediaItem
+}
+
+// PreviewGenerator creates poster frames and trickplay thumbnails of videos in the background.
+// Videos are queued and processed a few at a time, as set by previews.maxConcurrent.
+type PreviewGenerator struct {
+	store        *config.Store
+	newExtractor func(config.PreviewConfig) FrameExtractor
+
+	ctx    context.Context // Canceled on Stop, which kills running extractors
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	now func() time.Time
+
+	mu            sync.Mutex
+	wake          *sync.Cond
+	queue         []previewJob
+	states        map[string]string // Queued or running, by item ID
+	running       int
+	started       []time.Time // Of the jobs started within the last hour, oldest first
+	rateTimer     *time.Timer // Wakes the dispatcher when previews.maxPerHour allows another job
+	stopped       bool
+	missingLogged bool // Whether a missing ffmpeg was reported since the config last changed
+}
+
+// NewPreviewGenerator starts a generator building extractors for the active preview settings
+func NewPreviewGenerator(store *config.Store, newExtractor func(config.PreviewConfig) FrameExtractor) *PreviewGenerator {
+	ctx, cancel := context.WithCancel(context.Background())
+	g := &PreviewGenerator{
+		store:        store,
+		newExtractor: newExtractor,
+		ctx:          ctx,
+		cancel:       cancel,
+		now:          time.Now,
+		states:       map[string]string{},
+	}
+	g.wake = sync.NewCond(&g.mu)
+
+	// A higher limit or a fixed ffmpeg path applies to the waiting videos right away
+	store.OnChange(func(old, new *config.Config) {
+		g.mu.Lock()
+		g.missingLogged = false
+		g.mu.Unlock()
+		g.wake.Broadcast()
+	})
+
+	g.wg.Add(1)
+	go g.dispatch()
+	return g
+}
+
+// Queue adds a video to the queue unless its previews exist, failed recently or previews are disabled.
+// It reports whether the video is waiting or being processed.
+func (g *PreviewGenerator) Queue(library config.Library, item MediaItem) bool {
+	waiting, _ := g.enqueue(library, item)
+	return waiting
+}
+
+// enqueue queues a video like Queue, also reporting whether it was added by this call
+func (g *PreviewGenerator) enqueue(library config.Library, item MediaItem) (waiting, added bool) {
+	if item.Type != "video" || !g.store.Get().Previews.Enabled {
+		return false, false
+	}
+
+	g.mu.Lock()
+	_, pending := g.states[item.ID]
+	g.mu.Unlock()
+	if pending {
+		return true, false
+	}
+
+	// Checked without the lock, they read the disk
+	if failure, failed := loadPreviewFailure(&item); failed && g.now().Before(failure.Retry) {
+		return false, false
+	}
+	if previewsComplete(library, &item) {
+		return false, false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return false, false
+	}
+	if _, ok := g.states[item.ID]; ok {
+		return true, false
+	}
+	g.queue = append(g.queue, previewJob{library: library, item: item})
+	g.states[item.ID] = PreviewQueued
+	g.wake.Broadcast()
+	return true, true
+}
+
+// QueueLibrary queues the videos of a scanned library that are missing previews
+func (g *PreviewGenerator) QueueLibrary(ctx context.Context, library config.Library, items []MediaItem) {
+	if !g.store.Get().Previews.Enabled {
+		return
+	}
+
+	queued := 0
+	for _, item := range items {
+		if _, added := g.enqueue(library, item); added {
+			queued++
+		}
+	}
+	if queued > 0 {
+		previewLog.InfoContext(ctx, "Queued videos for previews", "library", library.ID, "videos", queued)
+	}
+}
+
+// QueueIndexed queues the videos of every library that are missing previews, scanning
+// libraries that aren't indexed yet
+func (g *PreviewGenerator) QueueIndexed(ctx context.Context, index *Index, cfg *config.Config) {
+	if !cfg.Previews.Enabled {
+		return
+	}
+	for _, library := range cfg.Libraries {
+		if items, err := index.Items(ctx, library, cfg); err == nil {
+			g.QueueLibrary(ctx, library, items)
+		}
+	}
+}
+
+// Status returns which previews of a video exist, and whether it is waiting for them
+func (g *PreviewGenerator) Status(library config.Library, item *MediaItem) PreviewStatus {
+	var status PreviewStatus
+	_, status.PosterFrame = PosterFrame(item)
+	status.Trickplay, _ = LoadTrickplay(item)
+
+	g.mu.Lock()
+	state, pending := g.states[item.ID]
+	g.mu.Unlock()
+
+	switch {
+	case pending:
+		status.State = state
+	case status.Trickplay != nil && (status.PosterFrame || !needsPosterFrame(library, item)):
+		status.State = PreviewReady
+	default:
+		if failure, failed := loadPreviewFailure(item); failed && g.now().Before(failure.Retry) {
+			status.State = PreviewFailed
+			status.Error = failure.Error
+		} else if g.store.Get().Previews.Enabled {
+			status.State = PreviewMissing
+		} else {
+			status.State = PreviewDisabled
+		}
+	}
+	return status
+}
+
+// Stop drops the queue, kills running extractors and waits for them to exit
+func (g *PreviewGenerator) Stop() {
+	g.mu.Lock()
+	g.stopped = true
+	g.queue = nil
+	if g.rateTimer != nil {
+		g.rateTimer.Stop()
+	}
+	g.mu.Unlock()
+	g.cancel()
+	g.wake.Broadcast()
+	g.wg.Wait()
+}
+
+// rateWait returns how long until previews.maxPerHour allows another job, 0 if it does now.
+// It must be called with the lock held.
+func (g *PreviewGenerator) rateWait(limit int) time.Duration {
+	now := g.now()
+	recent := 0
+	for recent < len(g.started) && now.Sub(g.started[recent]) >= time.Hour {
+		recent++
+	}
+	g.started = g.started[recent:]
+
+	if limit == 0 || len(g.started) < limit {
+		return 0
+	}
+	return g.started[0].Add(time.Hour).Sub(now)
+}
+
+// ready reports whether a queued job may start, arranging a wake-up if only the hourly limit holds
+// it back. It must be called with the lock held.
+func (g *PreviewGenerator) ready() bool {
+	cfg := g.store.Get().Previews
+	if len(g.queue) == 0 || g.running >= cfg.MaxConcurrent {
+		return false
+	}
+	wait := g.rateWait(cfg.MaxPerHour)
+	if wait > 0 && g.rateTimer == nil {
+		g.rateTimer = time.AfterFunc(wait, func() {
+			g.mu.Lock()
+			g.rateTimer = nil
+			g.mu.Unlock()
+			g.wake.Broadcast()
+		})
+	}
+	return wait == 0
+}
+
+// dispatch starts queued jobs while fewer than previews.maxConcurrent are running and fewer
+// than previews.maxPerHour were started within the last hour
+func (g *PreviewGenerator) dispatch() {
+	defer g.wg.Done()
+	for {
+		g.mu.Lock()
+		for !g.stopped && !g.ready() {
+			g.wake.Wait()
+		}
+		if g.stopped {
+			g.mu.Unlock()
+			return
+		}
+		job := g.queue[0]
+		g.queue = g.queue[1:]
+		g.states[job.item.ID] = PreviewRunning
+		g.started = append(g.started, g.now())
+		g.running++
+		g.wg.Add(1)
+		g.mu.Unlock()
+
+		go func() {
+			defer g.wg.Done()
+			g.generate(job)
+
+			g.mu.Lock()
+			g.running--
+			delete(g.states, job.item.ID)
+			g.mu.Unlock()
+			g.wake.Broadcast()
+		}()
+	}
+}
+
+// generate creates the previews of a queued video and records how it went
+func (g *PreviewGenerator) generate(job previewJob) {
+	cfg := g.store.Get().Previews
+	ctx, cancel := context.WithTimeout(g.ctx, time.Duration(cfg.TimeoutMinutes)*time.Minute)
+	defer cancel()
+	ctx = logging.WithRequestID(ctx, logging.NewRequestID())
+
+	start := time.Now()
+	item := &job.item
+	err := createPreviews(ctx, g.newExtractor(cfg), cfg, job.library, item)
+	switch {
+	case err == nil:
+		os.Remove(filepath.Join(previewDir(item), previewFailedFile))
+		metrics.PreviewJobs.Inc("success")
+		previewLog.InfoContext(ctx, "Generated previews", "library", job.library.ID, "path", item.RelativePath, "duration", time.Since(start))
+
+	case g.ctx.Err() != nil:
+		// Shutting down, the video is picked up again after the next scan
+
+	case errors.Is(err, ErrExtractorUnavailable):
+		metrics.PreviewJobs.Inc("unavailable")
+		g.mu.Lock()
+		logged := g.missingLogged
+		g.missingLogged = true
+		g.mu.Unlock()
+		if !logged {
+			previewLog.WarnContext(ctx, "Can't generate previews, ffmpeg or ffprobe not found; install them or set previews.ffmpegPath", "error", err)
+		}
+
+	default:
+		metrics.PreviewJobs.Inc("error")
+		previewLog.WarnContext(ctx, "Error generating previews", "library", job.library.ID, "path", item.RelativePath, "error", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %d minutes", cfg.TimeoutMinutes)
+		}
+		if writeErr := recordPreviewFailure(item, err, g.now()); writeErr != nil {
+			previewLog.WarnContext(ctx, "Error recording failed previews", "error", writeErr)
+		}
+	}
+}
+
+// createPreviews generates the poster frame, if the video has no artwork, and the trickplay thumbnails
+func createPreviews(ctx context.Context, extractor FrameExtractor, cfg config.PreviewConfig, library config.Library, item *MediaItem) error {
+	file, _, _, err := ResolveLibraryFile(library, item.Root, item.RelativePath)
+	if err != nil {
+		return err
+	}
+
+	dir := previewDir(item)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	removeStalePreviews(item)
+
+	duration, err := extractor.Duration(ctx, file)
+	if err != nil {
+		return err
+	}
+
+	if !fileExists(filepath.Join(dir, posterFrameFile)) && needsPosterFrame(library, item) {
+		if err := writePosterFrame(ctx, extractor, file, duration, dir); err != nil {
+			return err
+		}
+	}
+	if !fileExists(filepath.Join(dir, trickplayDir, trickplayManifest)) {
+		if err := writeTrickplay(ctx, extractor, cfg, file, duration, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStalePreviews deletes the previews of earlier versions of an item's file
+func removeStalePreviews(item *MediaItem) {
+	current := filepath.Base(previewDir(item))
+	entries, err := os.ReadDir(previewRoot(item))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.Name() != current {
+			os.RemoveAll(filepath.Join(previewRoot(item), entry.Name()))
+		}
+	}
+}
+
+// writePosterFrame saves a frame a tenth into the video, past opening logos, as its poster
+func writePosterFrame(ctx context.Context, extractor FrameExtractor, file string, duration time.Duration, dir string) error {
+	at := duration / 10
+	if at > 10*time.Minute {
+		at = 10 * time.Minute
+	}
+	frame, err := extractor.Frame(ctx, file, at, posterFrameWidth)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, frame, &jpeg.Options{Quality: 85}); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(dir, posterFrameFile), buf.Bytes(), 0644)
+}
+
+// writeTrickplay extracts a thumbnail every interval, tiles them into sprite sheets of
+// columns×columns thumbnails and writes the WebVTT track and manifest. The finished
+// folder is moved into place at once, so requests never see half of it.
+func writeTrickplay(ctx context.Context, extractor FrameExtractor, cfg config.PreviewConfig, file string, duration time.Duration, dir string) error {
+	tmp, err := os.MkdirTemp(dir, ".trickplay-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	trickplay := Trickplay{IntervalSeconds: cfg.IntervalSeconds, Width: cfg.Width, Columns: cfg.Columns}
+	perSheet := cfg.Columns * cfg.Columns
+	var sheet *image.RGBA
+	used := 0
+
+	// flush writes the current sheet, cropped to the rows in use
+	flush := func() error {
+		if sheet == nil {
+			return nil
+		}
+		columns := min(used, cfg.Columns)
+		rows := (used + cfg.Columns - 1) / cfg.Columns
+		cropped := sheet.SubImage(image.Rect(0, 0, columns*trickplay.Width, rows*trickplay.Height))
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, cropped, &jpeg.Options{Quality: 75}); err != nil {
+			return err
+		}
+		name := fmt.Sprintf("sheet-%03d.jpg", len(trickplay.Sheets))
+		if err := os.WriteFile(filepath.Join(tmp, name), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		trickplay.Sheets = append(trickplay.Sheets, name)
+		sheet = nil
+		return nil
+	}
+
+	interval := time.Duration(cfg.IntervalSeconds) * time.Second
+	err = extractor.Frames(ctx, file, interval, cfg.Width, func(index int, frame image.Image) error {
+		bounds := frame.Bounds()
+		if trickplay.Height == 0 {
+			trickplay.Height = max(1, bounds.Dy()*cfg.Width/max(1, bounds.Dx()))
+		}
+
+		n := index % perSheet
+		if n == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			sheet = image.NewRGBA(image.Rect(0, 0, cfg.Columns*trickplay.Width, cfg.Columns*trickplay.Height))
+		}
+		x, y := (n%cfg.Columns)*trickplay.Width, (n/cfg.Columns)*trickplay.Height
+		cell := image.Rect(x, y, x+trickplay.Width, y+trickplay.Height)
+		draw.ApproxBiLinear.Scale(sheet, cell, frame, bounds, draw.Src, nil)
+		used = n + 1
+		trickplay.Count = index + 1
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(tmp, TrickplayVTT), trickplayVTT(trickplay, duration), 0644); err != nil {
+		return err
+	}
+	trickplay.Generated = time.Now()
+	data, err := json.MarshalIndent(trickplay, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, trickplayManifest), data, 0644); err != nil {
+		return err
+	}
+
+	target := filepath.Join(dir, trickplayDir)
+	os.RemoveAll(target)
+	return os.Rename(tmp, target)
+}
+
+// trickplayVTT builds a WebVTT track with a cue per thumbnail, pointing at its tile in a sprite sheet
+// with a media fragment, e.g. "sheet-000.jpg#xywh=240,0,240,135". Sheet URLs are relative to the track.
+func trickplayVTT(trickplay Trickplay, duration time.Duration) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("WEBVTT\n")
+
+	interval := time.Duration(trickplay.IntervalSeconds) * time.Second
+	perSheet := trickplay.Columns * trickplay.Columns
+	for i := 0; i < trickplay.Count; i++ {
+		start := time.Duration(i) * interval
+		end := start + interval
+		if duration > start && duration < end {
+			end = duration
+		}
+		n := i % perSheet
+		fmt.Fprintf(&buf, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n", vttTimestamp(start), vttTimestamp(end),
+			trickplay.Sheets[i/perSheet], (n%trickplay.Columns)*trickplay.Width, (n/trickplay.Columns)*trickplay.Height,
+			trickplay.Width, trickplay.Height)
+	}
+	return buf.Bytes()
+}
+
+// vttTimestamp formats an offset as a WebVTT timestamp, e.g. 01:02:03.450
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,257 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mediastream/config"
+)
+
+// fakeExtractor stands in for ffmpeg with videos of blank frames
+type fakeExtractor struct {
+	duration time.Duration
+	err      error // Returned by every call when set
+
+	mu    sync.Mutex
+	calls int // Of Duration, once per video processed
+}
+
+func (f *fakeExtractor) Duration(ctx context.Context, file string) (time.Duration, error) {
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+	return f.duration, f.err
+}
+
+func (f *fakeExtractor) Frame(ctx context.Context, file string, at time.Duration, width int) (image.Image, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return image.NewRGBA(image.Rect(0, 0, width, width*9/16)), nil
+}
+
+func (f *fakeExtractor) Frames(ctx context.Context, file string, interval time.Duration, width int, fn func(index int, frame image.Image) error) error {
+	for i := 0; time.Duration(i)*interval < f.duration; i++ {
+		frame, err := f.Frame(ctx, file, time.Duration(i)*interval, width)
+		if err != nil {
+			return err
+		}
+		if err := fn(i, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeExtractor) processed() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// fakeClock is a time that only moves when told to
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// previewTest creates a movie library with the given videos and a preview generator using extractor
+func previewTest(t *testing.T, extractor *fakeExtractor, videos ...string) (*PreviewGenerator, config.Library, []MediaItem, *fakeClock) {
+	dir := t.TempDir()
+	cacheDir := config.CacheDir
+	config.CacheDir = filepath.Join(dir, "cache")
+	t.Cleanup(func() { config.CacheDir = cacheDir })
+
+	root := filepath.Join(dir, "movies")
+	for _, video := range videos {
+		file := filepath.Join(root, filepath.FromSlash(video))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte("not really a video"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Previews.Enabled = true
+	cfg.Libraries = []config.Library{{ID: "movies", Name: "Movies", Kind: config.KindMovies, Paths: []string{root}}}
+	library := cfg.Libraries[0]
+	items, err := ScanLibrary(context.Background(), library, cfg)
+	if err != nil || len(items) != len(videos) {
+		t.Fatalf("scan found %d items: %v", len(items), err)
+	}
+
+	store := config.NewStore(cfg, filepath.Join(dir, "config.json"))
+	g := NewPreviewGenerator(store, func(config.PreviewConfig) FrameExtractor { return extractor })
+	clock := &fakeClock{now: time.Now()}
+	g.now = clock.Now
+	t.Cleanup(g.Stop)
+	return g, library, items, clock
+}
+
+// waitForPreviews waits until a video is neither queued nor running and returns its status
+func waitForPreviews(t *testing.T, g *PreviewGenerator, library config.Library, item *MediaItem) PreviewStatus {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if status := g.Status(library, item); status.State != PreviewQueued && status.State != PreviewRunning {
+			return status
+		}
+	}
+	t.Fatalf("previews of %s still pending", item.RelativePath)
+	return PreviewStatus{}
+}
+
+func TestCreatePreviews(t *testing.T) {
+	extractor := &fakeExtractor{duration: 95 * time.Second}
+	g, library, items, _ := previewTest(t, extractor, "Film (2001)/Film (2001).mp4")
+	cfg := g.store.Get().Previews
+	cfg.IntervalSeconds, cfg.Columns = 10, 3
+	item := &items[0]
+
+	if err := createPreviews(context.Background(), extractor, cfg, library, item); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := PosterFrame(item); !ok {
+		t.Error("no poster frame for a video without artwork")
+	}
+	trickplay, err := LoadTrickplay(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A thumbnail every 10 seconds of 95, in sheets of 3×3
+	if trickplay.Count != 10 || len(trickplay.Sheets) != 2 || trickplay.Width != cfg.Width || trickplay.Height != cfg.Width*9/16 {
+		t.Errorf("trickplay = %+v", trickplay)
+	}
+
+	file, err := TrickplayFile(item, TrickplayVTT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vtt, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "00:01:30.000 --> 00:01:35.000\nsheet-001.jpg#xywh=0,0,240,135\n"
+	if !strings.HasPrefix(string(vtt), "WEBVTT\n") || !strings.HasSuffix(string(vtt), want) {
+		t.Errorf("VTT track ends with %q, want %q", string(vtt)[max(0, len(vtt)-len(want)):], want)
+	}
+	if _, err := TrickplayFile(item, "../poster.jpg"); err == nil {
+		t.Error("file outside the trickplay sheets served")
+	}
+}
+
+func TestPreviewGeneratorRetriesFailures(t *testing.T) {
+	extractor := &fakeExtractor{duration: 30 * time.Second, err: errors.New("moov atom not found")}
+	g, library, items, clock := previewTest(t, extractor, "Film (2001)/Film (2001).mp4")
+	item := &items[0]
+
+	if !g.Queue(library, *item) {
+		t.Fatal("video not queued")
+	}
+	status := waitForPreviews(t, g, library, item)
+	if status.State != PreviewFailed || status.Error != "moov atom not found" {
+		t.Fatalf("status after failure = %+v", status)
+	}
+
+	// Not retried on every scan
+	if g.Queue(library, *item) {
+		t.Error("failed video queued again right away")
+	}
+
+	// The first retry comes after an hour and fails again, the next waits twice as long
+	clock.Add(minPreviewRetry)
+	if !g.Queue(library, *item) {
+		t.Fatal("failed video not retried after an hour")
+	}
+	waitForPreviews(t, g, library, item)
+	clock.Add(minPreviewRetry)
+	if g.Queue(library, *item) {
+		t.Error("video retried an hour after its second failure")
+	}
+	if failure, _ := loadPreviewFailure(item); failure.Failures != 2 {
+		t.Errorf("failure = %+v", failure)
+	}
+
+	extractor.mu.Lock()
+	extractor.err = nil
+	extractor.mu.Unlock()
+	clock.Add(minPreviewRetry)
+	if !g.Queue(library, *item) {
+		t.Fatal("video not retried after two hours")
+	}
+	if status := waitForPreviews(t, g, library, item); status.State != PreviewReady {
+		t.Errorf("status after a successful retry = %+v", status)
+	}
+	if _, failed := loadPreviewFailure(item); failed {
+		t.Error("failure kept after success")
+	}
+}
+
+func TestPreviewGeneratorHourlyLimit(t *testing.T) {
+	extractor := &fakeExtractor{duration: 10 * time.Second}
+	g, library, items, clock := previewTest(t, extractor, "One (2001)/One.mp4", "Two (2002)/Two.mp4", "Three (2003)/Three.mp4")
+	if _, err := g.store.Update(func(cfg *config.Config) error {
+		cfg.Previews.MaxPerHour = 2
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	g.QueueLibrary(context.Background(), library, items)
+	waitForPreviews(t, g, library, &items[1])
+	time.Sleep(50 * time.Millisecond)
+	if n := extractor.processed(); n != 2 {
+		t.Fatalf("%d videos processed within the hour, want 2", n)
+	}
+	if status := g.Status(library, &items[2]); status.State != PreviewQueued {
+		t.Errorf("third video is %s, want queued", status.State)
+	}
+
+	// An hour later the next one starts
+	clock.Add(time.Hour)
+	g.wake.Broadcast()
+	if status := waitForPreviews(t, g, library, &items[2]); status.State != PreviewReady {
+		t.Errorf("third video is %s after an hour", status.State)
+	}
+}
+
+func TestPreviewGeneratorSkipsComplete(t *testing.T) {
+	extractor := &fakeExtractor{duration: 10 * time.Second}
+	g, library, items, _ := previewTest(t, extractor, "Film (2001)/Film (2001).mp4")
+	item := &items[0]
+
+	g.Queue(library, *item)
+	if status := waitForPreviews(t, g, library, item); status.State != PreviewReady || !status.PosterFrame {
+		t.Fatalf("status = %+v", status)
+	}
+	if g.Queue(library, *item) {
+		t.Error("video with previews queued again")
+	}
+
+	// A changed file gets new previews
+	item.Modified = item.Modified.Add(time.Second)
+	if status := g.Status(library, item); status.State != PreviewMissing {
+		t.Errorf("changed file is %s, want missing", status.State)
+	}
+}
@@ -0,0 +1,217 @@
+package models
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"mediastream/utils"
+)
+
+// Profile is a viewer within an account, e.g. one family member on a shared login
+type Profile struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Avatar    string `json:"avatar,omitempty"`
+	PIN       string `json:"pin,omitempty"`       // bcrypt hash, empty if the profile isn't locked
+	MaxRating string `json:"maxRating,omitempty"` // Highest content rating the profile may watch
+	// Whether the profile sees videos without a content rating despite its limit
+	AllowUnrated bool      `json:"allowUnrated,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+// ProfileResponse is a safe representation of a profile for API responses
+type ProfileResponse struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Avatar       string    `json:"avatar,omitempty"`
+	HasPIN       bool      `json:"hasPin"`
+	MaxRating    string    `json:"maxRating,omitempty"`
+	AllowUnrated bool      `json:"allowUnrated"`
+	Created      time.Time `json:"created"`
+}
+
+// ToResponse converts a Profile to a ProfileResponse (removing the PIN hash)
+func (p *Profile) ToResponse() ProfileResponse {
+	return ProfileResponse{
+		ID:           p.ID,
+		Name:         p.Name,
+		Avatar:       p.Avatar,
+		HasPIN:       p.PIN != "",
+		MaxRating:    p.MaxRating,
+		AllowUnrated: p.AllowUnrated,
+		Created:      p.Created,
+	}
+}
+
+// SetPIN hashes and stores a PIN, or removes it when pin is empty
+func (p *Profile) SetPIN(pin string) error {
+	if pin == "" {
+		p.PIN = ""
+		return nil
+	}
+	hashedPIN, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	p.PIN = string(hashedPIN)
+	return nil
+}
+
+// CheckPIN reports whether the PIN unlocks the profile
+func (p *Profile) CheckPIN(pin string) bool {
+	if p.PIN == "" {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(p.PIN), []byte(pin)) == nil
+}
+
+// NewProfile creates a new profile with the given name
+func NewProfile(name string) *Profile {
+	return &Profile{
+		ID:      utils.GenerateUniqueID(),
+		Name:    name,
+		Created: time.Now(),
+	}
+}
+
+// FindProfileByID finds a profile of the user by ID
+func (u *User) FindProfileByID(id string) *Profile {
+	for i := range u.Profiles {
+		if u.Profiles[i].ID == id {
+			return &u.Profiles[i]
+		}
+	}
+	return nil
+}
+
+// GetProfileFromContext gets the active profile from the Gin context
+func GetProfileFromContext(c *gin.Context) (*Profile, bool) {
+	profile, exists := c.Get("profile")
+	if !exists {
+		return nil, false
+	}
+
+	p, ok := profile.(*Profile)
+	return p, ok
+}
+
+// ActiveProfileID returns the ID that scopes per-viewer data for the request.
+// Without a selected profile the account itself acts as the viewer.
+func ActiveProfileID(c *gin.Context) string {
+	if profile, ok := GetProfileFromContext(c); ok {
+		return profile.ID
+	}
+	if user, ok := GetUserFromContext(c); ok {
+		return user.ID
+	}
+	return ""
+}
+
+// contentRatingLevels orders content ratings from least to most restrictive audience
+var contentRatingLevels = map[string]int{
+	"G":     0,
+	"TV-Y":  0,
+	"TV-Y7": 1,
+	"TV-G":  1,
+	"PG":    2,
+	"TV-PG": 2,
+	"PG-13": 3,
+	"TV-14": 3,
+	"R":     4,
+	"TV-MA": 4,
+	"NC-17": 5,
+}
+
+// IsKnownRating reports whether a rating can be used as a profile limit
+func IsKnownRating(rating string) bool {
+	_, ok := contentRatingLevels[rating]
+	return ok
+}
+
+// RatingLimit is what a profile may watch; the zero value allows everything
+type RatingLimit struct {
+	MaxRating    string
+	AllowUnrated bool
+}
+
+// RatingLimit returns the limit of the profile
+func (p *Profile) RatingLimit() RatingLimit {
+	return RatingLimit{MaxRating: p.MaxRating, AllowUnrated: p.AllowUnrated}
+}
+
+// Allows reports whether content with the given rating may be shown under the limit.
+// Unrated content is hidden unless the limit allows it, since nothing tells whether it is
+// suitable; content with a rating the server doesn't know is always hidden.
+func (l RatingLimit) Allows(rating string) bool {
+	if l.MaxRating == "" {
+		return true
+	}
+	if rating == "" {
+		return l.AllowUnrated
+	}
+	level, ok := contentRatingLevels[rating]
+	if !ok {
+		return false
+	}
+	return level <= contentRatingLevels[l.MaxRating]
+}
+
+// WatchEntry records how far a profile got through a media item
+type WatchEntry struct {
+	MediaID   string    `json:"mediaId"`
+	Position  float64   `json:"position"` // Seconds
+	Duration  float64   `json:"duration"` // Seconds
+	Completed bool      `json:"completed"`
+	Updated   time.Time `json:"updated"`
+}
+
+// Playlist is an ordered list of media items owned by a profile
+type Playlist struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Items   []string  `json:"items"` // Media IDs
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// ProfileData holds the watch history and playlists of a single profile
+type ProfileData struct {
+	History   []WatchEntry `json:"history"`
+	Playlists []Playlist   `json:"playlists"`
+}
+
+// LoadProfileData loads per-profile data, keyed by profile ID
+func LoadProfileData(filename string) (map[string]*ProfileData, error) {
+	data := map[string]*ProfileData{}
+
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		// If file doesn't exist, return empty map
+		return data, nil
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(content, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// SaveProfileData saves per-profile data to the profile data file
+func SaveProfileData(data map[string]*ProfileData, filename string) error {
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(filename, content, 0644)
+}
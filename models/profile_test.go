@@ -0,0 +1,43 @@
+package models
+
+import "testing"
+
+func TestRatingLimitAllows(t *testing.T) {
+	tests := []struct {
+		limit  RatingLimit
+		rating string
+		want   bool
+	}{
+		{RatingLimit{}, "", true},
+		{RatingLimit{}, "NC-17", true},
+		{RatingLimit{MaxRating: "PG-13"}, "PG", true},
+		{RatingLimit{MaxRating: "PG-13"}, "PG-13", true},
+		{RatingLimit{MaxRating: "PG-13"}, "TV-14", true},
+		{RatingLimit{MaxRating: "PG-13"}, "R", false},
+		{RatingLimit{MaxRating: "TV-Y"}, "G", true},
+		{RatingLimit{MaxRating: "PG-13"}, "", false},
+		{RatingLimit{MaxRating: "PG-13", AllowUnrated: true}, "", true},
+		{RatingLimit{MaxRating: "PG-13", AllowUnrated: true}, "X", false},
+		{RatingLimit{MaxRating: "PG-13", AllowUnrated: true}, "R", false},
+	}
+	for _, test := range tests {
+		if got := test.limit.Allows(test.rating); got != test.want {
+			t.Errorf("%+v.Allows(%q) = %v, want %v", test.limit, test.rating, got, test.want)
+		}
+	}
+}
+
+func TestAllowedUnder(t *testing.T) {
+	limit := RatingLimit{MaxRating: "PG"}
+	video := MediaItem{Type: "video"}
+	if video.AllowedUnder(limit) {
+		t.Error("unrated video allowed under a limit")
+	}
+	video.Metadata = &Metadata{ContentRating: "G"}
+	if !video.AllowedUnder(limit) {
+		t.Error("G video hidden under PG")
+	}
+	if photo := (MediaItem{Type: "image"}); !photo.AllowedUnder(limit) {
+		t.Error("photos aren't rated")
+	}
+}
@@ -0,0 +1,232 @@
+package models
+
+import (
+	"context"
+	"errors"
+
+	"mediastream/config"
+)
+
+// ErrMetadataNotFound is returned by providers that know nothing about an item
+var ErrMetadataNotFound = errors.New("metadata not found")
+
+// MetadataQuery describes the item metadata is looked up for
+type MetadataQuery struct {
+	Kind      string // Library kind, e.g. movies or shows
+	Title     string
+	Year      int
+	ShowTitle string
+	Season    int
+	Episode   int
+	IDs       map[string]string // Known external IDs by source, e.g. from an NFO file
+
+	// Where the item is on disk, for providers reading local files
+	Library      config.Library
+	Root         string
+	RelativePath string // Slash-separated, relative to Root
+	Name         string // Folder or file name that names the item
+}
+
+// Artwork is an image of a media item
+type Artwork struct {
+	Kind string `json:"kind"`           // poster, fanart, banner, thumb, ...
+	URL  string `json:"url,omitempty"`  // Image of an online provider
+	Path string `json:"path,omitempty"` // Local image, slash-separated and relative to the library root
+	File string `json:"-"`              // Generated image in the cache, such as a video's poster frame
+}
+
+// MetadataProvider is a source of metadata, such as NFO files or an online database
+type MetadataProvider interface {
+	// Name identifies the provider in library chains
+	Name() string
+	// Search finds items matching the query's title and year, best match first
+	Search(ctx context.Context, query MetadataQuery) ([]Metadata, error)
+	// Fetch returns the item with the given ID in an external database such as imdb or tmdb
+	Fetch(ctx context.Context, query MetadataQuery, source, id string) (*Metadata, error)
+	// Artwork lists the images of an item
+	Artwork(ctx context.Context, query MetadataQuery) ([]Artwork, error)
+}
+
+type offlineKey struct{}
+
+// offline marks a context whose metadata lookups only use what providers answered before,
+// so that requests never wait for an online service
+func offline(ctx context.Context) context.Context {
+	return context.WithValue(ctx, offlineKey{}, true)
+}
+
+// isOffline reports whether a context's lookups may only use cached answers
+func isOffline(ctx context.Context) bool {
+	return ctx.Value(offlineKey{}) != nil
+}
+
+// metadataProviders returns the providers of a library's chain, highest priority first
+func metadataProviders(library config.Library, cfg *config.Config) []MetadataProvider {
+	var providers []MetadataProvider
+	for _, name := range library.MetadataChain() {
+		if name == config.LocalProvider {
+			providers = append(providers, localProvider{})
+			continue
+		}
+		if def := cfg.FindMetadataProvider(name); def != nil {
+			providers = append(providers, newCachedProvider(newHTTPProvider(*def), def.CacheHours))
+		}
+	}
+	return providers
+}
+
+// lookupMetadata asks a provider for an item, by ID if one is known and by title otherwise
+func lookupMetadata(ctx context.Context, provider MetadataProvider, query MetadataQuery) (*Metadata, error) {
+	for _, source := range sortedKeys(query.IDs) {
+		meta, err := provider.Fetch(ctx, query, source, query.IDs[source])
+		if err == nil {
+			return meta, nil
+		}
+		if !errors.Is(err, ErrMetadataNotFound) {
+			return nil, err
+		}
+	}
+
+	results, err := provider.Search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrMetadataNotFound
+	}
+	return &results[0], nil
+}
+
+// ResolveMetadata asks every provider in a library's chain about an item and merges the answers.
+// Later providers search with what earlier ones found, e.g. the IMDb ID from an NFO file.
+func ResolveMetadata(ctx context.Context, library config.Library, cfg *config.Config, query MetadataQuery) Metadata {
+	results := map[string]*Metadata{}
+	var order []string
+
+	for _, provider := range metadataProviders(library, cfg) {
+		meta, err := lookupMetadata(ctx, provider, query)
+		if err != nil {
+			if errors.Is(err, errProviderUnavailable) {
+				scanLog.DebugContext(ctx, "Skipping metadata provider", "provider", provider.Name(), "title", query.Title, "error", err)
+			} else if !errors.Is(err, ErrMetadataNotFound) {
+				scanLog.WarnContext(ctx, "Error looking up metadata", "provider", provider.Name(), "title", query.Title, "error", err)
+			}
+			continue
+		}
+
+		query = refineQuery(query, meta)
+		if artwork, err := provider.Artwork(ctx, query); err == nil {
+			meta.Artwork = artwork
+		} else if !errors.Is(err, ErrMetadataNotFound) && !errors.Is(err, errProviderUnavailable) {
+			scanLog.WarnContext(ctx, "Error looking up artwork", "provider", provider.Name(), "title", query.Title, "error", err)
+		}
+
+		results[provider.Name()] = meta
+		order = append(order, provider.Name())
+	}
+
+	merged := mergeMetadata(results, order, library.Options.MetadataPriority)
+	if merged.Title == "" {
+		// Every provider failed, so fall back to the name
+		merged = filenameMetadata(query.Name)
+	}
+	return merged
+}
+
+// refineQuery adds what a provider found to the query for the next providers
+func refineQuery(query MetadataQuery, meta *Metadata) MetadataQuery {
+	if meta.Title != "" {
+		query.Title = meta.Title
+	}
+	if meta.Year > 0 {
+		query.Year = meta.Year
+	}
+	if meta.ShowTitle != "" {
+		query.ShowTitle = meta.ShowTitle
+	}
+	if meta.Season > 0 || meta.Episode > 0 {
+		query.Season, query.Episode = meta.Season, meta.Episode
+	}
+
+	ids := map[string]string{}
+	for source, id := range meta.IDs {
+		ids[source] = id
+	}
+	for source, id := range query.IDs {
+		ids[source] = id
+	}
+	query.IDs = ids
+	return query
+}
+
+// mergeMetadata combines the answers of several providers field by field. A field is taken from
+// the first provider in its priority list that has it, falling back to the chain order.
+func mergeMetadata(results map[string]*Metadata, order []string, priority map[string][]string) Metadata {
+	pick := func(field string, has func(m *Metadata) bool) *Metadata {
+		chain := append(append([]string{}, priority[field]...), order...)
+		for _, name := range chain {
+			if m := results[name]; m != nil && has(m) {
+				return m
+			}
+		}
+		return nil
+	}
+
+	var merged Metadata
+	if m := pick("title", func(m *Metadata) bool { return m.Title != "" }); m != nil {
+		merged.Title = m.Title
+		merged.Source = m.Source
+	}
+	if m := pick("originalTitle", func(m *Metadata) bool { return m.OriginalTitle != "" }); m != nil {
+		merged.OriginalTitle = m.OriginalTitle
+	}
+	if m := pick("sortTitle", func(m *Metadata) bool { return m.SortTitle != "" }); m != nil {
+		merged.SortTitle = m.SortTitle
+	}
+	if m := pick("year", func(m *Metadata) bool { return m.Year > 0 }); m != nil {
+		merged.Year = m.Year
+	}
+	if m := pick("runtime", func(m *Metadata) bool { return m.Runtime > 0 }); m != nil {
+		merged.Runtime = m.Runtime
+	}
+	if m := pick("plot", func(m *Metadata) bool { return m.Plot != "" }); m != nil {
+		merged.Plot = m.Plot
+	}
+	if m := pick("genres", func(m *Metadata) bool { return len(m.Genres) > 0 }); m != nil {
+		merged.Genres = m.Genres
+	}
+	if m := pick("cast", func(m *Metadata) bool { return len(m.Cast) > 0 }); m != nil {
+		merged.Cast = m.Cast
+	}
+	if m := pick("contentRating", func(m *Metadata) bool { return m.ContentRating != "" }); m != nil {
+		merged.ContentRating = m.ContentRating
+	}
+	if m := pick("ratings", func(m *Metadata) bool { return len(m.Ratings) > 0 }); m != nil {
+		merged.Ratings = m.Ratings
+	}
+	if m := pick("artwork", func(m *Metadata) bool { return len(m.Artwork) > 0 }); m != nil {
+		merged.Artwork = m.Artwork
+	}
+
+	// Episode numbering and IDs come from the chain order
+	for i := len(order) - 1; i >= 0; i-- {
+		m := results[order[i]]
+		if m.ShowTitle != "" {
+			merged.ShowTitle = m.ShowTitle
+		}
+		if m.Season > 0 || m.Episode > 0 {
+			merged.Season, merged.Episode = m.Season, m.Episode
+		}
+		if m.NFO != "" {
+			merged.NFO = m.NFO
+		}
+		for source, id := range m.IDs {
+			if merged.IDs == nil {
+				merged.IDs = map[string]string{}
+			}
+			merged.IDs[source] = id
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,275 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"mediastream/config"
+)
+
+// mockProvider is an online metadata service speaking the httpProvider protocol
+type mockProvider struct {
+	*httptest.Server
+	search  map[string][]Metadata // By title
+	items   map[string]Metadata   // By "source/id"
+	artwork map[string][]Artwork  // By "source/id"
+
+	mu       sync.Mutex
+	failing  bool
+	requests []string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	m := &mockProvider{search: map[string][]Metadata{}, items: map[string]Metadata{}, artwork: map[string][]Artwork{}}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serve))
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockProvider) serve(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	m.requests = append(m.requests, r.URL.Path)
+	failing := m.failing
+	m.mu.Unlock()
+
+	if failing {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	var result any
+	var found bool
+	switch path := r.URL.Path; {
+	case path == "/search":
+		result, found = m.search[r.URL.Query().Get("title")]
+	case strings.HasSuffix(path, "/artwork"):
+		result, found = m.artwork[strings.TrimSuffix(strings.TrimPrefix(path, "/items/"), "/artwork")]
+	case strings.HasPrefix(path, "/items/"):
+		result, found = m.items[strings.TrimPrefix(path, "/items/")]
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// fail makes the provider answer every request with an error
+func (m *mockProvider) fail(failing bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failing = failing
+}
+
+// calls returns and forgets the paths requested so far
+func (m *mockProvider) calls() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	calls := m.requests
+	m.requests = nil
+	return calls
+}
+
+// providerTest isolates the metadata cache and provider backoffs of a test
+func providerTest(t *testing.T) {
+	cacheDir := config.CacheDir
+	config.CacheDir = t.TempDir()
+	t.Cleanup(func() {
+		config.CacheDir = cacheDir
+		providerHealth.Lock()
+		clear(providerHealth.failures)
+		clear(providerHealth.retry)
+		providerHealth.Unlock()
+	})
+}
+
+// providerConfig builds a config with the given mock providers and a library asking them in order
+func providerConfig(providers map[string]*mockProvider, chain ...string) (*config.Config, config.Library) {
+	cfg := config.DefaultConfig()
+	for _, name := range chain {
+		if m := providers[name]; m != nil {
+			cfg.MetadataProviders = append(cfg.MetadataProviders, config.MetadataProviderConfig{Name: name, Type: config.ProviderHTTP, URL: m.URL})
+		}
+	}
+	library := config.Library{ID: "movies", Kind: config.KindMovies}
+	library.Options.MetadataProviders = chain
+	return cfg, library
+}
+
+func TestResolveMetadataChainOrder(t *testing.T) {
+	providerTest(t)
+	first, second := newMockProvider(t), newMockProvider(t)
+	first.search["Heat"] = []Metadata{{Title: "Heat", Year: 1995, IDs: map[string]string{"imdb": "tt0113277"}}}
+	second.items["imdb/tt0113277"] = Metadata{Title: "Heat (1995)", Plot: "A crew of thieves.", IDs: map[string]string{"tmdb": "949"}}
+	second.artwork["imdb/tt0113277"] = []Artwork{{Kind: "poster", URL: "https://images.example.com/heat.jpg"}, {Kind: "fanart", Path: "local.jpg"}}
+
+	cfg, library := providerConfig(map[string]*mockProvider{"first": first, "second": second}, "first", "second")
+	meta := ResolveMetadata(context.Background(), library, cfg, MetadataQuery{Kind: library.Kind, Title: "Heat", Name: "Heat"})
+
+	// The second provider is asked by the ID the first one found, instead of searching
+	if calls := second.calls(); !slices.Equal(calls, []string{"/items/imdb/tt0113277", "/items/imdb/tt0113277/artwork"}) {
+		t.Errorf("second provider requests = %v", calls)
+	}
+	if meta.Title != "Heat" || meta.Source != "first" || meta.Year != 1995 {
+		t.Errorf("title and year should come from the first provider: %+v", meta)
+	}
+	if meta.Plot != "A crew of thieves." {
+		t.Errorf("plot should come from the second provider: %q", meta.Plot)
+	}
+	if meta.IDs["imdb"] != "tt0113277" || meta.IDs["tmdb"] != "949" {
+		t.Errorf("IDs should be merged: %v", meta.IDs)
+	}
+	if len(meta.Artwork) != 1 || meta.Artwork[0].URL != "https://images.example.com/heat.jpg" {
+		t.Errorf("only remote artwork should be kept: %+v", meta.Artwork)
+	}
+}
+
+func TestResolveMetadataFieldPriority(t *testing.T) {
+	providerTest(t)
+	first, second := newMockProvider(t), newMockProvider(t)
+	first.search["Heat"] = []Metadata{{Title: "Heat", Plot: "First plot", ContentRating: "R"}}
+	second.search["Heat"] = []Metadata{{Title: "Heat!", Plot: "Second plot", Year: 1995}}
+
+	cfg, library := providerConfig(map[string]*mockProvider{"first": first, "second": second}, "first", "second")
+	library.Options.MetadataPriority = map[string][]string{"plot": {"second"}, "title": {"second"}}
+	meta := ResolveMetadata(context.Background(), library, cfg, MetadataQuery{Kind: library.Kind, Title: "Heat", Name: "Heat"})
+
+	want := Metadata{Title: "Heat!", Source: "second", Plot: "Second plot", Year: 1995, ContentRating: "R"}
+	if meta.Title != want.Title || meta.Source != want.Source || meta.Plot != want.Plot || meta.Year != want.Year || meta.ContentRating != want.ContentRating {
+		t.Errorf("got %+v, want %+v", meta, want)
+	}
+}
+
+func TestResolveMetadataFallsBackToFilename(t *testing.T) {
+	providerTest(t)
+	provider := newMockProvider(t)
+	cfg, library := providerConfig(map[string]*mockProvider{"db": provider}, "db")
+
+	meta := ResolveMetadata(context.Background(), library, cfg, MetadataQuery{Kind: library.Kind, Title: "Unknown", Name: "Unknown (2001)"})
+	if meta.Source != MetadataFromFilename || meta.Title != "Unknown" || meta.Year != 2001 {
+		t.Errorf("got %+v", meta)
+	}
+}
+
+func TestCachedProviderAnswers(t *testing.T) {
+	providerTest(t)
+	provider := newMockProvider(t)
+	provider.search["Heat"] = []Metadata{{Title: "Heat", Year: 1995}}
+	cfg, library := providerConfig(map[string]*mockProvider{"db": provider}, "db")
+	query := MetadataQuery{Kind: library.Kind, Title: "Heat", Name: "Heat"}
+
+	ResolveMetadata(context.Background(), library, cfg, query)
+	if calls := provider.calls(); len(calls) != 1 {
+		t.Fatalf("first lookup requests = %v", calls)
+	}
+
+	// Answers and unknown items are cached
+	if meta := ResolveMetadata(context.Background(), library, cfg, query); meta.Year != 1995 {
+		t.Errorf("cached answer = %+v", meta)
+	}
+	unknown := MetadataQuery{Kind: library.Kind, Title: "Unknown", Name: "Unknown"}
+	ResolveMetadata(context.Background(), library, cfg, unknown)
+	ResolveMetadata(context.Background(), library, cfg, unknown)
+	if calls := provider.calls(); !slices.Equal(calls, []string{"/search"}) {
+		t.Errorf("cached lookups requests = %v", calls)
+	}
+}
+
+func TestCachedProviderErrors(t *testing.T) {
+	providerTest(t)
+	provider := newMockProvider(t)
+	provider.search["Heat"] = []Metadata{{Title: "Heat", Year: 1995}}
+	cfg, library := providerConfig(map[string]*mockProvider{"db": provider}, "db")
+	cached := newCachedProvider(newHTTPProvider(cfg.MetadataProviders[0]), 0)
+	ctx := context.Background()
+
+	provider.fail(true)
+	if _, err := cached.Search(ctx, MetadataQuery{Kind: library.Kind, Title: "Heat"}); err == nil || errors.Is(err, errProviderUnavailable) {
+		t.Fatalf("first failure = %v", err)
+	}
+	provider.calls()
+
+	// Neither the same nor other items are asked for until the backoff ends
+	for _, title := range []string{"Heat", "Ronin"} {
+		if _, err := cached.Search(ctx, MetadataQuery{Kind: library.Kind, Title: title}); !errors.Is(err, errProviderUnavailable) {
+			t.Errorf("%s during backoff = %v", title, err)
+		}
+	}
+	if calls := provider.calls(); len(calls) != 0 {
+		t.Errorf("requests during backoff = %v", calls)
+	}
+
+	// Once the provider backoff ends, items whose own backoff ended are asked again
+	provider.fail(false)
+	providerSucceeded("db")
+	if _, err := cached.Search(ctx, MetadataQuery{Kind: library.Kind, Title: "Heat"}); !errors.Is(err, errProviderUnavailable) {
+		t.Errorf("item during its own backoff = %v", err)
+	}
+	if results, err := cached.Search(ctx, MetadataQuery{Kind: library.Kind, Title: "Ronin"}); !errors.Is(err, ErrMetadataNotFound) || results != nil {
+		t.Errorf("other item after backoff = %v, %v", results, err)
+	}
+	if calls := provider.calls(); !slices.Equal(calls, []string{"/search"}) {
+		t.Errorf("requests after backoff = %v", calls)
+	}
+}
+
+func TestProviderBackoff(t *testing.T) {
+	for failures, want := range map[int]string{1: "1m0s", 2: "2m0s", 5: "16m0s", 11: "17h4m0s", 12: "24h0m0s", 100: "24h0m0s"} {
+		if got := providerBackoff(failures).String(); got != want {
+			t.Errorf("providerBackoff(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
+
+func TestCachedProviderKeepsAnswerOnError(t *testing.T) {
+	providerTest(t)
+	provider := newMockProvider(t)
+	provider.search["Heat"] = []Metadata{{Title: "Heat", Year: 1995}}
+	cfg, library := providerConfig(map[string]*mockProvider{"db": provider}, "db")
+	cached := newCachedProvider(newHTTPProvider(cfg.MetadataProviders[0]), 0)
+	query := MetadataQuery{Kind: library.Kind, Title: "Heat"}
+
+	if _, err := cached.Search(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	// An expired answer is still used when asking again fails
+	cached.ttl = 0
+	provider.fail(true)
+	results, err := cached.Search(context.Background(), query)
+	if err != nil || len(results) != 1 || results[0].Year != 1995 {
+		t.Errorf("expired answer after error = %v, %v", results, err)
+	}
+}
+
+func TestOfflineLookups(t *testing.T) {
+	providerTest(t)
+	provider := newMockProvider(t)
+	provider.search["Heat"] = []Metadata{{Title: "Heat", Year: 1995}}
+	cfg, library := providerConfig(map[string]*mockProvider{"db": provider}, "db")
+	query := MetadataQuery{Kind: library.Kind, Title: "Heat", Name: "Heat"}
+
+	// Requests don't ask providers, and only see what scans cached
+	if meta := ResolveMetadata(offline(context.Background()), library, cfg, query); meta.Source != MetadataFromFilename {
+		t.Errorf("offline lookup before a scan = %+v", meta)
+	}
+	if calls := provider.calls(); len(calls) != 0 {
+		t.Errorf("offline requests = %v", calls)
+	}
+
+	ResolveMetadata(context.Background(), library, cfg, query)
+	provider.calls()
+	if meta := ResolveMetadata(offline(context.Background()), library, cfg, query); meta.Source != "db" || meta.Year != 1995 {
+		t.Errorf("offline lookup after a scan = %+v", meta)
+	}
+	if calls := provider.calls(); len(calls) != 0 {
+		t.Errorf("offline requests = %v", calls)
+	}
+}
@@ -0,0 +1,493 @@
+package models
+
+import (
+	"context"
+	"maps"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+
+	"mediastream/config"
+)
+
+// Weights of the fields a search looks at, so a match in the title ranks above one in the cast
+const (
+	weightTitle         = 10
+	weightOriginalTitle = 8
+	weightShowTitle     = 6
+	weightMusic         = 6 // Album and artist
+	weightCast          = 4
+	weightFilename      = 2
+)
+
+// How well a query word matches a word of an item: the same word, a longer one or one with a typo
+const (
+	matchExact  = 1.0
+	matchPrefix = 0.7
+	matchFuzzy  = 0.4 // Divided by the number of typos
+)
+
+// foldReplacer spells out letters that have no accent to strip, and drops apostrophes
+var foldReplacer = strings.NewReplacer("ß", "ss", "æ", "ae", "œ", "oe", "ø", "o", "ł", "l", "đ", "d", "þ", "th", "'", "", "’", "")
+
+// foldText lowercases text and strips its accents, so "Amélie" and "amelie" are the same
+func foldText(s string) string {
+	s = strings.ToLower(s)
+	if stripped, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), s); err == nil {
+		s = stripped
+	}
+	return foldReplacer.Replace(s)
+}
+
+// searchWords splits text into folded words
+func searchWords(s string) []string {
+	return strings.FieldsFunc(foldText(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// musicFolders returns the artist and album of a track, named by its Artist/Album/Track path
+func musicFolders(item *MediaItem) (artist, album string) {
+	dir := path.Dir(item.RelativePath)
+	if item.Type != "audio" || dir == "." {
+		return "", ""
+	}
+	album = path.Base(dir)
+	if parent := path.Dir(dir); parent != "." {
+		artist = path.Base(parent)
+	}
+	return artist, album
+}
+
+// searchField is text of an item a search looks at
+type searchField struct {
+	text   string
+	weight float64
+}
+
+// searchFields lists the texts of an item a search looks at
+func searchFields(item *MediaItem) []searchField {
+	fields := []searchField{
+		{item.Title, weightTitle},
+		{strings.TrimSuffix(item.Filename, path.Ext(item.Filename)), weightFilename},
+		{item.Folder, weightFilename},
+	}
+	if meta := item.Metadata; meta != nil {
+		fields = append(fields, searchField{meta.OriginalTitle, weightOriginalTitle}, searchField{meta.ShowTitle, weightShowTitle})
+		for _, member := range meta.Cast {
+			fields = append(fields, searchField{member.Name, weightCast})
+		}
+	}
+	artist, album := musicFolders(item)
+	return append(fields, searchField{artist, weightMusic}, searchField{album, weightMusic})
+}
+
+// posting is an item containing a word, weighted by the most important field it is in
+type posting struct {
+	item   int
+	weight float64
+}
+
+// fuzzyTerm is an indexed word with its letters, for typo matches
+type fuzzyTerm struct {
+	word  string
+	runes []rune
+}
+
+// searchIndex is an inverted index of the items of one library
+type searchIndex struct {
+	items    []MediaItem
+	postings map[string][]posting
+	words    []string      // Sorted, for prefix matches
+	lengths  [][]fuzzyTerm // Words by their number of letters, so typo matches only compare words of similar length
+	titles   []string      // Folded titles by item, to rank exact title matches first
+}
+
+// buildSearchIndex indexes the words of items
+func buildSearchIndex(items []MediaItem) *searchIndex {
+	index := &searchIndex{items: items, postings: map[string][]posting{}, titles: make([]string, len(items))}
+	for i := range items {
+		weights := map[string]float64{}
+		for _, field := range searchFields(&items[i]) {
+			for _, word := range searchWords(field.text) {
+				weights[word] = max(weights[word], field.weight)
+			}
+		}
+		for word, weight := range weights {
+			index.postings[word] = append(index.postings[word], posting{i, weight})
+		}
+		index.titles[i] = strings.Join(searchWords(items[i].Title), " ")
+	}
+	index.words = slices.Sorted(maps.Keys(index.postings))
+	for _, word := range index.words {
+		runes := []rune(word)
+		for len(index.lengths) <= len(runes) {
+			index.lengths = append(index.lengths, nil)
+		}
+		index.lengths[len(runes)] = append(index.lengths[len(runes)], fuzzyTerm{word, runes})
+	}
+	return index
+}
+
+// covers reports whether the index was built from items
+func (s *searchIndex) covers(items []MediaItem) bool {
+	return len(s.items) == len(items) && (len(items) == 0 || &s.items[0] == &items[0])
+}
+
+// allowedTypos returns how many typos a query word may contain: none in short words
+func allowedTypos(word []rune) int {
+	switch {
+	case len(word) < 4:
+		return 0
+	case len(word) < 8:
+		return 1
+	}
+	return 2
+}
+
+// match scores the items containing a query word, a word starting with it or one a typo away
+func (s *searchIndex) match(word string) map[int]float64 {
+	scores := map[int]float64{}
+	add := func(term string, quality float64) {
+		for _, p := range s.postings[term] {
+			scores[p.item] = max(scores[p.item], p.weight*quality)
+		}
+	}
+
+	start, _ := slices.BinarySearch(s.words, word)
+	for i := start; i < len(s.words) && strings.HasPrefix(s.words[i], word); i++ {
+		if s.words[i] == word {
+			add(word, matchExact)
+		} else {
+			add(s.words[i], matchPrefix)
+		}
+	}
+
+	// Words with more letters added or removed than typos allowed can't match
+	runes := []rune(word)
+	typos := allowedTypos(runes)
+	for n := max(len(runes)-typos, 1); typos > 0 && n <= len(runes)+typos && n < len(s.lengths); n++ {
+		for _, term := range s.lengths[n] {
+			if d := editDistance(runes, term.runes, typos); d > 0 && d <= typos {
+				add(term.word, matchFuzzy/float64(d))
+			}
+		}
+	}
+	return scores
+}
+
+// score returns the items matching every query word with their relevance.
+// phrase is the words joined by spaces; titles equal to or starting with it rank higher.
+func (s *searchIndex) score(words []string, phrase string) map[int]float64 {
+	var scores map[int]float64
+	for i, word := range words {
+		matches := s.match(word)
+		if i == 0 {
+			scores = matches
+			continue
+		}
+		for item, score := range scores {
+			if m, ok := matches[item]; ok {
+				scores[item] = score + m
+			} else {
+				delete(scores, item)
+			}
+		}
+	}
+
+	for item := range scores {
+		switch {
+		case s.titles[item] == phrase:
+			scores[item] += weightTitle
+		case strings.HasPrefix(s.titles[item], phrase):
+			scores[item] += weightTitle / 2
+		}
+	}
+	return scores
+}
+
+// editDistance counts the letters to insert, delete, replace or swap with their neighbour to
+// turn a into b, giving up with limit+1 once it is larger than limit
+func editDistance(a, b []rune, limit int) int {
+	if len(a)-len(b) > limit || len(b)-len(a) > limit {
+		return limit + 1
+	}
+
+	// Three rows of the distance matrix: swaps look two rows back
+	before, prev, cur := make([]int, len(b)+1), make([]int, len(b)+1), make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], before[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		before, prev, cur = prev, cur, before
+	}
+	return min(prev[len(b)], limit+1)
+}
+
+// Facets search results are counted by
+const (
+	FacetLibrary    = "library"
+	FacetType       = "type"
+	FacetYear       = "year"
+	FacetGenre      = "genre"
+	FacetResolution = "resolution"
+	FacetWatched    = "watched"
+)
+
+// ItemFilter narrows down media items; empty fields match every item
+type ItemFilter struct {
+	Libraries   []string
+	Types       []string
+	YearFrom    int
+	YearTo      int
+	Genres      []string              // Matched case-insensitively
+	Resolutions []string              // e.g. 1080p
+	Letter      string                // First letter of the sort title, or # for digits and symbols
+	Watched     *bool                 // Whether the profile finished the item
+	History     map[string]WatchEntry // Watch history of the profile by media ID
+	Rating      RatingLimit           // Rating limit of the profile, which hides items instead of filtering them
+}
+
+// Narrows reports whether the filter rejects any items, apart from the rating limit
+func (f ItemFilter) Narrows() bool {
+	return len(f.Libraries) > 0 || len(f.Types) > 0 || f.YearFrom != 0 || f.YearTo != 0 ||
+		len(f.Genres) > 0 || len(f.Resolutions) > 0 || f.Letter != "" || f.Watched != nil
+}
+
+// admits checks the conditions of the filter that aren't facets: the rating limit and letter
+func (f ItemFilter) admits(item *MediaItem) bool {
+	return item.AllowedUnder(f.Rating) && (f.Letter == "" || strings.EqualFold(f.Letter, item.Letter()))
+}
+
+// containsFold reports whether a list contains a value, ignoring case
+func containsFold(list []string, value string) bool {
+	return slices.ContainsFunc(list, func(s string) bool { return strings.EqualFold(s, value) })
+}
+
+// accepts reports whether an item passes the filter of one facet
+func (f ItemFilter) accepts(item *MediaItem, facet string) bool {
+	switch facet {
+	case FacetLibrary:
+		return len(f.Libraries) == 0 || slices.Contains(f.Libraries, item.LibraryID)
+	case FacetType:
+		return len(f.Types) == 0 || slices.Contains(f.Types, item.Type)
+	case FacetYear:
+		year := item.Year()
+		return (f.YearFrom == 0 || year >= f.YearFrom) && (f.YearTo == 0 || year != 0 && year <= f.YearTo)
+	case FacetGenre:
+		return len(f.Genres) == 0 || slices.ContainsFunc(item.Genres(), func(genre string) bool { return containsFold(f.Genres, genre) })
+	case FacetResolution:
+		return len(f.Resolutions) == 0 || item.Release != nil && containsFold(f.Resolutions, item.Release.Resolution)
+	case FacetWatched:
+		return f.Watched == nil || f.History[item.ID].Completed == *f.Watched
+	}
+	return true
+}
+
+// searchFacets lists every facet
+var searchFacets = []string{FacetLibrary, FacetType, FacetYear, FacetGenre, FacetResolution, FacetWatched}
+
+// Matches reports whether an item passes the filter and the rating limit
+func (f ItemFilter) Matches(item *MediaItem) bool {
+	if !f.admits(item) {
+		return false
+	}
+	for _, facet := range searchFacets {
+		if !f.accepts(item, facet) {
+			return false
+		}
+	}
+	return true
+}
+
+// facetValues returns the values of an item for a facet
+func facetValues(item *MediaItem, facet string, history map[string]WatchEntry) []string {
+	switch facet {
+	case FacetLibrary:
+		return []string{item.LibraryID}
+	case FacetType:
+		return []string{item.Type}
+	case FacetYear:
+		if year := item.Year(); year != 0 {
+			return []string{strconv.Itoa(year)}
+		}
+	case FacetGenre:
+		return item.Genres()
+	case FacetResolution:
+		if item.Release != nil && item.Release.Resolution != "" {
+			return []string{item.Release.Resolution}
+		}
+	case FacetWatched:
+		return []string{strconv.FormatBool(history[item.ID].Completed)}
+	}
+	return nil
+}
+
+// SearchQuery is a full-text search with filters, of which one page of results is returned
+type SearchQuery struct {
+	Text   string
+	Filter ItemFilter
+	Offset int
+	Limit  int
+}
+
+// SearchResult is a media item found by a search, with its relevance
+type SearchResult struct {
+	MediaItem
+	Score float64 `json:"score"`
+	title string  // Folded, to sort equally relevant results
+}
+
+// FacetCount is the number of results with a value of a facet, e.g. 12 in genre Drama
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// SearchResults is a page of search results, with facet counts over all of them
+type SearchResults struct {
+	Total   int                     `json:"total"`
+	Offset  int                     `json:"offset"`
+	Limit   int                     `json:"limit"`
+	Results []SearchResult          `json:"results"`
+	Facets  map[string][]FacetCount `json:"facets"`
+}
+
+// librarySearch returns the search index of a library's current items, building it on first use
+func (x *Index) librarySearch(ctx context.Context, library config.Library, cfg *config.Config) (*searchIndex, error) {
+	items, err := x.Items(ctx, library, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	x.mu.RLock()
+	search := x.searches[library.ID]
+	x.mu.RUnlock()
+	if search != nil && search.covers(items) {
+		return search, nil
+	}
+
+	search = buildSearchIndex(items)
+	x.mu.Lock()
+	x.searches[library.ID] = search
+	x.mu.Unlock()
+	return search, nil
+}
+
+// Search finds the items of libraries matching every word of a query, best match first. Without
+// words it lists the items passing the filter, if it narrows anything down. Each facet counts the
+// results passing all other filters, so clients can offer the alternatives to a chosen filter.
+func (x *Index) Search(ctx context.Context, libraries []config.Library, cfg *config.Config, query SearchQuery) *SearchResults {
+	results := &SearchResults{Offset: query.Offset, Limit: query.Limit, Results: []SearchResult{}, Facets: map[string][]FacetCount{}}
+	words := searchWords(query.Text)
+	filter := query.Filter
+	if len(words) == 0 && !filter.Narrows() {
+		return results
+	}
+
+	var found []SearchResult
+	counts := map[string]map[string]int{}
+	count := func(item *MediaItem, facet string) {
+		if counts[facet] == nil {
+			counts[facet] = map[string]int{}
+		}
+		for _, value := range facetValues(item, facet, filter.History) {
+			counts[facet][value]++
+		}
+	}
+
+	for _, library := range libraries {
+		// Scan errors are logged by the scan
+		search, err := x.librarySearch(ctx, library, cfg)
+		if err != nil {
+			continue
+		}
+
+		scores := map[int]float64{}
+		if len(words) > 0 {
+			scores = search.score(words, strings.Join(words, " "))
+		} else {
+			for i := range search.items {
+				scores[i] = 0
+			}
+		}
+
+		for i, score := range scores {
+			item := &search.items[i]
+			if !filter.admits(item) {
+				continue
+			}
+			var rejected []string
+			for _, facet := range searchFacets {
+				if !filter.accepts(item, facet) {
+					rejected = append(rejected, facet)
+				}
+			}
+			switch len(rejected) {
+			case 0:
+				found = append(found, SearchResult{MediaItem: *item, Score: score, title: search.titles[i]})
+				for _, facet := range searchFacets {
+					count(item, facet)
+				}
+			case 1:
+				count(item, rejected[0])
+			}
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Score != found[j].Score {
+			return found[i].Score > found[j].Score
+		}
+		if found[i].title != found[j].title {
+			return found[i].title < found[j].title
+		}
+		return found[i].ID < found[j].ID
+	})
+
+	results.Total = len(found)
+	start := min(query.Offset, len(found))
+	end := len(found)
+	if query.Limit > 0 {
+		end = min(start+query.Limit, len(found))
+	}
+	results.Results = append(results.Results, found[start:end]...)
+
+	for _, facet := range searchFacets {
+		values := []FacetCount{}
+		for value, n := range counts[facet] {
+			values = append(values, FacetCount{Value: value, Count: n})
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if values[i].Count != values[j].Count {
+				return values[i].Count > values[j].Count
+			}
+			return values[i].Value < values[j].Value
+		})
+		results.Facets[facet] = values
+	}
+	return results
+}
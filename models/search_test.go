@@ -0,0 +1,211 @@
+package models
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"mediastream/config"
+	"mediastream/utils"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"matrix", "matrix", 2, 0},
+		{"matrix", "matrx", 2, 1},   // Deletion
+		{"matrix", "matrixx", 2, 1}, // Insertion
+		{"matrix", "metrix", 2, 1},  // Replacement
+		{"matrix", "mtarix", 2, 1},  // Swap of neighbours
+		{"amelie", "amlie", 2, 1},
+		{"godfather", "godfahter", 2, 1},
+		{"godfather", "gdofahter", 2, 2},
+		{"alien", "aliens", 1, 1},
+		{"alien", "allien", 0, 1},  // Beyond the limit
+		{"ab", "abcde", 2, 3},      // Lengths differ by more than the limit
+		{"abcdef", "ghijkl", 2, 3}, // Given up early
+		{"", "ab", 2, 2},
+		{"über", "uber", 2, 1},
+		{"straße", "strasse", 2, 2},
+	}
+	for _, test := range tests {
+		if got := editDistance([]rune(test.a), []rune(test.b), test.limit); got != test.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, want %d", test.a, test.b, test.limit, got, test.want)
+		}
+	}
+}
+
+func TestFoldText(t *testing.T) {
+	for text, want := range map[string]string{"Amélie": "amelie", "Straße": "strasse", "Schindler's List": "schindlers list", "Ærø": "aero"} {
+		if got := foldText(text); got != want {
+			t.Errorf("foldText(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+// searchItems are the movies the search tests look through
+func searchItems() []MediaItem {
+	movie := func(id, title string, year int, genres []string, resolution string, cast ...string) MediaItem {
+		item := MediaItem{ID: id, Title: title, Type: "video", LibraryID: "movies", Filename: id + ".mkv",
+			Metadata: &Metadata{Title: title, Year: year, Genres: genres}, Release: &utils.Release{Resolution: resolution}}
+		for _, name := range cast {
+			item.Metadata.Cast = append(item.Metadata.Cast, CastMember{Name: name})
+		}
+		return item
+	}
+	return []MediaItem{
+		movie("alien", "Alien", 1979, []string{"Horror", "Science Fiction"}, "1080p", "Sigourney Weaver"),
+		movie("aliens", "Aliens", 1986, []string{"Action", "Science Fiction"}, "720p", "Sigourney Weaver"),
+		movie("amelie", "Amélie", 2001, []string{"Comedy", "Romance"}, "1080p", "Audrey Tautou", "Casper Ghost"),
+		movie("ghostbusters", "Ghostbusters", 1984, []string{"Comedy"}, "1080p", "Sigourney Weaver", "Bill Murray"),
+		movie("alien-nation", "Alien Nation", 1988, []string{"Science Fiction"}, "", "James Caan"),
+		movie("matrix", "The Matrix", 1999, []string{"Action"}, "2160p", "Keanu Reeves"),
+	}
+}
+
+// searchIDs returns the IDs of the items a search index finds for a query, best match first
+func searchIDs(index *searchIndex, query string) []string {
+	words := searchWords(query)
+	scores := index.score(words, strings.Join(words, " "))
+	items := slices.Collect(maps.Keys(scores))
+	slices.SortStableFunc(items, func(a, b int) int { return cmp.Compare(scores[b], scores[a]) })
+	ids := []string{}
+	for _, item := range items {
+		ids = append(ids, index.items[item].ID)
+	}
+	return ids
+}
+
+func TestSearchRanking(t *testing.T) {
+	index := buildSearchIndex(searchItems())
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// The whole title first, then titles starting with it, then longer words
+		{"alien", []string{"alien", "alien-nation", "aliens"}},
+		{"Amelie", []string{"amelie"}},
+		{"amélie", []string{"amelie"}},
+		// Title matches before cast matches
+		{"ghost", []string{"ghostbusters", "amelie"}},
+		{"weaver", []string{"alien", "aliens", "ghostbusters"}},
+		// Every word must match
+		{"alien nation", []string{"alien-nation"}},
+		{"matrix reeves", []string{"matrix"}},
+		{"matrix murray", []string{}},
+		// Typos in longer words
+		{"ghostbuster", []string{"ghostbusters"}},
+		{"ghsotbusters", []string{"ghostbusters"}},
+		{"matirx", []string{"matrix"}},
+		{"xyz", []string{}},
+	}
+	for _, test := range tests {
+		got := searchIDs(index, test.query)
+		if test.query == "weaver" {
+			// Equal scores are in no particular order
+			if !sameElements(got, test.want) {
+				t.Errorf("%q found %v, want %v", test.query, got, test.want)
+			}
+			continue
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%q found %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+// sameElements reports whether two lists hold the same strings in any order
+func sameElements(a, b []string) bool {
+	return slices.Equal(slices.Sorted(slices.Values(a)), slices.Sorted(slices.Values(b)))
+}
+
+func TestSearchTypoLimits(t *testing.T) {
+	index := buildSearchIndex(searchItems())
+	// No typos in words shorter than four letters, one up to seven letters, two from eight
+	for query, found := range map[string]bool{"cann": true, "cam": false, "alein": true, "aleni": false, "alxxn": false, "ghostbsuetrs": true, "ghostbsuetsr": false} {
+		if got := len(searchIDs(index, query)) > 0; got != found {
+			t.Errorf("%q found anything = %v, want %v", query, got, found)
+		}
+	}
+}
+
+// searchTest returns an index holding items as the scanned movies library
+func searchTest(items []MediaItem) (*Index, []config.Library, *config.Config) {
+	cfg := config.DefaultConfig()
+	cfg.Libraries = []config.Library{{ID: "movies", Name: "Movies", Kind: config.KindMovies}}
+	index := NewIndex("")
+	index.libraries["movies"] = &IndexedLibrary{Fingerprint: libraryFingerprint(cfg.Libraries[0], cfg), Items: items}
+	return index, cfg.Libraries, cfg
+}
+
+// facetCounts flattens the counts of a facet into value=count strings
+func facetCounts(results *SearchResults, facet string) []string {
+	var counts []string
+	for _, count := range results.Facets[facet] {
+		counts = append(counts, fmt.Sprintf("%s=%d", count.Value, count.Count))
+	}
+	return counts
+}
+
+func TestSearchFacets(t *testing.T) {
+	index, libraries, cfg := searchTest(searchItems())
+	ctx := context.Background()
+
+	// Each facet counts the results of all other filters, so choosing another genre can be offered
+	results := index.Search(ctx, libraries, cfg, SearchQuery{Text: "weaver", Filter: ItemFilter{Genres: []string{"comedy"}}})
+	if results.Total != 1 || results.Results[0].ID != "ghostbusters" {
+		t.Errorf("results = %+v", results.Results)
+	}
+	if got, want := facetCounts(results, FacetGenre), []string{"Science Fiction=2", "Action=1", "Comedy=1", "Horror=1"}; !slices.Equal(got, want) {
+		t.Errorf("genre counts = %v, want %v", got, want)
+	}
+	if got, want := facetCounts(results, FacetYear), []string{"1984=1"}; !slices.Equal(got, want) {
+		t.Errorf("year counts = %v, want %v", got, want)
+	}
+
+	// Without words the filter lists the items
+	results = index.Search(ctx, libraries, cfg, SearchQuery{Filter: ItemFilter{Resolutions: []string{"1080P"}, YearFrom: 1980}})
+	if got := results.Total; got != 2 {
+		t.Errorf("filtered %d items, want 2", got)
+	}
+	if got, want := facetCounts(results, FacetResolution), []string{"1080p=2", "2160p=1", "720p=1"}; !slices.Equal(got, want) {
+		t.Errorf("resolution counts = %v, want %v", got, want)
+	}
+
+	// Neither words nor a filter find nothing
+	if results := index.Search(ctx, libraries, cfg, SearchQuery{}); results.Total != 0 || results.Results == nil {
+		t.Errorf("empty search = %+v", results)
+	}
+}
+
+func TestSearchPaging(t *testing.T) {
+	index, libraries, cfg := searchTest(searchItems())
+	results := index.Search(context.Background(), libraries, cfg, SearchQuery{Filter: ItemFilter{Types: []string{"video"}}, Offset: 4, Limit: 3})
+	if results.Total != 6 || len(results.Results) != 2 {
+		t.Fatalf("page = %d of %d results", len(results.Results), results.Total)
+	}
+	// Equally relevant results are sorted by title
+	if results.Results[0].ID != "ghostbusters" || results.Results[1].ID != "matrix" {
+		t.Errorf("page = %s, %s", results.Results[0].ID, results.Results[1].ID)
+	}
+}
+
+func TestSearchWatched(t *testing.T) {
+	index, libraries, cfg := searchTest(searchItems())
+	watched := true
+	filter := ItemFilter{Watched: &watched, History: map[string]WatchEntry{"alien": {Completed: true}}}
+	results := index.Search(context.Background(), libraries, cfg, SearchQuery{Text: "alien", Filter: filter})
+	if results.Total != 1 || results.Results[0].ID != "alien" {
+		t.Errorf("watched results = %+v", results.Results)
+	}
+	if got, want := facetCounts(results, FacetWatched), []string{"false=2", "true=1"}; !slices.Equal(got, want) {
+		t.Errorf("watched counts = %v, want %v", got, want)
+	}
+}
@@ -3,7 +3,10 @@ package models
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"mediastream/utils"
@@ -14,31 +17,52 @@ import (
 
 // User represents a user in the system
 type User struct {
-	ID       string    `json:"id"`
-	Username string    `json:"username"`
-	Password string    `json:"password"`
-	IsAdmin  bool      `json:"isAdmin"`
-	Created  time.Time `json:"created"`
+	ID           string    `json:"id"`
+	Username     string    `json:"username"`
+	Password     string    `json:"password"`
+	IsAdmin      bool      `json:"isAdmin"`
+	Disabled     bool      `json:"disabled,omitempty"`
+	Libraries    []string  `json:"libraries"` // IDs of the libraries the user may access, nil means all
+	Profiles     []Profile `json:"profiles,omitempty"`
+	Created      time.Time `json:"created"`
+	SessionEpoch int       `json:"sessionEpoch,omitempty"` // Sessions started in an earlier epoch are no longer valid
 }
 
 // UserResponse is a safe representation of a user for API responses
 type UserResponse struct {
-	ID       string    `json:"id"`
-	Username string    `json:"username"`
-	IsAdmin  bool      `json:"isAdmin"`
-	Created  time.Time `json:"created,omitempty"`
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	IsAdmin   bool      `json:"isAdmin"`
+	Disabled  bool      `json:"disabled"`
+	Libraries []string  `json:"libraries"`
+	Created   time.Time `json:"created,omitempty"`
 }
 
 // ToResponse converts a User to a UserResponse (removing sensitive data)
 func (u *User) ToResponse() UserResponse {
 	return UserResponse{
-		ID:       u.ID,
-		Username: u.Username,
-		IsAdmin:  u.IsAdmin,
-		Created:  u.Created,
+		ID:        u.ID,
+		Username:  u.Username,
+		IsAdmin:   u.IsAdmin,
+		Disabled:  u.Disabled,
+		Libraries: u.Libraries,
+		Created:   u.Created,
 	}
 }
 
+// CanAccessLibrary reports whether the user may browse and stream the given library
+func (u *User) CanAccessLibrary(libraryID string) bool {
+	if u.IsAdmin || u.Libraries == nil {
+		return true
+	}
+	for _, library := range u.Libraries {
+		if library == libraryID {
+			return true
+		}
+	}
+	return false
+}
+
 // GetUserFromContext gets the user from the Gin context
 func GetUserFromContext(c *gin.Context) (*User, bool) {
 	user, exists := c.Get("user")
@@ -79,7 +103,41 @@ func SaveUsers(users []User, filename string) error {
 		return err
 	}
 
-	return os.WriteFile(filename, data, 0644)
+	return utils.WriteFileAtomic(filename, data, 0644)
+}
+
+// usersMu serializes changes to the users file
+var usersMu sync.Mutex
+
+// UpdateUsers loads the users, changes them with update and saves the ones it returns, holding a
+// lock so that concurrent changes see each other. Nothing is saved if update fails.
+func UpdateUsers(filename string, update func(users []User) ([]User, error)) error {
+	usersMu.Lock()
+	defer usersMu.Unlock()
+
+	users, err := LoadUsers(filename)
+	if err != nil {
+		return err
+	}
+	users, err = update(users)
+	if err != nil {
+		return err
+	}
+	return SaveUsers(users, filename)
+}
+
+// MinUsernameLength is the minimum number of characters in a username
+const MinUsernameLength = 3
+
+// ValidateUsername checks that a username is acceptable
+func ValidateUsername(username string) error {
+	if len(username) < MinUsernameLength {
+		return fmt.Errorf("Username must be at least %d characters", MinUsernameLength)
+	}
+	if strings.TrimSpace(username) != username {
+		return errors.New("Username must not start or end with whitespace")
+	}
+	return nil
 }
 
 // FindUserByUsername finds a user by username
@@ -110,7 +168,7 @@ func CreateUser(users []User, username, password string, isAdmin bool) (*User, e
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := HashPassword(password)
 	if err != nil {
 		return nil, err
 	}
@@ -119,7 +177,7 @@ func CreateUser(users []User, username, password string, isAdmin bool) (*User, e
 	user := User{
 		ID:       utils.GenerateUniqueID(),
 		Username: username,
-		Password: string(hashedPassword),
+		Password: hashedPassword,
 		IsAdmin:  isAdmin,
 		Created:  time.Now(),
 	}
@@ -132,3 +190,47 @@ func ValidateCredentials(user *User, password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	return err == nil
 }
+
+// HashPassword hashes a plain-text password for storage
+func HashPassword(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+// SetPassword replaces a user's password with the hash of the given one
+func SetPassword(user *User, password string) error {
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+	return nil
+}
+
+// EndSessions logs the user out everywhere, as after a password change
+func (u *User) EndSessions() {
+	u.SessionEpoch++
+}
+
+// CountActiveAdmins returns the number of admins that are not disabled
+func CountActiveAdmins(users []User) int {
+	count := 0
+	for _, user := range users {
+		if user.IsAdmin && !user.Disabled {
+			count++
+		}
+	}
+	return count
+}
+
+// IsLastActiveAdmin reports whether the user is the only admin that is not disabled
+func IsLastActiveAdmin(users []User, id string) bool {
+	user := FindUserByID(users, id)
+	if user == nil || !user.IsAdmin || user.Disabled {
+		return false
+	}
+	return CountActiveAdmins(users) == 1
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestUpdateUsers(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "users.json")
+	if err := SaveUsers([]User{{ID: "a", Username: "a", IsAdmin: true}, {ID: "b", Username: "b", IsAdmin: true}}, file); err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent demotions of both admins, and additions, all see each other
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := UpdateUsers(file, func(users []User) ([]User, error) {
+				if id := []string{"a", "b"}[i%2]; !IsLastActiveAdmin(users, id) {
+					FindUserByID(users, id).IsAdmin = false
+				}
+				return append(users, User{ID: fmt.Sprint(i), Username: fmt.Sprint("user", i)}), nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	users, err := LoadUsers(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 22 {
+		t.Errorf("%d users after 20 additions to 2, want 22", len(users))
+	}
+	if admins := CountActiveAdmins(users); admins != 1 {
+		t.Errorf("%d admins left, want 1", admins)
+	}
+
+	// A failed update saves nothing
+	refused := errors.New("refused")
+	err = UpdateUsers(file, func(users []User) ([]User, error) {
+		return nil, refused
+	})
+	if !errors.Is(err, refused) {
+		t.Errorf("UpdateUsers returned %v, want the update's error", err)
+	}
+	if users, _ := LoadUsers(file); len(users) != 22 {
+		t.Errorf("%d users after a failed update, want 22", len(users))
+	}
+}
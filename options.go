@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"mediastream/config"
+	"mediastream/logging"
+)
+
+// options are the command-line flags shared by every command
+type options struct {
+	dataDir    string
+	configFile string
+	settings   settingFlags
+	devMode    bool
+}
+
+// settingFlags collects repeated -set name=value flags
+type settingFlags []string
+
+func (s *settingFlags) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *settingFlags) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// shorthandFlags are shorthands for -set <setting>=value
+var shorthandFlags = map[string]string{
+	"address":    "server.address",
+	"port":       "server.port",
+	"socket":     "server.socket",
+	"base-path":  "server.basePath",
+	"tls-cert":   "server.tlsCert",
+	"tls-key":    "server.tlsKey",
+	"log-level":  "logging.level",
+	"log-format": "logging.format",
+}
+
+// parseOptions parses the global flags and returns the remaining arguments, which name the command.
+// Settings are layered: defaults, then the config file, then MEDIASTREAM_* variables, then flags.
+func parseOptions(args []string) (*options, []string) {
+	opts := &options{
+		dataDir:    os.Getenv("MEDIASTREAM_DATA_DIR"),
+		configFile: os.Getenv("MEDIASTREAM_CONFIG"),
+		devMode:    os.Getenv("MEDIASTREAM_ENV") == "development",
+	}
+	if opts.dataDir == "" {
+		opts.dataDir = "."
+	}
+
+	flags := flag.NewFlagSet("mediastream", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [flags] [dev] [command]\n\nCommands:\n", os.Args[0])
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(flags.Output(), "  %s\n", commands[name].usage)
+		}
+		fmt.Fprintf(flags.Output(), "\nFlags:\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(flags.Output(), "\nSettings for -set and their MEDIASTREAM_* variables:\n")
+		for _, name := range config.SettingNames() {
+			fmt.Fprintf(flags.Output(), "  %-30s %s\n", name, config.EnvName(name))
+		}
+	}
+	flags.StringVar(&opts.dataDir, "data-dir", opts.dataDir, "directory holding the configuration and server state (MEDIASTREAM_DATA_DIR)")
+	flags.StringVar(&opts.configFile, "config", opts.configFile, "config file, .json, .yaml or .toml (MEDIASTREAM_CONFIG, default config.* in the data directory)")
+	flags.Var(&opts.settings, "set", "override a setting as name=value, e.g. scanIntervalMinutes=10 (repeatable)")
+	flags.String("address", "", "interface to listen on (default all interfaces)")
+	flags.Int("port", config.DefaultPort, "TCP port to listen on")
+	flags.String("socket", "", "Unix socket to listen on instead of a TCP port")
+	flags.String("base-path", "", "URL prefix when hosted below a reverse proxy path, e.g. /media")
+	flags.String("tls-cert", "", "TLS certificate file")
+	flags.String("tls-key", "", "TLS private key file")
+	flags.String("log-level", "info", "lowest level logged: debug, info, warn or error")
+	flags.String("log-format", "text", "log output format: text or json")
+
+	// "dev" before the command enables development mode, and may be followed by more flags.
+	// Later arguments belong to the command, e.g. a user named dev.
+	flags.Parse(args)
+	if flags.Arg(0) == "dev" {
+		opts.devMode = true
+		flags.Parse(flags.Args()[1:])
+	}
+
+	// Shorthand flags are applied after -set, whatever their order on the command line
+	flags.Visit(func(f *flag.Flag) {
+		if path, ok := shorthandFlags[f.Name]; ok {
+			opts.settings = append(opts.settings, path+"="+f.Value.String())
+		}
+	})
+
+	return opts, flags.Args()
+}
+
+// setPaths points the data directory and config file at those given by the flags
+func setPaths(opts *options) {
+	config.SetDataDir(opts.dataDir)
+	if opts.configFile != "" {
+		config.ConfigFile = opts.configFile
+	}
+}
+
+// loadConfigStore loads the config file from the data directory and applies environment and flag overrides
+func loadConfigStore(opts *options) (*config.Store, error) {
+	setPaths(opts)
+
+	cfg, err := config.LoadConfig(config.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	store := config.NewStore(cfg, config.ConfigFile)
+	if err := store.SetOverrides(func(cfg *config.Config) error {
+		return config.ApplyOverrides(cfg, opts.settings)
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := logging.Configure(store.Get().Logging); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
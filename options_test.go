@@ -0,0 +1,30 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseOptionsDevMode(t *testing.T) {
+	tests := []struct {
+		args []string
+		dev  bool
+		rest []string
+	}{
+		{nil, false, nil},
+		{[]string{"dev"}, true, nil},
+		{[]string{"-port", "3001", "dev"}, true, nil},
+		{[]string{"dev", "-port", "3001", "serve"}, true, []string{"serve"}},
+		{[]string{"dev", "user", "add", "dev"}, true, []string{"user", "add", "dev"}},
+		{[]string{"user", "add", "dev"}, false, []string{"user", "add", "dev"}},
+		{[]string{"user", "passwd", "dev"}, false, []string{"user", "passwd", "dev"}},
+		{[]string{"-data-dir", "dev", "scan"}, false, []string{"scan"}},
+	}
+	for _, test := range tests {
+		t.Setenv("MEDIASTREAM_ENV", "")
+		opts, rest := parseOptions(test.args)
+		if opts.devMode != test.dev || !slices.Equal(rest, test.rest) && len(rest)+len(test.rest) > 0 {
+			t.Errorf("parseOptions(%q) = dev %v, %q; want dev %v, %q", test.args, opts.devMode, rest, test.dev, test.rest)
+		}
+	}
+}
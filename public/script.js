@@ -30,7 +30,7 @@ document.addEventListener('DOMContentLoaded', () => {
   logoutButton.textContent = 'Logout';
   logoutButton.className = 'logout-button';
   logoutButton.addEventListener('click', () => {
-    window.location.href = '/logout';
+    window.location.href = 'logout';
   });
   headerRight.appendChild(logoutButton);
   
@@ -210,7 +210,7 @@ document.addEventListener('DOMContentLoaded', () => {
   // Check if user is admin and set up admin panel
   async function checkAdmin() {
     try {
-      const response = await fetch('/api/admin/users');
+      const response = await fetch('api/admin/users');
       
       if (response.ok) {
         isAdmin = true;
@@ -297,7 +297,7 @@ document.addEventListener('DOMContentLoaded', () => {
   // Load users for admin panel
   async function loadUsers() {
     try {
-      const response = await fetch('/api/admin/users');
+      const response = await fetch('api/admin/users');
       const users = await response.json();
       
       const userListBody = document.getElementById('user-list-body');
@@ -342,7 +342,7 @@ document.addEventListener('DOMContentLoaded', () => {
     }
     
     try {
-      const response = await fetch('/api/admin/users', {
+      const response = await fetch('api/admin/users', {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json'
@@ -388,7 +388,7 @@ document.addEventListener('DOMContentLoaded', () => {
   async function deleteUser(userId) {
     if (confirm('Are you sure you want to delete this user?')) {
       try {
-        const response = await fetch(`/api/admin/users/${userId}`, {
+        const response = await fetch(`api/admin/users/${userId}`, {
           method: 'DELETE'
         });
         
@@ -438,14 +438,14 @@ document.addEventListener('DOMContentLoaded', () => {
     }
     
     try {
-      const response = await fetch(`/api/search?q=${encodeURIComponent(query)}`);
-      const results = await response.json();
+      const response = await fetch(`api/v1/search?q=${encodeURIComponent(query)}`);
+      const { results, total } = await response.json();
       
-      if (results.length === 0) {
+      if (total === 0) {
         searchGrid.innerHTML = '<div class="loading-message">No results found</div>';
         searchCount.textContent = 'No results found';
       } else {
-        searchCount.textContent = `Found ${results.length} result${results.length === 1 ? '' : 's'}`;
+        searchCount.textContent = `Found ${total} result${total === 1 ? '' : 's'}`;
         renderSearchResults(results, query);
       }
       
@@ -521,7 +521,7 @@ document.addEventListener('DOMContentLoaded', () => {
   // Load all media libraries
   async function loadLibraries() {
     try {
-      const response = await fetch('/api/libraries');
+      const response = await fetch('api/libraries');
       const libraries = await response.json();
       
       renderLibraries(libraries);
@@ -544,15 +544,15 @@ document.addEventListener('DOMContentLoaded', () => {
       li.className = 'nav-item';
       li.dataset.id = library.id;
       
-      // Choose icon based on library type
-      let icon = '📁';
-      if (library.type === 'movies') {
-        icon = '🎬';
-      } else if (library.type === 'tvshows') {
-        icon = '📺';
-      } else if (library.type === 'music') {
-        icon = '🎵';
-      }
+      // Choose icon based on library kind
+      const kindIcons = {
+        movies: '🎬',
+        shows: '📺',
+        music: '🎵',
+        photos: '🖼️',
+        homevideos: '📹'
+      };
+      const icon = kindIcons[library.kind] || '📁';
       
       li.innerHTML = `
         <div class="nav-item-icon">${icon}</div>
@@ -585,15 +585,39 @@ document.addEventListener('DOMContentLoaded', () => {
     }
   }
   
+  // Items requested at a time while scrolling through a library
+  const libraryPageSize = 100;
+  
+  // Page of the library being shown, replaced when another library is selected
+  let libraryPage = null;
+  
+  // Loads the next page when the end of the grid scrolls into view
+  const libraryPageObserver = new IntersectionObserver(entries => {
+    if (entries.some(entry => entry.isIntersecting) && libraryPage) {
+      loadLibraryPage(libraryPage);
+    }
+  });
+  
   // Load media items for a specific library
   async function loadLibrary(library) {
     currentLibrary = library;
     currentLibraryTitle.textContent = library.name;
     
     mediaGrid.innerHTML = '<div class="loading-message">Loading content...</div>';
+    libraryPageObserver.disconnect();
+    libraryPage = { library, offset: 0, loading: false };
+    await loadLibraryPage(libraryPage);
+  }
+  
+  // Load the next page of a library and add it to the grid
+  async function loadLibraryPage(page) {
+    if (page.loading) {
+      return;
+    }
+    page.loading = true;
     
     try {
-      const response = await fetch(`/api/library/${library.type}`);
+      const response = await fetch(`api/library/${encodeURIComponent(page.library.id)}?offset=${page.offset}&limit=${libraryPageSize}`);
       
       if (!response.ok) {
         // If the server returns an error, display it properly
@@ -603,34 +627,44 @@ document.addEventListener('DOMContentLoaded', () => {
         return;
       }
       
-      const items = await response.json();
+      const { items, total } = await response.json();
       
-      // Ensure items is always treated as an array
-      const mediaItems = Array.isArray(items) ? items : [];
+      // Another library was selected in the meantime
+      if (page !== libraryPage) {
+        return;
+      }
       
-      renderMediaItems(mediaItems, library.type);
+      if (page.offset === 0) {
+        mediaGrid.innerHTML = '';
+        if (total === 0) {
+          mediaGrid.innerHTML = `<div class="loading-message">No media found in this library</div>`;
+          return;
+        }
+      }
+      
+      libraryPageObserver.disconnect();
+      mediaGrid.querySelector('.load-more')?.remove();
+      
+      page.offset += items.length;
+      renderMediaItems(items, page.library.kind);
+      
+      if (items.length > 0 && page.offset < total) {
+        const more = document.createElement('div');
+        more.className = 'loading-message load-more';
+        more.textContent = `Showing ${page.offset} of ${total}`;
+        mediaGrid.appendChild(more);
+        libraryPageObserver.observe(more);
+      }
     } catch (error) {
       console.error("Error fetching library data:", error);
       mediaGrid.innerHTML = `<div class="loading-message error">Error loading media: ${error.message}</div>`;
+    } finally {
+      page.loading = false;
     }
   }
   
-  // Render media items in the grid
+  // Add media items to the grid
   function renderMediaItems(items, libraryType) {
-    // Ensure items is an array
-    if (!items || !Array.isArray(items)) {
-      console.error("Invalid items data:", items);
-      mediaGrid.innerHTML = `<div class="loading-message">Error: Invalid media data received</div>`;
-      return;
-    }
-    
-    if (items.length === 0) {
-      mediaGrid.innerHTML = `<div class="loading-message">No media found in this library</div>`;
-      return;
-    }
-    
-    mediaGrid.innerHTML = '';
-    
     items.forEach(item => {
       const mediaItem = document.createElement('div');
       mediaItem.className = 'media-item';
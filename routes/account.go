@@ -0,0 +1,81 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+// ChangePasswordRequest is the request body for changing the logged-in user's password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+// HandleChangePassword lets the logged-in user change their own password
+func HandleChangePassword(c *gin.Context, cfg *config.Config) {
+	var form ChangePasswordRequest
+
+	if err := c.ShouldBindJSON(&form); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Get current user from context
+	currentUser, exists := models.GetUserFromContext(c)
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := cfg.PasswordPolicy.Validate(form.NewPassword); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hashedPassword, err := models.HashPassword(form.NewPassword)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to set password")
+		return
+	}
+
+	var epoch int
+	errWrongPassword := &userError{http.StatusForbidden, "Current password is incorrect"}
+	err = models.UpdateUsers(config.UsersFile, func(users []models.User) ([]models.User, error) {
+		user := models.FindUserByID(users, currentUser.ID)
+		if user == nil {
+			return nil, &userError{http.StatusNotFound, "User not found"}
+		}
+
+		// Require the current password so a hijacked session can't take over the account
+		if !models.ValidateCredentials(user, form.CurrentPassword) {
+			return nil, errWrongPassword
+		}
+
+		user.Password = hashedPassword
+		user.EndSessions()
+		epoch = user.SessionEpoch
+		return users, nil
+	})
+	if errors.Is(err, errWrongPassword) {
+		RecordAudit(c, models.AuditUserPasswordChange, currentUser.Username, false, map[string]string{"reason": "wrong current password"})
+	}
+	if err != nil {
+		respondUsersError(c, err)
+		return
+	}
+
+	// Other sessions end with the old password, this one goes on
+	session := sessions.Default(c)
+	session.Set("sessionEpoch", epoch)
+	session.Save()
+
+	RecordAudit(c, models.AuditUserPasswordChange, currentUser.Username, true, nil)
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Password changed successfully"})
+}
@@ -1,7 +1,12 @@
 package routes
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 
@@ -9,12 +14,49 @@ import (
 	"mediastream/models"
 )
 
+// validateLibraries checks that every library in an access list exists
+func validateLibraries(cfg *config.Config, libraries []string) error {
+	for _, library := range libraries {
+		if cfg.FindLibrary(library) == nil {
+			return fmt.Errorf("Unknown library: %s", library)
+		}
+	}
+	return nil
+}
+
+// describeLibraries lists the libraries of an access list for the audit log
+func describeLibraries(libraries []string) string {
+	if libraries == nil {
+		return "all"
+	}
+	return strings.Join(libraries, ",")
+}
+
+// userError refuses a change to the users with a status and message
+type userError struct {
+	status  int
+	message string
+}
+
+func (e *userError) Error() string { return e.message }
+
+// respondUsersError answers a failed models.UpdateUsers: refusals with their status, anything else
+// with 500
+func respondUsersError(c *gin.Context, err error) {
+	var refused *userError
+	if errors.As(err, &refused) {
+		respondError(c, refused.status, refused.message)
+		return
+	}
+	respondError(c, http.StatusInternalServerError, "Failed to save users")
+}
+
 // HandleGetUsers returns all users (for admin)
 func HandleGetUsers(c *gin.Context) {
 	// Load users from file
 	users, err := models.LoadUsers(config.UsersFile)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load users"})
+		respondError(c, http.StatusInternalServerError, "Failed to load users")
 		return
 	}
 
@@ -27,56 +69,69 @@ func HandleGetUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, safeUsers)
 }
 
+// CreateUserRequest is the request body for creating a user
+type CreateUserRequest struct {
+	Username  string   `json:"username" binding:"required"`
+	Password  string   `json:"password" binding:"required"`
+	IsAdmin   bool     `json:"isAdmin"`
+	Libraries []string `json:"libraries"` // Missing or null grants every library, [] none
+}
+
 // HandleCreateUser creates a new user (admin only)
-func HandleCreateUser(c *gin.Context) {
-	var form struct {
-		Username string `json:"username" binding:"required"`
-		Password string `json:"password" binding:"required"`
-		IsAdmin  bool   `json:"isAdmin"`
-	}
+func HandleCreateUser(c *gin.Context, cfg *config.Config) {
+	var form CreateUserRequest
 
 	if err := c.ShouldBindJSON(&form); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// Basic validation
 	if form.Username == "" || form.Password == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Username and password are required"})
+		respondError(c, http.StatusBadRequest, "Username and password are required")
 		return
 	}
 
-	// Load existing users
-	users, err := models.LoadUsers(config.UsersFile)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load users"})
+	if err := models.ValidateUsername(form.Username); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Check if username already exists
-	if models.FindUserByUsername(users, form.Username) != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Username already exists"})
+	if err := cfg.PasswordPolicy.Validate(form.Password); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Create new user
-	user, err := models.CreateUser(users, form.Username, form.Password, form.IsAdmin)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := validateLibraries(cfg, form.Libraries); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Add to users list
-	users = append(users, *user)
+	// Create new user, hashing the password before taking the users lock
+	user, err := models.CreateUser(nil, form.Username, form.Password, form.IsAdmin)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	user.Libraries = form.Libraries
 
-	// Save users to file
-	err = models.SaveUsers(users, config.UsersFile)
+	err = models.UpdateUsers(config.UsersFile, func(users []models.User) ([]models.User, error) {
+		if models.FindUserByUsername(users, form.Username) != nil {
+			return nil, &userError{http.StatusBadRequest, "Username already exists"}
+		}
+		return append(users, *user), nil
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save users"})
+		respondUsersError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "User created successfully"})
+	RecordAudit(c, models.AuditUserCreate, user.Username, true, map[string]string{
+		"id":      user.ID,
+		"isAdmin": strconv.FormatBool(user.IsAdmin),
+	})
+
+	c.JSON(http.StatusCreated, MessageResponse{Message: "User created successfully"})
 }
 
 // HandleDeleteUser deletes a user (admin only)
@@ -86,46 +141,218 @@ func HandleDeleteUser(c *gin.Context) {
 	// Get current user from context
 	currentUser, exists := models.GetUserFromContext(c)
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
 	// Don't allow the admin to delete themselves
 	if userID == currentUser.ID {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete your own account"})
+		respondError(c, http.StatusBadRequest, "Cannot delete your own account")
 		return
 	}
 
-	// Load existing users
-	users, err := models.LoadUsers(config.UsersFile)
+	var deletedUsername string
+	var deletedProfileIDs []string
+	err := models.UpdateUsers(config.UsersFile, func(users []models.User) ([]models.User, error) {
+		// Never remove the last active admin
+		if models.IsLastActiveAdmin(users, userID) {
+			return nil, &userError{http.StatusBadRequest, "Cannot delete the last admin"}
+		}
+
+		// Find user to delete
+		var updatedUsers []models.User
+		found := false
+
+		for _, user := range users {
+			if user.ID == userID {
+				found = true
+				deletedUsername = user.Username
+				deletedProfileIDs = append(deletedProfileIDs, user.ID)
+				for _, profile := range user.Profiles {
+					deletedProfileIDs = append(deletedProfileIDs, profile.ID)
+				}
+				continue
+			}
+			updatedUsers = append(updatedUsers, user)
+		}
+
+		if !found {
+			return nil, &userError{http.StatusNotFound, "User not found"}
+		}
+		return updatedUsers, nil
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load users"})
+		respondUsersError(c, err)
+		return
+	}
+
+	// Remove the user's watch history and playlists
+	if err := deleteProfileData(deletedProfileIDs...); err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to delete profile data")
+		return
+	}
+
+	RecordAudit(c, models.AuditUserDelete, deletedUsername, true, map[string]string{"id": userID})
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "User deleted successfully"})
+}
+
+// UpdateUserRequest is the request body for updating a user; missing fields are left unchanged
+type UpdateUserRequest struct {
+	Username  *string   `json:"username"`
+	IsAdmin   *bool     `json:"isAdmin"`
+	Disabled  *bool     `json:"disabled"`
+	Libraries *[]string `json:"libraries"` // null grants every library, [] none
+}
+
+// UnmarshalJSON tells "libraries": null, which points Libraries at a nil list, from a missing field
+func (r *UpdateUserRequest) UnmarshalJSON(data []byte) error {
+	type plain UpdateUserRequest
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if raw, ok := fields["libraries"]; ok && string(raw) == "null" {
+		r.Libraries = new([]string)
+	}
+	return nil
+}
+
+// HandleUpdateUser updates a user's username, role, library access or disabled status (admin only)
+func HandleUpdateUser(c *gin.Context, cfg *config.Config) {
+	userID := c.Param("id")
+
+	var form UpdateUserRequest
+
+	if err := c.ShouldBindJSON(&form); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if form.Libraries != nil {
+		if err := validateLibraries(cfg, *form.Libraries); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// Get current user from context
+	currentUser, exists := models.GetUserFromContext(c)
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// Don't allow the admin to lock themselves out
+	if userID == currentUser.ID && form.Disabled != nil && *form.Disabled {
+		respondError(c, http.StatusBadRequest, "Cannot disable your own account")
 		return
 	}
 
-	// Find user to delete
-	var updatedUsers []models.User
-	found := false
+	if form.Username != nil {
+		if err := models.ValidateUsername(*form.Username); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// Record what changed for the audit log
+	changes := map[string]string{"id": userID}
+
+	var updated models.User
+	err := models.UpdateUsers(config.UsersFile, func(users []models.User) ([]models.User, error) {
+		user := models.FindUserByID(users, userID)
+		if user == nil {
+			return nil, &userError{http.StatusNotFound, "User not found"}
+		}
+
+		if form.Username != nil && *form.Username != user.Username {
+			if models.FindUserByUsername(users, *form.Username) != nil {
+				return nil, &userError{http.StatusBadRequest, "Username already exists"}
+			}
+			changes["username"] = user.Username + " -> " + *form.Username
+			user.Username = *form.Username
+		}
+
+		// Demoting or disabling the last active admin would leave nobody able to administer the server
+		removesAdmin := (form.IsAdmin != nil && !*form.IsAdmin) || (form.Disabled != nil && *form.Disabled)
+		if removesAdmin && models.IsLastActiveAdmin(users, userID) {
+			return nil, &userError{http.StatusBadRequest, "Cannot remove the last admin"}
+		}
 
-	for _, user := range users {
-		if user.ID == userID {
-			found = true
-			continue
+		if form.IsAdmin != nil {
+			user.IsAdmin = *form.IsAdmin
+			changes["isAdmin"] = strconv.FormatBool(user.IsAdmin)
+		}
+		if form.Disabled != nil {
+			user.Disabled = *form.Disabled
+			changes["disabled"] = strconv.FormatBool(user.Disabled)
+		}
+		if form.Libraries != nil {
+			user.Libraries = *form.Libraries
+			changes["libraries"] = describeLibraries(user.Libraries)
 		}
-		updatedUsers = append(updatedUsers, user)
+
+		updated = *user
+		return users, nil
+	})
+	if err != nil {
+		respondUsersError(c, err)
+		return
+	}
+
+	RecordAudit(c, models.AuditUserUpdate, updated.Username, true, changes)
+
+	c.JSON(http.StatusOK, updated.ToResponse())
+}
+
+// ResetPasswordRequest is the request body for setting another user's password
+type ResetPasswordRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// HandleResetPassword sets a new password for a user (admin only)
+func HandleResetPassword(c *gin.Context, cfg *config.Config) {
+	userID := c.Param("id")
+
+	var form ResetPasswordRequest
+
+	if err := c.ShouldBindJSON(&form); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	if !found {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	if err := cfg.PasswordPolicy.Validate(form.Password); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Save updated users list
-	err = models.SaveUsers(updatedUsers, config.UsersFile)
+	hashedPassword, err := models.HashPassword(form.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save users"})
+		respondError(c, http.StatusInternalServerError, "Failed to set password")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	var username string
+	err = models.UpdateUsers(config.UsersFile, func(users []models.User) ([]models.User, error) {
+		user := models.FindUserByID(users, userID)
+		if user == nil {
+			return nil, &userError{http.StatusNotFound, "User not found"}
+		}
+		user.Password = hashedPassword
+		user.EndSessions()
+		username = user.Username
+		return users, nil
+	})
+	if err != nil {
+		respondUsersError(c, err)
+		return
+	}
+
+	RecordAudit(c, models.AuditUserPasswordReset, username, true, map[string]string{"id": userID})
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Password reset successfully"})
 }
@@ -0,0 +1,104 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+func TestLibraryAccessLists(t *testing.T) {
+	s := newContractServer(t)
+	s.login("admin", "admin-password")
+	admin := s.session
+	s.call("POST", "/admin/users", CreateUserRequest{Username: "viewer", Password: "viewer-password", Libraries: []string{"movies"}}, http.StatusCreated)
+	users, err := models.LoadUsers(config.UsersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := models.FindUserByUsername(users, "viewer").ID
+	s.login("viewer", "viewer-password")
+	viewer := s.session
+
+	tests := []struct {
+		patch string
+		want  int
+	}{
+		{`{"libraries": ["photos"]}`, 1},
+		{`{"libraries": []}`, 0},
+		{`{}`, 0},
+		{`{"libraries": null}`, 2},
+		{`{"isAdmin": false}`, 2},
+		{`{"libraries": ["movies", "photos"]}`, 2},
+	}
+	for _, test := range tests {
+		s.session = admin
+		s.call("PATCH", "/admin/users/"+id, json.RawMessage(test.patch), http.StatusOK)
+		s.session = viewer
+		if libraries := s.call("GET", "/libraries", nil, http.StatusOK).([]any); len(libraries) != test.want {
+			t.Errorf("after PATCH %s: %d libraries, want %d", test.patch, len(libraries), test.want)
+		}
+	}
+}
+
+func TestInviteLibraryAccessLists(t *testing.T) {
+	s := newContractServer(t)
+	s.login("admin", "admin-password")
+	admin := s.session
+
+	tests := []struct {
+		username string
+		invite   string
+		want     int
+	}{
+		{"everything", `{}`, 2},
+		{"unlisted", `{"libraries": null}`, 2},
+		{"nothing", `{"libraries": []}`, 0},
+		{"movies", `{"libraries": ["movies"]}`, 1},
+	}
+	for _, test := range tests {
+		s.session = admin
+		link := field(t, s.call("POST", "/admin/invites", json.RawMessage(test.invite), http.StatusCreated), "link")
+		s.session = nil
+		s.call("POST", "/invite/"+link[strings.LastIndex(link, "/")+1:], AcceptInviteRequest{Username: test.username, Password: test.username + "-password"}, http.StatusCreated)
+		if libraries := s.call("GET", "/libraries", nil, http.StatusOK).([]any); len(libraries) != test.want {
+			t.Errorf("invite %s: %d libraries, want %d", test.invite, len(libraries), test.want)
+		}
+	}
+}
+
+func TestDeleteLibraryStripsAccessLists(t *testing.T) {
+	s := newContractServer(t)
+	s.login("admin", "admin-password")
+	s.call("POST", "/admin/libraries", LibraryRequest{ID: "extra", Name: "Extra", Kind: config.KindMixed, Paths: []string{filepath.Join(config.DataDir, "extra")}}, http.StatusCreated)
+	s.call("POST", "/admin/users", CreateUserRequest{Username: "both", Password: "both-password", Libraries: []string{"movies", "extra"}}, http.StatusCreated)
+	s.call("POST", "/admin/users", CreateUserRequest{Username: "extra", Password: "extra-password", Libraries: []string{"extra"}}, http.StatusCreated)
+	s.call("POST", "/admin/invites", CreateInviteRequest{Libraries: []string{"extra"}}, http.StatusCreated)
+
+	s.call("DELETE", "/admin/libraries/extra", nil, http.StatusOK)
+
+	users, err := models.LoadUsers(config.UsersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for username, want := range map[string][]string{"admin": nil, "both": {"movies"}, "extra": {}} {
+		if got := models.FindUserByUsername(users, username).Libraries; !slices.Equal(got, want) || (got == nil) != (want == nil) {
+			t.Errorf("libraries of %s = %#v, want %#v", username, got, want)
+		}
+	}
+	invites, err := models.LoadInvites(config.InvitesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := invites[0].Libraries; got == nil || len(got) != 0 {
+		t.Errorf("libraries of the invite = %#v, want none", got)
+	}
+
+	// The stripped list can be sent back unchanged
+	s.call("PATCH", "/admin/users/"+models.FindUserByUsername(users, "both").ID, UpdateUserRequest{Libraries: &[]string{"movies"}}, http.StatusOK)
+}
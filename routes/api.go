@@ -0,0 +1,266 @@
+package routes
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+// APIPrefix is the path of the current API version below the base path
+const APIPrefix = "/api/v1"
+
+// ErrorCode tells clients why an /api/v1 request failed
+type ErrorCode string
+
+// ErrorCodes are the codes of failed /api/v1 requests by HTTP status
+var ErrorCodes = map[int]ErrorCode{
+	http.StatusBadRequest:                   "invalid_request",
+	http.StatusUnauthorized:                 "unauthorized",
+	http.StatusForbidden:                    "forbidden",
+	http.StatusNotFound:                     "not_found",
+	http.StatusUnsupportedMediaType:         "unsupported_media_type",
+	http.StatusRequestedRangeNotSatisfiable: "range_not_satisfiable",
+	http.StatusInternalServerError:          "internal_error",
+	http.StatusServiceUnavailable:           "unavailable",
+}
+
+// Enum lists every error code, sorted
+func (ErrorCode) Enum() []string {
+	codes := make([]string, 0, len(ErrorCodes))
+	for _, code := range ErrorCodes {
+		codes = append(codes, string(code))
+	}
+	slices.Sort(codes)
+	return codes
+}
+
+// APIError tells why an /api/v1 request failed. Clients act on the code; the message is for people.
+type APIError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// ErrorResponse is the body of a failed /api/v1 request
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// isV1 reports whether a request is for the versioned API
+func isV1(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.URL.Path, URL(APIPrefix+"/"))
+}
+
+// legacyList reports whether a request of the unversioned API lists items without any of the
+// paging params, which older clients expect as a bare array instead of a page
+func legacyList(c *gin.Context, params ...string) bool {
+	if isV1(c) {
+		return false
+	}
+	for _, param := range params {
+		if _, ok := c.GetQuery(param); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// respondError answers a request with an error: an ErrorResponse in /api/v1, and
+// {"error": message} on the unversioned routes older clients use
+func respondError(c *gin.Context, status int, message string) {
+	if !isV1(c) {
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+
+	code, ok := ErrorCodes[status]
+	if !ok {
+		code = ErrorCodes[http.StatusInternalServerError]
+		if status < http.StatusInternalServerError {
+			code = ErrorCodes[http.StatusBadRequest]
+		}
+	}
+	c.JSON(status, ErrorResponse{Error: APIError{Code: code, Message: message}})
+}
+
+// HandleNotFound answers requests for unknown routes
+func HandleNotFound(c *gin.Context) {
+	if isV1(c) {
+		respondError(c, http.StatusNotFound, "Unknown API endpoint")
+		return
+	}
+	c.String(http.StatusNotFound, "404 page not found")
+}
+
+// MessageResponse confirms a change that returns nothing else
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// SuccessResponse confirms a completed login, setup or invite redemption
+type SuccessResponse struct {
+	Success bool `json:"success"`
+}
+
+// RegisterAPI adds the API routes to a router group, the versioned /api/v1 or the
+// unversioned /api older clients use
+func RegisterAPI(api *gin.RouterGroup, store *config.Store, index *models.Index, previews *models.PreviewGenerator) {
+	authMiddleware := EnsureAuthenticated(store.Get())
+	adminMiddleware := EnsureAdmin()
+	setupMiddleware := CheckSetup()
+
+	api.GET("/openapi.json", HandleOpenAPI)
+	api.GET("/version", HandleVersion)
+	api.POST("/setup", func(c *gin.Context) {
+		HandleSetup(c, store)
+	})
+
+	// Admin routes
+	adminGroup := api.Group("/admin")
+	adminGroup.Use(authMiddleware, adminMiddleware)
+	{
+		adminGroup.GET("/users", HandleGetUsers)
+		adminGroup.POST("/users", func(c *gin.Context) {
+			HandleCreateUser(c, store.Get())
+		})
+		adminGroup.PATCH("/users/:id", func(c *gin.Context) {
+			HandleUpdateUser(c, store.Get())
+		})
+		adminGroup.POST("/users/:id/password", func(c *gin.Context) {
+			HandleResetPassword(c, store.Get())
+		})
+		adminGroup.DELETE("/users/:id", HandleDeleteUser)
+
+		adminGroup.GET("/invites", HandleGetInvites)
+		adminGroup.POST("/invites", func(c *gin.Context) {
+			HandleCreateInvite(c, store.Get())
+		})
+		adminGroup.DELETE("/invites/:id", HandleRevokeInvite)
+
+		adminGroup.GET("/audit", HandleGetAudit)
+		adminGroup.GET("/backup", HandleBackup)
+
+		adminGroup.GET("/libraries", func(c *gin.Context) {
+			HandleGetLibrariesAdmin(c, store)
+		})
+		adminGroup.POST("/libraries", func(c *gin.Context) {
+			HandleCreateLibrary(c, store)
+		})
+		adminGroup.PUT("/libraries/:id", func(c *gin.Context) {
+			HandleUpdateLibrary(c, store)
+		})
+		adminGroup.DELETE("/libraries/:id", func(c *gin.Context) {
+			HandleDeleteLibrary(c, store)
+		})
+		adminGroup.GET("/libraries/:id/duplicates", func(c *gin.Context) {
+			HandleGetDuplicates(c, store.Get(), index)
+		})
+		adminGroup.POST("/libraries/:id/scan", func(c *gin.Context) {
+			HandleScanLibrary(c, store.Get(), index)
+		})
+
+		adminGroup.GET("/media/:id/metadata", func(c *gin.Context) {
+			HandleGetMetadataEdit(c, store.Get())
+		})
+		adminGroup.PATCH("/media/:id/metadata", func(c *gin.Context) {
+			HandleEditMetadata(c, store.Get(), index)
+		})
+		adminGroup.DELETE("/media/:id/metadata", func(c *gin.Context) {
+			HandleResetMetadata(c, store.Get(), index)
+		})
+
+		adminGroup.GET("/config", func(c *gin.Context) {
+			HandleGetConfig(c, store)
+		})
+		adminGroup.PUT("/config", func(c *gin.Context) {
+			HandleUpdateConfig(c, store)
+		})
+		adminGroup.POST("/config/reload", func(c *gin.Context) {
+			HandleReloadConfig(c, store)
+		})
+
+		adminGroup.GET("/scan", func(c *gin.Context) {
+			HandleGetIndexStatus(c, index)
+		})
+		adminGroup.POST("/scan", func(c *gin.Context) {
+			HandleScanAll(c, store.Get(), index)
+		})
+	}
+
+	// Invite redemption (the token in the link is the credential)
+	api.POST("/invite/:token", setupMiddleware, func(c *gin.Context) {
+		HandleAcceptInvite(c, store.Get())
+	})
+
+	// Account routes for the logged-in user
+	api.PUT("/me/password", authMiddleware, func(c *gin.Context) {
+		HandleChangePassword(c, store.Get())
+	})
+
+	// Profile routes, scoped to the logged-in account
+	api.GET("/profiles", authMiddleware, HandleGetProfiles)
+	api.POST("/profiles", authMiddleware, HandleCreateProfile)
+	api.PATCH("/profiles/:id", authMiddleware, HandleUpdateProfile)
+	api.DELETE("/profiles/:id", authMiddleware, HandleDeleteProfile)
+	api.POST("/profiles/:id/switch", authMiddleware, HandleSwitchProfile)
+
+	// Watch history and playlists, scoped to the active profile
+	api.GET("/history", authMiddleware, HandleGetHistory)
+	api.PUT("/history/:id", authMiddleware, func(c *gin.Context) {
+		HandleUpdateHistory(c, store.Get())
+	})
+	api.DELETE("/history/:id", authMiddleware, HandleDeleteHistory)
+	api.GET("/playlists", authMiddleware, HandleGetPlaylists)
+	api.POST("/playlists", authMiddleware, func(c *gin.Context) {
+		HandleCreatePlaylist(c, store.Get())
+	})
+	api.GET("/playlists/:id", authMiddleware, HandleGetPlaylist)
+	api.PUT("/playlists/:id", authMiddleware, func(c *gin.Context) {
+		HandleUpdatePlaylist(c, store.Get())
+	})
+	api.DELETE("/playlists/:id", authMiddleware, HandleDeletePlaylist)
+
+	// Media library routes
+	api.GET("/libraries", authMiddleware, func(c *gin.Context) {
+		HandleGetLibraries(c, store.Get())
+	})
+	api.GET("/library/:id", authMiddleware, func(c *gin.Context) {
+		HandleGetLibrary(c, store.Get(), index)
+	})
+	api.GET("/library/:id/timeline", authMiddleware, func(c *gin.Context) {
+		HandleGetTimeline(c, store.Get(), index)
+	})
+	api.GET("/library/:id/albums", authMiddleware, func(c *gin.Context) {
+		HandleGetAlbums(c, store.Get(), index)
+	})
+	api.GET("/library/:id/albums/:album", authMiddleware, func(c *gin.Context) {
+		HandleGetAlbum(c, store.Get(), index)
+	})
+	api.GET("/media/:id", authMiddleware, func(c *gin.Context) {
+		HandleGetMediaItem(c, store.Get())
+	})
+	api.GET("/media/:id/image/:kind", authMiddleware, func(c *gin.Context) {
+		HandleMediaImage(c, store.Get())
+	})
+	api.GET("/media/:id/photo", authMiddleware, func(c *gin.Context) {
+		HandlePhoto(c, store.Get())
+	})
+	api.GET("/media/:id/previews", authMiddleware, func(c *gin.Context) {
+		HandleGetPreviews(c, store.Get(), previews)
+	})
+	api.GET("/media/:id/trickplay/:file", authMiddleware, func(c *gin.Context) {
+		HandleTrickplayFile(c, store.Get(), previews)
+	})
+	api.GET("/search", authMiddleware, func(c *gin.Context) {
+		HandleSearch(c, store.Get(), index)
+	})
+
+	// Debug endpoint to check media scanning
+	api.GET("/debug/scan", authMiddleware, adminMiddleware, func(c *gin.Context) {
+		HandleDebugScan(c, store.Get())
+	})
+}
@@ -0,0 +1,119 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+// Pagination limits for the audit log API
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// RecordAudit appends an event to the audit log, taking the actor from the request
+func RecordAudit(c *gin.Context, eventType, target string, success bool, details map[string]string) {
+	event := models.AuditEvent{
+		Time:    time.Now(),
+		Type:    eventType,
+		IP:      c.ClientIP(),
+		Target:  target,
+		Success: success,
+		Details: details,
+	}
+
+	if user, exists := models.GetUserFromContext(c); exists {
+		event.ActorID = user.ID
+		event.Actor = user.Username
+	} else if userID, ok := sessions.Default(c).Get("userID").(string); ok {
+		event.ActorID = userID
+	}
+
+	if err := models.AppendAuditEvent(event, config.AuditFile); err != nil {
+		logger.ErrorContext(c.Request.Context(), "Error writing audit event", "type", eventType, "error", err)
+	}
+}
+
+// AuditPage is a page of the audit log, with the number of matching events on all pages
+type AuditPage struct {
+	Events []models.AuditEvent `json:"events"`
+	Total  int                 `json:"total"`
+	Offset int                 `json:"offset"`
+	Limit  int                 `json:"limit"`
+}
+
+// HandleGetAudit returns audit events, newest first, with filters and pagination (admin only).
+// With format=jsonl every matching event is exported as JSON lines instead.
+func HandleGetAudit(c *gin.Context) {
+	filter := models.AuditFilter{
+		Type:  c.Query("type"),
+		Actor: c.Query("actor"),
+		IP:    c.Query("ip"),
+	}
+
+	if value := c.Query("success"); value != "" {
+		success, err := strconv.ParseBool(value)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "Invalid success filter")
+			return
+		}
+		filter.Success = &success
+	}
+
+	for param, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := c.Query(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, "Invalid "+param+" time, expected RFC 3339")
+				return
+			}
+			*target = t
+		}
+	}
+
+	events, err := models.LoadAuditEvents(config.AuditFile, filter)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to load audit log")
+		return
+	}
+
+	if c.Query("format") == "jsonl" {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		c.Status(http.StatusOK)
+		encoder := json.NewEncoder(c.Writer)
+		for _, event := range events {
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		respondError(c, http.StatusBadRequest, "Invalid offset")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultAuditLimit)))
+	if err != nil || limit < 1 || limit > maxAuditLimit {
+		respondError(c, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+
+	// Newest first
+	page := []models.AuditEvent{}
+	for i := len(events) - 1 - offset; i >= 0 && len(page) < limit; i-- {
+		page = append(page, events[i])
+	}
+
+	c.JSON(http.StatusOK, AuditPage{Events: page, Total: len(events), Offset: offset, Limit: limit})
+}
@@ -1,14 +1,18 @@
 package routes
 
 import (
+	"errors"
 	"net/http"
 	"os"
-	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 
 	"mediastream/config"
+	"mediastream/metrics"
 	"mediastream/models"
 )
 
@@ -16,7 +20,7 @@ import (
 func HandleLogin(c *gin.Context) {
 	if c.Request.Method == "GET" {
 		// Serve login page
-		c.File(filepath.Join("public", "login.html"))
+		ServePage(c, "login.html")
 		return
 	}
 
@@ -24,140 +28,258 @@ func HandleLogin(c *gin.Context) {
 	username := c.PostForm("username")
 	password := c.PostForm("password")
 
+	// Clients guessing passwords are locked out, guesses at one account from many clients slowed down
+	ipKey, accountKey := "ip:"+c.ClientIP(), "user:"+strings.ToLower(username)
+	if wait := loginLimiter.retryAfter(ipKey); wait > 0 {
+		RecordAudit(c, models.AuditLogin, username, false, map[string]string{"reason": "too many attempts"})
+		metrics.LoginFailures.Inc("locked_out")
+		setRetryAfter(c, wait)
+		redirect(c, "/login?error=locked")
+		return
+	}
+
+	if !sleep(c.Request.Context(), accountLimiter.delay(accountKey)) {
+		return
+	}
+
 	// Load users from file
 	users, err := models.LoadUsers(config.UsersFile)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load users"})
+		respondError(c, http.StatusInternalServerError, "Failed to load users")
 		return
 	}
 
 	// Find user by username
 	user := models.FindUserByUsername(users, username)
 	if user == nil {
-		c.Redirect(http.StatusFound, "/login?error=1")
+		RecordAudit(c, models.AuditLogin, username, false, map[string]string{"reason": "unknown user"})
+		metrics.LoginFailures.Inc("unknown_user")
+		loginLimiter.fail(ipKey)
+		accountLimiter.fail(accountKey)
+		redirect(c, "/login?error=1")
 		return
 	}
 
 	// Check password
 	if !models.ValidateCredentials(user, password) {
-		c.Redirect(http.StatusFound, "/login?error=1")
+		RecordAudit(c, models.AuditLogin, username, false, map[string]string{"reason": "wrong password"})
+		metrics.LoginFailures.Inc("wrong_password")
+		loginLimiter.fail(ipKey)
+		accountLimiter.fail(accountKey)
+		redirect(c, "/login?error=1")
 		return
 	}
 
-	// Set user in session
+	// Disabled accounts can't log in
+	if user.Disabled {
+		RecordAudit(c, models.AuditLogin, username, false, map[string]string{"reason": "account disabled"})
+		metrics.LoginFailures.Inc("disabled")
+		redirect(c, "/login?error=1")
+		return
+	}
+
+	accountLimiter.succeed(accountKey)
+
+	startSession(c, user)
+
+	RecordAudit(c, models.AuditLogin, username, true, nil)
+
+	redirect(c, "/")
+}
+
+// startSession logs a user in on this client, without a profile selected
+func startSession(c *gin.Context, user *models.User) {
 	session := sessions.Default(c)
 	session.Set("userID", user.ID)
+	session.Set("sessionEpoch", user.SessionEpoch)
+	session.Delete("profileID")
 	session.Save()
-
-	c.Redirect(http.StatusFound, "/")
 }
 
 // HandleLogout logs out a user
 func HandleLogout(c *gin.Context) {
+	RecordAudit(c, models.AuditLogout, "", true, nil)
+
 	session := sessions.Default(c)
 	session.Delete("userID")
+	session.Delete("sessionEpoch")
+	session.Delete("profileID")
 	session.Save()
-	c.Redirect(http.StatusFound, "/login")
+	redirect(c, "/login")
 }
 
+// SetupRequest is the request body of the setup wizard
+type SetupRequest struct {
+	Username     string `json:"username" binding:"required"`
+	Password     string `json:"password" binding:"required"`
+	MediaFolders struct {
+		Movies  string `json:"movies"`
+		TVShows string `json:"tvshows"`
+		Music   string `json:"music"`
+	} `json:"mediaFolders"`
+	Libraries []LibraryRequest `json:"libraries"`
+}
+
+// setupMu keeps two setups from running at once
+var setupMu sync.Mutex
+
 // HandleSetup handles the initial setup
-func HandleSetup(c *gin.Context) {
+func HandleSetup(c *gin.Context, store *config.Store) {
 	if c.Request.Method == "GET" {
 		// If setup is already completed, redirect to home
 		if config.IsSetupCompleted() {
-			c.Redirect(http.StatusFound, "/")
+			redirect(c, "/")
 			return
 		}
 
 		// Serve setup page
-		c.File(filepath.Join("public", "setup.html"))
+		ServePage(c, "setup.html")
 		return
 	}
 
-	// Process setup form
-	var setupForm struct {
-		Username     string `json:"username" binding:"required"`
-		Password     string `json:"password" binding:"required"`
-		MediaFolders struct {
-			Movies  string `json:"movies"`
-			TVShows string `json:"tvshows"`
-			Music   string `json:"music"`
-		} `json:"mediaFolders"`
+	// Setup can only be run once
+	if config.IsSetupCompleted() {
+		respondError(c, http.StatusForbidden, "Setup has already been completed")
+		return
 	}
 
+	// Process setup form
+	var setupForm SetupRequest
+
 	if err := c.ShouldBindJSON(&setupForm); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// Validate input
-	if len(setupForm.Username) < 3 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Username must be at least 3 characters"})
+	if err := models.ValidateUsername(setupForm.Username); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if len(setupForm.Password) < 8 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 8 characters"})
+	if err := store.Get().PasswordPolicy.Validate(setupForm.Password); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Create admin user
-	users := []models.User{}
-	user, err := models.CreateUser(users, setupForm.Username, setupForm.Password, true)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	setupMu.Lock()
+	defer setupMu.Unlock()
+	if config.IsSetupCompleted() {
+		respondError(c, http.StatusForbidden, "Setup has already been completed")
 		return
 	}
 
-	users = append(users, *user)
-
-	// Save users to file
-	err = models.SaveUsers(users, config.UsersFile)
+	// Users created from the command line before setup are kept
+	users, err := models.LoadUsers(config.UsersFile)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save user"})
+		respondError(c, http.StatusInternalServerError, "Failed to load users")
 		return
 	}
-
-	// Create default config
-	cfg := config.DefaultConfig()
-
-	// Update config with custom media folders if provided
-	if setupForm.MediaFolders.Movies != "" {
-		cfg.MediaFolders[0].Path = setupForm.MediaFolders.Movies
+	if models.FindUserByUsername(users, setupForm.Username) != nil {
+		respondError(c, http.StatusBadRequest, "Username already exists")
+		return
+	}
+	user, err := models.CreateUser(users, setupForm.Username, setupForm.Password, true)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	if setupForm.MediaFolders.TVShows != "" {
-		cfg.MediaFolders[1].Path = setupForm.MediaFolders.TVShows
+	// Update the configured libraries with the ones chosen in the wizard
+	saved := store.Saved()
+	cfg, err := store.Update(func(cfg *config.Config) error {
+		if len(setupForm.Libraries) > 0 {
+			previous := &config.Config{Libraries: cfg.Libraries}
+			cfg.Libraries = nil
+			for _, form := range setupForm.Libraries {
+				library := config.Library{
+					ID:    form.ID,
+					Name:  strings.TrimSpace(form.Name),
+					Kind:  form.Kind,
+					Paths: form.Paths,
+				}
+				if library.ID == "" {
+					library.ID = cfg.NewLibraryID(library.Name)
+				}
+				// A default library left without a path keeps its default folder
+				if existing := previous.FindLibrary(library.ID); existing != nil && len(library.Paths) == 0 {
+					library.Paths = existing.Paths
+				}
+				if form.Options != nil {
+					library.Options = *form.Options
+				}
+				cfg.Libraries = append(cfg.Libraries, library)
+			}
+			return nil
+		}
+
+		// Older clients send one path per default library
+		customPaths := map[string]string{
+			"movies":  setupForm.MediaFolders.Movies,
+			"tvshows": setupForm.MediaFolders.TVShows,
+			"music":   setupForm.MediaFolders.Music,
+		}
+		for id, path := range customPaths {
+			if library := cfg.FindLibrary(id); library != nil && path != "" {
+				library.Paths = []string{path}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		var invalid *config.ValidationError
+		if errors.As(err, &invalid) {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "Failed to save configuration")
+		return
 	}
 
-	if setupForm.MediaFolders.Music != "" {
-		cfg.MediaFolders[2].Path = setupForm.MediaFolders.Music
+	// Undo a setup that failed halfway, so that it can be run again
+	fail := func(message string) {
+		if _, err := store.Update(func(cfg *config.Config) error {
+			*cfg = *saved
+			return nil
+		}); err != nil {
+			logger.ErrorContext(c.Request.Context(), "Error restoring the config after a failed setup", "error", err)
+		}
+		if err := models.UpdateUsers(config.UsersFile, func(users []models.User) ([]models.User, error) {
+			return slices.DeleteFunc(users, func(other models.User) bool { return other.ID == user.ID }), nil
+		}); err != nil {
+			logger.ErrorContext(c.Request.Context(), "Error restoring users after a failed setup", "error", err)
+		}
+		respondError(c, http.StatusInternalServerError, message)
 	}
 
 	// Create media directories if they don't exist
-	for _, folder := range cfg.MediaFolders {
-		if _, err := os.Stat(folder.Path); os.IsNotExist(err) {
-			err = os.MkdirAll(folder.Path, 0755)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create media directory: " + folder.Path})
+	for _, library := range cfg.Libraries {
+		for _, path := range library.Paths {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				fail("Failed to create media directory: " + path)
 				return
 			}
 		}
 	}
 
-	// Save config
-	err = config.SaveConfig(cfg, config.ConfigFile)
+	err = models.UpdateUsers(config.UsersFile, func(users []models.User) ([]models.User, error) {
+		if models.FindUserByUsername(users, user.Username) != nil {
+			return nil, &userError{http.StatusBadRequest, "Username already exists"}
+		}
+		return append(users, *user), nil
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save configuration"})
+		fail("Failed to save user")
 		return
 	}
 
 	// Mark setup as completed
-	err = config.MarkSetupCompleted()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark setup as completed"})
+	if err := config.MarkSetupCompleted(); err != nil {
+		fail("Failed to mark setup as completed")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true})
+	RecordAudit(c, models.AuditSetupComplete, setupForm.Username, true, nil)
+
+	c.JSON(http.StatusOK, SuccessResponse{Success: true})
 }
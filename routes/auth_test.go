@@ -0,0 +1,85 @@
+package routes
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+func TestSetupRollsBack(t *testing.T) {
+	s := newContractServer(t)
+	if err := os.Remove(config.SetupFlagFile); err != nil {
+		t.Fatal(err)
+	}
+	libraries := s.store.Saved().Libraries
+
+	// A folder that can't be created, below a file
+	blocked := filepath.Join(config.DataDir, "blocked")
+	if err := os.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.call("POST", "/setup", SetupRequest{
+		Username:  "owner",
+		Password:  "owner-password",
+		Libraries: []LibraryRequest{{Name: "Home Videos", Kind: config.KindHomeVideos, Paths: []string{filepath.Join(blocked, "videos")}}},
+	}, http.StatusInternalServerError)
+
+	if config.IsSetupCompleted() {
+		t.Error("failed setup marked as completed")
+	}
+	if got := s.store.Saved().Libraries; len(got) != len(libraries) || got[0].ID != libraries[0].ID {
+		t.Errorf("libraries after a failed setup = %+v", got)
+	}
+	if cfg, err := config.LoadConfig(config.ConfigFile); err != nil || len(cfg.Libraries) != len(libraries) {
+		t.Errorf("saved config after a failed setup = %+v, %v", cfg, err)
+	}
+
+	// Run again, the existing admin is kept
+	s.call("POST", "/setup", SetupRequest{Username: "owner", Password: "owner-password"}, http.StatusOK)
+	users, err := models.LoadUsers(config.UsersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, user := range users {
+		names = append(names, user.Username)
+	}
+	if !slices.Equal(names, []string{"admin", "owner"}) {
+		t.Errorf("users after setup = %v", names)
+	}
+}
+
+func TestPasswordChangeEndsSessions(t *testing.T) {
+	s := newContractServer(t)
+	s.login("admin", "admin-password")
+	other := s.session
+	s.login("admin", "admin-password")
+
+	// Changing the password keeps this session and ends the other one
+	s.call("PUT", "/me/password", ChangePasswordRequest{CurrentPassword: "admin-password", NewPassword: "new-admin-password"}, http.StatusOK)
+	s.call("GET", "/profiles", nil, http.StatusOK)
+	admin := s.session
+	s.session = other
+	s.call("GET", "/profiles", nil, http.StatusUnauthorized)
+
+	// A reset by an admin ends all sessions of the user
+	s.session = admin
+	s.call("POST", "/admin/users", CreateUserRequest{Username: "viewer", Password: "viewer-password"}, http.StatusCreated)
+	s.login("viewer", "viewer-password")
+	viewer := s.session
+	users, err := models.LoadUsers(config.UsersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.session = admin
+	s.call("POST", "/admin/users/"+models.FindUserByUsername(users, "viewer").ID+"/password", ResetPasswordRequest{Password: "reset-password"}, http.StatusOK)
+	s.session = viewer
+	s.call("GET", "/profiles", nil, http.StatusUnauthorized)
+	s.login("viewer", "reset-password")
+	s.call("GET", "/profiles", nil, http.StatusOK)
+}
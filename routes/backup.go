@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+// HandleBackup downloads a backup archive of the server state (admin only).
+// Restoring is left to the restore command, which must run while the server is stopped.
+func HandleBackup(c *gin.Context) {
+	// Build the archive in a temporary file so a failure can still be reported as an error
+	file, err := os.CreateTemp(config.DataDir, ".backup-*.tar.gz")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Error creating backup")
+		return
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	manifest, err := models.WriteBackup(file)
+	if err != nil {
+		RecordAudit(c, models.AuditBackupCreate, "download", false, map[string]string{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, fmt.Sprintf("Error creating backup: %v", err))
+		return
+	}
+
+	RecordAudit(c, models.AuditBackupCreate, "download", true, map[string]string{"files": fmt.Sprint(len(manifest.Files))})
+
+	filename := "mediastream-backup-" + time.Now().Format("20060102-150405") + ".tar.gz"
+	c.FileAttachment(file.Name(), filename)
+}
@@ -0,0 +1,162 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+// HandleGetConfig returns the configuration as saved in the config file (admin only).
+// Settings overridden by flags or the environment are not included.
+func HandleGetConfig(c *gin.Context, store *config.Store) {
+	c.JSON(http.StatusOK, store.Saved())
+}
+
+// HandleUpdateConfig applies the settings in the request body to the saved configuration
+// (admin only). Missing settings keep their value; the change applies without a restart.
+// The programs the server runs can't be changed here, so an admin login can't run others.
+func HandleUpdateConfig(c *gin.Context, store *config.Store) {
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := store.Update(func(cfg *config.Config) error {
+		previews := cfg.Previews
+		if err := config.MergeJSON(cfg, data); err != nil {
+			return &config.ValidationError{Err: err}
+		}
+		if !cfg.Previews.SameBinaries(previews) {
+			return &config.ValidationError{Err: errors.New("previews.ffmpegPath and previews.ffprobePath can only be changed in the config file, by flags or by the environment")}
+		}
+		return nil
+	}); err != nil {
+		RecordAudit(c, models.AuditConfigChange, "config", false, map[string]string{"error": err.Error()})
+		var invalid *config.ValidationError
+		if errors.As(err, &invalid) {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "Error saving configuration")
+		return
+	}
+
+	RecordAudit(c, models.AuditConfigChange, "config", true, map[string]string{"action": "update"})
+
+	c.JSON(http.StatusOK, store.Saved())
+}
+
+// HandleReloadConfig reads the configuration file again and applies it (admin only)
+func HandleReloadConfig(c *gin.Context, store *config.Store) {
+	if _, err := store.Reload(); err != nil {
+		RecordAudit(c, models.AuditConfigChange, "config", false, map[string]string{"action": "reload", "error": err.Error()})
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Error reloading configuration: %v", err))
+		return
+	}
+
+	RecordAudit(c, models.AuditConfigChange, "config", true, map[string]string{"action": "reload"})
+
+	c.JSON(http.StatusOK, store.Saved())
+}
+
+// ScanResult reports a finished library scan
+type ScanResult struct {
+	LibraryID string `json:"libraryId"`
+	ItemCount int    `json:"itemCount"`
+	Duration  string `json:"duration"`
+}
+
+// HandleScanLibrary rescans one library and waits for the result (admin only)
+func HandleScanLibrary(c *gin.Context, cfg *config.Config, index *models.Index) {
+	library := cfg.FindLibrary(c.Param("id"))
+	if library == nil {
+		respondError(c, http.StatusNotFound, "Library not found")
+		return
+	}
+
+	start := time.Now()
+	items, err := index.Rescan(c.Request.Context(), *library, cfg)
+	if err != nil {
+		RecordAudit(c, models.AuditLibraryScan, library.ID, false, map[string]string{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, fmt.Sprintf("Error scanning library: %v", err))
+		return
+	}
+
+	RecordAudit(c, models.AuditLibraryScan, library.ID, true, map[string]string{"items": strconv.Itoa(len(items))})
+
+	c.JSON(http.StatusOK, ScanResult{
+		LibraryID: library.ID,
+		ItemCount: len(items),
+		Duration:  time.Since(start).String(),
+	})
+}
+
+// HandleScanAll starts a background rescan of every library (admin only)
+func HandleScanAll(c *gin.Context, cfg *config.Config, index *models.Index) {
+	index.RescanInBackground(c.Request.Context(), cfg.Libraries, cfg)
+
+	RecordAudit(c, models.AuditLibraryScan, "all", true, nil)
+
+	c.JSON(http.StatusAccepted, MessageResponse{Message: "Rescan started"})
+}
+
+// DebugScanResult is what a fresh scan of one library root finds
+type DebugScanResult struct {
+	Library   string             `json:"library"`
+	Kind      string             `json:"kind"`
+	Path      string             `json:"path"`
+	Entries   []string           `json:"entries"`
+	ItemCount int                `json:"itemCount"`
+	Items     []models.MediaItem `json:"items"`
+	Error     bool               `json:"error"`
+}
+
+// HandleDebugScan scans every library root without touching the index and returns what it
+// finds, to debug media that doesn't show up (admin only)
+func HandleDebugScan(c *gin.Context, cfg *config.Config) {
+	results := []DebugScanResult{}
+
+	for _, library := range cfg.Libraries {
+		for _, path := range library.Paths {
+			entries, err := os.ReadDir(path)
+
+			entryList := []string{}
+			if err == nil {
+				for _, entry := range entries {
+					entryList = append(entryList, entry.Name())
+				}
+			}
+
+			items, _ := models.ScanDirectory(c.Request.Context(), path, library, cfg)
+
+			results = append(results, DebugScanResult{
+				Library:   library.ID,
+				Kind:      library.Kind,
+				Path:      path,
+				Entries:   entryList,
+				ItemCount: len(items),
+				Items:     items,
+				Error:     err != nil,
+			})
+		}
+	}
+
+	RecordAudit(c, models.AuditLibraryScan, "all", true, nil)
+
+	c.JSON(http.StatusOK, results)
+}
+
+// HandleGetIndexStatus returns when each library was last scanned (admin only)
+func HandleGetIndexStatus(c *gin.Context, index *models.Index) {
+	c.JSON(http.StatusOK, index.Status())
+}
@@ -0,0 +1,157 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"mediastream/config"
+	"mediastream/models"
+	"mediastream/version"
+)
+
+// ReadinessCheck is the result of one check made by the readiness probe
+type ReadinessCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthStatus is the answer of the liveness probe
+type HealthStatus struct {
+	Status string `json:"status"`
+}
+
+// Readiness is the answer of the readiness probe, "ready" or "not ready" with every check made
+type Readiness struct {
+	Status string           `json:"status"`
+	Checks []ReadinessCheck `json:"checks"`
+}
+
+// HandleHealth reports that the process is alive. It needs no login and does no work,
+// so it stays cheap for liveness probes.
+func HandleHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthStatus{Status: "ok"})
+}
+
+// HandleReady reports whether the server can serve requests, with the result of every check.
+// It responds 503 when any check fails so orchestrators hold traffic back. Paths and errors
+// are only shown to admins, since the probe needs no login.
+func HandleReady(c *gin.Context, store *config.Store, index *models.Index) {
+	cfg := store.Get()
+
+	checks := []ReadinessCheck{
+		checkConfig(cfg),
+		checkDataDir(),
+		checkIndex(index),
+	}
+	for _, library := range cfg.Libraries {
+		checks = append(checks, checkLibrary(library))
+	}
+
+	status := http.StatusOK
+	ready := "ready"
+	for _, check := range checks {
+		if !check.OK {
+			status = http.StatusServiceUnavailable
+			ready = "not ready"
+			break
+		}
+	}
+
+	if !isSessionAdmin(c) {
+		for i := range checks {
+			checks[i].Detail, checks[i].Error = "", ""
+		}
+	}
+
+	c.JSON(status, Readiness{Status: ready, Checks: checks})
+}
+
+// HandleVersion returns the build version, commit and Go version
+func HandleVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, version.Get())
+}
+
+// isSessionAdmin reports whether a request to a route without login comes from an admin's session
+func isSessionAdmin(c *gin.Context) bool {
+	userID, ok := sessions.Default(c).Get("userID").(string)
+	if !ok {
+		return false
+	}
+	users, err := models.LoadUsers(config.UsersFile)
+	if err != nil {
+		return false
+	}
+	user := models.FindUserByID(users, userID)
+	return user != nil && user.IsAdmin && !user.Disabled
+}
+
+// checkConfig makes sure a valid configuration is loaded
+func checkConfig(cfg *config.Config) ReadinessCheck {
+	check := ReadinessCheck{Name: "config"}
+	if cfg == nil {
+		check.Error = "No configuration loaded"
+		return check
+	}
+	if err := cfg.Validate(); err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.OK = true
+	check.Detail = config.ConfigFile
+	return check
+}
+
+// checkDataDir makes sure server state can be saved
+func checkDataDir() ReadinessCheck {
+	check := ReadinessCheck{Name: "dataDir", Detail: config.DataDir}
+	file, err := os.CreateTemp(config.DataDir, ".readyz-*")
+	if err != nil {
+		check.Error = fmt.Sprintf("Data directory is not writable: %v", err)
+		return check
+	}
+	file.Close()
+	os.Remove(file.Name())
+	check.OK = true
+	return check
+}
+
+// checkIndex makes sure the library index is open and scans can be saved
+func checkIndex(index *models.Index) ReadinessCheck {
+	check := ReadinessCheck{Name: "index"}
+	if index == nil {
+		check.Error = "Library index is not open"
+		return check
+	}
+	if err := index.SaveError(); err != nil {
+		check.Error = fmt.Sprintf("Library index can't be saved: %v", err)
+		return check
+	}
+	check.OK = true
+	check.Detail = fmt.Sprintf("%d libraries indexed", len(index.Status()))
+	return check
+}
+
+// checkLibrary makes sure every root folder of a library can be read
+func checkLibrary(library config.Library) ReadinessCheck {
+	check := ReadinessCheck{Name: "library:" + library.ID}
+	for _, path := range library.Paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			check.Error = fmt.Sprintf("%s is not reachable: %v", path, err)
+			return check
+		}
+		if !info.IsDir() {
+			check.Error = fmt.Sprintf("%s is not a folder", path)
+			return check
+		}
+	}
+	check.OK = true
+	check.Detail = fmt.Sprintf("%d folders reachable", len(library.Paths))
+	return check
+}
@@ -0,0 +1,64 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"mediastream/models"
+)
+
+func TestCheckIndex(t *testing.T) {
+	if check := checkIndex(nil); check.OK {
+		t.Error("missing index is ready")
+	}
+
+	index := models.NewIndex(filepath.Join(t.TempDir(), "index.json"))
+	if check := checkIndex(index); !check.OK {
+		t.Errorf("new index isn't ready: %+v", check)
+	}
+
+	broken := models.NewIndex(filepath.Join(t.TempDir(), "missing", "index.json"))
+	if broken.Save() == nil {
+		t.Fatal("saving into a missing folder succeeded")
+	}
+	if check := checkIndex(broken); check.OK || check.Error == "" {
+		t.Errorf("index that can't be saved is ready: %+v", check)
+	}
+}
+
+func TestReadyDetailsOnlyForAdmins(t *testing.T) {
+	s := newContractServer(t)
+	s.engine.GET("/readyz", func(c *gin.Context) {
+		HandleReady(c, s.store, s.index)
+	})
+
+	ready := func() Readiness {
+		req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+		if s.session != nil {
+			req.AddCookie(s.session)
+		}
+		rec := httptest.NewRecorder()
+		s.engine.ServeHTTP(rec, req)
+		var readiness Readiness
+		if err := json.Unmarshal(rec.Body.Bytes(), &readiness); err != nil {
+			t.Fatal(err)
+		}
+		return readiness
+	}
+
+	for _, check := range ready().Checks {
+		if check.Detail != "" || check.Error != "" {
+			t.Errorf("anonymous probe shows %+v", check)
+		}
+	}
+
+	s.login("admin", "admin-password")
+	if checks := ready().Checks; len(checks) == 0 || checks[0].Detail == "" {
+		t.Errorf("admin sees no details: %+v", checks)
+	}
+}
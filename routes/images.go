@@ -0,0 +1,85 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+// imageOptions reads the ?width= and ?format= of an image request, answering 400 itself
+func imageOptions(c *gin.Context) (int, string, bool) {
+	width := 0
+	if value := c.Query("width"); value != "" {
+		var err error
+		width, err = strconv.Atoi(value)
+		if err != nil || width <= 0 {
+			respondError(c, http.StatusBadRequest, "width must be a positive number")
+			return 0, "", false
+		}
+	}
+	format := c.Query("format")
+	if _, ok := models.ArtworkFormats[format]; format != "" && !ok {
+		respondError(c, http.StatusBadRequest, "format must be jpeg or png")
+		return 0, "", false
+	}
+	return width, format, true
+}
+
+// serveImage sends a rendered image with its ETag
+func serveImage(c *gin.Context, image *models.ArtworkImage) {
+	// ServeContent answers If-None-Match with 304 Not Modified based on the ETag
+	c.Header("ETag", `"`+image.ETag+`"`)
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Header("Content-Type", image.ContentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.File(image.Path)
+}
+
+// HandleMediaImage serves an image of a media item such as its poster, optionally resized to
+// ?width= and converted to ?format=jpeg or png. Variants are cached and tagged with an ETag.
+// Photos are their own image of every kind.
+func HandleMediaImage(c *gin.Context, cfg *config.Config) {
+	item, err := models.FindMediaByID(c.Request.Context(), c.Param("id"), cfg)
+	if err != nil || !canAccessLibrary(c, item.LibraryID) || !item.AllowedUnder(ratingLimit(c)) {
+		respondError(c, http.StatusNotFound, "Media not found")
+		return
+	}
+	library := cfg.FindLibrary(item.LibraryID)
+
+	width, format, ok := imageOptions(c)
+	if !ok {
+		return
+	}
+	if item.Photo != nil {
+		servePhoto(c, cfg, *library, item, width, format)
+		return
+	}
+
+	artwork, err := models.FindArtwork(*library, item, c.Param("kind"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "Image not found")
+		return
+	}
+
+	image, err := models.RenderArtwork(c.Request.Context(), *library, item, artwork, width, format)
+	if errors.Is(err, models.ErrArtworkNotFound) {
+		respondError(c, http.StatusNotFound, "Image not found")
+		return
+	}
+	if errors.Is(err, models.ErrUnsupportedImage) {
+		respondError(c, http.StatusUnsupportedMediaType, "Unsupported image format")
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(c.Request.Context(), "Error rendering image", "item", item.ID, "kind", artwork.Kind, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to render image")
+		return
+	}
+
+	serveImage(c, image)
+}
@@ -0,0 +1,263 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+// inviteMu serializes invite redemption so a single-use invite can't be redeemed twice
+var inviteMu sync.Mutex
+
+// Default lifetime of an invite when the admin doesn't specify one
+const defaultInviteHours = 72
+
+// InviteResponse is the admin view of an invite, including the shareable link
+type InviteResponse struct {
+	ID        string    `json:"id"`
+	Link      string    `json:"link"`
+	IsAdmin   bool      `json:"isAdmin"`
+	Libraries []string  `json:"libraries"`
+	MaxUses   int       `json:"maxUses"`
+	Uses      int       `json:"uses"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	CreatedBy string    `json:"createdBy"`
+	Created   time.Time `json:"created"`
+}
+
+// inviteResponse converts an invite to its admin view
+func inviteResponse(invite models.Invite) InviteResponse {
+	return InviteResponse{
+		ID:        invite.ID,
+		Link:      URL("/invite/" + invite.Token),
+		IsAdmin:   invite.IsAdmin,
+		Libraries: invite.Libraries,
+		MaxUses:   invite.MaxUses,
+		Uses:      invite.Uses,
+		ExpiresAt: invite.ExpiresAt,
+		CreatedBy: invite.CreatedBy,
+		Created:   invite.Created,
+	}
+}
+
+// HandleGetInvites returns all outstanding invites (admin only)
+func HandleGetInvites(c *gin.Context) {
+	invites, err := models.LoadInvites(config.InvitesFile)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to load invites")
+		return
+	}
+
+	now := time.Now()
+	outstanding := []InviteResponse{}
+	for _, invite := range invites {
+		if invite.IsUsable(now) {
+			outstanding = append(outstanding, inviteResponse(invite))
+		}
+	}
+
+	c.JSON(http.StatusOK, outstanding)
+}
+
+// CreateInviteRequest is the request body for creating an invite
+type CreateInviteRequest struct {
+	IsAdmin        bool     `json:"isAdmin"`
+	Libraries      []string `json:"libraries"` // Missing or null grants every library, [] none
+	MaxUses        int      `json:"maxUses"`
+	ExpiresInHours int      `json:"expiresInHours"`
+}
+
+// HandleCreateInvite creates a new invite link (admin only)
+func HandleCreateInvite(c *gin.Context, cfg *config.Config) {
+	var form CreateInviteRequest
+
+	if err := c.ShouldBindJSON(&form); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if form.MaxUses == 0 {
+		form.MaxUses = 1
+	}
+	if form.ExpiresInHours == 0 {
+		form.ExpiresInHours = defaultInviteHours
+	}
+	if form.MaxUses < 0 || form.ExpiresInHours < 0 {
+		respondError(c, http.StatusBadRequest, "maxUses and expiresInHours must be positive")
+		return
+	}
+
+	if err := validateLibraries(cfg, form.Libraries); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Get current user from context
+	currentUser, exists := models.GetUserFromContext(c)
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	inviteMu.Lock()
+	defer inviteMu.Unlock()
+
+	invites, err := models.LoadInvites(config.InvitesFile)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to load invites")
+		return
+	}
+
+	invite := models.CreateInvite(currentUser.ID, form.IsAdmin, form.Libraries, form.MaxUses,
+		time.Duration(form.ExpiresInHours)*time.Hour)
+	invites = append(invites, *invite)
+
+	if err := models.SaveInvites(invites, config.InvitesFile); err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to save invites")
+		return
+	}
+
+	RecordAudit(c, models.AuditInviteCreate, invite.ID, true, map[string]string{
+		"isAdmin": strconv.FormatBool(invite.IsAdmin),
+		"maxUses": strconv.Itoa(invite.MaxUses),
+	})
+
+	c.JSON(http.StatusCreated, inviteResponse(*invite))
+}
+
+// HandleRevokeInvite deletes an outstanding invite (admin only)
+func HandleRevokeInvite(c *gin.Context) {
+	inviteID := c.Param("id")
+
+	inviteMu.Lock()
+	defer inviteMu.Unlock()
+
+	invites, err := models.LoadInvites(config.InvitesFile)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to load invites")
+		return
+	}
+
+	var updatedInvites []models.Invite
+	found := false
+
+	for _, invite := range invites {
+		if invite.ID == inviteID {
+			found = true
+			continue
+		}
+		updatedInvites = append(updatedInvites, invite)
+	}
+
+	if !found {
+		respondError(c, http.StatusNotFound, "Invite not found")
+		return
+	}
+
+	if err := models.SaveInvites(updatedInvites, config.InvitesFile); err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to save invites")
+		return
+	}
+
+	RecordAudit(c, models.AuditInviteRevoke, inviteID, true, nil)
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Invite revoked successfully"})
+}
+
+// HandleInvitePage serves the sign-up page for a valid invite link
+func HandleInvitePage(c *gin.Context) {
+	invites, err := models.LoadInvites(config.InvitesFile)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to load invites")
+		return
+	}
+
+	invite := models.FindInviteByToken(invites, c.Param("token"))
+	if invite == nil || !invite.IsUsable(time.Now()) {
+		c.String(http.StatusNotFound, "This invite link is invalid or has expired")
+		return
+	}
+
+	ServePage(c, "invite.html")
+}
+
+// AcceptInviteRequest is the request body for creating an account from an invite
+type AcceptInviteRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// HandleAcceptInvite creates an account from an invite and logs the new user in
+func HandleAcceptInvite(c *gin.Context, cfg *config.Config) {
+	var form AcceptInviteRequest
+
+	if err := c.ShouldBindJSON(&form); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := models.ValidateUsername(form.Username); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := cfg.PasswordPolicy.Validate(form.Password); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	inviteMu.Lock()
+	defer inviteMu.Unlock()
+
+	invites, err := models.LoadInvites(config.InvitesFile)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to load invites")
+		return
+	}
+
+	invite := models.FindInviteByToken(invites, c.Param("token"))
+	if invite == nil || !invite.IsUsable(time.Now()) {
+		respondError(c, http.StatusNotFound, "This invite link is invalid or has expired")
+		return
+	}
+
+	user, err := models.CreateUser(nil, form.Username, form.Password, invite.IsAdmin)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	user.Libraries = invite.Libraries
+
+	err = models.UpdateUsers(config.UsersFile, func(users []models.User) ([]models.User, error) {
+		if models.FindUserByUsername(users, form.Username) != nil {
+			return nil, &userError{http.StatusBadRequest, "Username already exists"}
+		}
+		return append(users, *user), nil
+	})
+	if err != nil {
+		respondUsersError(c, err)
+		return
+	}
+
+	invite.Uses++
+	if err := models.SaveInvites(invites, config.InvitesFile); err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to save invites")
+		return
+	}
+
+	// Log the new user in
+	startSession(c, user)
+
+	RecordAudit(c, models.AuditInviteAccept, user.Username, true, map[string]string{
+		"invite": invite.ID,
+		"id":     user.ID,
+	})
+
+	c.JSON(http.StatusCreated, SuccessResponse{Success: true})
+}
@@ -0,0 +1,280 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+// LibraryRequest is the request body for creating or updating a library
+type LibraryRequest struct {
+	ID      string                 `json:"id"`
+	Name    string                 `json:"name"`
+	Kind    string                 `json:"kind"`
+	Paths   []string               `json:"paths"`
+	Options *config.ScannerOptions `json:"options"`
+}
+
+// errLibraryNotFound aborts a configuration update for an unknown library
+var errLibraryNotFound = errors.New("Library not found")
+
+// respondLibraryError answers a failed change to the libraries: 404 for an unknown library, 400
+// for an invalid configuration and 500 for a failure to save it
+func respondLibraryError(c *gin.Context, err error) {
+	var invalid *config.ValidationError
+	switch {
+	case errors.Is(err, errLibraryNotFound):
+		respondError(c, http.StatusNotFound, "Library not found")
+	case errors.As(err, &invalid):
+		respondError(c, http.StatusBadRequest, err.Error())
+	default:
+		respondError(c, http.StatusInternalServerError, "Error saving configuration")
+	}
+}
+
+// withoutLibrary removes a library from an access list. A list left empty still grants none.
+func withoutLibrary(libraries []string, libraryID string) []string {
+	return slices.DeleteFunc(libraries, func(id string) bool { return id == libraryID })
+}
+
+// checkLibraryPaths verifies that every root of a library is an existing directory
+func checkLibraryPaths(paths []string) error {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() {
+			return fmt.Errorf("Library path is not a directory: %s", path)
+		}
+	}
+	return nil
+}
+
+// HandleGetLibrariesAdmin returns the full definition of every library (admin only)
+func HandleGetLibrariesAdmin(c *gin.Context, store *config.Store) {
+	c.JSON(http.StatusOK, store.Get().Libraries)
+}
+
+// HandleCreateLibrary adds a library (admin only)
+func HandleCreateLibrary(c *gin.Context, store *config.Store) {
+	var form LibraryRequest
+	if err := c.ShouldBindJSON(&form); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := checkLibraryPaths(form.Paths); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var library config.Library
+	_, err := store.Update(func(cfg *config.Config) error {
+		library = config.Library{
+			ID:    form.ID,
+			Name:  strings.TrimSpace(form.Name),
+			Kind:  form.Kind,
+			Paths: form.Paths,
+		}
+		if library.ID == "" {
+			library.ID = cfg.NewLibraryID(library.Name)
+		}
+		if form.Options != nil {
+			library.Options = *form.Options
+		}
+		cfg.Libraries = append(cfg.Libraries, library)
+		return nil
+	})
+	if err != nil {
+		respondLibraryError(c, err)
+		return
+	}
+
+	RecordAudit(c, models.AuditConfigChange, "library:"+library.ID, true, map[string]string{
+		"action": "create",
+		"kind":   library.Kind,
+		"paths":  strings.Join(library.Paths, ","),
+	})
+
+	c.JSON(http.StatusCreated, library)
+}
+
+// HandleUpdateLibrary changes a library's name, kind, paths or scanner options (admin only)
+func HandleUpdateLibrary(c *gin.Context, store *config.Store) {
+	libraryID := c.Param("id")
+
+	var form LibraryRequest
+	if err := c.ShouldBindJSON(&form); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if form.ID != "" && form.ID != libraryID {
+		respondError(c, http.StatusBadRequest, "Library IDs can't be changed")
+		return
+	}
+
+	if form.Paths != nil {
+		if err := checkLibraryPaths(form.Paths); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	var library config.Library
+	_, err := store.Update(func(cfg *config.Config) error {
+		existing := cfg.FindLibrary(libraryID)
+		if existing == nil {
+			return errLibraryNotFound
+		}
+
+		if form.Name != "" {
+			existing.Name = strings.TrimSpace(form.Name)
+		}
+		if form.Kind != "" {
+			existing.Kind = form.Kind
+		}
+		if form.Paths != nil {
+			existing.Paths = form.Paths
+		}
+		if form.Options != nil {
+			existing.Options = *form.Options
+		}
+		library = *existing
+		return nil
+	})
+	if err != nil {
+		respondLibraryError(c, err)
+		return
+	}
+
+	RecordAudit(c, models.AuditConfigChange, "library:"+library.ID, true, map[string]string{
+		"action": "update",
+		"kind":   library.Kind,
+		"paths":  strings.Join(library.Paths, ","),
+	})
+
+	c.JSON(http.StatusOK, library)
+}
+
+// HandleDeleteLibrary removes a library from the configuration and from the access lists of users
+// and invites; media files are left untouched (admin only)
+func HandleDeleteLibrary(c *gin.Context, store *config.Store) {
+	libraryID := c.Param("id")
+
+	inviteMu.Lock()
+	defer inviteMu.Unlock()
+
+	invites, err := models.LoadInvites(config.InvitesFile)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to load invites")
+		return
+	}
+
+	// The library is removed under the users lock, and only then stripped from their access lists
+	err = models.UpdateUsers(config.UsersFile, func(users []models.User) ([]models.User, error) {
+		_, err := store.Update(func(cfg *config.Config) error {
+			var updatedLibraries []config.Library
+			found := false
+			for _, library := range cfg.Libraries {
+				if library.ID == libraryID {
+					found = true
+					continue
+				}
+				updatedLibraries = append(updatedLibraries, library)
+			}
+			if !found {
+				return errLibraryNotFound
+			}
+			cfg.Libraries = updatedLibraries
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range users {
+			users[i].Libraries = withoutLibrary(users[i].Libraries, libraryID)
+		}
+		return users, nil
+	})
+	if err != nil {
+		respondLibraryError(c, err)
+		return
+	}
+
+	for i := range invites {
+		invites[i].Libraries = withoutLibrary(invites[i].Libraries, libraryID)
+	}
+	if err := models.SaveInvites(invites, config.InvitesFile); err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to save invites")
+		return
+	}
+
+	RecordAudit(c, models.AuditConfigChange, "library:"+libraryID, true, map[string]string{"action": "delete"})
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Library deleted successfully"})
+}
+
+// LibraryRoot is a root folder of a library with the key stream URLs use for it
+type LibraryRoot struct {
+	Key  string `json:"key"`
+	Path string `json:"path"`
+}
+
+// DuplicatesResponse lists the roots of a library and the groups of items found in several of them
+type DuplicatesResponse struct {
+	Roots      []LibraryRoot        `json:"roots"`
+	Duplicates [][]models.MediaItem `json:"duplicates"`
+}
+
+// HandleGetDuplicates lists media items found in more than one root of a library (admin only)
+func HandleGetDuplicates(c *gin.Context, cfg *config.Config, index *models.Index) {
+	library := cfg.FindLibrary(c.Param("id"))
+	if library == nil {
+		respondError(c, http.StatusNotFound, "Library not found")
+		return
+	}
+
+	items, err := index.Items(c.Request.Context(), *library, cfg)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, fmt.Sprintf("Error scanning library: %v", err))
+		return
+	}
+
+	roots := make([]LibraryRoot, len(library.Paths))
+	for i, path := range library.Paths {
+		roots[i] = LibraryRoot{Key: config.RootKey(path), Path: path}
+	}
+
+	// Group every item with its copies, listing each group once
+	byID := map[string]models.MediaItem{}
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+
+	seen := map[string]bool{}
+	groups := [][]models.MediaItem{}
+	for _, item := range items {
+		if len(item.Duplicates) == 0 || seen[item.ID] {
+			continue
+		}
+		group := []models.MediaItem{item}
+		seen[item.ID] = true
+		for _, id := range item.Duplicates {
+			if !seen[id] {
+				group = append(group, byID[id])
+				seen[id] = true
+			}
+		}
+		groups = append(groups, group)
+	}
+
+	c.JSON(http.StatusOK, DuplicatesResponse{Roots: roots, Duplicates: groups})
+}
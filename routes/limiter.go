@@ -0,0 +1,133 @@
+package routes
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// attemptLimiter locks out keys, such as client IPs or accounts, after too many failed attempts
+type attemptLimiter struct {
+	max     int           // Failures allowed within the window
+	window  time.Duration // How long failures are remembered, and how long a lockout lasts
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]*attempts
+}
+
+// attempts are the recent failures of a key
+type attempts struct {
+	failures    int
+	first       time.Time // Of the failures counted
+	lockedUntil time.Time
+}
+
+// newAttemptLimiter allows max failures per key within window, then locks the key out for window
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{max: max, window: window, now: time.Now, entries: map[string]*attempts{}}
+}
+
+// Limits of password logins and of profile PINs, which have far fewer combinations. Accounts
+// are only slowed down, as anyone knowing a username could otherwise lock its owner out.
+var (
+	loginLimiter   = newAttemptLimiter(10, 15*time.Minute) // By client
+	accountLimiter = newAttemptLimiter(10, 15*time.Minute) // By account, for delay
+	pinLimiter     = newAttemptLimiter(5, 15*time.Minute)
+)
+
+// maxAttemptDelay is the longest an attempt is held up by delay
+const maxAttemptDelay = 16 * time.Second
+
+// sleep waits for d unless the request is cancelled first, and reports whether it waited
+var sleep = func(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// retryAfter returns how long the first locked out key stays locked, 0 if none is
+func (l *attemptLimiter) retryAfter(keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var wait time.Duration
+	for _, key := range keys {
+		if entry := l.entries[key]; entry != nil && now.Before(entry.lockedUntil) {
+			wait = max(wait, entry.lockedUntil.Sub(now))
+		}
+	}
+	return wait
+}
+
+// delay returns how long to hold up an attempt of a key with too many failures: a second
+// once it has max, doubling with every further one up to maxAttemptDelay
+func (l *attemptLimiter) delay(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := l.entries[key]
+	if entry == nil || entry.failures < l.max || l.now().Sub(entry.first) > l.window {
+		return 0
+	}
+	return min(time.Second<<min(entry.failures-l.max, 8), maxAttemptDelay)
+}
+
+// fail records a failed attempt for every key, locking out those with too many
+func (l *attemptLimiter) fail(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for _, key := range keys {
+		entry := l.entries[key]
+		if entry == nil || now.Sub(entry.first) > l.window {
+			entry = &attempts{first: now}
+			l.entries[key] = entry
+		}
+		entry.failures++
+		if entry.failures >= l.max {
+			entry.lockedUntil = now.Add(l.window)
+		}
+	}
+
+	// Forget keys that neither count failures nor are locked out anymore
+	if len(l.entries) > 10000 {
+		for key, entry := range l.entries {
+			if now.Sub(entry.first) > l.window && now.After(entry.lockedUntil) {
+				delete(l.entries, key)
+			}
+		}
+	}
+}
+
+// succeed forgets the failures of a key
+func (l *attemptLimiter) succeed(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// setRetryAfter tells the client when it may try again
+func setRetryAfter(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// respondLockedOut answers a request of a client that is locked out
+func respondLockedOut(c *gin.Context, wait time.Duration) {
+	setRetryAfter(c, wait)
+	respondError(c, http.StatusTooManyRequests, "Too many failed attempts, try again later")
+}
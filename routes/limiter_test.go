@@ -0,0 +1,168 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+func TestAttemptLimiter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newAttemptLimiter(3, time.Minute)
+	l.now = func() time.Time { return now }
+
+	l.fail("a", "b")
+	l.fail("a")
+	if wait := l.retryAfter("a", "b"); wait != 0 {
+		t.Fatalf("locked out after 2 failures: %v", wait)
+	}
+	l.fail("a")
+	if wait := l.retryAfter("a"); wait != time.Minute {
+		t.Errorf("after 3 failures retryAfter = %v", wait)
+	}
+	if wait := l.retryAfter("b"); wait != 0 {
+		t.Errorf("other key locked out: %v", wait)
+	}
+	if wait := l.retryAfter("b", "a"); wait != time.Minute {
+		t.Errorf("any locked key should lock out: %v", wait)
+	}
+
+	now = now.Add(time.Minute + time.Second)
+	if wait := l.retryAfter("a"); wait != 0 {
+		t.Errorf("still locked out after the window: %v", wait)
+	}
+
+	// Old failures are forgotten, and success forgets them all
+	l.fail("b")
+	l.fail("b")
+	if wait := l.retryAfter("b"); wait != 0 {
+		t.Errorf("failures from before the window counted: %v", wait)
+	}
+	l.succeed("b")
+	l.fail("b")
+	l.fail("b")
+	if wait := l.retryAfter("b"); wait != 0 {
+		t.Errorf("failures from before a success counted: %v", wait)
+	}
+}
+
+func TestAttemptDelay(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newAttemptLimiter(2, time.Minute)
+	l.now = func() time.Time { return now }
+
+	var delays []time.Duration
+	for range 8 {
+		delays = append(delays, l.delay("a"))
+		l.fail("a")
+	}
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 16 * time.Second}
+	if !slices.Equal(delays, want) {
+		t.Errorf("delays = %v, want %v", delays, want)
+	}
+
+	now = now.Add(time.Minute + time.Second)
+	if d := l.delay("a"); d != 0 {
+		t.Errorf("delay after the window = %v", d)
+	}
+}
+
+// freshLimiters gives a test its own login and PIN limiters, and records login delays instead of waiting
+func freshLimiters(t *testing.T) *[]time.Duration {
+	login, account, pin, wait := loginLimiter, accountLimiter, pinLimiter, sleep
+	loginLimiter = newAttemptLimiter(login.max, login.window)
+	accountLimiter = newAttemptLimiter(account.max, account.window)
+	pinLimiter = newAttemptLimiter(pin.max, pin.window)
+	var delays []time.Duration
+	sleep = func(ctx context.Context, d time.Duration) bool {
+		if d > 0 {
+			delays = append(delays, d)
+		}
+		return true
+	}
+	t.Cleanup(func() { loginLimiter, accountLimiter, pinLimiter, sleep = login, account, pin, wait })
+	return &delays
+}
+
+func TestSwitchProfileLockout(t *testing.T) {
+	freshLimiters(t)
+	s := newContractServer(t)
+	s.login("admin", "admin-password")
+	profile := field(t, s.call("POST", "/profiles", CreateProfileRequest{Name: "Kids", PIN: "1234"}, http.StatusCreated), "id")
+
+	for range pinLimiter.max {
+		s.call("POST", "/profiles/"+profile+"/switch", SwitchProfileRequest{PIN: "0000"}, http.StatusForbidden)
+	}
+	// Even the right PIN is refused until the lockout ends
+	s.call("POST", "/profiles/"+profile+"/switch", SwitchProfileRequest{PIN: "1234"}, http.StatusTooManyRequests)
+
+	failed := false
+	events, err := models.LoadAuditEvents(config.AuditFile, models.AuditFilter{Type: models.AuditProfileSwitch, Success: &failed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != pinLimiter.max+1 || events[0].Target != profile {
+		t.Errorf("audited failed switches: %+v", events)
+	}
+}
+
+// postLogin logs in from a client address
+func postLogin(s *contractServer, client, password string) *httptest.ResponseRecorder {
+	form := url.Values{"username": {"admin"}, "password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = client + ":40000"
+	rec := httptest.NewRecorder()
+	s.engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLoginLockout(t *testing.T) {
+	freshLimiters(t)
+	s := newContractServer(t)
+	post := func(password string) *httptest.ResponseRecorder { return postLogin(s, "192.0.2.1", password) }
+
+	for range loginLimiter.max {
+		if location := post("wrong").Header().Get("Location"); location != "/login?error=1" {
+			t.Fatalf("wrong password redirected to %q", location)
+		}
+	}
+	rec := post("admin-password")
+	if location := rec.Header().Get("Location"); location != "/login?error=locked" || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("locked out login redirected to %q with Retry-After %q", location, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestLoginAccountSlowdown(t *testing.T) {
+	delays := freshLimiters(t)
+	s := newContractServer(t)
+
+	// Failures from many clients, each with a new X-Forwarded-For, which isn't trusted
+	for i := range accountLimiter.max + 2 {
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(url.Values{"username": {"admin"}, "password": {"wrong"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		req.RemoteAddr = fmt.Sprintf("192.0.2.%d:40000", i)
+		s.engine.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// The owner still gets in from another client, only slower
+	if location := postLogin(s, "203.0.113.1", "admin-password").Header().Get("Location"); location != "/" {
+		t.Errorf("login of a guessed account redirected to %q", location)
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}; !slices.Equal(*delays, want) {
+		t.Errorf("delays = %v, want %v", *delays, want)
+	}
+	if d := accountLimiter.delay("user:admin"); d != 0 {
+		t.Errorf("delay after a successful login = %v", d)
+	}
+}
@@ -5,279 +5,363 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"unicode"
 
 	"github.com/gin-gonic/gin"
 
 	"mediastream/config"
+	"mediastream/metrics"
 	"mediastream/models"
 	"mediastream/utils"
 )
 
-// capitalizeFirst capitalizes the first letter of a string
-func capitalizeFirst(s string) string {
-	if s == "" {
-		return ""
+// activeStreams counts the streams currently being served
+var activeStreams atomic.Int64
+
+// ActiveStreams returns the number of streams currently being served
+func ActiveStreams() int64 {
+	return activeStreams.Load()
+}
+
+// ratingLimit returns the content rating limit of the active profile, the zero limit if there is none
+func ratingLimit(c *gin.Context) models.RatingLimit {
+	if profile, ok := models.GetProfileFromContext(c); ok {
+		return profile.RatingLimit()
+	}
+	return models.RatingLimit{}
+}
+
+// allowedItems drops items above the active profile's rating limit
+func allowedItems(c *gin.Context, items []models.MediaItem) []models.MediaItem {
+	limit := ratingLimit(c)
+	if limit.MaxRating == "" {
+		return items
 	}
-	r := []rune(s)
-	r[0] = unicode.ToUpper(r[0])
-	return string(r)
+
+	allowed := []models.MediaItem{}
+	for _, item := range items {
+		if item.AllowedUnder(limit) {
+			allowed = append(allowed, item)
+		}
+	}
+	return allowed
+}
+
+// canAccessLibrary checks the logged-in user's library restrictions
+func canAccessLibrary(c *gin.Context, libraryID string) bool {
+	user, exists := models.GetUserFromContext(c)
+	return exists && user.CanAccessLibrary(libraryID)
+}
+
+// findVisibleMedia looks up a media item the user may access and the active profile may see
+func findVisibleMedia(c *gin.Context, cfg *config.Config, id string) (*models.MediaItem, bool) {
+	item, err := models.FindMediaByID(c.Request.Context(), id, cfg)
+	if err != nil || !canAccessLibrary(c, item.LibraryID) || !item.AllowedUnder(ratingLimit(c)) {
+		return nil, false
+	}
+	return item, true
+}
+
+// LibrarySummary is the view of a library users get, without its scanner options
+type LibrarySummary struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Kind  string   `json:"kind"`
+	Paths []string `json:"paths"`
 }
 
 // HandleGetLibraries returns all media libraries
 func HandleGetLibraries(c *gin.Context, cfg *config.Config) {
-	libraries := make([]gin.H, len(cfg.MediaFolders))
-
-	for i, folder := range cfg.MediaFolders {
-		libraries[i] = gin.H{
-			"id":   folder.Type,
-			"name": capitalizeFirst(folder.Type),
-			"path": folder.Path,
-			"type": folder.Type,
+	libraries := []LibrarySummary{}
+
+	for _, library := range cfg.Libraries {
+		if !canAccessLibrary(c, library.ID) {
+			continue
 		}
+		libraries = append(libraries, LibrarySummary{
+			ID:    library.ID,
+			Name:  library.Name,
+			Kind:  library.Kind,
+			Paths: library.Paths,
+		})
 	}
 
 	c.JSON(http.StatusOK, libraries)
 }
 
-// HandleGetLibrary returns media items for a specific library
-func HandleGetLibrary(c *gin.Context, cfg *config.Config) {
-	libraryType := c.Param("type")
+// HandleGetLibrary returns a page of the media items of a library, filtered and sorted as requested
+func HandleGetLibrary(c *gin.Context, cfg *config.Config, index *models.Index) {
+	libraryID := c.Param("id")
 
-	var libraryFolder string
-	for _, folder := range cfg.MediaFolders {
-		if folder.Type == libraryType {
-			libraryFolder = folder.Path
-			break
-		}
+	library := cfg.FindLibrary(libraryID)
+	if library == nil || !canAccessLibrary(c, libraryID) {
+		respondError(c, http.StatusNotFound, "Library not found")
+		return
 	}
 
-	if libraryFolder == "" {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Library not found"})
+	offset, limit, ok := pageParams(c)
+	if !ok {
 		return
 	}
-
-	// First check if directory exists
-	if _, err := os.Stat(libraryFolder); os.IsNotExist(err) {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Library directory does not exist: %s", libraryFolder),
-		})
+	sortBy, descending, ok := sortParams(c)
+	if !ok {
+		return
+	}
+	filter, ok := itemFilter(c)
+	if !ok {
 		return
 	}
 
-	items, err := models.ScanDirectory(libraryFolder, libraryType, cfg)
+	items, err := index.Items(c.Request.Context(), *library, cfg)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Error scanning directory: %v", err),
-		})
+		respondError(c, http.StatusInternalServerError, fmt.Sprintf("Error scanning library: %v", err))
 		return
 	}
 
-	// Even if no error, check if items array is empty
-	if len(items) == 0 {
-		// Return empty array instead of null to avoid client-side errors
-		c.JSON(http.StatusOK, []models.MediaItem{})
-	} else {
-		c.JSON(http.StatusOK, items)
+	// Older clients of the unversioned API that neither page nor sort get every item as an array
+	if legacyList(c, "offset", "limit", "sort", "order") {
+		listed := []models.MediaItem{}
+		for i := range items {
+			if filter.Matches(&items[i]) {
+				listed = append(listed, items[i])
+			}
+		}
+		c.JSON(http.StatusOK, listed)
+		return
 	}
+
+	c.JSON(http.StatusOK, models.ListItems(items, models.ListQuery{
+		Filter:     filter,
+		Sort:       sortBy,
+		Descending: descending,
+		Offset:     offset,
+		Limit:      limit,
+	}))
 }
 
 // HandleGetMediaItem returns details for a specific media item
 func HandleGetMediaItem(c *gin.Context, cfg *config.Config) {
 	mediaID := c.Param("id")
 
-	mediaItem, err := models.FindMediaByID(mediaID, cfg)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+	mediaItem, err := models.FindMediaByID(c.Request.Context(), mediaID, cfg)
+	if err != nil || !canAccessLibrary(c, mediaItem.LibraryID) || !mediaItem.AllowedUnder(ratingLimit(c)) {
+		respondError(c, http.StatusNotFound, "Media not found")
 		return
 	}
 
 	c.JSON(http.StatusOK, mediaItem)
 }
 
-// HandleSearch searches for media items
-func HandleSearch(c *gin.Context, cfg *config.Config) {
-	query := c.Query("q")
-	query = strings.ToLower(query)
+// Page sizes of listings
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
 
-	if query == "" || len(query) < 2 {
-		c.JSON(http.StatusOK, []gin.H{})
-		return
+// pageParams reads the ?offset= and ?limit= of a listing, answering 400 itself
+func pageParams(c *gin.Context) (offset, limit int, ok bool) {
+	offset, limit = 0, defaultPageSize
+	var err error
+	if value := c.Query("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			respondError(c, http.StatusBadRequest, "offset must be a number of at least 0")
+			return 0, 0, false
+		}
 	}
-
-	results := []models.MediaItem{}
-
-	// Search in each media library
-	for _, folder := range cfg.MediaFolders {
-		items, err := models.ScanDirectory(folder.Path, folder.Type, cfg)
-		if err != nil {
-			fmt.Printf("Error searching in %s library: %v\n", folder.Type, err)
-			continue
+	if value := c.Query("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxPageSize {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("limit must be a number from 1 to %d", maxPageSize))
+			return 0, 0, false
 		}
+	}
+	return offset, limit, true
+}
 
-		// Filter items based on search query
-		for _, item := range items {
-			// Search in title
-			if strings.Contains(strings.ToLower(item.Title), query) {
-				results = append(results, item)
-				continue
-			}
-
-			// If it's a movie, also search in folder name
-			if item.Folder != "" && strings.Contains(strings.ToLower(item.Folder), query) {
-				results = append(results, item)
+// queryList reads a parameter given several times or as a comma-separated list
+func queryList(c *gin.Context, name string) []string {
+	var values []string
+	for _, param := range c.QueryArray(name) {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
 			}
 		}
 	}
-
-	// Sort results (basic sorting - exact matches first, then startsWith)
-	// For more complex sorting, implement a custom sort function
-
-	c.JSON(http.StatusOK, results)
+	return values
 }
 
-// HandleStreamMedia streams a media file
-func HandleStreamMedia(c *gin.Context, cfg *config.Config) {
-	mediaType := c.Param("type")
-	filename := c.Param("filename")
-
-	var libraryFolder string
-	for _, folder := range cfg.MediaFolders {
-		if folder.Type == mediaType {
-			libraryFolder = folder.Path
-			break
-		}
+// watchHistory returns the active profile's watch history by media ID
+func watchHistory(c *gin.Context) (map[string]models.WatchEntry, error) {
+	data, err := activeProfileData(c)
+	if err != nil {
+		return nil, err
 	}
-
-	if libraryFolder == "" {
-		c.String(http.StatusNotFound, "Library not found")
-		return
+	history := map[string]models.WatchEntry{}
+	for _, entry := range data.History {
+		history[entry.MediaID] = entry
 	}
+	return history, nil
+}
 
-	filePath := filepath.Join(libraryFolder, filename)
-
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		c.String(http.StatusNotFound, "File not found")
-		return
+// itemFilter reads the filter parameters of a listing, answering 400 itself
+func itemFilter(c *gin.Context) (models.ItemFilter, bool) {
+	filter := models.ItemFilter{
+		Libraries:   queryList(c, "library"),
+		Types:       queryList(c, "type"),
+		Genres:      queryList(c, "genre"),
+		Resolutions: queryList(c, "resolution"),
+		Letter:      c.Query("letter"),
+		Rating:      ratingLimit(c),
 	}
 
-	// Set headers to discourage downloading
-	c.Header("Content-Disposition", "inline")
-	c.Header("X-Content-Type-Options", "nosniff")
-
-	// Get file info
-	fileInfo, err := os.Stat(filePath)
-	if err != nil {
-		c.String(http.StatusInternalServerError, "Error reading file")
-		return
+	for name, year := range map[string]*int{"yearFrom": &filter.YearFrom, "yearTo": &filter.YearTo} {
+		if value := c.Query(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				respondError(c, http.StatusBadRequest, name+" must be a year")
+				return filter, false
+			}
+			*year = n
+		}
 	}
 
-	fileSize := fileInfo.Size()
-	contentType := utils.GetContentType(filePath)
-
-	// Handle range requests for video streaming
-	rangeHeader := c.GetHeader("Range")
-	if rangeHeader != "" {
-		// Parse range header
-		parts := strings.Split(strings.Replace(rangeHeader, "bytes=", "", 1), "-")
-		start, err := strconv.ParseInt(parts[0], 10, 64)
-		if err != nil {
-			c.String(http.StatusBadRequest, "Invalid range header")
-			return
-		}
+	if letter := []rune(filter.Letter); len(letter) > 1 || len(letter) == 1 && letter[0] != '#' && !unicode.IsLetter(letter[0]) {
+		respondError(c, http.StatusBadRequest, "letter must be a single letter or #")
+		return filter, false
+	}
 
-		var end int64
-		if parts[1] == "" {
-			end = fileSize - 1
-		} else {
-			end, err = strconv.ParseInt(parts[1], 10, 64)
-			if err != nil {
-				end = fileSize - 1
-			}
+	// unwatched=true is short for watched=false
+	if c.Query("watched") != "" && c.Query("unwatched") != "" {
+		respondError(c, http.StatusBadRequest, "watched and unwatched can't be combined")
+		return filter, false
+	}
+	for _, name := range []string{"watched", "unwatched"} {
+		value := c.Query(name)
+		if value == "" {
+			continue
 		}
-
-		// Prevent overflows
-		if end >= fileSize {
-			end = fileSize - 1
+		watched, err := strconv.ParseBool(value)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, name+" must be true or false")
+			return filter, false
 		}
-		if start > end {
-			c.String(http.StatusRequestedRangeNotSatisfiable, "Invalid range")
-			return
+		if name == "unwatched" {
+			watched = !watched
 		}
+		filter.Watched = &watched
+	}
 
-		// Set headers for partial content
-		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize))
-		c.Header("Accept-Ranges", "bytes")
-		c.Header("Content-Length", strconv.FormatInt(end-start+1, 10))
-		c.Header("Content-Type", contentType)
-		c.Status(http.StatusPartialContent)
-
-		// Open the file
-		file, err := os.Open(filePath)
-		if err != nil {
-			c.String(http.StatusInternalServerError, "Error opening file")
-			return
-		}
-		defer file.Close()
+	history, err := watchHistory(c)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to load watch history")
+		return filter, false
+	}
+	filter.History = history
+	return filter, true
+}
 
-		// Seek to start position
-		file.Seek(start, io.SeekStart)
+// sortParams reads the ?sort= and ?order= of a listing, answering 400 itself
+func sortParams(c *gin.Context) (sortBy string, descending bool, ok bool) {
+	sortBy = c.DefaultQuery("sort", models.SortByTitle)
+	descending, known := models.SortDescending[sortBy]
+	if !known {
+		respondError(c, http.StatusBadRequest, "sort must be title, added, year, size, duration or lastPlayed")
+		return "", false, false
+	}
 
-		// Stream the file
-		_, err = io.CopyN(c.Writer, file, end-start+1)
-		if err != nil {
-			fmt.Printf("Error streaming file: %v\n", err)
-		}
-	} else {
-		// No range header, serve the entire file
-		c.Header("Content-Length", strconv.FormatInt(fileSize, 10))
-		c.Header("Content-Type", contentType)
-		c.File(filePath)
+	switch c.Query("order") {
+	case "":
+	case "asc":
+		descending = false
+	case "desc":
+		descending = true
+	default:
+		respondError(c, http.StatusBadRequest, "order must be asc or desc")
+		return "", false, false
 	}
+	return sortBy, descending, true
 }
 
-// HandleStreamMediaWithFolder streams a media file from a subfolder
-func HandleStreamMediaWithFolder(c *gin.Context, cfg *config.Config) {
-	mediaType := c.Param("type")
-	folder := c.Param("folder")
-	filename := c.Param("filename")
-
-	var libraryFolder string
-	for _, folder := range cfg.MediaFolders {
-		if folder.Type == mediaType {
-			libraryFolder = folder.Path
-			break
+// HandleSearch searches the titles, original titles, cast, artists, albums and file names of
+// the libraries the user may access. Results are ranked by relevance and can be filtered;
+// facet counts tell how many results each filter value would give. Older clients of the
+// unversioned API that don't page get every result as a bare array.
+func HandleSearch(c *gin.Context, cfg *config.Config, index *models.Index) {
+	offset, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+	legacy := legacyList(c, "offset", "limit")
+	if legacy {
+		offset, limit = 0, 0
+	}
+	filter, ok := itemFilter(c)
+	if !ok {
+		return
+	}
+
+	var libraries []config.Library
+	for _, library := range cfg.Libraries {
+		if canAccessLibrary(c, library.ID) {
+			libraries = append(libraries, library)
 		}
 	}
 
-	if libraryFolder == "" {
-		c.String(http.StatusNotFound, "Library not found")
+	results := index.Search(c.Request.Context(), libraries, cfg, models.SearchQuery{
+		Text:   c.Query("q"),
+		Filter: filter,
+		Offset: offset,
+		Limit:  limit,
+	})
+	if legacy {
+		c.JSON(http.StatusOK, results.Results)
 		return
 	}
+	c.JSON(http.StatusOK, results)
+}
 
-	filePath := filepath.Join(libraryFolder, folder, filename)
+// HandleStreamMedia streams a media file by its path within one of a library's root folders
+func HandleStreamMedia(c *gin.Context, cfg *config.Config) {
+	libraryID := c.Param("library")
+	rootKey := c.Param("root")
+	relativePath := c.Param("path")
 
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		c.String(http.StatusNotFound, "File not found")
+	library := cfg.FindLibrary(libraryID)
+	if library == nil || !canAccessLibrary(c, libraryID) {
+		respondError(c, http.StatusNotFound, "Library not found")
 		return
 	}
 
-	// Set headers to discourage downloading
-	c.Header("Content-Disposition", "inline")
-	c.Header("X-Content-Type-Options", "nosniff")
-
-	// Get file info
-	fileInfo, err := os.Stat(filePath)
+	filePath, _, fileInfo, err := models.ResolveLibraryFile(*library, rootKey, relativePath)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Error reading file")
+		respondError(c, http.StatusNotFound, "File not found")
 		return
 	}
 
+	// Profiles with a rating limit can't stream what they aren't shown
+	if limit := ratingLimit(c); limit.MaxRating != "" {
+		item, err := models.FindLibraryItem(c.Request.Context(), *library, cfg, rootKey, relativePath)
+		if err != nil || !item.AllowedUnder(limit) {
+			respondError(c, http.StatusNotFound, "File not found")
+			return
+		}
+	}
+
+	activeStreams.Add(1)
+	defer activeStreams.Add(-1)
+	defer func() {
+		if size := c.Writer.Size(); size > 0 {
+			metrics.StreamBytes.Add(float64(size), libraryID)
+		}
+	}()
+
+	// Set headers to discourage downloading
+	c.Header("Content-Disposition", "inline")
+	c.Header("X-Content-Type-Options", "nosniff")
+
 	fileSize := fileInfo.Size()
 	contentType := utils.GetContentType(filePath)
 
@@ -286,9 +370,13 @@ func HandleStreamMediaWithFolder(c *gin.Context, cfg *config.Config) {
 	if rangeHeader != "" {
 		// Parse range header
 		parts := strings.Split(strings.Replace(rangeHeader, "bytes=", "", 1), "-")
+		if len(parts) != 2 {
+			respondError(c, http.StatusBadRequest, "Invalid range header")
+			return
+		}
 		start, err := strconv.ParseInt(parts[0], 10, 64)
 		if err != nil {
-			c.String(http.StatusBadRequest, "Invalid range header")
+			respondError(c, http.StatusBadRequest, "Invalid range header")
 			return
 		}
 
@@ -307,7 +395,7 @@ func HandleStreamMediaWithFolder(c *gin.Context, cfg *config.Config) {
 			end = fileSize - 1
 		}
 		if start > end {
-			c.String(http.StatusRequestedRangeNotSatisfiable, "Invalid range")
+			respondError(c, http.StatusRequestedRangeNotSatisfiable, "Invalid range")
 			return
 		}
 
@@ -321,7 +409,7 @@ func HandleStreamMediaWithFolder(c *gin.Context, cfg *config.Config) {
 		// Open the file
 		file, err := os.Open(filePath)
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Error opening file")
+			respondError(c, http.StatusInternalServerError, "Error opening file")
 			return
 		}
 		defer file.Close()
@@ -332,7 +420,8 @@ func HandleStreamMediaWithFolder(c *gin.Context, cfg *config.Config) {
 		// Stream the file
 		_, err = io.CopyN(c.Writer, file, end-start+1)
 		if err != nil {
-			fmt.Printf("Error streaming file: %v\n", err)
+			// Usually the client stopped playback or seeked elsewhere
+			logger.DebugContext(c.Request.Context(), "Stream ended early", "library", libraryID, "path", relativePath, "error", err)
 		}
 	} else {
 		// No range header, serve the entire file
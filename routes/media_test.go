@@ -0,0 +1,74 @@
+package routes
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSearchVersions(t *testing.T) {
+	s := newContractServer(t)
+	s.login("admin", "admin-password")
+
+	// Older clients of the unversioned API get every result as an array, as before paging
+	status, body := s.legacyGet("/search?q=film")
+	if results, ok := body.([]any); status != http.StatusOK || !ok || len(results) != 1 {
+		t.Errorf("unversioned search = %d %v", status, body)
+	}
+	status, body = s.legacyGet("/search?q=film&limit=10")
+	if _, ok := body.(map[string]any); status != http.StatusOK || !ok {
+		t.Errorf("paged unversioned search = %d %v", status, body)
+	}
+
+	if page, ok := s.call("GET", "/search?q=film", nil, http.StatusOK).(map[string]any); !ok || page["total"] != 1.0 {
+		t.Errorf("versioned search = %v", page)
+	}
+}
+
+func TestLibraryVersions(t *testing.T) {
+	s := newContractServer(t)
+	s.login("admin", "admin-password")
+
+	status, body := s.legacyGet("/library/movies")
+	if items, ok := body.([]any); status != http.StatusOK || !ok || len(items) != 1 {
+		t.Errorf("unversioned listing = %d %v", status, body)
+	}
+	for _, query := range []string{"?offset=0", "?limit=10", "?sort=title", "?order=desc"} {
+		status, body := s.legacyGet("/library/movies" + query)
+		if _, ok := body.(map[string]any); status != http.StatusOK || !ok {
+			t.Errorf("unversioned listing%s = %d %v", query, status, body)
+		}
+	}
+	if _, ok := s.call("GET", "/library/movies", nil, http.StatusOK).(map[string]any); !ok {
+		t.Error("versioned listing is not a page")
+	}
+}
+
+func TestTimelineVersions(t *testing.T) {
+	s := newContractServer(t)
+	s.login("admin", "admin-password")
+
+	status, body := s.legacyGet("/library/photos/timeline")
+	if groups, ok := body.([]any); status != http.StatusOK || !ok || len(groups) == 0 {
+		t.Errorf("unversioned timeline = %d %v", status, body)
+	}
+	if status, body := s.legacyGet("/library/photos/timeline?limit=1"); status != http.StatusOK || body.(map[string]any)["total"] == nil {
+		t.Errorf("unversioned timeline page = %d %v", status, body)
+	}
+	page, ok := s.call("GET", "/library/photos/timeline?group=day&limit=1", nil, http.StatusOK).(map[string]any)
+	if !ok || page["limit"] != 1.0 || len(page["groups"].([]any)) != 1 {
+		t.Errorf("versioned timeline = %v", page)
+	}
+	s.call("GET", "/library/photos/timeline?offset=-1", nil, http.StatusBadRequest)
+}
+
+func TestWatchedFilters(t *testing.T) {
+	s := newContractServer(t)
+	s.login("admin", "admin-password")
+
+	for _, query := range []string{"watched=true", "unwatched=true", "watched=false"} {
+		s.call("GET", "/library/movies?"+query, nil, http.StatusOK)
+	}
+	s.call("GET", "/library/movies?watched=true&unwatched=true", nil, http.StatusBadRequest)
+	s.call("GET", "/search?watched=false&unwatched=false", nil, http.StatusBadRequest)
+	s.call("GET", "/library/movies?watched=maybe", nil, http.StatusBadRequest)
+}
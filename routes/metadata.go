@@ -0,0 +1,175 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+// errNoMetadataEdits stops resetting the edits of an item that has none
+var errNoMetadataEdits = errors.New("item has no metadata edits")
+
+// findEditableItem looks up the item of the :id parameter and its library, answering 404 itself
+func findEditableItem(c *gin.Context, cfg *config.Config) (*models.MediaItem, *config.Library, bool) {
+	item, err := models.FindMediaByID(c.Request.Context(), c.Param("id"), cfg)
+	if err != nil {
+		respondError(c, http.StatusNotFound, "Media not found")
+		return nil, nil, false
+	}
+	return item, cfg.FindLibrary(item.LibraryID), true
+}
+
+// MetadataEditResponse is an item with the edits an admin made and the fields they can edit
+type MetadataEditResponse struct {
+	Item     *models.MediaItem    `json:"item"`
+	Edit     *models.MetadataEdit `json:"edit"`
+	Editable []string             `json:"editable"`
+}
+
+// HandleGetMetadataEdit returns an item with the fields an admin edited (admin only)
+func HandleGetMetadataEdit(c *gin.Context, cfg *config.Config) {
+	item, _, ok := findEditableItem(c, cfg)
+	if !ok {
+		return
+	}
+
+	edits, err := models.LoadMetadataEdits(config.EditsFile)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to load metadata edits")
+		return
+	}
+
+	var edit *models.MetadataEdit
+	if e, ok := edits[models.EditKey(*item)]; ok {
+		edit = &e
+	}
+	c.JSON(http.StatusOK, MetadataEditResponse{Item: item, Edit: edit, Editable: models.EditableFields})
+}
+
+// HandleEditMetadata changes and locks metadata fields of an item; null unlocks a field (admin only)
+func HandleEditMetadata(c *gin.Context, cfg *config.Config, index *models.Index) {
+	item, library, ok := findEditableItem(c, cfg)
+	if !ok {
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&fields); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(fields) == 0 {
+		respondError(c, http.StatusBadRequest, "No fields to edit")
+		return
+	}
+
+	// Invalid fields are answered with 400, failures to load or save the edits with 500
+	var invalid error
+	var names []string
+	err := models.UpdateMetadataEdits(config.EditsFile, func(edits map[string]models.MetadataEdit) error {
+		key := models.EditKey(*item)
+		edit := edits[key]
+		for field, value := range fields {
+			if invalid = edit.Set(field, value); invalid != nil {
+				return invalid
+			}
+			names = append(names, field)
+		}
+		if invalid = edit.Validate(); invalid != nil {
+			return invalid
+		}
+
+		if len(edit.Locked()) == 0 {
+			delete(edits, key)
+			return nil
+		}
+		edit.Updated = time.Now()
+		edit.Size, edit.Modified = item.Size, item.Modified
+		if user, exists := models.GetUserFromContext(c); exists {
+			edit.UpdatedBy = user.Username
+		}
+		edits[key] = edit
+		return nil
+	})
+	if invalid != nil {
+		respondError(c, http.StatusBadRequest, invalid.Error())
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to save metadata edits")
+		return
+	}
+	sort.Strings(names)
+
+	updated := refreshEditedItems(c, cfg, index, *library, *item, true)
+	RecordAudit(c, models.AuditMetadataEdit, item.ID, true, map[string]string{
+		"title":  updated.Title,
+		"fields": strings.Join(names, ","),
+	})
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// HandleResetMetadata drops every edit of an item, unlocking all its fields (admin only)
+func HandleResetMetadata(c *gin.Context, cfg *config.Config, index *models.Index) {
+	item, library, ok := findEditableItem(c, cfg)
+	if !ok {
+		return
+	}
+
+	err := models.UpdateMetadataEdits(config.EditsFile, func(edits map[string]models.MetadataEdit) error {
+		key := models.EditKey(*item)
+		if _, ok := edits[key]; !ok {
+			return errNoMetadataEdits
+		}
+		delete(edits, key)
+		return nil
+	})
+	if errors.Is(err, errNoMetadataEdits) {
+		respondError(c, http.StatusNotFound, "Item has no metadata edits")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to save metadata edits")
+		return
+	}
+
+	updated := refreshEditedItems(c, cfg, index, *library, *item, false)
+	RecordAudit(c, models.AuditMetadataReset, item.ID, true, map[string]string{"title": updated.Title})
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// refreshEditedItems updates the index after an item's edits changed, writing the NFO files of
+// the item and its copies if asked and the library writes them. It returns the item as it is now.
+func refreshEditedItems(c *gin.Context, cfg *config.Config, index *models.Index, library config.Library, item models.MediaItem, writeNFO bool) models.MediaItem {
+	ctx := c.Request.Context()
+	items, err := index.RefreshItems(ctx, library, cfg, models.EditKey(item))
+	if err != nil {
+		logger.ErrorContext(ctx, "Error refreshing edited items", "library", library.ID, "error", err)
+	}
+
+	updated := item
+	if fresh, err := models.FindMediaByID(ctx, item.ID, cfg); err == nil {
+		updated = *fresh
+	}
+
+	for _, edited := range items {
+		if writeNFO && library.Options.WriteNFO && edited.Metadata != nil {
+			meta := *edited.Metadata
+			edited.Metadata = &meta
+			if err := models.WriteNFO(library, &edited); err != nil {
+				logger.WarnContext(ctx, "Error writing NFO file", "library", library.ID, "path", edited.RelativePath, "error", err)
+			}
+		}
+	}
+	return updated
+}
@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mediastream/metrics"
+)
+
+// activeStreamsGauge reports the streams being served when metrics are scraped
+var activeStreamsGauge = metrics.NewGaugeFunc("mediastream_active_streams",
+	"Media streams currently being served.", func() float64 {
+		return float64(ActiveStreams())
+	})
+
+// RecordMetrics counts every request and its duration by route.
+// Routes are reported by their pattern, e.g. /api/library/:id, to keep the number of series small.
+func RecordMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := strings.TrimPrefix(c.FullPath(), basePath)
+		if c.FullPath() == "" {
+			route = "unmatched"
+		} else if route == "" {
+			route = "/"
+		}
+
+		method := c.Request.Method
+		metrics.HTTPRequests.Inc(method, route, strconv.Itoa(c.Writer.Status()))
+		metrics.HTTPRequestDuration.Observe(time.Since(start).Seconds(), method, route)
+	}
+}
+
+// HandleMetrics writes every metric in the Prometheus text format
+func HandleMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	metrics.WriteAll(c.Writer)
+}
@@ -1,7 +1,6 @@
 package routes
 
 import (
-	"fmt"
 	"net/http"
 	"strings"
 
@@ -12,22 +11,36 @@ import (
 	"mediastream/models"
 )
 
+// rejectLogin sends a request without a valid login to the login or setup page. The versioned
+// API answers with an error instead, as its clients can't fill in the forms.
+func rejectLogin(c *gin.Context, page string) {
+	switch {
+	case !isV1(c):
+		redirect(c, page)
+	case page == "/setup":
+		respondError(c, http.StatusServiceUnavailable, "Setup has not been completed")
+	default:
+		respondError(c, http.StatusUnauthorized, "Login required")
+	}
+	c.Abort()
+}
+
 // Middleware to check if user is authenticated
 func EnsureAuthenticated(cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Skip middleware for static files, login and setup routes
-		if strings.HasPrefix(c.Request.URL.Path, "/static") ||
-			c.Request.URL.Path == "/api/setup" ||
-			c.Request.URL.Path == "/setup.html" ||
-			c.Request.URL.Path == "/style.css" {
+		if strings.HasPrefix(c.Request.URL.Path, URL("/static")) ||
+			c.Request.URL.Path == URL("/api/setup") ||
+			c.Request.URL.Path == URL(APIPrefix+"/setup") ||
+			c.Request.URL.Path == URL("/setup.html") ||
+			c.Request.URL.Path == URL("/style.css") {
 			c.Next()
 			return
 		}
 
 		// If setup is not complete, redirect to setup page
-		if !config.IsSetupCompleted() && c.Request.URL.Path != "/setup" {
-			c.Redirect(http.StatusFound, "/setup")
-			c.Abort()
+		if !config.IsSetupCompleted() && c.Request.URL.Path != URL("/setup") {
+			rejectLogin(c, "/setup")
 			return
 		}
 
@@ -35,32 +48,43 @@ func EnsureAuthenticated(cfg *config.Config) gin.HandlerFunc {
 		session := sessions.Default(c)
 		userID := session.Get("userID")
 		if userID == nil {
-			c.Redirect(http.StatusFound, "/login")
-			c.Abort()
+			rejectLogin(c, "/login")
 			return
 		}
 
 		// Load users and find the authenticated user
 		users, err := models.LoadUsers(config.UsersFile)
 		if err != nil {
-			fmt.Printf("Error loading users in middleware: %v\n", err)
-			c.Redirect(http.StatusFound, "/login")
-			c.Abort()
+			logger.ErrorContext(c.Request.Context(), "Error loading users", "error", err)
+			rejectLogin(c, "/login")
 			return
 		}
 
+		// Sessions started before the user's password changed are no longer valid
+		epoch, _ := session.Get("sessionEpoch").(int)
 		user := models.FindUserByID(users, userID.(string))
-		if user == nil {
-			// Invalid user ID in session
+		if user == nil || user.Disabled || epoch != user.SessionEpoch {
+			// Invalid, disabled or ended user ID in session
 			session.Delete("userID")
+			session.Delete("sessionEpoch")
 			session.Save()
-			c.Redirect(http.StatusFound, "/login")
-			c.Abort()
+			rejectLogin(c, "/login")
 			return
 		}
 
 		// Store user in context
 		c.Set("user", user)
+
+		// Store the selected profile, dropping it if it was deleted meanwhile
+		if profileID, ok := session.Get("profileID").(string); ok {
+			if profile := user.FindProfileByID(profileID); profile != nil {
+				c.Set("profile", profile)
+			} else {
+				session.Delete("profileID")
+				session.Save()
+			}
+		}
+
 		c.Next()
 	}
 }
@@ -70,7 +94,14 @@ func EnsureAdmin() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user, exists := models.GetUserFromContext(c)
 		if !exists || !user.IsAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			respondError(c, http.StatusForbidden, "Admin access required")
+			c.Abort()
+			return
+		}
+
+		// A rating-limited profile on an admin account doesn't get admin rights
+		if !canManageProfiles(c) {
+			respondError(c, http.StatusForbidden, "Admin access required")
 			c.Abort()
 			return
 		}
@@ -82,8 +113,7 @@ func EnsureAdmin() gin.HandlerFunc {
 func CheckSetup() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if !config.IsSetupCompleted() {
-			c.Redirect(http.StatusFound, "/setup")
-			c.Abort()
+			rejectLogin(c, "/setup")
 			return
 		}
 		c.Next()
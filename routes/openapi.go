@@ -0,0 +1,518 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mediastream/config"
+	"mediastream/models"
+	"mediastream/version"
+)
+
+// Operation documents one endpoint of the versioned API
+type Operation struct {
+	Method      string
+	Path        string // Below APIPrefix, with gin's :param syntax
+	Summary     string
+	Tag         string
+	Query       []QueryParam
+	Request     any    // Zero value of the request body type, nil without a body
+	Response    any    // Zero value of the response body type, nil for Binary responses
+	Status      int    // Success status, 200 when zero
+	Binary      string // Content type of a response that isn't JSON
+	Public      bool   // Needs no login
+	Admin       bool
+	Description string
+}
+
+// QueryParam documents a query parameter of an operation
+type QueryParam struct {
+	Name        string
+	Type        string // string, integer, boolean or date-time
+	Description string
+	List        bool     // May be repeated or comma separated
+	Enum        []string // Allowed values, if limited
+}
+
+// Query parameters shared by listings and search
+var (
+	pageQuery = []QueryParam{
+		{Name: "offset", Type: "integer", Description: "Number of results to skip"},
+		{Name: "limit", Type: "integer", Description: "Results per page, at most 200 (default 50)"},
+	}
+	filterQuery = []QueryParam{
+		{Name: "library", Type: "string", Description: "Library IDs", List: true},
+		{Name: "type", Type: "string", Description: "Media types", List: true},
+		{Name: "genre", Type: "string", Description: "Genres", List: true},
+		{Name: "resolution", Type: "string", Description: "Video resolutions such as 1080p", List: true},
+		{Name: "yearFrom", Type: "integer", Description: "Earliest release year"},
+		{Name: "yearTo", Type: "integer", Description: "Latest release year"},
+		{Name: "letter", Type: "string", Description: "First letter of the sort title, or # for digits and symbols"},
+		{Name: "watched", Type: "boolean", Description: "Only watched or unwatched items"},
+		{Name: "unwatched", Type: "boolean", Description: "Short for watched=false"},
+	}
+	imageQuery = []QueryParam{
+		{Name: "width", Type: "integer", Description: "Resize to this width, keeping the aspect ratio"},
+		{Name: "format", Type: "string", Description: "Convert to this format", Enum: []string{"jpeg", "png"}},
+	}
+	sortQuery = []QueryParam{
+		{Name: "sort", Type: "string", Description: "Sort order (default title)", Enum: []string{
+			models.SortByTitle, models.SortByAdded, models.SortByYear, models.SortBySize, models.SortByDuration, models.SortByLastPlayed,
+		}},
+		{Name: "order", Type: "string", Description: "Direction, by default ascending for titles and descending otherwise", Enum: []string{"asc", "desc"}},
+	}
+)
+
+// Operations lists every endpoint of the versioned API. CheckAPIRoutes verifies at startup
+// that it matches the routes the router serves.
+var Operations = []Operation{
+	{Method: "GET", Path: "/openapi.json", Summary: "This OpenAPI document", Tag: "server", Response: map[string]any{}, Public: true},
+	{Method: "GET", Path: "/version", Summary: "Build information of the server", Tag: "server", Response: version.Info{}, Public: true},
+	{Method: "POST", Path: "/setup", Summary: "Create the first admin account and libraries", Tag: "server", Request: SetupRequest{}, Response: SuccessResponse{}, Public: true},
+
+	{Method: "GET", Path: "/admin/users", Summary: "List users", Tag: "admin", Response: []models.UserResponse{}, Admin: true},
+	{Method: "POST", Path: "/admin/users", Summary: "Create a user", Tag: "admin", Request: CreateUserRequest{}, Response: MessageResponse{}, Status: http.StatusCreated, Admin: true},
+	{Method: "PATCH", Path: "/admin/users/:id", Summary: "Update a user", Tag: "admin", Request: UpdateUserRequest{}, Response: models.UserResponse{}, Admin: true},
+	{Method: "POST", Path: "/admin/users/:id/password", Summary: "Set a user's password", Tag: "admin", Request: ResetPasswordRequest{}, Response: MessageResponse{}, Admin: true},
+	{Method: "DELETE", Path: "/admin/users/:id", Summary: "Delete a user", Tag: "admin", Response: MessageResponse{}, Admin: true},
+	{Method: "GET", Path: "/admin/invites", Summary: "List outstanding invites", Tag: "admin", Response: []InviteResponse{}, Admin: true},
+	{Method: "POST", Path: "/admin/invites", Summary: "Create an invite link", Tag: "admin", Request: CreateInviteRequest{}, Response: InviteResponse{}, Status: http.StatusCreated, Admin: true},
+	{Method: "DELETE", Path: "/admin/invites/:id", Summary: "Revoke an invite", Tag: "admin", Response: MessageResponse{}, Admin: true},
+	{Method: "GET", Path: "/admin/audit", Summary: "Page through the audit log, newest first", Tag: "admin", Response: AuditPage{}, Admin: true,
+		Description: "With format=jsonl every matching event is downloaded as JSON lines instead.",
+		Query: append([]QueryParam{
+			{Name: "type", Type: "string", Description: "Event type"},
+			{Name: "actor", Type: "string", Description: "User name of the actor"},
+			{Name: "ip", Type: "string", Description: "Client IP address"},
+			{Name: "success", Type: "boolean", Description: "Only successful or failed events"},
+			{Name: "since", Type: "date-time", Description: "Earliest event time"},
+			{Name: "until", Type: "date-time", Description: "Latest event time"},
+			{Name: "format", Type: "string", Description: "Export format", Enum: []string{"jsonl"}},
+		}, pageQuery...)},
+	{Method: "GET", Path: "/admin/backup", Summary: "Download a backup archive", Tag: "admin", Binary: "application/gzip", Admin: true},
+	{Method: "GET", Path: "/admin/libraries", Summary: "List libraries with their scanner options", Tag: "admin", Response: []config.Library{}, Admin: true},
+	{Method: "POST", Path: "/admin/libraries", Summary: "Add a library", Tag: "admin", Request: LibraryRequest{}, Response: config.Library{}, Status: http.StatusCreated, Admin: true},
+	{Method: "PUT", Path: "/admin/libraries/:id", Summary: "Update a library", Tag: "admin", Request: LibraryRequest{}, Response: config.Library{}, Admin: true},
+	{Method: "DELETE", Path: "/admin/libraries/:id", Summary: "Remove a library", Tag: "admin", Response: MessageResponse{}, Admin: true},
+	{Method: "GET", Path: "/admin/libraries/:id/duplicates", Summary: "List items found in more than one root", Tag: "admin", Response: DuplicatesResponse{}, Admin: true},
+	{Method: "POST", Path: "/admin/libraries/:id/scan", Summary: "Rescan a library", Tag: "admin", Response: ScanResult{}, Admin: true},
+	{Method: "GET", Path: "/admin/media/:id/metadata", Summary: "Get an item with its metadata edits", Tag: "admin", Response: MetadataEditResponse{}, Admin: true},
+	{Method: "PATCH", Path: "/admin/media/:id/metadata", Summary: "Edit and lock metadata fields; null unlocks a field", Tag: "admin", Request: map[string]json.RawMessage{}, Response: models.MediaItem{}, Admin: true},
+	{Method: "DELETE", Path: "/admin/media/:id/metadata", Summary: "Drop all metadata edits of an item", Tag: "admin", Response: models.MediaItem{}, Admin: true},
+	{Method: "GET", Path: "/admin/config", Summary: "Get the saved configuration", Tag: "admin", Response: config.Config{}, Admin: true},
+	{Method: "PUT", Path: "/admin/config", Summary: "Change settings, keeping those missing from the body", Tag: "admin", Request: config.Config{}, Response: config.Config{}, Admin: true},
+	{Method: "POST", Path: "/admin/config/reload", Summary: "Reload the configuration file", Tag: "admin", Response: config.Config{}, Admin: true},
+	{Method: "GET", Path: "/admin/scan", Summary: "When each library was last scanned", Tag: "admin", Response: []models.IndexStatus{}, Admin: true},
+	{Method: "POST", Path: "/admin/scan", Summary: "Rescan every library in the background", Tag: "admin", Response: MessageResponse{}, Status: http.StatusAccepted, Admin: true},
+
+	{Method: "POST", Path: "/invite/:token", Summary: "Create an account from an invite", Tag: "account", Request: AcceptInviteRequest{}, Response: SuccessResponse{}, Status: http.StatusCreated, Public: true},
+	{Method: "PUT", Path: "/me/password", Summary: "Change your password", Tag: "account", Request: ChangePasswordRequest{}, Response: MessageResponse{}},
+	{Method: "GET", Path: "/profiles", Summary: "List your profiles", Tag: "account", Response: ProfilesResponse{}},
+	{Method: "POST", Path: "/profiles", Summary: "Create a profile", Tag: "account", Request: CreateProfileRequest{}, Response: models.ProfileResponse{}, Status: http.StatusCreated},
+	{Method: "PATCH", Path: "/profiles/:id", Summary: "Update a profile", Tag: "account", Request: UpdateProfileRequest{}, Response: models.ProfileResponse{}},
+	{Method: "DELETE", Path: "/profiles/:id", Summary: "Delete a profile", Tag: "account", Response: MessageResponse{}},
+	{Method: "POST", Path: "/profiles/:id/switch", Summary: "Switch to a profile", Tag: "account", Request: SwitchProfileRequest{}, Response: models.ProfileResponse{}},
+
+	{Method: "GET", Path: "/history", Summary: "Watch history of the active profile, latest first", Tag: "history", Response: []models.WatchEntry{}},
+	{Method: "PUT", Path: "/history/:id", Summary: "Save playback progress", Tag: "history", Request: UpdateHistoryRequest{}, Response: models.WatchEntry{}},
+	{Method: "DELETE", Path: "/history/:id", Summary: "Remove an item from the watch history", Tag: "history", Response: MessageResponse{}},
+	{Method: "GET", Path: "/playlists", Summary: "List playlists", Tag: "history", Response: []models.Playlist{}},
+	{Method: "POST", Path: "/playlists", Summary: "Create a playlist", Tag: "history", Request: CreatePlaylistRequest{}, Response: models.Playlist{}, Status: http.StatusCreated},
+	{Method: "GET", Path: "/playlists/:id", Summary: "Get a playlist", Tag: "history", Response: models.Playlist{}},
+	{Method: "PUT", Path: "/playlists/:id", Summary: "Update a playlist", Tag: "history", Request: UpdatePlaylistRequest{}, Response: models.Playlist{}},
+	{Method: "DELETE", Path: "/playlists/:id", Summary: "Delete a playlist", Tag: "history", Response: MessageResponse{}},
+
+	{Method: "GET", Path: "/libraries", Summary: "List the libraries you can access", Tag: "media", Response: []LibrarySummary{}},
+	{Method: "GET", Path: "/library/:id", Summary: "Page through a library", Tag: "media", Response: models.ItemPage{},
+		Query: slices.Concat(pageQuery, sortQuery, filterQuery)},
+	{Method: "GET", Path: "/library/:id/timeline", Summary: "Photos and videos of a photo library by date", Tag: "media", Response: models.TimelinePage{},
+		Query: append([]QueryParam{{Name: "group", Type: "string", Description: "Grouping (default month)", Enum: []string{"year", "month", "day"}}}, pageQuery...)},
+	{Method: "GET", Path: "/library/:id/albums", Summary: "Folder albums of a photo library", Tag: "media", Response: []models.Album{}},
+	{Method: "GET", Path: "/library/:id/albums/:album", Summary: "A folder album with its contents", Tag: "media", Response: AlbumResponse{}},
+	{Method: "GET", Path: "/media/:id", Summary: "Get a media item", Tag: "media", Response: models.MediaItem{}},
+	{Method: "GET", Path: "/media/:id/image/:kind", Summary: "Artwork of an item", Tag: "media", Binary: "image/*", Query: imageQuery},
+	{Method: "GET", Path: "/media/:id/photo", Summary: "A photo, rotated upright", Tag: "media", Binary: "image/*", Query: imageQuery},
+	{Method: "GET", Path: "/media/:id/previews", Summary: "State of the poster frame and trickplay thumbnails of a video", Tag: "media", Response: models.PreviewStatus{}},
+	{Method: "GET", Path: "/media/:id/trickplay/:file", Summary: "The trickplay WebVTT track or one of its sprite sheets", Tag: "media", Binary: "text/vtt, image/jpeg"},
+	{Method: "GET", Path: "/search", Summary: "Search the libraries you can access", Tag: "media", Response: models.SearchResults{},
+		Query: slices.Concat([]QueryParam{{Name: "q", Type: "string", Description: "Search text"}}, pageQuery, filterQuery)},
+	{Method: "GET", Path: "/debug/scan", Summary: "Scan every library root without updating the index", Tag: "admin", Response: []DebugScanResult{}, Admin: true},
+}
+
+// openAPIPath converts a gin route path to an OpenAPI path template
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// pathParams returns the names of the parameters in a gin route path
+func pathParams(path string) []string {
+	var names []string
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			names = append(names, part[1:])
+		}
+	}
+	return names
+}
+
+// CheckAPIRoutes verifies that the versioned API routes of the router and Operations match,
+// so the OpenAPI document can't drift from the handlers
+func CheckAPIRoutes(routes gin.RoutesInfo) error {
+	prefix := URL(APIPrefix)
+	served := map[string]bool{}
+	for _, route := range routes {
+		if path, ok := strings.CutPrefix(route.Path, prefix); ok && strings.HasPrefix(path, "/") {
+			served[route.Method+" "+path] = true
+		}
+	}
+
+	var problems []string
+	documented := map[string]bool{}
+	for _, op := range Operations {
+		key := op.Method + " " + op.Path
+		if documented[key] {
+			problems = append(problems, "documented twice: "+key)
+		}
+		documented[key] = true
+		if !served[key] {
+			problems = append(problems, "documented but not served: "+key)
+		}
+	}
+	for key := range served {
+		if !documented[key] {
+			problems = append(problems, "served but not documented: "+key)
+		}
+	}
+
+	if len(problems) > 0 {
+		slices.Sort(problems)
+		return fmt.Errorf("API routes don't match the OpenAPI document: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// schemaBuilder turns Go types into OpenAPI schemas, collecting named structs as components
+type schemaBuilder struct {
+	components map[string]any
+	names      map[reflect.Type]string
+	requests   map[reflect.Type]bool // Types sent by clients, whose fields are optional unless bound as required
+}
+
+// enumerated is implemented by string types with a fixed set of values
+type enumerated interface {
+	Enum() []string
+}
+
+var (
+	timeType = reflect.TypeFor[time.Time]()
+	rawType  = reflect.TypeFor[json.RawMessage]()
+	enumType = reflect.TypeFor[enumerated]()
+)
+
+// markRequest records a request body type and every struct it contains
+func (b *schemaBuilder) markRequest(t reflect.Type) {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType || b.requests[t] {
+		return
+	}
+	b.requests[t] = true
+	for i := range t.NumField() {
+		b.markRequest(t.Field(i).Type)
+	}
+}
+
+// componentName names the schema of a struct, qualifying it with its package on a clash
+func (b *schemaBuilder) componentName(t reflect.Type) string {
+	if name, ok := b.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	for _, taken := range b.names {
+		if taken == name {
+			pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+			name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+			break
+		}
+	}
+	b.names[t] = name
+	return name
+}
+
+// schema returns the schema of a type, a reference for named structs
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawType:
+		return map[string]any{}
+	case t.Kind() == reflect.String && t.Implements(enumType):
+		return map[string]any{"type": "string", "enum": reflect.Zero(t).Interface().(enumerated).Enum()}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(b.schema(t.Elem()))
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Array {
+			return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte", "nullable": true}
+		}
+		return map[string]any{"type": "array", "items": b.schema(t.Elem()), "nullable": true}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem()), "nullable": true}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		name := b.componentName(t)
+		if _, ok := b.components[name]; !ok {
+			b.components[name] = nil // Placeholder for recursive types
+			b.components[name] = b.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// nullable allows null in place of a value, as encoding/json writes nil pointers, slices and maps
+func nullable(schema map[string]any) map[string]any {
+	if _, ok := schema["$ref"]; ok {
+		return map[string]any{"allOf": []any{schema}, "nullable": true}
+	}
+	if len(schema) == 0 {
+		return schema
+	}
+	schema["nullable"] = true
+	return schema
+}
+
+// object returns the schema of a struct the way encoding/json marshals it
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	b.fields(t, properties, &required)
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		slices.Sort(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// fields adds the JSON fields of a struct to an object schema, flattening embedded structs
+func (b *schemaBuilder) fields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				b.fields(embedded, properties, required)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = b.schema(field.Type)
+
+		optional := strings.Contains(options, "omitempty") || strings.Contains(options, "omitzero")
+		if strings.Contains(field.Tag.Get("binding"), "required") || !optional && !b.requests[t] {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// queryParameter returns the OpenAPI description of a query parameter
+func queryParameter(param QueryParam) map[string]any {
+	schema := map[string]any{"type": param.Type}
+	if param.Type == "date-time" {
+		schema = map[string]any{"type": "string", "format": "date-time"}
+	}
+	if param.Enum != nil {
+		schema["enum"] = param.Enum
+	}
+	if param.List {
+		schema = map[string]any{"type": "array", "items": schema}
+	}
+	return map[string]any{
+		"name":        param.Name,
+		"in":          "query",
+		"description": param.Description,
+		"schema":      schema,
+	}
+}
+
+// errorResponse describes an error answer of an operation
+func errorResponse(description string) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/ErrorResponse"}},
+		},
+	}
+}
+
+// OpenAPI builds the OpenAPI 3 document of the versioned API from Operations
+func OpenAPI() map[string]any {
+	b := &schemaBuilder{components: map[string]any{}, names: map[reflect.Type]string{}, requests: map[reflect.Type]bool{}}
+	for _, op := range Operations {
+		if op.Request != nil {
+			b.markRequest(reflect.TypeOf(op.Request))
+		}
+	}
+
+	b.schema(reflect.TypeFor[ErrorResponse]())
+
+	paths := map[string]any{}
+	for _, op := range Operations {
+		operation := map[string]any{
+			"summary":     op.Summary,
+			"tags":        []string{op.Tag},
+			"operationId": strings.ToLower(op.Method) + strings.ReplaceAll(openAPIPath(op.Path), "/", "_"),
+		}
+		if op.Description != "" {
+			operation["description"] = op.Description
+		}
+
+		var parameters []any
+		for _, name := range pathParams(op.Path) {
+			parameters = append(parameters, map[string]any{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+		for _, param := range op.Query {
+			parameters = append(parameters, queryParameter(param))
+		}
+		if parameters != nil {
+			operation["parameters"] = parameters
+		}
+
+		if op.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": b.schema(reflect.TypeOf(op.Request))},
+				},
+			}
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]any{"description": http.StatusText(status)}
+		if op.Binary != "" {
+			content := map[string]any{}
+			for _, contentType := range strings.Split(op.Binary, ", ") {
+				content[contentType] = map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}
+			}
+			success["content"] = content
+		} else if op.Response != nil {
+			success["content"] = map[string]any{
+				"application/json": map[string]any{"schema": b.schema(reflect.TypeOf(op.Response))},
+			}
+		}
+
+		responses := map[string]any{
+			fmt.Sprint(status): success,
+			"default":          errorResponse("Error"),
+		}
+		if op.Request != nil || op.Query != nil {
+			responses["400"] = errorResponse("Invalid request")
+		}
+		if op.Public {
+			operation["security"] = []any{}
+		} else {
+			responses["401"] = errorResponse("Not logged in")
+		}
+		if op.Admin {
+			responses["403"] = errorResponse("Admin access required")
+		}
+		if len(pathParams(op.Path)) > 0 {
+			responses["404"] = errorResponse("Not found")
+		}
+		operation["responses"] = responses
+
+		path := openAPIPath(op.Path)
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path].(map[string]any)[strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Media Stream API",
+			"version":     "1",
+			"description": "Server build " + version.Get().Version + ". Errors are answered with an ErrorResponse.",
+		},
+		"servers":  []any{map[string]any{"url": URL(APIPrefix)}},
+		"security": []any{map[string]any{"session": []string{}}},
+		"paths":    paths,
+		"components": map[string]any{
+			"schemas": b.components,
+			"securitySchemes": map[string]any{
+				"session": map[string]any{
+					"type":        "apiKey",
+					"in":          "cookie",
+					"name":        "mediastream",
+					"description": "Session cookie set by POST /login",
+				},
+			},
+		},
+	}
+}
+
+// openAPIDocument is built on first use, when the base path is known
+var openAPIDocument = sync.OnceValue(OpenAPI)
+
+// HandleOpenAPI serves the OpenAPI document of the versioned API
+func HandleOpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, openAPIDocument())
+}
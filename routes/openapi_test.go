@@ -0,0 +1,626 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+// contractServer serves the versioned API from a temporary data directory and checks every
+// response against the OpenAPI document
+type contractServer struct {
+	t         *testing.T
+	engine    *gin.Engine
+	doc       map[string]any
+	session   *http.Cookie
+	store     *config.Store
+	index     *models.Index
+	route     string          // Route of the last request, as registered
+	exercised map[string]bool // Operations answered with their success status
+}
+
+// fakeExtractor stands in for ffmpeg with a 30 second video of blank frames
+type fakeExtractor struct{}
+
+func (fakeExtractor) Duration(ctx context.Context, file string) (time.Duration, error) {
+	return 30 * time.Second, nil
+}
+
+func (fakeExtractor) Frame(ctx context.Context, file string, at time.Duration, width int) (image.Image, error) {
+	return image.NewRGBA(image.Rect(0, 0, width, width*9/16)), nil
+}
+
+func (f fakeExtractor) Frames(ctx context.Context, file string, interval time.Duration, width int, fn func(index int, frame image.Image) error) error {
+	for i := 0; time.Duration(i)*interval < 30*time.Second; i++ {
+		frame, _ := f.Frame(ctx, file, time.Duration(i)*interval, width)
+		if err := fn(i, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeJPEG writes a small valid JPEG file
+func writeJPEG(t *testing.T, file string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 16, 9)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newContractServer(t *testing.T) *contractServer {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	config.SetDataDir(dir)
+	SetBasePath("")
+
+	movies := filepath.Join(dir, "movies")
+	photos := filepath.Join(dir, "photos")
+	extra := filepath.Join(dir, "extra")
+	writeJPEG(t, filepath.Join(movies, "Film (2001)", "poster.jpg"))
+	if err := os.WriteFile(filepath.Join(movies, "Film (2001)", "Film (2001).mp4"), []byte("not really a video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeJPEG(t, filepath.Join(photos, "Trip", "beach.jpg"))
+	if err := os.MkdirAll(extra, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Previews.Enabled = true
+	cfg.Libraries = []config.Library{
+		{ID: "movies", Name: "Movies", Kind: config.KindMovies, Paths: []string{movies}},
+		{ID: "photos", Name: "Photos", Kind: config.KindPhotos, Paths: []string{photos}},
+	}
+	store := config.NewStore(cfg, config.ConfigFile)
+	if err := config.SaveConfig(cfg, config.ConfigFile); err != nil {
+		t.Fatal(err)
+	}
+
+	admin, err := models.CreateUser(nil, "admin", "admin-password", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.SaveUsers([]models.User{*admin}, config.UsersFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.MarkSetupCompleted(); err != nil {
+		t.Fatal(err)
+	}
+
+	index := models.NewIndex(config.IndexFile)
+	previews := models.NewPreviewGenerator(store, func(config.PreviewConfig) models.FrameExtractor {
+		return fakeExtractor{}
+	})
+	t.Cleanup(func() {
+		previews.Stop()
+		index.Wait()
+	})
+
+	s := &contractServer{t: t, doc: OpenAPI(), exercised: map[string]bool{}, store: store, index: index}
+
+	s.engine = gin.New()
+	s.engine.Use(sessions.Sessions("mediastream", cookie.NewStore([]byte("test-secret"))))
+	s.engine.Use(func(c *gin.Context) {
+		c.Next()
+		s.route = c.FullPath()
+	})
+	s.engine.POST("/login", HandleLogin)
+	RegisterAPI(s.engine.Group("/api"), store, index, previews)
+	RegisterAPI(s.engine.Group(APIPrefix), store, index, previews)
+	s.engine.NoRoute(HandleNotFound)
+
+	if err := CheckAPIRoutes(s.engine.Routes()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// login starts a session as a user
+func (s *contractServer) login(username, password string) {
+	s.t.Helper()
+	form := url.Values{"username": {username}, "password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.engine.ServeHTTP(rec, req)
+	if location := rec.Header().Get("Location"); location != "/" {
+		s.t.Fatalf("login as %s redirected to %q", username, location)
+	}
+	s.keepSession(rec)
+}
+
+// legacyGet sends a GET request to the unversioned API and returns its status and decoded JSON body
+func (s *contractServer) legacyGet(path string) (int, any) {
+	s.t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api"+path, nil)
+	if s.session != nil {
+		req.AddCookie(s.session)
+	}
+	rec := httptest.NewRecorder()
+	s.engine.ServeHTTP(rec, req)
+
+	var body any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		s.t.Fatalf("GET /api%s: %v: %s", path, err, rec.Body.String())
+	}
+	return rec.Code, body
+}
+
+// keepSession remembers the session cookie a response sets
+func (s *contractServer) keepSession(rec *httptest.ResponseRecorder) {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "mediastream" {
+			s.session = c
+		}
+	}
+}
+
+// call sends a request to the versioned API and checks the answer has the expected status
+// and matches the OpenAPI document. It returns the decoded JSON body.
+func (s *contractServer) call(method, path string, body any, want int) any {
+	s.t.Helper()
+
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, APIPrefix+path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.session != nil {
+		req.AddCookie(s.session)
+	}
+	rec := httptest.NewRecorder()
+	s.route = ""
+	s.engine.ServeHTTP(rec, req)
+	s.keepSession(rec)
+
+	name := method + " " + path
+	if rec.Code != want {
+		s.t.Fatalf("%s: status %d, want %d: %s", name, rec.Code, want, rec.Body.String())
+	}
+
+	route, ok := strings.CutPrefix(s.route, APIPrefix)
+	if !ok {
+		// Unknown routes still answer with the error envelope
+		return s.checkBody(name, rec, s.errorContent())
+	}
+
+	op := s.operation(method, route)
+	if op == nil {
+		s.t.Fatalf("%s: route %s %s is not documented", name, method, route)
+	}
+	responses := s.doc["paths"].(map[string]any)[openAPIPath(op.Path)].(map[string]any)[strings.ToLower(method)].(map[string]any)["responses"].(map[string]any)
+	response, ok := responses[strconv.Itoa(rec.Code)].(map[string]any)
+	if !ok && rec.Code >= http.StatusBadRequest {
+		response, ok = responses["default"].(map[string]any)
+	}
+	if !ok {
+		s.t.Fatalf("%s: status %d is not documented", name, rec.Code)
+	}
+
+	if rec.Code == statusOf(op) {
+		s.exercised[op.Method+" "+op.Path] = true
+	}
+
+	content, _ := response["content"].(map[string]any)
+	return s.checkBody(name, rec, content)
+}
+
+// errorContent is the content of an error response
+func (s *contractServer) errorContent() map[string]any {
+	return errorResponse("")["content"].(map[string]any)
+}
+
+// checkBody validates a response body against the documented content types and schemas
+func (s *contractServer) checkBody(name string, rec *httptest.ResponseRecorder, content map[string]any) any {
+	s.t.Helper()
+
+	mediaType, _, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if err != nil {
+		s.t.Fatalf("%s: invalid content type %q", name, rec.Header().Get("Content-Type"))
+	}
+
+	for documented, value := range content {
+		if !contentTypeMatches(documented, mediaType) {
+			continue
+		}
+		schema := value.(map[string]any)["schema"].(map[string]any)
+		if schema["format"] == "binary" {
+			return nil
+		}
+
+		var body any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			s.t.Fatalf("%s: invalid JSON: %v", name, err)
+		}
+		if err := s.validate(schema, body, "body"); err != nil {
+			s.t.Fatalf("%s: response doesn't match the OpenAPI document: %v\n%s", name, err, rec.Body.String())
+		}
+		return body
+	}
+
+	s.t.Fatalf("%s: content type %s is not documented", name, mediaType)
+	return nil
+}
+
+// contentTypeMatches compares a documented content type, which may be a range like image/*
+func contentTypeMatches(documented, actual string) bool {
+	if prefix, ok := strings.CutSuffix(documented, "/*"); ok {
+		return strings.HasPrefix(actual, prefix+"/")
+	}
+	return documented == actual
+}
+
+// operation finds the documented operation of a route
+func (s *contractServer) operation(method, path string) *Operation {
+	for i := range Operations {
+		if Operations[i].Method == method && Operations[i].Path == path {
+			return &Operations[i]
+		}
+	}
+	return nil
+}
+
+// statusOf returns the success status of an operation
+func statusOf(op *Operation) int {
+	if op.Status == 0 {
+		return http.StatusOK
+	}
+	return op.Status
+}
+
+// validate checks a decoded JSON value against a schema of the document
+func (s *contractServer) validate(schema map[string]any, value any, at string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		component, ok := s.doc["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", at, ref)
+		}
+		return s.validate(component, value, at)
+	}
+
+	if value == nil {
+		if schema["nullable"] == true || len(schema) == 0 {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not allowed", at)
+	}
+
+	if allOf, ok := schema["allOf"].([]any); ok {
+		for _, sub := range allOf {
+			if err := s.validate(sub.(map[string]any), value, at); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch schema["type"] {
+	case nil:
+		return nil
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %T is not an object", at, value)
+		}
+		for _, name := range asStrings(schema["required"]) {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s: required property %s is missing", at, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		additional, _ := schema["additionalProperties"].(map[string]any)
+		for name, v := range object {
+			property, ok := properties[name].(map[string]any)
+			if !ok {
+				property = additional
+			}
+			if property == nil {
+				return fmt.Errorf("%s: property %s is not documented", at, name)
+			}
+			if err := s.validate(property, v, at+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: %T is not an array", at, value)
+		}
+		for i, v := range array {
+			if err := s.validate(schema["items"].(map[string]any), v, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: %T is not a string", at, value)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return fmt.Errorf("%s: %q is not a date-time", at, str)
+			}
+		}
+		if enum := asStrings(schema["enum"]); enum != nil && !slices.Contains(enum, str) {
+			return fmt.Errorf("%s: %q is not one of %v", at, str, enum)
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) {
+			return fmt.Errorf("%s: %v is not an integer", at, value)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s: %T is not a number", at, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: %T is not a boolean", at, value)
+		}
+	default:
+		return fmt.Errorf("%s: unknown type %v", at, schema["type"])
+	}
+	return nil
+}
+
+// asStrings converts a list of the document, which is []string as built and []any once decoded
+func asStrings(value any) []string {
+	switch list := value.(type) {
+	case []string:
+		return list
+	case []any:
+		var strs []string
+		for _, v := range list {
+			strs = append(strs, v.(string))
+		}
+		return strs
+	}
+	return nil
+}
+
+// field reads a string field of a decoded JSON object
+func field(t *testing.T, value any, name string) string {
+	t.Helper()
+	object, ok := value.(map[string]any)
+	if !ok {
+		t.Fatalf("%v is not an object", value)
+	}
+	str, ok := object[name].(string)
+	if !ok {
+		t.Fatalf("%v has no %s", value, name)
+	}
+	return str
+}
+
+// first returns the first element of a decoded JSON array, or of an array field of an object
+func first(t *testing.T, value any, name string) any {
+	t.Helper()
+	if name != "" {
+		value = value.(map[string]any)[name]
+	}
+	list, ok := value.([]any)
+	if !ok || len(list) == 0 {
+		t.Fatalf("expected a non-empty list, got %v", value)
+	}
+	return list[0]
+}
+
+// TestAPIContract drives every documented operation and checks that the handlers answer with
+// the documented statuses and bodies, errors included
+func TestAPIContract(t *testing.T) {
+	s := newContractServer(t)
+
+	// Without a login; setup adds an admin account next to the existing one
+	s.call("GET", "/version", nil, http.StatusOK)
+	s.call("GET", "/openapi.json", nil, http.StatusOK)
+	if err := os.Remove(config.SetupFlagFile); err != nil {
+		t.Fatal(err)
+	}
+	s.call("GET", "/libraries", nil, http.StatusServiceUnavailable)
+	s.call("POST", "/setup", SetupRequest{Username: "owner", Password: "short"}, http.StatusBadRequest)
+	s.call("POST", "/setup", SetupRequest{Username: "admin", Password: "admin-password"}, http.StatusBadRequest)
+	s.call("POST", "/setup", SetupRequest{Username: "owner", Password: "owner-password"}, http.StatusOK)
+	s.call("POST", "/setup", SetupRequest{Username: "someone", Password: "password123"}, http.StatusForbidden)
+	s.call("GET", "/libraries", nil, http.StatusUnauthorized)
+	s.call("GET", "/no/such/endpoint", nil, http.StatusNotFound)
+
+	s.login("admin", "admin-password")
+
+	// Libraries and scanning
+	s.call("GET", "/admin/libraries", nil, http.StatusOK)
+	s.call("POST", "/admin/libraries", LibraryRequest{ID: "extra", Name: "Extra", Kind: config.KindMixed, Paths: []string{filepath.Join(config.DataDir, "extra")}}, http.StatusCreated)
+	s.call("POST", "/admin/libraries", LibraryRequest{Name: "Broken", Kind: config.KindMovies, Paths: []string{"/does/not/exist"}}, http.StatusBadRequest)
+	s.call("PUT", "/admin/libraries/extra", LibraryRequest{Name: "More"}, http.StatusOK)
+	s.call("PUT", "/admin/libraries/extra", LibraryRequest{Kind: "bogus"}, http.StatusBadRequest)
+	s.call("POST", "/admin/libraries", LibraryRequest{ID: "extra", Name: "Again", Kind: config.KindMixed}, http.StatusBadRequest)
+	s.call("POST", "/admin/libraries/movies/scan", nil, http.StatusOK)
+	s.call("POST", "/admin/libraries/photos/scan", nil, http.StatusOK)
+	s.call("POST", "/admin/libraries/nope/scan", nil, http.StatusNotFound)
+	s.call("GET", "/admin/libraries/movies/duplicates", nil, http.StatusOK)
+	s.call("GET", "/admin/scan", nil, http.StatusOK)
+	s.call("GET", "/debug/scan", nil, http.StatusOK)
+
+	// Browsing
+	s.call("GET", "/libraries", nil, http.StatusOK)
+	movie := field(t, first(t, s.call("GET", "/library/movies?sort=year&limit=10", nil, http.StatusOK), "items"), "id")
+	s.call("GET", "/library/movies?sort=nope", nil, http.StatusBadRequest)
+	s.call("GET", "/library/nope", nil, http.StatusNotFound)
+	photo := field(t, first(t, s.call("GET", "/library/photos", nil, http.StatusOK), "items"), "id")
+	s.call("GET", "/library/photos/timeline?group=year", nil, http.StatusOK)
+	album := field(t, first(t, s.call("GET", "/library/photos/albums", nil, http.StatusOK), ""), "id")
+	s.call("GET", "/library/photos/albums/"+album, nil, http.StatusOK)
+	s.call("GET", "/library/photos/albums/nope", nil, http.StatusNotFound)
+	s.call("GET", "/media/"+movie, nil, http.StatusOK)
+	s.call("GET", "/media/nope", nil, http.StatusNotFound)
+	s.call("GET", "/media/"+movie+"/image/poster?width=100", nil, http.StatusOK)
+	s.call("GET", "/media/"+movie+"/image/poster?width=abc", nil, http.StatusBadRequest)
+	s.call("GET", "/media/"+photo+"/photo", nil, http.StatusOK)
+	s.call("GET", "/media/"+movie+"/trickplay/thumbnails.vtt", nil, http.StatusNotFound)
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		status := s.call("GET", "/media/"+movie+"/previews", nil, http.StatusOK)
+		if state := field(t, status, "state"); state == models.PreviewReady {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("previews still %s", state)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	s.call("GET", "/media/"+movie+"/trickplay/thumbnails.vtt", nil, http.StatusOK)
+	s.call("GET", "/search?q=film&type=movie", nil, http.StatusOK)
+	s.call("GET", "/search?limit=1000", nil, http.StatusBadRequest)
+
+	// Metadata edits
+	s.call("GET", "/admin/media/"+movie+"/metadata", nil, http.StatusOK)
+	s.call("PATCH", "/admin/media/"+movie+"/metadata", map[string]any{"title": "Edited"}, http.StatusOK)
+	s.call("PATCH", "/admin/media/"+movie+"/metadata", map[string]any{}, http.StatusBadRequest)
+	s.call("DELETE", "/admin/media/"+movie+"/metadata", nil, http.StatusOK)
+
+	// Profiles, history and playlists
+	s.call("GET", "/profiles", nil, http.StatusOK)
+	profile := field(t, s.call("POST", "/profiles", CreateProfileRequest{Name: "Kids", PIN: "1234"}, http.StatusCreated), "id")
+	s.call("POST", "/profiles", CreateProfileRequest{}, http.StatusBadRequest)
+	name := "Children"
+	s.call("PATCH", "/profiles/"+profile, UpdateProfileRequest{Name: &name}, http.StatusOK)
+	s.call("POST", "/profiles/"+profile+"/switch", SwitchProfileRequest{PIN: "0000"}, http.StatusForbidden)
+	s.call("POST", "/profiles/"+profile+"/switch", SwitchProfileRequest{PIN: "1234"}, http.StatusOK)
+	s.call("PUT", "/history/"+movie, UpdateHistoryRequest{Position: 10, Duration: 100}, http.StatusOK)
+	s.call("PUT", "/history/"+movie, UpdateHistoryRequest{Position: -1}, http.StatusBadRequest)
+	s.call("GET", "/history", nil, http.StatusOK)
+	s.call("DELETE", "/history/"+movie, nil, http.StatusOK)
+	s.call("GET", "/playlists", nil, http.StatusOK)
+	playlist := field(t, s.call("POST", "/playlists", CreatePlaylistRequest{Name: "Favorites", Items: []string{movie}}, http.StatusCreated), "id")
+	s.call("GET", "/playlists/"+playlist, nil, http.StatusOK)
+	s.call("PUT", "/playlists/"+playlist, UpdatePlaylistRequest{Name: &name}, http.StatusOK)
+	s.call("DELETE", "/playlists/"+playlist, nil, http.StatusOK)
+	s.call("GET", "/playlists/"+playlist, nil, http.StatusNotFound)
+	s.call("DELETE", "/profiles/"+profile, nil, http.StatusOK)
+
+	// Users and invites
+	s.call("GET", "/admin/users", nil, http.StatusOK)
+	s.call("POST", "/admin/users", CreateUserRequest{Username: "alice", Password: "alice-password"}, http.StatusCreated)
+	s.call("POST", "/admin/users", CreateUserRequest{Username: "alice"}, http.StatusBadRequest)
+	var alice string
+	for _, user := range s.call("GET", "/admin/users", nil, http.StatusOK).([]any) {
+		if field(t, user, "username") == "alice" {
+			alice = field(t, user, "id")
+		}
+	}
+	disabled := false
+	s.call("PATCH", "/admin/users/"+alice, UpdateUserRequest{Disabled: &disabled}, http.StatusOK)
+	s.call("POST", "/admin/users/"+alice+"/password", ResetPasswordRequest{Password: "new-alice-password"}, http.StatusOK)
+	s.call("DELETE", "/admin/users/"+alice, nil, http.StatusOK)
+	s.call("DELETE", "/admin/users/"+alice, nil, http.StatusNotFound)
+
+	revoked := field(t, s.call("POST", "/admin/invites", CreateInviteRequest{}, http.StatusCreated), "id")
+	s.call("DELETE", "/admin/invites/"+revoked, nil, http.StatusOK)
+	link := field(t, s.call("POST", "/admin/invites", CreateInviteRequest{MaxUses: 1}, http.StatusCreated), "link")
+	s.call("GET", "/admin/invites", nil, http.StatusOK)
+
+	// Audit log, backup and configuration
+	s.call("GET", "/admin/audit?limit=5", nil, http.StatusOK)
+	s.call("GET", "/admin/audit?since=yesterday", nil, http.StatusBadRequest)
+	s.call("GET", "/admin/backup", nil, http.StatusOK)
+	s.call("GET", "/admin/config", nil, http.StatusOK)
+	s.call("PUT", "/admin/config", map[string]any{"scanIntervalMinutes": 0}, http.StatusOK)
+	s.call("PUT", "/admin/config", map[string]any{"scanIntervalMinutes": -1}, http.StatusBadRequest)
+	s.call("PUT", "/admin/config", map[string]any{"previews": map[string]any{"ffmpegPath": "/tmp/ffmpeg"}}, http.StatusBadRequest)
+	s.call("POST", "/admin/config/reload", nil, http.StatusOK)
+	s.call("DELETE", "/admin/libraries/extra", nil, http.StatusOK)
+	s.call("POST", "/admin/scan", nil, http.StatusAccepted)
+
+	// Account changes, and an invite redeemed in a new session
+	s.call("PUT", "/me/password", ChangePasswordRequest{CurrentPassword: "wrong-password", NewPassword: "another-password"}, http.StatusForbidden)
+	s.call("PUT", "/me/password", ChangePasswordRequest{CurrentPassword: "admin-password", NewPassword: "another-password"}, http.StatusOK)
+	s.session = nil
+	token := link[strings.LastIndex(link, "/")+1:]
+	s.call("POST", "/invite/"+token, AcceptInviteRequest{Username: "bob", Password: "bob-password"}, http.StatusCreated)
+	s.call("POST", "/invite/"+token, AcceptInviteRequest{Username: "carol", Password: "carol-password"}, http.StatusNotFound)
+	s.call("GET", "/admin/users", nil, http.StatusForbidden)
+
+	for _, op := range Operations {
+		if !s.exercised[op.Method+" "+op.Path] {
+			t.Errorf("%s %s was never answered with %d", op.Method, op.Path, statusOf(&op))
+		}
+	}
+}
+
+// TestErrorCodeEnum checks that the document lists every error code
+func TestErrorCodeEnum(t *testing.T) {
+	doc := OpenAPI()
+	apiError := doc["components"].(map[string]any)["schemas"].(map[string]any)["APIError"].(map[string]any)
+	code := apiError["properties"].(map[string]any)["code"].(map[string]any)
+
+	var want []string
+	for _, c := range ErrorCodes {
+		want = append(want, string(c))
+	}
+	slices.Sort(want)
+	if got := asStrings(code["enum"]); !reflect.DeepEqual(got, want) {
+		t.Errorf("error code enum = %v, want %v", got, want)
+	}
+}
+
+// TestCheckAPIRoutes checks that undocumented and unserved routes are reported
+func TestCheckAPIRoutes(t *testing.T) {
+	SetBasePath("")
+	var routes gin.RoutesInfo
+	for _, op := range Operations[1:] {
+		routes = append(routes, gin.RouteInfo{Method: op.Method, Path: APIPrefix + op.Path})
+	}
+	routes = append(routes, gin.RouteInfo{Method: "GET", Path: APIPrefix + "/extra"}, gin.RouteInfo{Method: "GET", Path: "/api/other"})
+
+	err := CheckAPIRoutes(routes)
+	if err == nil {
+		t.Fatal("mismatched routes were accepted")
+	}
+	for _, want := range []string{"documented but not served: GET /openapi.json", "served but not documented: GET /extra"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "/api/other") {
+		t.Errorf("error %q mentions an unversioned route", err)
+	}
+}
@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"html"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// basePath is the URL prefix the server is mounted under, empty when served from the root
+var basePath string
+
+// SetBasePath sets the URL prefix used for redirects, links and pages
+func SetBasePath(path string) {
+	basePath = path
+}
+
+// URL prefixes a server-relative path with the base path
+func URL(path string) string {
+	return basePath + path
+}
+
+// redirect sends the client to a server-relative path
+func redirect(c *gin.Context, path string) {
+	c.Redirect(http.StatusFound, URL(path))
+}
+
+// ServePage serves an HTML page from the public folder.
+// A base element is added so the page's relative links resolve below the base path.
+func ServePage(c *gin.Context, name string) {
+	data, err := os.ReadFile(filepath.Join("public", name))
+	if err != nil {
+		c.String(http.StatusNotFound, "Page not found")
+		return
+	}
+
+	base := `<head>
+    <base href="` + html.EscapeString(basePath) + `/">`
+	page := strings.Replace(string(data), "<head>", base, 1)
+
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page))
+}
@@ -0,0 +1,122 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+// servePhoto sends a photo turned upright, resized and converted as requested
+func servePhoto(c *gin.Context, cfg *config.Config, library config.Library, item *models.MediaItem, width int, format string) {
+	// HEIC and other formats Go can't decode are handed to ffmpeg
+	image, err := models.RenderPhoto(c.Request.Context(), library, item, width, format, models.NewFFmpegExtractor(cfg.Previews))
+	switch {
+	case errors.Is(err, models.ErrArtworkNotFound):
+		respondError(c, http.StatusNotFound, "Image not found")
+		return
+	case errors.Is(err, models.ErrPhotoUnsupported):
+		respondError(c, http.StatusUnsupportedMediaType, "This "+item.Photo.Format+" photo can't be displayed, download the original instead")
+		return
+	case err != nil:
+		logger.ErrorContext(c.Request.Context(), "Error rendering photo", "item", item.ID, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to render photo")
+		return
+	}
+	serveImage(c, image)
+}
+
+// HandlePhoto serves a photo turned upright, for viewing: ?width= resizes it and ?format=
+// converts it. The original file is available from the item's stream path.
+func HandlePhoto(c *gin.Context, cfg *config.Config) {
+	item, err := models.FindMediaByID(c.Request.Context(), c.Param("id"), cfg)
+	if err != nil || !canAccessLibrary(c, item.LibraryID) || !item.AllowedUnder(ratingLimit(c)) {
+		respondError(c, http.StatusNotFound, "Media not found")
+		return
+	}
+	if item.Photo == nil {
+		respondError(c, http.StatusNotFound, "Media is not a photo")
+		return
+	}
+
+	width, format, ok := imageOptions(c)
+	if !ok {
+		return
+	}
+	servePhoto(c, cfg, *cfg.FindLibrary(item.LibraryID), item, width, format)
+}
+
+// galleryItems returns the items of the :id library the user may see, answering errors itself
+func galleryItems(c *gin.Context, cfg *config.Config, index *models.Index) (*config.Library, []models.MediaItem, bool) {
+	library := cfg.FindLibrary(c.Param("id"))
+	if library == nil || !canAccessLibrary(c, library.ID) {
+		respondError(c, http.StatusNotFound, "Library not found")
+		return nil, nil, false
+	}
+
+	items, err := index.Items(c.Request.Context(), *library, cfg)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Error scanning library")
+		return nil, nil, false
+	}
+	return library, allowedItems(c, items), true
+}
+
+// HandleGetTimeline groups a page of a library's photos and videos by the date they were taken,
+// newest first; ?group= is year, month (default) or day
+func HandleGetTimeline(c *gin.Context, cfg *config.Config, index *models.Index) {
+	group := c.DefaultQuery("group", "month")
+	if _, ok := models.TimelineGroups[group]; !ok {
+		respondError(c, http.StatusBadRequest, "group must be year, month or day")
+		return
+	}
+	offset, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	library, items, ok := galleryItems(c, cfg, index)
+	if !ok {
+		return
+	}
+
+	// Older clients of the unversioned API that don't page get every group as an array
+	if legacyList(c, "offset", "limit") {
+		c.JSON(http.StatusOK, index.Timeline(*library, items, group, 0, 0).Groups)
+		return
+	}
+	c.JSON(http.StatusOK, index.Timeline(*library, items, group, offset, limit))
+}
+
+// HandleGetAlbums lists the folder albums of a library
+func HandleGetAlbums(c *gin.Context, cfg *config.Config, index *models.Index) {
+	library, items, ok := galleryItems(c, cfg, index)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, index.Albums(*library, items))
+}
+
+// AlbumResponse is a folder album with its contents
+type AlbumResponse struct {
+	Album *models.Album      `json:"album"`
+	Items []models.MediaItem `json:"items"`
+}
+
+// HandleGetAlbum returns a folder album with its photos and videos, oldest first
+func HandleGetAlbum(c *gin.Context, cfg *config.Config, index *models.Index) {
+	library, items, ok := galleryItems(c, cfg, index)
+	if !ok {
+		return
+	}
+
+	album, contents, found := index.AlbumItems(*library, items, c.Param("album"))
+	if !found {
+		respondError(c, http.StatusNotFound, "Album not found")
+		return
+	}
+	c.JSON(http.StatusOK, AlbumResponse{Album: album, Items: contents})
+}
@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+// findPreviewItem looks up the video of the :id parameter the user may watch, answering 404 itself
+func findPreviewItem(c *gin.Context, cfg *config.Config) (*models.MediaItem, *config.Library, bool) {
+	item, err := models.FindMediaByID(c.Request.Context(), c.Param("id"), cfg)
+	if err != nil || !canAccessLibrary(c, item.LibraryID) || !item.AllowedUnder(ratingLimit(c)) {
+		respondError(c, http.StatusNotFound, "Media not found")
+		return nil, nil, false
+	}
+	if item.Type != "video" {
+		respondError(c, http.StatusNotFound, "Only videos have previews")
+		return nil, nil, false
+	}
+	return item, cfg.FindLibrary(item.LibraryID), true
+}
+
+// HandleGetPreviews reports which previews of a video exist, queueing the missing ones
+func HandleGetPreviews(c *gin.Context, cfg *config.Config, previews *models.PreviewGenerator) {
+	item, library, ok := findPreviewItem(c, cfg)
+	if !ok {
+		return
+	}
+
+	status := previews.Status(*library, item)
+	if status.State == models.PreviewMissing && previews.Queue(*library, *item) {
+		status = previews.Status(*library, item)
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// HandleTrickplayFile serves the WebVTT thumbnails track of a video or one of its sprite sheets.
+// The track refers to the sheets by relative URLs, so players load them from this endpoint too.
+func HandleTrickplayFile(c *gin.Context, cfg *config.Config, previews *models.PreviewGenerator) {
+	item, library, ok := findPreviewItem(c, cfg)
+	if !ok {
+		return
+	}
+
+	name := c.Param("file")
+	file, err := models.TrickplayFile(item, name)
+	if err != nil {
+		if name == models.TrickplayVTT && previews.Queue(*library, *item) {
+			respondError(c, http.StatusNotFound, "Trickplay thumbnails are being generated")
+			return
+		}
+		respondError(c, http.StatusNotFound, "Trickplay thumbnails not found")
+		return
+	}
+
+	if path.Ext(name) == ".vtt" {
+		c.Header("Content-Type", "text/vtt; charset=utf-8")
+	} else {
+		c.Header("Content-Type", "image/jpeg")
+	}
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.File(file)
+}
@@ -0,0 +1,695 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"mediastream/config"
+	"mediastream/models"
+	"mediastream/utils"
+)
+
+// profileDataMu serializes read-modify-write cycles on the profile data file
+var profileDataMu sync.Mutex
+
+// Portion of a media item after which it counts as watched
+const completedThreshold = 0.9
+
+// Limits of a profile's history and playlists; the oldest history entries are dropped
+const (
+	maxHistoryEntries = 1000
+	maxPlaylists      = 100
+	maxPlaylistItems  = 1000
+)
+
+// errTooManyPlaylists refuses a playlist beyond the limit
+var errTooManyPlaylists = fmt.Errorf("Profiles can have at most %d playlists", maxPlaylists)
+
+// checkPlaylistItems answers 400 unless a playlist's items fit the limit and are all media the
+// active profile can see
+func checkPlaylistItems(c *gin.Context, cfg *config.Config, items []string) bool {
+	if len(items) > maxPlaylistItems {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Playlists can hold at most %d items", maxPlaylistItems))
+		return false
+	}
+	for _, id := range items {
+		if _, ok := findVisibleMedia(c, cfg, id); !ok {
+			respondError(c, http.StatusBadRequest, "Unknown media item: "+id)
+			return false
+		}
+	}
+	return true
+}
+
+// validatePIN checks that a PIN is 4 to 8 digits
+func validatePIN(pin string) bool {
+	if len(pin) < 4 || len(pin) > 8 {
+		return false
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// canManageProfiles reports whether the session may add, change or remove profiles.
+// Profiles with a rating limit are meant for children and can't lift their own limits.
+func canManageProfiles(c *gin.Context) bool {
+	profile, ok := models.GetProfileFromContext(c)
+	return !ok || profile.MaxRating == ""
+}
+
+// updateProfileData loads the profile data, applies fn to the active profile's entry and saves it
+func updateProfileData(c *gin.Context, fn func(data *models.ProfileData) error) error {
+	profileDataMu.Lock()
+	defer profileDataMu.Unlock()
+
+	all, err := models.LoadProfileData(config.ProfilesFile)
+	if err != nil {
+		return err
+	}
+
+	profileID := models.ActiveProfileID(c)
+	data, ok := all[profileID]
+	if !ok {
+		data = &models.ProfileData{}
+		all[profileID] = data
+	}
+
+	if err := fn(data); err != nil {
+		return err
+	}
+
+	return models.SaveProfileData(all, config.ProfilesFile)
+}
+
+// activeProfileData returns a copy of the active profile's data
+func activeProfileData(c *gin.Context) (models.ProfileData, error) {
+	profileDataMu.Lock()
+	defer profileDataMu.Unlock()
+
+	all, err := models.LoadProfileData(config.ProfilesFile)
+	if err != nil {
+		return models.ProfileData{}, err
+	}
+
+	if data, ok := all[models.ActiveProfileID(c)]; ok {
+		return *data, nil
+	}
+	return models.ProfileData{}, nil
+}
+
+// deleteProfileData removes the data of the given profiles
+func deleteProfileData(profileIDs ...string) error {
+	profileDataMu.Lock()
+	defer profileDataMu.Unlock()
+
+	all, err := models.LoadProfileData(config.ProfilesFile)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range profileIDs {
+		delete(all, id)
+	}
+
+	return models.SaveProfileData(all, config.ProfilesFile)
+}
+
+// ProfilesResponse lists the profiles of an account and the ID of the active one, if any
+type ProfilesResponse struct {
+	Profiles []models.ProfileResponse `json:"profiles"`
+	Active   string                   `json:"active"`
+}
+
+// HandleGetProfiles returns the profiles of the logged-in account and the active one
+func HandleGetProfiles(c *gin.Context) {
+	currentUser, exists := models.GetUserFromContext(c)
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	profiles := make([]models.ProfileResponse, len(currentUser.Profiles))
+	for i := range currentUser.Profiles {
+		profiles[i] = currentUser.Profiles[i].ToResponse()
+	}
+
+	active := ""
+	if profile, ok := models.GetProfileFromContext(c); ok {
+		active = profile.ID
+	}
+
+	c.JSON(http.StatusOK, ProfilesResponse{Profiles: profiles, Active: active})
+}
+
+// CreateProfileRequest is the request body for creating a profile
+type CreateProfileRequest struct {
+	Name         string `json:"name" binding:"required"`
+	Avatar       string `json:"avatar"`
+	PIN          string `json:"pin"`
+	MaxRating    string `json:"maxRating"`
+	AllowUnrated bool   `json:"allowUnrated"` // Show unrated videos despite the rating limit
+}
+
+// HandleCreateProfile adds a profile to the logged-in account
+func HandleCreateProfile(c *gin.Context) {
+	var form CreateProfileRequest
+
+	if err := c.ShouldBindJSON(&form); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !canManageProfiles(c) {
+		respondError(c, http.StatusForbidden, "This profile can't manage profiles")
+		return
+	}
+
+	if form.PIN != "" && !validatePIN(form.PIN) {
+		respondError(c, http.StatusBadRequest, "PIN must be 4 to 8 digits")
+		return
+	}
+
+	if form.MaxRating != "" && !models.IsKnownRating(form.MaxRating) {
+		respondError(c, http.StatusBadRequest, "Unknown rating: "+form.MaxRating)
+		return
+	}
+
+	currentUser, exists := models.GetUserFromContext(c)
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	profile := models.NewProfile(form.Name)
+	profile.Avatar = form.Avatar
+	profile.MaxRating = form.MaxRating
+	profile.AllowUnrated = form.AllowUnrated
+	if err := profile.SetPIN(form.PIN); err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to set PIN")
+		return
+	}
+
+	err := models.UpdateUsers(config.UsersFile, func(users []models.User) ([]models.User, error) {
+		user := models.FindUserByID(users, currentUser.ID)
+		if user == nil {
+			return nil, &userError{http.StatusNotFound, "User not found"}
+		}
+		user.Profiles = append(user.Profiles, *profile)
+		return users, nil
+	})
+	if err != nil {
+		respondUsersError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, profile.ToResponse())
+}
+
+// UpdateProfileRequest is the request body for updating a profile; missing fields are left unchanged
+type UpdateProfileRequest struct {
+	Name         *string `json:"name"`
+	Avatar       *string `json:"avatar"`
+	PIN          *string `json:"pin"` // Empty string removes the PIN
+	MaxRating    *string `json:"maxRating"`
+	AllowUnrated *bool   `json:"allowUnrated"`
+}
+
+// HandleUpdateProfile changes a profile's name, avatar, PIN or rating limit
+func HandleUpdateProfile(c *gin.Context) {
+	profileID := c.Param("id")
+
+	var form UpdateProfileRequest
+
+	if err := c.ShouldBindJSON(&form); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !canManageProfiles(c) {
+		respondError(c, http.StatusForbidden, "This profile can't manage profiles")
+		return
+	}
+
+	if form.Name != nil && *form.Name == "" {
+		respondError(c, http.StatusBadRequest, "Name is required")
+		return
+	}
+
+	if form.PIN != nil && *form.PIN != "" && !validatePIN(*form.PIN) {
+		respondError(c, http.StatusBadRequest, "PIN must be 4 to 8 digits")
+		return
+	}
+
+	if form.MaxRating != nil && *form.MaxRating != "" && !models.IsKnownRating(*form.MaxRating) {
+		respondError(c, http.StatusBadRequest, "Unknown rating: "+*form.MaxRating)
+		return
+	}
+
+	currentUser, exists := models.GetUserFromContext(c)
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// Hash a new PIN before taking the users lock
+	var pin models.Profile
+	if form.PIN != nil {
+		if err := pin.SetPIN(*form.PIN); err != nil {
+			respondError(c, http.StatusInternalServerError, "Failed to set PIN")
+			return
+		}
+	}
+
+	var updated models.Profile
+	err := models.UpdateUsers(config.UsersFile, func(users []models.User) ([]models.User, error) {
+		user := models.FindUserByID(users, currentUser.ID)
+		if user == nil {
+			return nil, &userError{http.StatusNotFound, "User not found"}
+		}
+
+		profile := user.FindProfileByID(profileID)
+		if profile == nil {
+			return nil, &userError{http.StatusNotFound, "Profile not found"}
+		}
+
+		if form.Name != nil {
+			profile.Name = *form.Name
+		}
+		if form.Avatar != nil {
+			profile.Avatar = *form.Avatar
+		}
+		if form.MaxRating != nil {
+			profile.MaxRating = *form.MaxRating
+		}
+		if form.AllowUnrated != nil {
+			profile.AllowUnrated = *form.AllowUnrated
+		}
+		if form.PIN != nil {
+			profile.PIN = pin.PIN
+		}
+
+		updated = *profile
+		return users, nil
+	})
+	if err != nil {
+		respondUsersError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, updated.ToResponse())
+}
+
+// HandleDeleteProfile removes a profile together with its history and playlists
+func HandleDeleteProfile(c *gin.Context) {
+	profileID := c.Param("id")
+
+	if !canManageProfiles(c) {
+		respondError(c, http.StatusForbidden, "This profile can't manage profiles")
+		return
+	}
+
+	currentUser, exists := models.GetUserFromContext(c)
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	err := models.UpdateUsers(config.UsersFile, func(users []models.User) ([]models.User, error) {
+		user := models.FindUserByID(users, currentUser.ID)
+		if user == nil {
+			return nil, &userError{http.StatusNotFound, "User not found"}
+		}
+
+		var updatedProfiles []models.Profile
+		found := false
+
+		for _, profile := range user.Profiles {
+			if profile.ID == profileID {
+				found = true
+				continue
+			}
+			updatedProfiles = append(updatedProfiles, profile)
+		}
+
+		if !found {
+			return nil, &userError{http.StatusNotFound, "Profile not found"}
+		}
+
+		user.Profiles = updatedProfiles
+		return users, nil
+	})
+	if err != nil {
+		respondUsersError(c, err)
+		return
+	}
+
+	if err := deleteProfileData(profileID); err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to delete profile data")
+		return
+	}
+
+	// Leave the profile if it was the active one
+	session := sessions.Default(c)
+	if session.Get("profileID") == profileID {
+		session.Delete("profileID")
+		session.Save()
+	}
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Profile deleted successfully"})
+}
+
+// SwitchProfileRequest is the request body for switching to a profile
+type SwitchProfileRequest struct {
+	PIN string `json:"pin"`
+}
+
+// HandleSwitchProfile scopes the session to one of the account's profiles
+func HandleSwitchProfile(c *gin.Context) {
+	var form SwitchProfileRequest
+
+	// The body is optional for profiles without a PIN
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&form); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	currentUser, exists := models.GetUserFromContext(c)
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	profile := currentUser.FindProfileByID(c.Param("id"))
+	if profile == nil {
+		respondError(c, http.StatusNotFound, "Profile not found")
+		return
+	}
+
+	// PINs are short, so guessing is limited both per client and per profile
+	ipKey, profileKey := "ip:"+c.ClientIP(), "profile:"+profile.ID
+	if wait := pinLimiter.retryAfter(ipKey, profileKey); wait > 0 {
+		RecordAudit(c, models.AuditProfileSwitch, profile.ID, false, map[string]string{"profile": profile.Name, "reason": "too many attempts"})
+		respondLockedOut(c, wait)
+		return
+	}
+
+	if !profile.CheckPIN(form.PIN) {
+		pinLimiter.fail(ipKey, profileKey)
+		RecordAudit(c, models.AuditProfileSwitch, profile.ID, false, map[string]string{"profile": profile.Name, "reason": "wrong PIN"})
+		respondError(c, http.StatusForbidden, "Incorrect PIN")
+		return
+	}
+	pinLimiter.succeed(profileKey)
+	RecordAudit(c, models.AuditProfileSwitch, profile.ID, true, map[string]string{"profile": profile.Name})
+
+	session := sessions.Default(c)
+	session.Set("profileID", profile.ID)
+	session.Save()
+
+	c.JSON(http.StatusOK, profile.ToResponse())
+}
+
+// HandleGetHistory returns the active profile's watch history, most recent first
+func HandleGetHistory(c *gin.Context) {
+	data, err := activeProfileData(c)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to load watch history")
+		return
+	}
+
+	history := data.History
+	if history == nil {
+		history = []models.WatchEntry{}
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Updated.After(history[j].Updated)
+	})
+
+	c.JSON(http.StatusOK, history)
+}
+
+// UpdateHistoryRequest is the request body for saving playback progress
+type UpdateHistoryRequest struct {
+	Position float64 `json:"position"`
+	Duration float64 `json:"duration"`
+}
+
+// HandleUpdateHistory records playback progress for a media item in the active profile
+func HandleUpdateHistory(c *gin.Context, cfg *config.Config) {
+	mediaID := c.Param("id")
+
+	var form UpdateHistoryRequest
+
+	if err := c.ShouldBindJSON(&form); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if form.Position < 0 || form.Duration < 0 {
+		respondError(c, http.StatusBadRequest, "Position and duration must not be negative")
+		return
+	}
+
+	if _, ok := findVisibleMedia(c, cfg, mediaID); !ok {
+		respondError(c, http.StatusNotFound, "Media not found")
+		return
+	}
+
+	entry := models.WatchEntry{
+		MediaID:   mediaID,
+		Position:  form.Position,
+		Duration:  form.Duration,
+		Completed: form.Duration > 0 && form.Position >= form.Duration*completedThreshold,
+		Updated:   time.Now(),
+	}
+
+	err := updateProfileData(c, func(data *models.ProfileData) error {
+		for i := range data.History {
+			if data.History[i].MediaID == mediaID {
+				data.History[i] = entry
+				return nil
+			}
+		}
+		data.History = append(data.History, entry)
+
+		// Forget the entries updated longest ago
+		if len(data.History) > maxHistoryEntries {
+			sort.Slice(data.History, func(i, j int) bool {
+				return data.History[i].Updated.After(data.History[j].Updated)
+			})
+			data.History = data.History[:maxHistoryEntries]
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to save watch history")
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// HandleDeleteHistory removes a media item from the active profile's watch history
+func HandleDeleteHistory(c *gin.Context) {
+	mediaID := c.Param("id")
+
+	err := updateProfileData(c, func(data *models.ProfileData) error {
+		var updatedHistory []models.WatchEntry
+		for _, entry := range data.History {
+			if entry.MediaID != mediaID {
+				updatedHistory = append(updatedHistory, entry)
+			}
+		}
+		data.History = updatedHistory
+		return nil
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to save watch history")
+		return
+	}
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "History entry deleted successfully"})
+}
+
+// HandleGetPlaylists returns the active profile's playlists
+func HandleGetPlaylists(c *gin.Context) {
+	data, err := activeProfileData(c)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to load playlists")
+		return
+	}
+
+	if data.Playlists == nil {
+		c.JSON(http.StatusOK, []models.Playlist{})
+		return
+	}
+	c.JSON(http.StatusOK, data.Playlists)
+}
+
+// HandleGetPlaylist returns a single playlist of the active profile
+func HandleGetPlaylist(c *gin.Context) {
+	data, err := activeProfileData(c)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to load playlists")
+		return
+	}
+
+	for _, playlist := range data.Playlists {
+		if playlist.ID == c.Param("id") {
+			c.JSON(http.StatusOK, playlist)
+			return
+		}
+	}
+
+	respondError(c, http.StatusNotFound, "Playlist not found")
+}
+
+// CreatePlaylistRequest is the request body for creating a playlist
+type CreatePlaylistRequest struct {
+	Name  string   `json:"name" binding:"required"`
+	Items []string `json:"items"`
+}
+
+// HandleCreatePlaylist creates a playlist in the active profile
+func HandleCreatePlaylist(c *gin.Context, cfg *config.Config) {
+	var form CreatePlaylistRequest
+
+	if err := c.ShouldBindJSON(&form); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if form.Items == nil {
+		form.Items = []string{}
+	}
+	if !checkPlaylistItems(c, cfg, form.Items) {
+		return
+	}
+
+	now := time.Now()
+	playlist := models.Playlist{
+		ID:      utils.GenerateUniqueID(),
+		Name:    form.Name,
+		Items:   form.Items,
+		Created: now,
+		Updated: now,
+	}
+
+	err := updateProfileData(c, func(data *models.ProfileData) error {
+		if len(data.Playlists) >= maxPlaylists {
+			return errTooManyPlaylists
+		}
+		data.Playlists = append(data.Playlists, playlist)
+		return nil
+	})
+	if errors.Is(err, errTooManyPlaylists) {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to save playlist")
+		return
+	}
+
+	c.JSON(http.StatusCreated, playlist)
+}
+
+// UpdatePlaylistRequest is the request body for updating a playlist; missing fields are left unchanged
+type UpdatePlaylistRequest struct {
+	Name  *string   `json:"name"`
+	Items *[]string `json:"items"`
+}
+
+// HandleUpdatePlaylist renames a playlist or replaces its items
+func HandleUpdatePlaylist(c *gin.Context, cfg *config.Config) {
+	playlistID := c.Param("id")
+
+	var form UpdatePlaylistRequest
+
+	if err := c.ShouldBindJSON(&form); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if form.Name != nil && *form.Name == "" {
+		respondError(c, http.StatusBadRequest, "Name is required")
+		return
+	}
+
+	if form.Items != nil && !checkPlaylistItems(c, cfg, *form.Items) {
+		return
+	}
+
+	var updated *models.Playlist
+	err := updateProfileData(c, func(data *models.ProfileData) error {
+		for i := range data.Playlists {
+			if data.Playlists[i].ID != playlistID {
+				continue
+			}
+			if form.Name != nil {
+				data.Playlists[i].Name = *form.Name
+			}
+			if form.Items != nil {
+				data.Playlists[i].Items = *form.Items
+			}
+			data.Playlists[i].Updated = time.Now()
+			updated = &data.Playlists[i]
+			break
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to save playlist")
+		return
+	}
+
+	if updated == nil {
+		respondError(c, http.StatusNotFound, "Playlist not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// HandleDeletePlaylist deletes a playlist of the active profile
+func HandleDeletePlaylist(c *gin.Context) {
+	playlistID := c.Param("id")
+
+	found := false
+	err := updateProfileData(c, func(data *models.ProfileData) error {
+		var updatedPlaylists []models.Playlist
+		for _, playlist := range data.Playlists {
+			if playlist.ID == playlistID {
+				found = true
+				continue
+			}
+			updatedPlaylists = append(updatedPlaylists, playlist)
+		}
+		data.Playlists = updatedPlaylists
+		return nil
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to save playlists")
+		return
+	}
+
+	if !found {
+		respondError(c, http.StatusNotFound, "Playlist not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Playlist deleted successfully"})
+}
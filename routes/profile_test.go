@@ -0,0 +1,82 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"mediastream/config"
+	"mediastream/models"
+)
+
+func TestHistoryAndPlaylistMedia(t *testing.T) {
+	s := newContractServer(t)
+	s.login("admin", "admin-password")
+	movie := field(t, first(t, s.call("GET", "/library/movies", nil, http.StatusOK), "items"), "id")
+	s.call("POST", "/admin/users", CreateUserRequest{Username: "viewer", Password: "viewer-password", Libraries: []string{"photos"}}, http.StatusCreated)
+
+	// Unknown media can't be recorded
+	s.call("PUT", "/history/bogus", UpdateHistoryRequest{Position: 1}, http.StatusNotFound)
+	s.call("POST", "/playlists", CreatePlaylistRequest{Name: "Unknown", Items: []string{movie, "bogus"}}, http.StatusBadRequest)
+	playlist := field(t, s.call("POST", "/playlists", CreatePlaylistRequest{Name: "Movies", Items: []string{movie}}, http.StatusCreated), "id")
+	s.call("PUT", "/playlists/"+playlist, UpdatePlaylistRequest{Items: &[]string{"bogus"}}, http.StatusBadRequest)
+
+	// Nor media of libraries the user can't access
+	s.login("viewer", "viewer-password")
+	s.call("PUT", "/history/"+movie, UpdateHistoryRequest{Position: 1}, http.StatusNotFound)
+	s.call("POST", "/playlists", CreatePlaylistRequest{Name: "Movies", Items: []string{movie}}, http.StatusBadRequest)
+	if history := s.call("GET", "/history", nil, http.StatusOK).([]any); len(history) != 0 {
+		t.Errorf("history of inaccessible media = %v", history)
+	}
+
+	// Too many items in one playlist
+	items := make([]string, maxPlaylistItems+1)
+	for i := range items {
+		items[i] = movie
+	}
+	s.login("admin", "admin-password")
+	s.call("POST", "/playlists", CreatePlaylistRequest{Name: "Long", Items: items}, http.StatusBadRequest)
+}
+
+func TestHistoryAndPlaylistLimits(t *testing.T) {
+	s := newContractServer(t)
+	s.login("admin", "admin-password")
+	movie := field(t, first(t, s.call("GET", "/library/movies", nil, http.StatusOK), "items"), "id")
+	users, err := models.LoadUsers(config.UsersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A full history, and as many playlists as a profile can have
+	data := &models.ProfileData{}
+	updated := time.Now().Add(-time.Hour)
+	for i := range maxHistoryEntries {
+		data.History = append(data.History, models.WatchEntry{MediaID: fmt.Sprint("old-", i), Updated: updated.Add(time.Duration(i) * time.Second)})
+	}
+	for i := range maxPlaylists {
+		data.Playlists = append(data.Playlists, models.Playlist{ID: fmt.Sprint(i), Name: fmt.Sprint("Playlist ", i), Items: []string{}})
+	}
+	all := map[string]*models.ProfileData{models.FindUserByUsername(users, "admin").ID: data}
+	if err := models.SaveProfileData(all, config.ProfilesFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entry updated longest ago makes room
+	s.call("PUT", "/history/"+movie, UpdateHistoryRequest{Position: 1, Duration: 100}, http.StatusOK)
+	history := s.call("GET", "/history", nil, http.StatusOK).([]any)
+	if len(history) != maxHistoryEntries {
+		t.Errorf("%d history entries, want %d", len(history), maxHistoryEntries)
+	}
+	if id := field(t, history[0], "mediaId"); id != movie {
+		t.Errorf("latest history entry is %s, want %s", id, movie)
+	}
+	if id := field(t, history[len(history)-1], "mediaId"); id != "old-1" {
+		t.Errorf("oldest history entry is %s, want old-1", id)
+	}
+
+	s.call("POST", "/playlists", CreatePlaylistRequest{Name: "One too many"}, http.StatusBadRequest)
+	if playlists := s.call("GET", "/playlists", nil, http.StatusOK).([]any); len(playlists) != maxPlaylists {
+		t.Errorf("%d playlists, want %d", len(playlists), maxPlaylists)
+	}
+}
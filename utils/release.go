@@ -0,0 +1,293 @@
+package utils
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Release holds what a scene-style release name such as
+// "The.Matrix.1999.1080p.BluRay.x264-GROUP" tells about a video
+type Release struct {
+	Title      string `json:"title"`
+	Year       int    `json:"year,omitempty"`
+	Season     int    `json:"season,omitempty"`
+	Episode    int    `json:"episode,omitempty"`
+	Resolution string `json:"resolution,omitempty"` // e.g. 1080p or 2160p
+	Source     string `json:"source,omitempty"`     // e.g. BluRay, WEB-DL or HDTV
+	VideoCodec string `json:"videoCodec,omitempty"` // e.g. x264 or HEVC
+	AudioCodec string `json:"audioCodec,omitempty"` // e.g. DTS-HD or AAC
+	Edition    string `json:"edition,omitempty"`    // e.g. Director's Cut or Extended
+	ThreeD     bool   `json:"threeD,omitempty"`
+	Part       int    `json:"part,omitempty"` // Of a movie split into several files
+	Group      string `json:"group,omitempty"`
+}
+
+var (
+	// plexEdition matches Plex's edition tag, e.g. "{edition-Director's Cut}"
+	plexEdition = regexp.MustCompile(`(?i)\s*\{edition-([^}]+)\}`)
+	// dottedCodec matches codec names written with a dot, e.g. H.264
+	dottedCodec = regexp.MustCompile(`(?i)\b([hx])\.(26[45])\b`)
+	// audioChannels matches channel layouts such as 5.1, which must not be split at the dot
+	audioChannels = regexp.MustCompile(`(^|[^0-9])([1-9])\.([0-2])($|[^0-9])`)
+	// wordDot matches dots separating words, but not the dot of an abbreviation like "Mr. Robot"
+	wordDot = regexp.MustCompile(`\.(\S)`)
+
+	yearToken       = regexp.MustCompile(`^(19|20)\d\d$`)
+	episodeToken    = regexp.MustCompile(`^s(\d{1,2})e(\d{1,3})(?:-?e\d{1,3}|-\d{1,3})*$`)
+	crossEpisode    = regexp.MustCompile(`^(\d{1,2})x(\d{2,3})(?:-(?:\d{1,2}x)?\d{2,3})*$`)
+	partToken       = regexp.MustCompile(`^(?:cd|disc|disk|pt)(\d{1,2})$`)
+	audioCodecToken = regexp.MustCompile(`^(ddp|dd\+|dd|eac3|ac3|aac|dts-hd|dtshd|dts-x|dts|truehd|atmos|flac|mp3|opus|lpcm)(\d\.\d)?$`)
+	channelsToken   = regexp.MustCompile(`^[1-9]\.[0-2]$`)
+)
+
+var resolutions = map[string]string{
+	"480p": "480p", "576p": "576p", "720p": "720p", "1080p": "1080p", "1080i": "1080i",
+	"2160p": "2160p", "4k": "2160p", "uhd": "2160p",
+}
+
+var sources = map[string]string{
+	"bluray": "BluRay", "blu-ray": "BluRay", "bdrip": "BluRay", "brrip": "BluRay", "bdremux": "BluRay", "remux": "BluRay",
+	"web-dl": "WEB-DL", "webdl": "WEB-DL", "webrip": "WEBRip", "web": "WEB",
+	"hdtv": "HDTV", "pdtv": "HDTV", "sdtv": "SDTV",
+	"dvd": "DVD", "dvdrip": "DVDRip", "dvdr": "DVD", "dvdscr": "DVDScr",
+	"hdrip": "HDRip", "cam": "CAM", "hdcam": "CAM", "telesync": "TS", "hdts": "TS",
+}
+
+var videoCodecs = map[string]string{
+	"x264": "x264", "x265": "x265", "h264": "H.264", "h265": "H.265", "hevc": "HEVC", "avc": "AVC",
+	"xvid": "XviD", "divx": "DivX", "av1": "AV1", "vp9": "VP9",
+}
+
+var audioCodecs = map[string]string{
+	"ddp": "DD+", "dd+": "DD+", "eac3": "DD+", "dd": "DD", "ac3": "DD", "aac": "AAC",
+	"dts-hd": "DTS-HD", "dtshd": "DTS-HD", "dts-x": "DTS:X", "dts": "DTS",
+	"truehd": "TrueHD", "atmos": "Atmos", "flac": "FLAC", "mp3": "MP3", "opus": "Opus", "lpcm": "LPCM",
+}
+
+var threeD = map[string]bool{"3d": true, "sbs": true, "hsbs": true, "h-sbs": true, "half-sbs": true, "ou": true, "hou": true, "h-ou": true, "half-ou": true}
+
+// editions maps lowercase phrases without apostrophes to edition names
+var editions = map[string]string{
+	"directors cut": "Director's Cut", "directors edition": "Director's Cut",
+	"extended": "Extended", "extended cut": "Extended", "extended edition": "Extended",
+	"unrated": "Unrated", "uncut": "Uncut", "uncensored": "Uncensored",
+	"theatrical": "Theatrical", "theatrical cut": "Theatrical",
+	"remastered": "Remastered", "final cut": "Final Cut", "imax": "IMAX", "criterion": "Criterion",
+	"special edition": "Special Edition", "ultimate edition": "Ultimate Edition", "ultimate cut": "Ultimate Edition",
+	"collectors edition": "Collector's Edition", "anniversary edition": "Anniversary Edition",
+}
+
+// noise are release tags that mark the end of the title but aren't kept
+var noise = map[string]bool{
+	"proper": true, "repack": true, "rerip": true, "internal": true, "limited": true, "readnfo": true,
+	"multi": true, "dual": true, "subbed": true, "dubbed": true, "hardsub": true,
+	"10bit": true, "8bit": true, "hdr": true, "hdr10": true, "hdr10+": true, "dv": true, "dovi": true, "sdr": true,
+}
+
+// releaseYear returns the year a token names, if it is one; years after next year are
+// taken as part of the title, as in "Blade Runner 2049"
+func releaseYear(key string) (int, bool) {
+	if !yearToken.MatchString(key) {
+		return 0, false
+	}
+	year, _ := strconv.Atoi(key)
+	return year, year <= time.Now().Year()+1
+}
+
+// releaseKey is how a token is looked up in the tag tables
+func releaseKey(token string) string {
+	key := strings.ToLower(strings.Trim(token, "()[]{}"))
+	return strings.NewReplacer("'", "", "’", "").Replace(key)
+}
+
+// editionAt matches an edition phrase of up to three tokens starting at tokens[i]
+func editionAt(tokens []string, i int) (string, int) {
+	for n := 3; n >= 1; n-- {
+		if i+n > len(tokens) {
+			continue
+		}
+		keys := make([]string, n)
+		for j := range keys {
+			keys[j] = releaseKey(tokens[i+j])
+		}
+		if edition, ok := editions[strings.Join(keys, " ")]; ok {
+			return edition, n
+		}
+	}
+	return "", 0
+}
+
+// isQualityTag reports whether tokens[i] is a tag that can't be a word of a title, such as
+// a resolution, codec or episode number. Channel layouts like "2.0" only count after an
+// audio codec, so that "Ghost in the Shell 2.0" keeps its title.
+func isQualityTag(tokens []string, i int) bool {
+	key := releaseKey(tokens[i])
+	switch {
+	case resolutions[key] != "", videoCodecs[key] != "":
+		return true
+	case audioCodecToken.MatchString(key), episodeToken.MatchString(key), crossEpisode.MatchString(key):
+		return true
+	case channelsToken.MatchString(key):
+		return i > 0 && audioCodecToken.MatchString(releaseKey(tokens[i-1]))
+	}
+	return false
+}
+
+// isTag reports whether tokens[i] is a release tag, including those that are ordinary
+// words too, such as "Web" or "Final Cut"
+func isTag(tokens []string, i int) bool {
+	key := releaseKey(tokens[i])
+	if _, n := editionAt(tokens, i); n > 0 {
+		return true
+	}
+	switch {
+	case sources[key] != "", threeD[key], noise[key], partToken.MatchString(key):
+		return true
+	}
+	return isQualityTag(tokens, i)
+}
+
+// splitGroup splits the release group off the last token, e.g. "x264-GROUP", if what precedes it
+// is a tag and the token isn't a tag itself, like "WEB-DL"
+func splitGroup(tokens []string) ([]string, string) {
+	if len(tokens) < 2 {
+		return tokens, ""
+	}
+	last := tokens[len(tokens)-1]
+	i := strings.LastIndex(last, "-")
+	if i <= 0 || i == len(last)-1 || isTag(tokens, len(tokens)-1) {
+		return tokens, ""
+	}
+
+	head := append(append([]string{}, tokens[:len(tokens)-1]...), last[:i])
+	if !isTag(head, len(head)-1) {
+		return tokens, ""
+	}
+	return head, last[i+1:]
+}
+
+// ParseRelease extracts the title, year and release tags from a file or folder name without
+// its extension. Dots and underscores separate words; hyphens inside words such as
+// "Spider-Man" are kept.
+func ParseRelease(name string) Release {
+	var release Release
+
+	if match := plexEdition.FindStringSubmatch(name); match != nil {
+		release.Edition = strings.TrimSpace(match[1])
+		name = plexEdition.ReplaceAllString(name, "")
+	}
+
+	// Keep dots that belong to tags, then turn the others into spaces
+	name = dottedCodec.ReplaceAllString(name, "${1}${2}")
+	for audioChannels.MatchString(name) {
+		name = audioChannels.ReplaceAllString(name, "${1}${2}\x00${3}${4}")
+	}
+	name = strings.ReplaceAll(name, "_", " ")
+	name = wordDot.ReplaceAllString(name, " $1")
+	if !strings.Contains(strings.TrimSpace(name), " ") {
+		name = strings.ReplaceAll(name, ".", " ")
+	}
+	name = strings.ReplaceAll(name, "\x00", ".")
+
+	tokens := strings.Fields(name)
+
+	// Fansub releases start with the group in brackets, e.g. "[Group] Title - 01"
+	if len(tokens) > 1 && strings.HasPrefix(tokens[0], "[") && strings.HasSuffix(tokens[0], "]") {
+		release.Group = strings.Trim(tokens[0], "[]")
+		tokens = tokens[1:]
+	}
+	var group string
+	tokens, group = splitGroup(tokens)
+	if group != "" {
+		release.Group = group
+	}
+
+	// The title runs until the last year before the first quality tag, so that words like
+	// "Web" in "Charlotte's Web (2006)" stay in it. Without a year it runs until the first tag.
+	qualityStart := len(tokens)
+	for i := 1; i < len(tokens); i++ {
+		if isQualityTag(tokens, i) {
+			qualityStart = i
+			break
+		}
+	}
+	titleEnd := -1
+	for i := qualityStart - 1; i >= 1; i-- {
+		if year, ok := releaseYear(releaseKey(tokens[i])); ok {
+			release.Year = year
+			titleEnd = i
+			break
+		}
+	}
+	start := titleEnd + 1
+	if titleEnd < 0 {
+		titleEnd = len(tokens)
+		for i := 1; i < len(tokens); i++ {
+			if isTag(tokens, i) {
+				titleEnd = i
+				break
+			}
+		}
+		start = titleEnd
+	}
+
+	for i := start; i < len(tokens); i++ {
+		key := releaseKey(tokens[i])
+		if edition, n := editionAt(tokens, i); n > 0 {
+			if release.Edition == "" {
+				release.Edition = edition
+			}
+			i += n - 1
+			continue
+		}
+
+		switch {
+		case yearToken.MatchString(key):
+			if year, ok := releaseYear(key); ok && release.Year == 0 {
+				release.Year = year
+			}
+		case resolutions[key] != "":
+			release.Resolution = resolutions[key]
+		case sources[key] != "":
+			if release.Source == "" {
+				release.Source = sources[key]
+			}
+		case videoCodecs[key] != "":
+			release.VideoCodec = videoCodecs[key]
+		case threeD[key]:
+			release.ThreeD = true
+		case audioCodecToken.MatchString(key):
+			if release.AudioCodec == "" {
+				release.AudioCodec = audioCodecs[audioCodecToken.FindStringSubmatch(key)[1]]
+			}
+		case episodeToken.MatchString(key):
+			match := episodeToken.FindStringSubmatch(key)
+			release.Season, _ = strconv.Atoi(match[1])
+			release.Episode, _ = strconv.Atoi(match[2])
+		case crossEpisode.MatchString(key):
+			match := crossEpisode.FindStringSubmatch(key)
+			release.Season, _ = strconv.Atoi(match[1])
+			release.Episode, _ = strconv.Atoi(match[2])
+		case partToken.MatchString(key):
+			release.Part, _ = strconv.Atoi(partToken.FindStringSubmatch(key)[1])
+		case key == "part" && i+1 < len(tokens):
+			if part, err := strconv.Atoi(releaseKey(tokens[i+1])); err == nil {
+				release.Part = part
+				i++
+			}
+		}
+	}
+
+	// Drop separators and brackets left around the title, e.g. "Title -" or "Title ("
+	title := tokens[:titleEnd]
+	for len(title) > 0 && strings.Trim(title[len(title)-1], "-()[]{}") == "" {
+		title = title[:len(title)-1]
+	}
+	release.Title = strings.TrimSpace(strings.Join(title, " "))
+	if release.Title == "" {
+		release.Title = strings.TrimSpace(strings.Join(tokens, " "))
+	}
+	return release
+}
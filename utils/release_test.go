@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseRelease(t *testing.T) {
+	tests := []struct {
+		name string
+		want Release
+	}{
+		// Plain folder names
+		{"Movie Title (2010)", Release{Title: "Movie Title", Year: 2010}},
+		{"Movie Title", Release{Title: "Movie Title"}},
+		{"Movie", Release{Title: "Movie"}},
+		{"Spider-Man (2002)", Release{Title: "Spider-Man", Year: 2002}},
+		{"Mr. Robot", Release{Title: "Mr. Robot"}},
+		{"Star Wars - Episode IV (1977)", Release{Title: "Star Wars - Episode IV", Year: 1977}},
+
+		// Words that are tags too stay in the title when a year follows them
+		{"Charlotte's Web (2006)", Release{Title: "Charlotte's Web", Year: 2006}},
+		{"The Final Cut (2004)", Release{Title: "The Final Cut", Year: 2004}},
+		{"The.Web.2001.720p", Release{Title: "The Web", Year: 2001, Resolution: "720p"}},
+		{"The.Extended.Family.2015.1080p.WEB-DL", Release{Title: "The Extended Family", Year: 2015, Resolution: "1080p", Source: "WEB-DL"}},
+		{"Cam (2018)", Release{Title: "Cam", Year: 2018}},
+		{"Uncut Gems (2019)", Release{Title: "Uncut Gems", Year: 2019}},
+		{"The Remastered Cut 2020 Remastered 1080p", Release{Title: "The Remastered Cut", Year: 2020, Edition: "Remastered", Resolution: "1080p"}},
+
+		// Numbers in titles
+		{"Ghost in the Shell 2.0", Release{Title: "Ghost in the Shell 2.0"}},
+		{"Ghost.in.the.Shell.2.0.2008.1080p.BluRay", Release{Title: "Ghost in the Shell 2.0", Year: 2008, Resolution: "1080p", Source: "BluRay"}},
+		{"2001 A Space Odyssey (1968)", Release{Title: "2001 A Space Odyssey", Year: 1968}},
+		{"1917 (2019)", Release{Title: "1917", Year: 2019}},
+		{"Blade Runner 2049 (2017)", Release{Title: "Blade Runner 2049", Year: 2017}},
+		{"Blade.Runner.2049.2017.2160p.UHD.BluRay.x265-GROUP", Release{Title: "Blade Runner 2049", Year: 2017, Resolution: "2160p", Source: "BluRay", VideoCodec: "x265", Group: "GROUP"}},
+		{"Wonder Woman 1984 (2020)", Release{Title: "Wonder Woman 1984", Year: 2020}},
+		{"Space 2099", Release{Title: "Space 2099"}},
+
+		// Scene-style names
+		{"The.Matrix.1999.1080p.BluRay.x264-GROUP", Release{Title: "The Matrix", Year: 1999, Resolution: "1080p", Source: "BluRay", VideoCodec: "x264", Group: "GROUP"}},
+		{"The_Matrix_1999_720p_HDTV", Release{Title: "The Matrix", Year: 1999, Resolution: "720p", Source: "HDTV"}},
+		{"Inception.2010.2160p.WEB-DL.DDP5.1.Atmos.HEVC-GROUP", Release{Title: "Inception", Year: 2010, Resolution: "2160p", Source: "WEB-DL", AudioCodec: "DD+", VideoCodec: "HEVC", Group: "GROUP"}},
+		{"Heat.1995.1080p.BluRay.DTS-HD.MA.5.1.H.264", Release{Title: "Heat", Year: 1995, Resolution: "1080p", Source: "BluRay", AudioCodec: "DTS-HD", VideoCodec: "H.264"}},
+		{"Alien.1979.Directors.Cut.1080p.BluRay.x264", Release{Title: "Alien", Year: 1979, Edition: "Director's Cut", Resolution: "1080p", Source: "BluRay", VideoCodec: "x264"}},
+		{"Blade Runner (1982) Final Cut 1080p", Release{Title: "Blade Runner", Year: 1982, Edition: "Final Cut", Resolution: "1080p"}},
+		{"Avatar.2009.Extended.Edition.PROPER.720p.BluRay.AAC.2.0.XviD", Release{Title: "Avatar", Year: 2009, Edition: "Extended", Resolution: "720p", Source: "BluRay", AudioCodec: "AAC", VideoCodec: "XviD"}},
+		{"Avatar.3D.2009.1080p.HSBS", Release{Title: "Avatar 3D", Year: 2009, Resolution: "1080p", ThreeD: true}},
+		{"Gravity.2013.3D.HSBS.1080p", Release{Title: "Gravity", Year: 2013, ThreeD: true, Resolution: "1080p"}},
+		{"Movie.Title.2010.CD1", Release{Title: "Movie Title", Year: 2010, Part: 1}},
+		{"Movie Title (2010) Part 2", Release{Title: "Movie Title", Year: 2010, Part: 2}},
+		{"Movie.Title.BluRay.1080p", Release{Title: "Movie Title", Resolution: "1080p", Source: "BluRay"}},
+		{"Movie.Title.1080p.2010", Release{Title: "Movie Title", Year: 2010, Resolution: "1080p"}},
+		{"Movie Title (2010) {edition-Director's Cut}", Release{Title: "Movie Title", Year: 2010, Edition: "Director's Cut"}},
+		{"Movie Title {edition-Special Edition} (2010)", Release{Title: "Movie Title", Year: 2010, Edition: "Special Edition"}},
+
+		// Episodes
+		{"Show.Name.S01E02.720p.HDTV.x264-GROUP", Release{Title: "Show Name", Season: 1, Episode: 2, Resolution: "720p", Source: "HDTV", VideoCodec: "x264", Group: "GROUP"}},
+		{"Show.Name.2019.S02E10.1080p.WEB", Release{Title: "Show Name", Year: 2019, Season: 2, Episode: 10, Resolution: "1080p", Source: "WEB"}},
+		{"Show Name 1x02", Release{Title: "Show Name", Season: 1, Episode: 2}},
+		{"Show.Name.S03E04E05.1080p", Release{Title: "Show Name", Season: 3, Episode: 4, Resolution: "1080p"}},
+		{"[Group] Show Title - 01 [1080p]", Release{Title: "Show Title - 01", Group: "Group", Resolution: "1080p"}},
+
+		// Editions
+		{"Donnie.Darko.2001.Directors.Edition.1080p.BluRay", Release{Title: "Donnie Darko", Year: 2001, Edition: "Director's Cut", Resolution: "1080p", Source: "BluRay"}},
+		{"Aliens (1986) Special Edition", Release{Title: "Aliens", Year: 1986, Edition: "Special Edition"}},
+		{"Alien.1979.Theatrical.Cut.720p", Release{Title: "Alien", Year: 1979, Edition: "Theatrical", Resolution: "720p"}},
+		{"Dune.2021.IMAX.2160p.WEB-DL.DDP5.1.Atmos.H.265-GROUP", Release{Title: "Dune", Year: 2021, Edition: "IMAX", Resolution: "2160p", Source: "WEB-DL", AudioCodec: "DD+", VideoCodec: "H.265", Group: "GROUP"}},
+		{"Seven.Samurai.1954.Criterion.1080p.BluRay.FLAC.x264", Release{Title: "Seven Samurai", Year: 1954, Edition: "Criterion", Resolution: "1080p", Source: "BluRay", AudioCodec: "FLAC", VideoCodec: "x264"}},
+		{"Movie.Title.2010.UNRATED.DVDRip.XviD", Release{Title: "Movie Title", Year: 2010, Edition: "Unrated", Source: "DVDRip", VideoCodec: "XviD"}},
+		{"Movie.Title.2010.Remastered.Uncut.1080p", Release{Title: "Movie Title", Year: 2010, Edition: "Remastered", Resolution: "1080p"}},
+		{"Movie.Title.2010.Collectors.Edition.1080p", Release{Title: "Movie Title", Year: 2010, Edition: "Collector's Edition", Resolution: "1080p"}},
+		{"Movie.Title.2010.25th.Anniversary.Edition.1080p", Release{Title: "Movie Title", Year: 2010, Edition: "Anniversary Edition", Resolution: "1080p"}},
+		{"Movie.Title.Extended.2010.1080p", Release{Title: "Movie Title Extended", Year: 2010, Resolution: "1080p"}},
+		{"Movie Title (2010) {edition-Extended} Unrated", Release{Title: "Movie Title", Year: 2010, Edition: "Extended"}},
+
+		// 3D
+		{"Avatar.2009.3D.SBS.1080p.BluRay.x264", Release{Title: "Avatar", Year: 2009, ThreeD: true, Resolution: "1080p", Source: "BluRay", VideoCodec: "x264"}},
+		{"Avatar.2009.1080p.3D.Half-OU.BluRay", Release{Title: "Avatar", Year: 2009, Resolution: "1080p", ThreeD: true, Source: "BluRay"}},
+		{"Hugo.2011.H-SBS.1080p", Release{Title: "Hugo", Year: 2011, ThreeD: true, Resolution: "1080p"}},
+		{"Hugo (2011) 3D HOU", Release{Title: "Hugo", Year: 2011, ThreeD: true}},
+		{"Hugo.3D.1080p", Release{Title: "Hugo", ThreeD: true, Resolution: "1080p"}},
+
+		// Parts
+		{"Movie.Title.2010.CD2.DVDRip.XviD-GROUP", Release{Title: "Movie Title", Year: 2010, Part: 2, Source: "DVDRip", VideoCodec: "XviD", Group: "GROUP"}},
+		{"Movie.Title.2010.Disc1", Release{Title: "Movie Title", Year: 2010, Part: 1}},
+		{"Movie Title (2010) pt3", Release{Title: "Movie Title", Year: 2010, Part: 3}},
+		{"Movie.Title.2010.Part.12.720p", Release{Title: "Movie Title", Year: 2010, Part: 12, Resolution: "720p"}},
+		{"Harry Potter and the Deathly Hallows Part 1 (2010)", Release{Title: "Harry Potter and the Deathly Hallows Part 1", Year: 2010}},
+		{"Movie.Title.2010.Part.One.1080p", Release{Title: "Movie Title", Year: 2010, Resolution: "1080p"}},
+
+		// Multi-episode files keep the first episode
+		{"Show.Name.S01E01E02E03.720p", Release{Title: "Show Name", Season: 1, Episode: 1, Resolution: "720p"}},
+		{"Show.Name.S01E01-E02.1080p.WEB-DL", Release{Title: "Show Name", Season: 1, Episode: 1, Resolution: "1080p", Source: "WEB-DL"}},
+		{"Show.Name.S01E01-02.1080p", Release{Title: "Show Name", Season: 1, Episode: 1, Resolution: "1080p"}},
+		{"Show Name 1x01-1x02", Release{Title: "Show Name", Season: 1, Episode: 1}},
+		{"Show Name - S10E99E100 - Finale", Release{Title: "Show Name", Season: 10, Episode: 99}},
+
+		// Non-English titles and release groups
+		{"Amélie.2001.1080p.BluRay.x264-ÁRVÍZTŰRŐ", Release{Title: "Amélie", Year: 2001, Resolution: "1080p", Source: "BluRay", VideoCodec: "x264", Group: "ÁRVÍZTŰRŐ"}},
+		{"Das.Boot.1981.German.1080p.BluRay.x264-DETAiLS", Release{Title: "Das Boot", Year: 1981, Resolution: "1080p", Source: "BluRay", VideoCodec: "x264", Group: "DETAiLS"}},
+		{"Le.Fabuleux.Destin.d'Amélie.Poulain.2001.FRENCH.720p.BluRay.x264-FiDELiO", Release{Title: "Le Fabuleux Destin d'Amélie Poulain", Year: 2001, Resolution: "720p", Source: "BluRay", VideoCodec: "x264", Group: "FiDELiO"}},
+		{"Брат.1997.1080p.WEB-DL.AAC-Кинозал", Release{Title: "Брат", Year: 1997, Resolution: "1080p", Source: "WEB-DL", AudioCodec: "AAC", Group: "Кинозал"}},
+		{"千と千尋の神隠し.2001.1080p.BluRay.FLAC.x265-字幕组", Release{Title: "千と千尋の神隠し", Year: 2001, Resolution: "1080p", Source: "BluRay", AudioCodec: "FLAC", VideoCodec: "x265", Group: "字幕组"}},
+		{"[字幕组] 進撃の巨人 - 01 [1080p]", Release{Title: "進撃の巨人 - 01", Group: "字幕组", Resolution: "1080p"}},
+		{"[Ohys-Raws] Shingeki no Kyojin - 01 [720p]", Release{Title: "Shingeki no Kyojin - 01", Group: "Ohys-Raws", Resolution: "720p"}},
+		{"La.Casa.de.Papel.S01E01.SPANISH.1080p.WEB-DL.x264-ÑOÑO", Release{Title: "La Casa de Papel", Season: 1, Episode: 1, Resolution: "1080p", Source: "WEB-DL", VideoCodec: "x264", Group: "ÑOÑO"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ParseRelease(test.name); got != test.want {
+				t.Errorf("ParseRelease(%q)\n got %+v\nwant %+v", test.name, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseReleaseYearLimit(t *testing.T) {
+	next := strconv.Itoa(time.Now().Year() + 1)
+	if got := ParseRelease("Movie " + next); got.Year != time.Now().Year()+1 {
+		t.Errorf("next year: got %+v", got)
+	}
+	later := strconv.Itoa(time.Now().Year() + 2)
+	if got := ParseRelease("Movie " + later); got.Year != 0 || got.Title != "Movie "+later {
+		t.Errorf("after next year: got %+v", got)
+	}
+}
+
+func TestGetTitle(t *testing.T) {
+	tests := map[string]string{
+		"/media/movies/The.Matrix.1999.1080p.BluRay.x264-GROUP.mkv": "The Matrix",
+		"Charlotte's Web (2006).mp4":                                "Charlotte's Web",
+		"home video.mov":                                            "home video",
+	}
+	for filename, want := range tests {
+		if got := GetTitle(filename); got != want {
+			t.Errorf("GetTitle(%q) = %q, want %q", filename, got, want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"os"
 	"path/filepath"
 	"strings"
 )
@@ -17,11 +18,10 @@ func GenerateUniqueID() string {
 	return hex.EncodeToString(b)
 }
 
-// GetTitle extracts a title from a filename
+// GetTitle extracts a title from a filename, dropping its extension and release tags
 func GetTitle(filename string) string {
-	// Remove extension and replace underscores, dots, and hyphens with spaces
 	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
-	return strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(name, "_", " "), ".", " "), "-", " ")
+	return ParseRelease(name).Title
 }
 
 // GetContentType returns the content type based on file extension
@@ -52,3 +52,37 @@ func GetContentType(filename string) string {
 
 	return "application/octet-stream"
 }
+
+// WriteFileAtomic replaces a file with data so readers and crashes never see it half-written.
+// The data is synced to disk before the old file is replaced.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	// A unique temporary name keeps concurrent writers from renaming each other's files
+	file, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpFile := file.Name()
+
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		os.Remove(tmpFile)
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmpFile)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpFile)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	return os.Rename(tmpFile, filename)
+}
@@ -0,0 +1,53 @@
+// Package version reports how the server binary was built.
+// Version and Commit are set at build time:
+//
+//	go build -ldflags "-X mediastream/version.Version=1.2.0 -X mediastream/version.Commit=$(git rev-parse --short HEAD)"
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags -X at build time
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build information, falling back to the VCS details Go records
+// in the binary when the commit wasn't set at build time
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			if setting.Key == "vcs.revision" && info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	return info
+}
+
+// String returns the version and commit for log messages
+func (i Info) String() string {
+	return i.Version + " (" + i.Commit + ", " + i.GoVersion + ")"
+}